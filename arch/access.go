@@ -0,0 +1,79 @@
+package arch
+
+// The helpers below implement byte and halfword accesses on a WordHandler. If it
+// is a SubWordHandler its native accessors are used; otherwise they operate on
+// the aligned word(s) containing the data.
+
+// readByte reads a single byte from memory.
+func readByte(memory WordHandler, addr uint32) (uint8, error) {
+	if h, ok := memory.(SubWordHandler); ok {
+		return h.ReadByteAt(addr)
+	}
+	word, err := memory.ReadWord(addr &^ 3)
+	if err != nil {
+		return 0, err
+	}
+	return uint8(word >> ((addr & 3) * 8)), nil
+}
+
+// readHalf reads a little-endian halfword from memory.
+func readHalf(memory WordHandler, addr uint32) (uint16, error) {
+	if h, ok := memory.(SubWordHandler); ok {
+		return h.ReadHalf(addr)
+	}
+	lo, err := readByte(memory, addr)
+	if err != nil {
+		return 0, err
+	}
+	hi, err := readByte(memory, addr+1)
+	if err != nil {
+		return 0, err
+	}
+	return uint16(lo) | uint16(hi)<<8, nil
+}
+
+// writeByte writes a single byte to memory using a read-modify-write of the containing word.
+func writeByte(memory WordHandler, addr uint32, value uint8) error {
+	if h, ok := memory.(SubWordHandler); ok {
+		return h.WriteByteAt(addr, value)
+	}
+	aligned := addr &^ 3
+	word, err := memory.ReadWord(aligned)
+	if err != nil {
+		return err
+	}
+	shift := (addr & 3) * 8
+	word = (word &^ (0xFF << shift)) | uint32(value)<<shift
+	return memory.WriteWord(aligned, word)
+}
+
+// writeHalf writes a little-endian halfword to memory.
+func writeHalf(memory WordHandler, addr uint32, value uint16) error {
+	if h, ok := memory.(SubWordHandler); ok {
+		return h.WriteHalf(addr, value)
+	}
+	if err := writeByte(memory, addr, uint8(value)); err != nil {
+		return err
+	}
+	return writeByte(memory, addr+1, uint8(value>>8))
+}
+
+// zeroChunk is the size of the buffer zeroRange writes at a time.
+const zeroChunk = 4096
+
+// zeroRange clears size bytes of memory at addr. Without a Zeroer it writes
+// zeros a chunk at a time, so that the buffer stays small for large ranges.
+func zeroRange(memory WordHandler, addr, size uint32) error {
+	if z, ok := memory.(Zeroer); ok {
+		return z.Zero(addr, size)
+	}
+	zeros := make([]byte, min(size, zeroChunk))
+	for done := uint32(0); done < size; {
+		n := min(size-done, zeroChunk)
+		if err := writeBytes(memory, addr+done, zeros[:n]); err != nil {
+			return err
+		}
+		done += n
+	}
+	return nil
+}
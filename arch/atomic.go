@@ -0,0 +1,104 @@
+package arch
+
+import (
+	"fmt"
+
+	"github.com/malikwirin/riscvemu/assembler"
+)
+
+// execAtomic executes the A extension instructions: LR.W, SC.W and the AMOs.
+// The emulator runs a single hart, so every access is trivially atomic and the
+// aq/rl ordering bits need no special handling.
+func (c *CPU) execAtomic(instr assembler.Instruction, memory WordHandler) error {
+	if instr.Funct3() != assembler.FUNCT3_AMO_W {
+		return fmt.Errorf("unsupported AMO funct3: 0x%X", instr.Funct3())
+	}
+	rd := RegIndex(instr.Rd())
+	addr := c.Reg[instr.Rs1()]
+	src := c.Reg[instr.Rs2()]
+	if addr%4 != 0 {
+		cause := CAUSE_MISALIGNED_STORE
+		if instr.Funct5() == assembler.FUNCT5_LR {
+			cause = CAUSE_MISALIGNED_LOAD
+		}
+		return &Exception{Cause: cause, Tval: addr, Err: fmt.Errorf("misaligned atomic access at address 0x%08X", addr)}
+	}
+
+	switch instr.Funct5() {
+	case assembler.FUNCT5_LR:
+		if instr.Rs2() != 0 {
+			return fmt.Errorf("invalid LR.W encoding: rs2 must be x0")
+		}
+		value, err := memory.ReadWord(addr)
+		if err != nil {
+			return accessFault(CAUSE_LOAD_ACCESS, addr, fmt.Errorf("LR failed: %w", err))
+		}
+		c.SetReg(rd, value)
+		c.reservation = addr
+		c.reservationValid = true
+		return nil
+	case assembler.FUNCT5_SC:
+		success := c.reservationValid && c.reservation == addr
+		c.reservationValid = false
+		if !success {
+			c.SetReg(rd, 1)
+			return nil
+		}
+		if err := memory.WriteWord(addr, src); err != nil {
+			return accessFault(CAUSE_STORE_ACCESS, addr, fmt.Errorf("SC failed: %w", err))
+		}
+		c.SetReg(rd, 0)
+		return nil
+	}
+
+	old, err := memory.ReadWord(addr)
+	if err != nil {
+		return accessFault(CAUSE_STORE_ACCESS, addr, fmt.Errorf("AMO failed: %w", err))
+	}
+	var result uint32
+	switch instr.Funct5() {
+	case assembler.FUNCT5_AMOSWAP:
+		result = src
+	case assembler.FUNCT5_AMOADD:
+		result = old + src
+	case assembler.FUNCT5_AMOXOR:
+		result = old ^ src
+	case assembler.FUNCT5_AMOAND:
+		result = old & src
+	case assembler.FUNCT5_AMOOR:
+		result = old | src
+	case assembler.FUNCT5_AMOMIN:
+		result = old
+		if int32(src) < int32(old) {
+			result = src
+		}
+	case assembler.FUNCT5_AMOMAX:
+		result = old
+		if int32(src) > int32(old) {
+			result = src
+		}
+	case assembler.FUNCT5_AMOMINU:
+		result = min(old, src)
+	case assembler.FUNCT5_AMOMAXU:
+		result = max(old, src)
+	default:
+		return fmt.Errorf("unknown AMO funct5: 0x%X", instr.Funct5())
+	}
+	if err := memory.WriteWord(addr, result); err != nil {
+		return accessFault(CAUSE_STORE_ACCESS, addr, fmt.Errorf("AMO failed: %w", err))
+	}
+	c.invalidateReservation(addr, 4)
+	c.SetReg(rd, old)
+	return nil
+}
+
+// invalidateReservation clears the LR reservation if a store of size bytes at addr
+// touches the reserved word.
+func (c *CPU) invalidateReservation(addr uint32, size uint32) {
+	if !c.reservationValid {
+		return
+	}
+	if addr&^3 == c.reservation || (addr+size-1)&^3 == c.reservation {
+		c.reservationValid = false
+	}
+}
@@ -0,0 +1,117 @@
+package arch
+
+import (
+	"testing"
+
+	"github.com/malikwirin/riscvemu/assembler"
+	"github.com/stretchr/testify/assert"
+)
+
+// runInstructions assembles lines into memory at address 0 and executes them one by one.
+func runInstructions(t *testing.T, cpu *CPU, mem *Memory, lines ...string) {
+	t.Helper()
+	for i, line := range lines {
+		instr, err := assembler.ParseInstruction(line)
+		assert.NoErrorf(t, err, "ParseInstruction(%q)", line)
+		assert.NoError(t, mem.WriteWord(uint32(i*4), uint32(instr)))
+	}
+	cpu.PC = 0
+	for range lines {
+		assert.NoError(t, cpu.Step(mem))
+	}
+}
+
+func TestCPU_LRSC_Success(t *testing.T) {
+	cpu, mem := NewCPU(), NewMemory(256)
+	assert.NoError(t, mem.WriteWord(128, 7))
+	cpu.Reg[2] = 128
+	cpu.Reg[3] = 42
+	runInstructions(t, cpu, mem,
+		"lr.w x1, (x2)",
+		"sc.w x4, x3, (x2)",
+	)
+	assert.Equal(t, uint32(7), cpu.Reg[1], "LR.W loads the old value")
+	assert.Equal(t, uint32(0), cpu.Reg[4], "SC.W reports success with 0")
+	got, _ := mem.ReadWord(128)
+	assert.Equal(t, uint32(42), got, "SC.W stores the new value")
+}
+
+func TestCPU_SC_FailsWithoutReservation(t *testing.T) {
+	cpu, mem := NewCPU(), NewMemory(256)
+	assert.NoError(t, mem.WriteWord(128, 7))
+	cpu.Reg[2] = 128
+	cpu.Reg[3] = 42
+	runInstructions(t, cpu, mem, "sc.w x4, x3, (x2)")
+	assert.Equal(t, uint32(1), cpu.Reg[4], "SC.W reports failure with 1")
+	got, _ := mem.ReadWord(128)
+	assert.Equal(t, uint32(7), got, "failed SC.W must not store")
+}
+
+func TestCPU_SC_FailsAfterInterveningStore(t *testing.T) {
+	cases := []string{"sw x0, 0(x2)", "sb x0, 3(x2)", "amoadd.w x0, x3, (x2)"}
+	for _, store := range cases {
+		t.Run(store, func(t *testing.T) {
+			cpu, mem := NewCPU(), NewMemory(256)
+			cpu.Reg[2] = 128
+			cpu.Reg[3] = 42
+			runInstructions(t, cpu, mem,
+				"lr.w x1, (x2)",
+				store,
+				"sc.w x4, x3, (x2)",
+			)
+			assert.Equal(t, uint32(1), cpu.Reg[4], "SC.W must fail after %s", store)
+		})
+	}
+}
+
+func TestCPU_SC_ReservationSurvivesUnrelatedStore(t *testing.T) {
+	cpu, mem := NewCPU(), NewMemory(256)
+	cpu.Reg[2] = 128
+	cpu.Reg[3] = 42
+	runInstructions(t, cpu, mem,
+		"lr.w x1, (x2)",
+		"sw x3, 4(x2)",
+		"sc.w x4, x3, (x2)",
+	)
+	assert.Equal(t, uint32(0), cpu.Reg[4])
+}
+
+func TestCPU_AMO(t *testing.T) {
+	tests := []struct {
+		asm     string
+		old     uint32
+		src     uint32
+		wantMem uint32
+	}{
+		{"amoswap.w x1, x3, (x2)", 5, 9, 9},
+		{"amoadd.w x1, x3, (x2)", 5, 9, 14},
+		{"amoxor.w x1, x3, (x2)", 0xF0, 0xFF, 0x0F},
+		{"amoand.w x1, x3, (x2)", 0xF0, 0x3C, 0x30},
+		{"amoor.w.aqrl x1, x3, (x2)", 0xF0, 0x0F, 0xFF},
+		{"amomin.w x1, x3, (x2)", 5, 0xFFFFFFFF, 0xFFFFFFFF},
+		{"amomax.w x1, x3, (x2)", 5, 0xFFFFFFFF, 5},
+		{"amominu.w x1, x3, (x2)", 5, 0xFFFFFFFF, 5},
+		{"amomaxu.w.aq x1, x3, (x2)", 5, 0xFFFFFFFF, 0xFFFFFFFF},
+	}
+	for _, tc := range tests {
+		t.Run(tc.asm, func(t *testing.T) {
+			cpu, mem := NewCPU(), NewMemory(256)
+			assert.NoError(t, mem.WriteWord(128, tc.old))
+			cpu.Reg[2] = 128
+			cpu.Reg[3] = tc.src
+			runInstructions(t, cpu, mem, tc.asm)
+			assert.Equal(t, tc.old, cpu.Reg[1], "rd receives the old value")
+			got, _ := mem.ReadWord(128)
+			assert.Equal(t, tc.wantMem, got)
+		})
+	}
+}
+
+func TestCPU_AMO_Misaligned(t *testing.T) {
+	cpu := NewCPU()
+	mem := NewMemory(256)
+	instr, _ := assembler.ParseInstruction("amoadd.w x1, x3, (x2)")
+	assert.NoError(t, mem.WriteWord(0, uint32(instr)))
+	cpu.Reg[2] = 130
+	assert.Error(t, cpu.Step(mem))
+}
@@ -0,0 +1,202 @@
+package arch
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrUnmapped is reported for accesses to addresses no device is mapped at.
+var ErrUnmapped = errors.New("no device mapped")
+
+// ErrReadOnly is reported for writes to read-only regions such as ROM.
+var ErrReadOnly = errors.New("region is read-only")
+
+// Region is an address range on the bus served by one device. The device sees
+// addresses relative to Base.
+type Region struct {
+	Name   string
+	Base   uint32
+	Size   uint32
+	Device WordHandler
+}
+
+// Contains reports whether addr lies in the region.
+func (r *Region) Contains(addr uint32) bool {
+	return addr >= r.Base && addr-r.Base < r.Size
+}
+
+// BusError describes a failed bus access. Region is empty if nothing is mapped at Addr.
+type BusError struct {
+	Addr   uint32
+	Size   uint32 // access size in bytes
+	Write  bool
+	Region string
+	Err    error
+}
+
+func (e *BusError) Error() string {
+	op := "read"
+	if e.Write {
+		op = "write"
+	}
+	if e.Region == "" {
+		return fmt.Sprintf("bus: %s of %d byte(s) at 0x%08x: %v", op, e.Size, e.Addr, e.Err)
+	}
+	return fmt.Sprintf("bus: %s of %d byte(s) at 0x%08x (%s): %v", op, e.Size, e.Addr, e.Region, e.Err)
+}
+
+func (e *BusError) Unwrap() error {
+	return e.Err
+}
+
+// Bus is the physical address space: RAM, ROM and devices are mapped into
+// non-overlapping regions, and each access is routed to the device owning the
+// address. An access must lie entirely within one region.
+type Bus struct {
+	regions []*Region // sorted by Base
+}
+
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Map attaches device at [base, base+size).
+func (b *Bus) Map(name string, base, size uint32, device WordHandler) error {
+	if size == 0 || base+size-1 < base {
+		return fmt.Errorf("invalid region %s: base 0x%08x, size 0x%x", name, base, size)
+	}
+	region := &Region{Name: name, Base: base, Size: size, Device: device}
+	for _, r := range b.regions {
+		if base <= r.Base+r.Size-1 && r.Base <= base+size-1 {
+			return fmt.Errorf("region %s at 0x%08x overlaps %s at 0x%08x", name, base, r.Name, r.Base)
+		}
+	}
+	b.regions = append(b.regions, region)
+	sort.Slice(b.regions, func(i, j int) bool { return b.regions[i].Base < b.regions[j].Base })
+	return nil
+}
+
+// Regions returns the mapped regions in address order.
+func (b *Bus) Regions() []*Region {
+	return b.regions
+}
+
+// Region returns the region containing addr, or nil.
+func (b *Bus) Region(addr uint32) *Region {
+	i := sort.Search(len(b.regions), func(i int) bool { return b.regions[i].Base+b.regions[i].Size-1 >= addr })
+	if i < len(b.regions) && b.regions[i].Contains(addr) {
+		return b.regions[i]
+	}
+	return nil
+}
+
+// route finds the region for an access of size bytes at addr and returns it with
+// the device-relative address.
+func (b *Bus) route(addr, size uint32, write bool) (*Region, uint32, error) {
+	r := b.Region(addr)
+	if r == nil {
+		return nil, 0, &BusError{Addr: addr, Size: size, Write: write, Err: ErrUnmapped}
+	}
+	if uint64(addr-r.Base)+uint64(size) > uint64(r.Size) {
+		return nil, 0, &BusError{Addr: addr, Size: size, Write: write, Region: r.Name, Err: fmt.Errorf("access crosses the end of the region")}
+	}
+	return r, addr - r.Base, nil
+}
+
+// fault wraps a device error as a BusError.
+func fault(r *Region, addr, size uint32, write bool, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &BusError{Addr: addr, Size: size, Write: write, Region: r.Name, Err: err}
+}
+
+func (b *Bus) ReadWord(addr uint32) (uint32, error) {
+	r, offset, err := b.route(addr, 4, false)
+	if err != nil {
+		return 0, err
+	}
+	v, err := r.Device.ReadWord(offset)
+	return v, fault(r, addr, 4, false, err)
+}
+
+func (b *Bus) WriteWord(addr uint32, value uint32) error {
+	r, offset, err := b.route(addr, 4, true)
+	if err != nil {
+		return err
+	}
+	return fault(r, addr, 4, true, r.Device.WriteWord(offset, value))
+}
+
+func (b *Bus) ReadHalf(addr uint32) (uint16, error) {
+	r, offset, err := b.route(addr, 2, false)
+	if err != nil {
+		return 0, err
+	}
+	v, err := readHalf(r.Device, offset)
+	return v, fault(r, addr, 2, false, err)
+}
+
+func (b *Bus) WriteHalf(addr uint32, value uint16) error {
+	r, offset, err := b.route(addr, 2, true)
+	if err != nil {
+		return err
+	}
+	return fault(r, addr, 2, true, writeHalf(r.Device, offset, value))
+}
+
+func (b *Bus) ReadByteAt(addr uint32) (uint8, error) {
+	r, offset, err := b.route(addr, 1, false)
+	if err != nil {
+		return 0, err
+	}
+	v, err := readByte(r.Device, offset)
+	return v, fault(r, addr, 1, false, err)
+}
+
+func (b *Bus) WriteByteAt(addr uint32, value uint8) error {
+	r, offset, err := b.route(addr, 1, true)
+	if err != nil {
+		return err
+	}
+	return fault(r, addr, 1, true, writeByte(r.Device, offset, value))
+}
+
+// Zero clears size bytes at addr, which must lie within one region.
+func (b *Bus) Zero(addr, size uint32) error {
+	if size == 0 {
+		return nil
+	}
+	r, offset, err := b.route(addr, size, true)
+	if err != nil {
+		return err
+	}
+	return fault(r, addr, size, true, zeroRange(r.Device, offset, size))
+}
+
+var _ SubWordHandler = (*Bus)(nil)
+var _ WordHandler = (*Bus)(nil)
+var _ Zeroer = (*Bus)(nil)
+
+// ROM is read-only memory. Its contents are set up through Data; writes from
+// the bus fail with ErrReadOnly.
+type ROM struct {
+	Data []byte
+}
+
+func NewROM(data []byte) *ROM {
+	return &ROM{Data: data}
+}
+
+func (r *ROM) ReadWord(addr uint32) (uint32, error) {
+	if uint64(addr)+4 > uint64(len(r.Data)) {
+		return 0, &MemoryError{Addr: addr, Size: 4, Err: ErrOutOfBounds}
+	}
+	return binary.LittleEndian.Uint32(r.Data[addr : addr+4]), nil
+}
+
+func (r *ROM) WriteWord(addr uint32, value uint32) error {
+	return ErrReadOnly
+}
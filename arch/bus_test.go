@@ -0,0 +1,114 @@
+package arch
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// wordDevice is a word-only device that records the offsets it is accessed at.
+type wordDevice struct {
+	words  map[uint32]uint32
+	writes []uint32
+}
+
+func (d *wordDevice) ReadWord(addr uint32) (uint32, error) {
+	return d.words[addr], nil
+}
+
+func (d *wordDevice) WriteWord(addr uint32, value uint32) error {
+	d.words[addr] = value
+	d.writes = append(d.writes, addr)
+	return nil
+}
+
+func TestBus_Routing(t *testing.T) {
+	bus := NewBus()
+	ram := NewMemory(0x100)
+	dev := &wordDevice{words: map[uint32]uint32{}}
+	assert.NoError(t, bus.Map("ram", 0, 0x100, ram))
+	assert.NoError(t, bus.Map("dev", 0x1000, 0x10, dev))
+
+	assert.NoError(t, bus.WriteWord(0x10, 0x11223344))
+	v, _ := ram.ReadWord(0x10)
+	assert.Equal(t, uint32(0x11223344), v)
+
+	assert.NoError(t, bus.WriteWord(0x1004, 0xAABBCCDD))
+	assert.Equal(t, uint32(0xAABBCCDD), dev.words[4], "devices see offsets relative to their base")
+
+	b, err := bus.ReadByteAt(0x1006)
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(0xBB), b)
+	h, err := bus.ReadHalf(0x12)
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(0x1122), h)
+
+	// sub-word writes to word-only devices go through the containing word
+	assert.NoError(t, bus.WriteHalf(0x1004, 0x5566))
+	assert.Equal(t, uint32(0xAABB5566), dev.words[4])
+	assert.NoError(t, bus.WriteByteAt(0x1007, 0x77))
+	assert.Equal(t, uint32(0x77BB5566), dev.words[4])
+
+	assert.Equal(t, "dev", bus.Region(0x100F).Name)
+	assert.Nil(t, bus.Region(0x1010))
+	assert.Len(t, bus.Regions(), 2)
+}
+
+func TestBus_Faults(t *testing.T) {
+	bus := NewBus()
+	assert.NoError(t, bus.Map("ram", 0, 0x100, NewMemory(0x100)))
+	assert.NoError(t, bus.Map("rom", 0x2000, 8, NewROM([]byte{1, 2, 3, 4, 5, 6, 7, 8})))
+
+	_, err := bus.ReadWord(0x500)
+	var busErr *BusError
+	if assert.True(t, errors.As(err, &busErr)) {
+		assert.Equal(t, uint32(0x500), busErr.Addr)
+		assert.Equal(t, uint32(4), busErr.Size)
+		assert.False(t, busErr.Write)
+	}
+	assert.ErrorIs(t, err, ErrUnmapped)
+
+	err = bus.WriteByteAt(0x2001, 0)
+	assert.ErrorIs(t, err, ErrReadOnly)
+	assert.Contains(t, err.Error(), "write of 1 byte(s) at 0x00002001 (rom)")
+	v, err := bus.ReadWord(0x2004)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0x08070605), v)
+
+	_, err = bus.ReadWord(0xFE)
+	assert.Error(t, err, "an access must not cross the end of a region")
+	assert.ErrorAs(t, err, &busErr)
+	assert.Equal(t, "ram", busErr.Region)
+
+	// a region smaller than the access
+	assert.NoError(t, bus.Map("tiny", 0x3000, 2, NewROM([]byte{1, 2})))
+	_, err = bus.ReadWord(0x3000)
+	assert.ErrorContains(t, err, "access crosses the end of the region")
+
+	// a ROM region larger than its contents
+	assert.NoError(t, bus.Map("short", 0x4000, 8, NewROM([]byte{1, 2, 3, 4})))
+	_, err = bus.ReadWord(0x4004)
+	assert.ErrorIs(t, err, ErrOutOfBounds)
+}
+
+func TestROM_OutOfBounds(t *testing.T) {
+	rom := NewROM([]byte{1, 2, 3, 4})
+	_, err := rom.ReadWord(2)
+	assert.ErrorIs(t, err, ErrOutOfBounds)
+	var memErr *MemoryError
+	if assert.ErrorAs(t, err, &memErr) {
+		assert.Equal(t, uint32(2), memErr.Addr)
+	}
+}
+
+func TestBus_Map(t *testing.T) {
+	bus := NewBus()
+	assert.NoError(t, bus.Map("a", 0x1000, 0x1000, NewMemory(0x1000)))
+	assert.Error(t, bus.Map("b", 0x1800, 0x1000, NewMemory(0x1000)), "overlap")
+	assert.Error(t, bus.Map("c", 0x0, 0x1001, NewMemory(0x1001)), "overlap")
+	assert.Error(t, bus.Map("d", 0x3000, 0, NewMemory(0)), "empty region")
+	assert.Error(t, bus.Map("e", 0xFFFFF000, 0x2000, NewMemory(0)), "wraps around")
+	assert.NoError(t, bus.Map("f", 0x0, 0x1000, NewMemory(0x1000)))
+	assert.Equal(t, "f", bus.Regions()[0].Name, "regions are kept in address order")
+}
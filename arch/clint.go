@@ -0,0 +1,128 @@
+package arch
+
+import (
+	"fmt"
+	"time"
+)
+
+// CLINT register layout (SiFive compatible, a single hart), relative to the
+// CLINT's base address on the bus. The 64-bit timer registers are accessed as
+// two 32-bit words, low word first.
+const (
+	CLINT_BASE uint32 = 0x02000000
+	CLINT_SIZE uint32 = 0x10000
+
+	CLINT_MSIP     uint32 = 0x0000
+	CLINT_MTIMECMP uint32 = 0x4000
+	CLINT_MTIME    uint32 = 0xBFF8
+)
+
+// CLINT is the core-local interruptor: it provides the machine timer (mtime and
+// mtimecmp) and the machine software interrupt (msip). By default mtime counts
+// retired instructions (see Tick); UseClock drives it from the host clock instead.
+type CLINT struct {
+	msip     uint32
+	mtimecmp uint64
+	// mtime is offset plus the ticks of the clock, if any
+	offset uint64
+	clock  func() uint64
+}
+
+func NewCLINT() *CLINT {
+	c := &CLINT{}
+	c.Reset()
+	return c
+}
+
+// Reset clears msip and mtime and disarms the timer. A clock set by UseClock is kept.
+func (c *CLINT) Reset() {
+	c.msip = 0
+	c.mtimecmp = ^uint64(0)
+	c.SetTime(0)
+}
+
+// UseClock makes mtime advance at frequency ticks per second of host time.
+func (c *CLINT) UseClock(frequency uint64) {
+	c.useClock(frequency, time.Now)
+}
+
+func (c *CLINT) useClock(frequency uint64, now func() time.Time) {
+	current := c.Time()
+	start := now()
+	c.clock = func() uint64 {
+		elapsed := now().Sub(start)
+		seconds, rest := uint64(elapsed/time.Second), uint64(elapsed%time.Second)
+		return seconds*frequency + rest*frequency/uint64(time.Second)
+	}
+	c.SetTime(current)
+}
+
+// Tick advances mtime by one retired instruction. It has no effect while a clock drives mtime.
+func (c *CLINT) Tick() {
+	if c.clock == nil {
+		c.offset++
+	}
+}
+
+// Time returns the current value of mtime.
+func (c *CLINT) Time() uint64 {
+	if c.clock == nil {
+		return c.offset
+	}
+	return c.offset + c.clock()
+}
+
+// SetTime sets mtime to value.
+func (c *CLINT) SetTime(value uint64) {
+	c.offset = value
+	if c.clock != nil {
+		c.offset -= c.clock()
+	}
+}
+
+// TimerPending reports whether the machine timer interrupt is pending (mtime >= mtimecmp).
+func (c *CLINT) TimerPending() bool {
+	return c.Time() >= c.mtimecmp
+}
+
+// SoftwarePending reports whether the machine software interrupt is pending.
+func (c *CLINT) SoftwarePending() bool {
+	return c.msip&1 != 0
+}
+
+func (c *CLINT) ReadWord(offset uint32) (uint32, error) {
+	switch offset {
+	case CLINT_MSIP:
+		return c.msip, nil
+	case CLINT_MTIMECMP:
+		return uint32(c.mtimecmp), nil
+	case CLINT_MTIMECMP + 4:
+		return uint32(c.mtimecmp >> 32), nil
+	case CLINT_MTIME:
+		return uint32(c.Time()), nil
+	case CLINT_MTIME + 4:
+		return uint32(c.Time() >> 32), nil
+	default:
+		return 0, fmt.Errorf("CLINT: no register at offset 0x%04x", offset)
+	}
+}
+
+func (c *CLINT) WriteWord(offset uint32, value uint32) error {
+	switch offset {
+	case CLINT_MSIP:
+		c.msip = value & 1
+	case CLINT_MTIMECMP:
+		c.mtimecmp = c.mtimecmp&^0xFFFFFFFF | uint64(value)
+	case CLINT_MTIMECMP + 4:
+		c.mtimecmp = c.mtimecmp&0xFFFFFFFF | uint64(value)<<32
+	case CLINT_MTIME:
+		c.SetTime(c.Time()&^0xFFFFFFFF | uint64(value))
+	case CLINT_MTIME + 4:
+		c.SetTime(c.Time()&0xFFFFFFFF | uint64(value)<<32)
+	default:
+		return fmt.Errorf("CLINT: no register at offset 0x%04x", offset)
+	}
+	return nil
+}
+
+var _ WordHandler = (*CLINT)(nil)
@@ -0,0 +1,55 @@
+package arch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCLINT_Registers(t *testing.T) {
+	c := NewCLINT()
+	assert.False(t, c.TimerPending(), "the timer is disarmed after reset")
+	assert.False(t, c.SoftwarePending())
+
+	assert.NoError(t, c.WriteWord(CLINT_MSIP, 0xFFFFFFFF))
+	v, _ := c.ReadWord(CLINT_MSIP)
+	assert.Equal(t, uint32(1), v, "only bit 0 of msip is writable")
+	assert.True(t, c.SoftwarePending())
+
+	assert.NoError(t, c.WriteWord(CLINT_MTIME, 0xFFFFFFFE))
+	assert.NoError(t, c.WriteWord(CLINT_MTIME+4, 1))
+	assert.Equal(t, uint64(0x1FFFFFFFE), c.Time())
+	c.Tick()
+	c.Tick()
+	lo, _ := c.ReadWord(CLINT_MTIME)
+	hi, _ := c.ReadWord(CLINT_MTIME + 4)
+	assert.Equal(t, []uint32{0, 2}, []uint32{lo, hi})
+
+	assert.NoError(t, c.WriteWord(CLINT_MTIMECMP, 5))
+	assert.NoError(t, c.WriteWord(CLINT_MTIMECMP+4, 2))
+	assert.False(t, c.TimerPending())
+	for i := 0; i < 5; i++ {
+		c.Tick()
+	}
+	assert.True(t, c.TimerPending(), "mtime >= mtimecmp")
+
+	_, err := c.ReadWord(0x100)
+	assert.Error(t, err)
+}
+
+func TestCLINT_Clock(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := NewCLINT()
+	c.SetTime(100)
+	c.useClock(1000, func() time.Time { return now }) // 1 kHz
+	assert.Equal(t, uint64(100), c.Time())
+
+	now = now.Add(2500 * time.Millisecond)
+	c.Tick() // ignored while the clock drives mtime
+	assert.Equal(t, uint64(2600), c.Time())
+
+	c.SetTime(0)
+	now = now.Add(time.Second)
+	assert.Equal(t, uint64(1000), c.Time())
+}
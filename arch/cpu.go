@@ -1,22 +1,58 @@
 package arch
 
 import (
+	"errors"
 	"fmt"
 	"github.com/malikwirin/riscvemu/assembler"
-	"reflect"
 )
 
 type CPU struct {
 	Reg [32]uint32
 	PC  uint32
+
+	// FReg is the F/D register file. Single-precision values are NaN-boxed
+	// into the lower half of a register (see BoxSingle).
+	FReg [32]uint64
+	// FCSR holds the FP exception flags and the dynamic rounding mode.
+	FCSR uint32
+
+	// Priv is the current privilege level (PRIV_M, PRIV_S or PRIV_U).
+	Priv uint32
+
+	// machine- and supervisor-level CSRs, accessed through ReadCSR/WriteCSR
+	csr csrFile
+	// tlb caches Sv32 translations, see translate
+	tlb map[uint32]tlbEntry
+
+	// Traps selects how exceptions are handled: when set they trap to the
+	// handler at mtvec, otherwise Step stops and returns them as errors.
+	Traps bool
+
+	// Env, if set, serves ECALLs instead of the trap handler (see Environment).
+	Env Environment
+	// Semihost, if set, serves semihosting calls (EBREAK between the marker
+	// instructions) instead of the trap handler.
+	Semihost *Semihosting
+
+	// LR/SC reservation: the word address reserved by the last LR.W
+	reservation      uint32
+	reservationValid bool
 }
 
 const INSTRUCTION_SIZE = assembler.INSTRUCTION_SIZE
 
+// ErrEcall is returned by Step after executing an ECALL instruction (unless Traps is set).
+var ErrEcall = errors.New("environment call")
+
+// ErrBreakpoint is returned by Step after executing an EBREAK instruction (unless Traps is set).
+var ErrBreakpoint = errors.New("breakpoint")
+
 func NewCPU() *CPU {
 	return &CPU{
-		Reg: [32]uint32{},
-		PC:  0,
+		Reg:  [32]uint32{},
+		PC:   0,
+		Priv: PRIV_M,
+		csr:  newCSRFile(),
 	}
 }
 
@@ -26,160 +62,113 @@ func (c *CPU) SetReg(idx RegIndex, value uint32) {
 	}
 }
 
-func (c *CPU) exec(instr assembler.Instruction, memory WordHandler) error {
-	opcode := instr.Opcode()
-	if opcode == assembler.OPCODE_INVALID {
-		return fmt.Errorf("invalid opcode: 0x%X (from instruction 0x%X)", opcode, uint32(instr))
-	}
-	switch opcode {
-	case assembler.OPCODE_R_TYPE:
-		switch instr.Funct3() {
-		case assembler.FUNCT3_ADD_SUB:
-			rd := instr.Rd()
-			rs1 := instr.Rs1()
-			rs2 := instr.Rs2()
-			if instr.Funct7() == assembler.FUNCT7_ADD {
-				if rd != 0 {
-					c.Reg[rd] = c.Reg[rs1] + c.Reg[rs2]
-				}
-			} else if instr.Funct7() == assembler.FUNCT7_SUB {
-				if rd != 0 {
-					c.Reg[rd] = c.Reg[rs1] - c.Reg[rs2]
-				}
-			} else {
-				return fmt.Errorf("unknown R-type funct7: 0x%X", instr.Funct7())
-			}
-		case assembler.FUNCT3_SLT:
-			rd := instr.Rd()
-			rs1 := instr.Rs1()
-			rs2 := instr.Rs2()
-			if rd != 0 {
-				// signed comparison!
-				if int32(c.Reg[rs1]) < int32(c.Reg[rs2]) {
-					c.Reg[rd] = 1
-				} else {
-					c.Reg[rd] = 0
-				}
-			}
-		default:
-			fmt.Printf("[DEBUG] instr=%#v, type=%T, reflect.Kind=%v, uint32(instr)=%#x\n", instr, instr, reflect.TypeOf(instr).Kind(), uint32(instr))
-			return fmt.Errorf("unknown R-type funct3: 0x%X", instr.Funct3())
+// exec executes a single (expanded) instruction. size is the length in bytes of the
+// instruction as it was fetched, which determines the address of the next instruction.
+func (c *CPU) exec(instr assembler.Instruction, size uint32, memory WordHandler) error {
+	spec := assembler.Decode(instr)
+	if spec == nil {
+		return fmt.Errorf("unknown instruction: 0x%08X", uint32(instr))
+	}
+	execute, ok := spec.Exec().(executor)
+	if !ok {
+		return fmt.Errorf("unimplemented instruction: %s", spec.Mnemonic)
+	}
+	return execute(c, instr, size, memory)
+}
+
+// fetch reads the instruction at PC. Compressed (16-bit) instructions are expanded
+// to their 32-bit equivalent; size reports how many bytes were consumed.
+func (c *CPU) fetch(memory WordHandler) (instr assembler.Instruction, size uint32, err error) {
+	if c.PC%2 != 0 {
+		return 0, 0, &Exception{Cause: CAUSE_MISALIGNED_FETCH, Tval: c.PC}
+	}
+	var word uint32
+	if c.PC%4 == 0 {
+		word, err = memory.ReadWord(c.PC)
+		if err != nil {
+			return 0, 0, accessFault(CAUSE_FETCH_ACCESS, c.PC, err)
 		}
-	case assembler.OPCODE_I_TYPE:
-		switch instr.Funct3() {
-		case assembler.FUNCT3_ADDI:
-			rd := instr.Rd()
-			rs1 := instr.Rs1()
-			imm := instr.ImmI()
-			if rd != 0 {
-				c.Reg[rd] = c.Reg[rs1] + uint32(imm)
-			}
-		case assembler.FUNCT3_SLLI:
-			rd := instr.Rd()
-			rs1 := instr.Rs1()
-			shamt := instr.ImmI() & 0x1F
-			if rd != 0 {
-				c.Reg[rd] = c.Reg[rs1] << shamt
-			}
-		default:
-			return fmt.Errorf("unknown I-type funct3: 0x%X", instr.Funct3())
+	} else {
+		// 2-byte aligned: the instruction may span two words
+		lo, err := readHalf(memory, c.PC)
+		if err != nil {
+			return 0, 0, accessFault(CAUSE_FETCH_ACCESS, c.PC, err)
 		}
-	case assembler.OPCODE_LOAD:
-		rd := instr.Rd()
-		rs1 := instr.Rs1()
-		imm := instr.ImmI()
-		addr := c.Reg[rs1] + uint32(imm)
-		switch instr.Funct3() {
-		case assembler.FUNCT3_LW: // Load Word
-			value, err := memory.ReadWord(addr)
+		word = uint32(lo)
+		if !assembler.IsCompressed(lo) {
+			hi, err := readHalf(memory, c.PC+2)
 			if err != nil {
-				return fmt.Errorf("LOAD failed: %w", err)
-			}
-			if rd != 0 {
-				c.Reg[rd] = value
-			}
-			return nil
-		default:
-			return fmt.Errorf("unsupported LOAD funct3: 0x%X", instr.Funct3())
-		}
-	case assembler.OPCODE_STORE:
-		rs1 := instr.Rs1()
-		rs2 := instr.Rs2()
-		imm := instr.ImmS()
-		addr := c.Reg[rs1] + uint32(imm)
-		value := c.Reg[rs2]
-		switch instr.Funct3() {
-		case assembler.FUNCT3_SW: // Store Word
-			return memory.WriteWord(addr, value)
-		default:
-			return fmt.Errorf("unsupported STORE funct3: 0x%X", instr.Funct3())
-		}
-	case assembler.OPCODE_BRANCH:
-		rs1 := instr.Rs1()
-		rs2 := instr.Rs2()
-		imm := instr.ImmB()
-		switch instr.Funct3() {
-		case assembler.FUNCT3_BEQ:
-			if c.Reg[rs1] == c.Reg[rs2] {
-				c.PC = uint32(int32(c.PC) + imm)
-				return nil
-			} else {
-				c.PC += INSTRUCTION_SIZE
-				return nil
+				return 0, 0, accessFault(CAUSE_FETCH_ACCESS, c.PC+2, err)
 			}
-		case assembler.FUNCT3_BNE:
-			if c.Reg[rs1] != c.Reg[rs2] {
-				c.PC = uint32(int32(c.PC) + imm)
-				return nil
-			} else {
-				c.PC += INSTRUCTION_SIZE
-				return nil
-			}
-		default:
-			return fmt.Errorf("unknown branch funct3: 0x%X", instr.Funct3())
-		}
-	case assembler.OPCODE_JAL:
-		rd := instr.Rd()
-		imm := instr.ImmJ()
-		if rd != 0 {
-			c.Reg[rd] = c.PC + INSTRUCTION_SIZE
+			word |= uint32(hi) << 16
 		}
-		c.PC = uint32(int32(c.PC) + imm)
-		return nil
-	case assembler.OPCODE_JALR:
-		rd := instr.Rd()
-		rs1 := instr.Rs1()
-		imm := instr.ImmI()
-		next := c.PC + INSTRUCTION_SIZE
-		target := (c.Reg[rs1] + uint32(imm)) &^ 1
-		if rd != 0 {
-			c.Reg[rd] = next
+	}
+	if assembler.IsCompressed(uint16(word)) {
+		half := assembler.Instruction(uint16(word))
+		instr, err = assembler.Expand(uint16(word))
+		if err != nil {
+			return half, assembler.COMPRESSED_SIZE, illegalInstruction(half, err)
 		}
-		c.PC = target
-		return nil
-	default:
-		return fmt.Errorf("unknown or unimplemented opcode: 0x%X (Opcode: 0x%X, type: %T)", instr.Opcode(), instr.Opcode(), instr)
+		return instr, assembler.COMPRESSED_SIZE, nil
 	}
-	return nil
+	return assembler.Instruction(word), INSTRUCTION_SIZE, nil
 }
 
+// Step executes a single instruction, or enters the trap handler instead if an
+// enabled interrupt is pending. Exceptions are taken as traps if Traps is set;
+// otherwise execution stops at the faulting instruction and the exception is
+// returned. ECALL and EBREAK complete in that case, leaving PC after them.
 func (c *CPU) Step(memory WordHandler) error {
-	word, err := memory.ReadWord(c.PC)
-	fmt.Printf("[CPU] Step: PC = %#x, Instruction = %#x\n", c.PC, word)
-	if err != nil {
-		return err
+	if cause, ok := c.pendingInterrupt(); ok {
+		c.takeTrap(&Exception{Cause: cause})
+		return nil
+	}
+	instr, size, err := c.fetch(c.mmu(memory, accessFetch))
+	if err == nil {
+		err = c.exec(instr, size, memory)
 	}
-	instr := assembler.Instruction(word)
-	err = c.exec(instr, memory)
 	if err != nil {
-		return err
+		return c.raise(instr, size, err)
 	}
-	// Only increment PC if it wasn't already set (by branch/jump)
+	// Only increment PC if it wasn't already set (by branch/jump/trap return)
 	switch instr.Opcode() {
 	case assembler.OPCODE_BRANCH, assembler.OPCODE_JAL, assembler.OPCODE_JALR:
 		// PC already set
 	default:
-		c.PC += INSTRUCTION_SIZE
+		if !isTrapReturn(instr) {
+			c.PC += size
+		}
 	}
 	return nil
 }
+
+// raise handles an error from executing instr. Errors of the Environment are
+// returned as they are, with the PC past the ECALL; other errors that are not
+// exceptions already are reported as illegal instructions.
+func (c *CPU) raise(instr assembler.Instruction, size uint32, err error) error {
+	var envErr *environmentError
+	if errors.As(err, &envErr) {
+		c.PC += size
+		return envErr.err
+	}
+	var exc *Exception
+	if !errors.As(err, &exc) {
+		exc = illegalInstruction(instr, err)
+	}
+	if c.Traps {
+		c.takeTrap(exc)
+		return nil
+	}
+	if errors.Is(exc, ErrEcall) || errors.Is(exc, ErrBreakpoint) {
+		c.PC += size
+	}
+	return exc
+}
+
+// boolToUint32 converts a comparison result to the 0/1 value written by the set-less-than instructions.
+func boolToUint32(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -116,6 +116,48 @@ func TestCPU_Opcode_ALU_Branch_Jump_Memory(t *testing.T) {
 		{"JAL", "jal x5, 12", nil, 200, nil, map[int]uint32{5: 204}, 212},
 		{"JALR", "jalr x6, 4(x2)", func(c *CPU) { c.Reg[2] = 500 }, 100, nil, map[int]uint32{6: 104}, 504},
 		{"LW", "lw x3, 0(x2)", func(c *CPU) { c.Reg[2] = 100 }, 0, map[uint32]uint32{100: 0xDEADBEEF}, map[int]uint32{3: 0xDEADBEEF}, 4},
+		{"LB sign-extends", "lb x3, 1(x2)", func(c *CPU) { c.Reg[2] = 100 }, 0, map[uint32]uint32{100: 0xDEADBEEF}, map[int]uint32{3: 0xFFFFFFBE}, 4},
+		{"LBU", "lbu x3, 3(x2)", func(c *CPU) { c.Reg[2] = 100 }, 0, map[uint32]uint32{100: 0xDEADBEEF}, map[int]uint32{3: 0xDE}, 4},
+		{"LH sign-extends", "lh x3, 2(x2)", func(c *CPU) { c.Reg[2] = 100 }, 0, map[uint32]uint32{100: 0xDEADBEEF}, map[int]uint32{3: 0xFFFFDEAD}, 4},
+		{"LHU", "lhu x3, 0(x2)", func(c *CPU) { c.Reg[2] = 100 }, 0, map[uint32]uint32{100: 0xDEADBEEF}, map[int]uint32{3: 0xBEEF}, 4},
+		{"LHU across words", "lhu x3, 3(x2)", func(c *CPU) { c.Reg[2] = 100 }, 0, map[uint32]uint32{100: 0xDEADBEEF, 104: 0x12345678}, map[int]uint32{3: 0x78DE}, 4},
+		{"LUI", "lui x1, 0x12345", nil, 0, nil, map[int]uint32{1: 0x12345000}, 4},
+		{"AUIPC", "auipc x1, 1", nil, 0x100, nil, map[int]uint32{1: 0x1100}, 0x104},
+		{"SLTI", "slti x1, x2, -1", func(c *CPU) { c.Reg[2] = 0xFFFFFFFE }, 0, nil, map[int]uint32{1: 1}, 4},
+		{"SLTIU", "sltiu x1, x2, -1", func(c *CPU) { c.Reg[2] = 5 }, 0, nil, map[int]uint32{1: 1}, 4},
+		{"XORI", "xori x1, x2, 0xFF", func(c *CPU) { c.Reg[2] = 0x0F }, 0, nil, map[int]uint32{1: 0xF0}, 4},
+		{"ORI", "ori x1, x2, 0x0F", func(c *CPU) { c.Reg[2] = 0xF0 }, 0, nil, map[int]uint32{1: 0xFF}, 4},
+		{"ANDI", "andi x1, x2, 0x0F", func(c *CPU) { c.Reg[2] = 0xFF }, 0, nil, map[int]uint32{1: 0x0F}, 4},
+		{"SRLI", "srli x1, x2, 4", func(c *CPU) { c.Reg[2] = 0x80000000 }, 0, nil, map[int]uint32{1: 0x08000000}, 4},
+		{"SRAI", "srai x1, x2, 4", func(c *CPU) { c.Reg[2] = 0x80000000 }, 0, nil, map[int]uint32{1: 0xF8000000}, 4},
+		{"SLL", "sll x1, x2, x3", func(c *CPU) { c.Reg[2], c.Reg[3] = 1, 33 }, 0, nil, map[int]uint32{1: 2}, 4},
+		{"SLTU", "sltu x1, x2, x3", func(c *CPU) { c.Reg[2], c.Reg[3] = 1, 0xFFFFFFFF }, 0, nil, map[int]uint32{1: 1}, 4},
+		{"XOR", "xor x1, x2, x3", func(c *CPU) { c.Reg[2], c.Reg[3] = 0xFF00, 0x0FF0 }, 0, nil, map[int]uint32{1: 0xF0F0}, 4},
+		{"SRL", "srl x1, x2, x3", func(c *CPU) { c.Reg[2], c.Reg[3] = 0xF0000000, 28 }, 0, nil, map[int]uint32{1: 0xF}, 4},
+		{"SRA", "sra x1, x2, x3", func(c *CPU) { c.Reg[2], c.Reg[3] = 0xF0000000, 28 }, 0, nil, map[int]uint32{1: 0xFFFFFFFF}, 4},
+		{"OR", "or x1, x2, x3", func(c *CPU) { c.Reg[2], c.Reg[3] = 0xF0, 0x0F }, 0, nil, map[int]uint32{1: 0xFF}, 4},
+		{"AND", "and x1, x2, x3", func(c *CPU) { c.Reg[2], c.Reg[3] = 0xF0, 0x3C }, 0, nil, map[int]uint32{1: 0x30}, 4},
+		{"BLT taken", "blt x1, x2, 8", func(c *CPU) { c.Reg[1], c.Reg[2] = 0xFFFFFFFF, 1 }, 100, nil, nil, 108},
+		{"BLT not taken", "blt x1, x2, 8", func(c *CPU) { c.Reg[1], c.Reg[2] = 1, 0xFFFFFFFF }, 100, nil, nil, 104},
+		{"BGE taken", "bge x1, x2, -8", func(c *CPU) { c.Reg[1], c.Reg[2] = 3, 3 }, 100, nil, nil, 92},
+		{"BLTU taken", "bltu x1, x2, 8", func(c *CPU) { c.Reg[1], c.Reg[2] = 1, 0xFFFFFFFF }, 100, nil, nil, 108},
+		{"BGEU not taken", "bgeu x1, x2, 8", func(c *CPU) { c.Reg[1], c.Reg[2] = 1, 0xFFFFFFFF }, 100, nil, nil, 104},
+		{"FENCE", "fence", nil, 0, nil, nil, 4},
+		{"MUL", "mul x1, x2, x3", func(c *CPU) { c.Reg[2], c.Reg[3] = 6, 0xFFFFFFF9 }, 0, nil, map[int]uint32{1: 0xFFFFFFD6}, 4},
+		{"MULH", "mulh x1, x2, x3", func(c *CPU) { c.Reg[2], c.Reg[3] = 0x80000000, 0x80000000 }, 0, nil, map[int]uint32{1: 0x40000000}, 4},
+		{"MULH negative", "mulh x1, x2, x3", func(c *CPU) { c.Reg[2], c.Reg[3] = 0xFFFFFFFF, 5 }, 0, nil, map[int]uint32{1: 0xFFFFFFFF}, 4},
+		{"MULHSU", "mulhsu x1, x2, x3", func(c *CPU) { c.Reg[2], c.Reg[3] = 0xFFFFFFFF, 0xFFFFFFFF }, 0, nil, map[int]uint32{1: 0xFFFFFFFF}, 4},
+		{"MULHU", "mulhu x1, x2, x3", func(c *CPU) { c.Reg[2], c.Reg[3] = 0xFFFFFFFF, 0xFFFFFFFF }, 0, nil, map[int]uint32{1: 0xFFFFFFFE}, 4},
+		{"DIV", "div x1, x2, x3", func(c *CPU) { c.Reg[2], c.Reg[3] = 0xFFFFFFF9, 2 }, 0, nil, map[int]uint32{1: 0xFFFFFFFD}, 4},
+		{"DIV by zero", "div x1, x2, x3", func(c *CPU) { c.Reg[2], c.Reg[3] = 7, 0 }, 0, nil, map[int]uint32{1: 0xFFFFFFFF}, 4},
+		{"DIV overflow", "div x1, x2, x3", func(c *CPU) { c.Reg[2], c.Reg[3] = 0x80000000, 0xFFFFFFFF }, 0, nil, map[int]uint32{1: 0x80000000}, 4},
+		{"DIVU", "divu x1, x2, x3", func(c *CPU) { c.Reg[2], c.Reg[3] = 0xFFFFFFF9, 2 }, 0, nil, map[int]uint32{1: 0x7FFFFFFC}, 4},
+		{"DIVU by zero", "divu x1, x2, x3", func(c *CPU) { c.Reg[2], c.Reg[3] = 7, 0 }, 0, nil, map[int]uint32{1: 0xFFFFFFFF}, 4},
+		{"REM", "rem x1, x2, x3", func(c *CPU) { c.Reg[2], c.Reg[3] = 0xFFFFFFF9, 2 }, 0, nil, map[int]uint32{1: 0xFFFFFFFF}, 4},
+		{"REM by zero", "rem x1, x2, x3", func(c *CPU) { c.Reg[2], c.Reg[3] = 7, 0 }, 0, nil, map[int]uint32{1: 7}, 4},
+		{"REM overflow", "rem x1, x2, x3", func(c *CPU) { c.Reg[2], c.Reg[3] = 0x80000000, 0xFFFFFFFF }, 0, nil, map[int]uint32{1: 0}, 4},
+		{"REMU", "remu x1, x2, x3", func(c *CPU) { c.Reg[2], c.Reg[3] = 0xFFFFFFF9, 10 }, 0, nil, map[int]uint32{1: 9}, 4},
+		{"REMU by zero", "remu x1, x2, x3", func(c *CPU) { c.Reg[2], c.Reg[3] = 7, 0 }, 0, nil, map[int]uint32{1: 7}, 4},
 	}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
@@ -135,6 +177,43 @@ func TestCPU_Opcode_ALU_Branch_Jump_Memory(t *testing.T) {
 	}
 }
 
+func TestCPU_SubWordStores(t *testing.T) {
+	tests := []struct {
+		asm  string
+		want uint32
+	}{
+		{"sb x1, 1(x2)", 0x1122DD44},
+		{"sh x1, 2(x2)", 0xCCDD3344},
+		{"sw x1, 0(x2)", 0xAABBCCDD},
+	}
+	for _, tc := range tests {
+		t.Run(tc.asm, func(t *testing.T) {
+			mem := NewMemory(64)
+			instr, err := assembler.ParseInstruction(tc.asm)
+			assert.NoError(t, err)
+			assert.NoError(t, mem.WriteWord(0, uint32(instr)))
+			assert.NoError(t, mem.WriteWord(32, 0x11223344))
+			cpu := NewCPU()
+			cpu.Reg[1] = 0xAABBCCDD
+			cpu.Reg[2] = 32
+			assert.NoError(t, cpu.Step(mem))
+			got, err := mem.ReadWord(32)
+			assert.NoError(t, err)
+			assert.Equalf(t, tc.want, got, "memory after %s", tc.asm)
+		})
+	}
+}
+
+func TestCPU_EcallEbreak(t *testing.T) {
+	for asm, want := range map[string]error{"ecall": ErrEcall, "ebreak": ErrBreakpoint} {
+		cpu := NewCPU()
+		instr, _ := assembler.ParseInstruction(asm)
+		err := cpu.Step(&MockWordHandler{Instr: uint32(instr)})
+		assert.ErrorIs(t, err, want, asm)
+		assert.Equal(t, uint32(4), cpu.PC, "PC after %s", asm)
+	}
+}
+
 func TestAssemblerEncodings(t *testing.T) {
 	type encTest struct {
 		asm      string
@@ -175,3 +254,79 @@ func TestCPU_Step_ReadsCorrectInstruction(t *testing.T) {
 	assert.NoError(t, cpu.Step(mem))
 	assert.Equal(t, uint32(4), cpu.PC)
 }
+
+// writeHalves stores a stream of 16-bit parcels into memory starting at address 0.
+func writeHalves(t *testing.T, mem *Memory, halves ...uint16) {
+	t.Helper()
+	for i, h := range halves {
+		assert.NoError(t, writeHalf(mem, uint32(i*2), h))
+	}
+}
+
+func TestCPU_CompressedExecution(t *testing.T) {
+	mem := NewMemory(64)
+	cli := uint32(mustAssemble(t, "c.li x10, 5"))
+	addi := uint32(mustAssemble(t, "addi x11, x10, 1")) // 32-bit instruction at a 2-byte aligned address
+	cadd := uint32(mustAssemble(t, "c.add x11, x10"))
+	writeHalves(t, mem, uint16(cli), uint16(addi), uint16(addi>>16), uint16(cadd))
+
+	cpu := NewCPU()
+	assert.NoError(t, cpu.Step(mem))
+	assert.Equal(t, uint32(5), cpu.Reg[10])
+	assert.Equal(t, uint32(2), cpu.PC, "compressed instruction advances PC by 2")
+	assert.NoError(t, cpu.Step(mem))
+	assert.Equal(t, uint32(6), cpu.Reg[11])
+	assert.Equal(t, uint32(6), cpu.PC, "32-bit instruction advances PC by 4")
+	assert.NoError(t, cpu.Step(mem))
+	assert.Equal(t, uint32(11), cpu.Reg[11])
+	assert.Equal(t, uint32(8), cpu.PC)
+}
+
+func TestCPU_CompressedJumps(t *testing.T) {
+	tests := []struct {
+		name    string
+		asm     string
+		setup   func(c *CPU)
+		pc      uint32
+		wantReg map[int]uint32
+		wantPC  uint32
+	}{
+		{"C.JAL links PC+2", "c.jal 10", nil, 2, map[int]uint32{1: 4}, 12},
+		{"C.J", "c.j -2", nil, 6, nil, 4},
+		{"C.JR", "c.jr x5", func(c *CPU) { c.Reg[5] = 22 }, 0, nil, 22},
+		{"C.JALR", "c.jalr x5", func(c *CPU) { c.Reg[5] = 22 }, 0, map[int]uint32{1: 2}, 22},
+		{"C.BEQZ taken", "c.beqz x8, 6", nil, 2, nil, 8},
+		{"C.BNEZ not taken", "c.bnez x8, 6", nil, 2, nil, 4},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mem := NewMemory(64)
+			instr := mustAssemble(t, tc.asm)
+			assert.NoError(t, writeHalf(mem, tc.pc, uint16(instr)))
+			cpu := NewCPU()
+			if tc.setup != nil {
+				tc.setup(cpu)
+			}
+			cpu.PC = tc.pc
+			assert.NoError(t, cpu.Step(mem))
+			for reg, want := range tc.wantReg {
+				assert.Equalf(t, want, cpu.Reg[reg], "Reg x%d", reg)
+			}
+			assert.Equal(t, tc.wantPC, cpu.PC)
+		})
+	}
+}
+
+func TestCPU_IllegalCompressedInstruction(t *testing.T) {
+	mem := NewMemory(64)
+	cpu := NewCPU()
+	assert.Error(t, cpu.Step(mem), "the all-zero parcel is illegal")
+	assert.Equal(t, uint32(0), cpu.PC)
+}
+
+func mustAssemble(t *testing.T, line string) assembler.Instruction {
+	t.Helper()
+	instr, err := assembler.ParseInstruction(line)
+	assert.NoErrorf(t, err, "ParseInstruction(%q)", line)
+	return instr
+}
@@ -0,0 +1,326 @@
+package arch
+
+import (
+	"fmt"
+
+	"github.com/malikwirin/riscvemu/assembler"
+)
+
+// mstatus fields
+const (
+	MSTATUS_SIE   uint32 = 1 << 1
+	MSTATUS_MIE   uint32 = 1 << 3
+	MSTATUS_SPIE  uint32 = 1 << 5
+	MSTATUS_MPIE  uint32 = 1 << 7
+	MSTATUS_SPP   uint32 = 1 << 8
+	MSTATUS_MPP   uint32 = 0x3 << 11
+	MSTATUS_FS    uint32 = 0x3 << 13
+	MSTATUS_MPRV  uint32 = 1 << 17 // loads and stores use the privilege in MPP
+	MSTATUS_SUM   uint32 = 1 << 18 // S-mode may access user pages
+	MSTATUS_MXR   uint32 = 1 << 19 // loads from executable pages are permitted
+	MSTATUS_TVM   uint32 = 1 << 20 // trap satp accesses and SFENCE.VMA in S-mode
+	MSTATUS_TSR   uint32 = 1 << 22 // trap SRET in S-mode
+	MSTATUS_SD    uint32 = 1 << 31
+	MSTATUS_MPP_M uint32 = 0x3 << 11
+
+	MSTATUS_MPP_SHIFT = 11
+	MSTATUS_SPP_SHIFT = 8
+
+	// SSTATUS_MASK selects the fields of mstatus visible through sstatus
+	SSTATUS_MASK = MSTATUS_SIE | MSTATUS_SPIE | MSTATUS_SPP | MSTATUS_FS | MSTATUS_SUM | MSTATUS_MXR | MSTATUS_SD
+
+	// FS states
+	FS_OFF     uint32 = 0x0 << 13
+	FS_INITIAL uint32 = 0x1 << 13
+	FS_CLEAN   uint32 = 0x2 << 13
+	FS_DIRTY   uint32 = 0x3 << 13
+)
+
+// Interrupt bits of mie and mip
+const (
+	MIP_SSIP uint32 = 1 << 1 // supervisor software interrupt
+	MIP_MSIP uint32 = 1 << 3 // machine software interrupt
+	MIP_STIP uint32 = 1 << 5 // supervisor timer interrupt
+	MIP_MTIP uint32 = 1 << 7 // machine timer interrupt
+	MIP_SEIP uint32 = 1 << 9
+	MIP_MEIP uint32 = 1 << 11
+
+	// MIP_SUPERVISOR selects the supervisor-level interrupts, which can be delegated via mideleg
+	MIP_SUPERVISOR = MIP_SSIP | MIP_STIP | MIP_SEIP
+)
+
+// MEDELEG_MASK selects the exceptions that can be delegated to S-mode. An
+// ECALL from M-mode always traps to M-mode.
+const MEDELEG_MASK uint32 = 1<<CAUSE_MISALIGNED_FETCH | 1<<CAUSE_FETCH_ACCESS | 1<<CAUSE_ILLEGAL_INSTRUCTION |
+	1<<CAUSE_BREAKPOINT | 1<<CAUSE_MISALIGNED_LOAD | 1<<CAUSE_LOAD_ACCESS | 1<<CAUSE_MISALIGNED_STORE |
+	1<<CAUSE_STORE_ACCESS | 1<<CAUSE_ECALL_U | 1<<CAUSE_ECALL_S |
+	1<<CAUSE_FETCH_PAGE_FAULT | 1<<CAUSE_LOAD_PAGE_FAULT | 1<<CAUSE_STORE_PAGE_FAULT
+
+// MISA describes the implemented ISA: RV32 (MXL=1) with the extensions I, M, A, F, D and C
+// and the supervisor and user modes.
+const MISA uint32 = 1<<30 | 1<<('I'-'A') | 1<<('M'-'A') | 1<<('A'-'A') | 1<<('F'-'A') | 1<<('D'-'A') | 1<<('C'-'A') |
+	1<<('S'-'A') | 1<<('U'-'A')
+
+// csrFile holds the state of the machine- and supervisor-level CSRs. The FP CSRs
+// are views of CPU.FCSR; sstatus, sie and sip are views of their machine counterparts.
+type csrFile struct {
+	mstatus  uint32
+	mtvec    uint32
+	mepc     uint32
+	mcause   uint32
+	mtval    uint32
+	mscratch uint32
+	mie      uint32
+	mip      uint32 // the software-writable pending bits
+	irq      uint32 // the pending bits driven by interrupt sources
+	medeleg  uint32
+	mideleg  uint32
+
+	stvec    uint32
+	sscratch uint32
+	sepc     uint32
+	scause   uint32
+	stval    uint32
+	satp     uint32
+}
+
+func newCSRFile() csrFile {
+	return csrFile{mstatus: MSTATUS_MPP_M | FS_INITIAL}
+}
+
+// csrDef implements one CSR. A nil write makes the CSR read-only; writes are
+// expected to apply the register's WARL constraints.
+type csrDef struct {
+	read  func(c *CPU) uint32
+	write func(c *CPU, value uint32)
+}
+
+func constCSR(value uint32) csrDef {
+	return csrDef{read: func(*CPU) uint32 { return value }}
+}
+
+var csrDefs = map[uint32]csrDef{
+	assembler.CSR_FFLAGS: {
+		read:  func(c *CPU) uint32 { return c.FFlags() },
+		write: func(c *CPU, v uint32) { c.setFCSR(c.FCSR&^FCSR_FFLAGS_MASK | v&FCSR_FFLAGS_MASK) },
+	},
+	assembler.CSR_FRM: {
+		read:  func(c *CPU) uint32 { return c.Frm() },
+		write: func(c *CPU, v uint32) { c.setFCSR(c.FCSR&^FCSR_FRM_MASK | v<<FCSR_FRM_SHIFT&FCSR_FRM_MASK) },
+	},
+	assembler.CSR_FCSR: {
+		read:  func(c *CPU) uint32 { return c.FCSR },
+		write: func(c *CPU, v uint32) { c.setFCSR(v & FCSR_MASK) },
+	},
+
+	assembler.CSR_MVENDORID: constCSR(0),
+	assembler.CSR_MARCHID:   constCSR(0),
+	assembler.CSR_MIMPID:    constCSR(0),
+	assembler.CSR_MHARTID:   constCSR(0),
+
+	assembler.CSR_SSTATUS: {
+		read: func(c *CPU) uint32 { return c.readStatus() & SSTATUS_MASK },
+		write: func(c *CPU, v uint32) {
+			const writable = SSTATUS_MASK &^ MSTATUS_SD
+			c.csr.mstatus = c.csr.mstatus&^writable | v&writable
+		},
+	},
+	assembler.CSR_SIE: {
+		read: func(c *CPU) uint32 { return c.csr.mie & c.csr.mideleg },
+		write: func(c *CPU, v uint32) {
+			c.csr.mie = c.csr.mie&^c.csr.mideleg | v&c.csr.mideleg
+		},
+	},
+	assembler.CSR_STVEC: {
+		read:  func(c *CPU) uint32 { return c.csr.stvec },
+		write: func(c *CPU, v uint32) { c.csr.stvec = warlTvec(c.csr.stvec, v) },
+	},
+	assembler.CSR_SSCRATCH: {
+		read:  func(c *CPU) uint32 { return c.csr.sscratch },
+		write: func(c *CPU, v uint32) { c.csr.sscratch = v },
+	},
+	assembler.CSR_SEPC: {
+		read:  func(c *CPU) uint32 { return c.csr.sepc },
+		write: func(c *CPU, v uint32) { c.csr.sepc = v &^ 1 },
+	},
+	assembler.CSR_SCAUSE: {
+		read:  func(c *CPU) uint32 { return c.csr.scause },
+		write: func(c *CPU, v uint32) { c.csr.scause = v },
+	},
+	assembler.CSR_STVAL: {
+		read:  func(c *CPU) uint32 { return c.csr.stval },
+		write: func(c *CPU, v uint32) { c.csr.stval = v },
+	},
+	// only a delegated supervisor software interrupt can be raised or cleared through sip
+	assembler.CSR_SIP: {
+		read: func(c *CPU) uint32 { return c.pending() & c.csr.mideleg },
+		write: func(c *CPU, v uint32) {
+			writable := MIP_SSIP & c.csr.mideleg
+			c.csr.mip = c.csr.mip&^writable | v&writable
+		},
+	},
+	// WARL: only the mode and PPN fields are writable (no ASIDs are implemented)
+	assembler.CSR_SATP: {
+		read:  func(c *CPU) uint32 { return c.csr.satp },
+		write: func(c *CPU, v uint32) { c.csr.satp = v & (SATP_MODE | SATP_PPN) },
+	},
+
+	assembler.CSR_MSTATUS: {
+		read: func(c *CPU) uint32 { return c.readStatus() },
+		write: func(c *CPU, v uint32) {
+			const writable = MSTATUS_SIE | MSTATUS_MIE | MSTATUS_SPIE | MSTATUS_MPIE | MSTATUS_SPP | MSTATUS_MPP |
+				MSTATUS_FS | MSTATUS_MPRV | MSTATUS_SUM | MSTATUS_MXR | MSTATUS_TVM | MSTATUS_TSR
+			// MPP is WARL: the reserved privilege level 2 keeps the current value
+			if v&MSTATUS_MPP>>MSTATUS_MPP_SHIFT == 2 {
+				v = v&^MSTATUS_MPP | c.csr.mstatus&MSTATUS_MPP
+			}
+			c.csr.mstatus = c.csr.mstatus&^writable | v&writable
+		},
+	},
+	// WARL: the ISA cannot be changed, writes are ignored
+	assembler.CSR_MISA: {
+		read:  func(*CPU) uint32 { return MISA },
+		write: func(*CPU, uint32) {},
+	},
+	assembler.CSR_MEDELEG: {
+		read:  func(c *CPU) uint32 { return c.csr.medeleg },
+		write: func(c *CPU, v uint32) { c.csr.medeleg = v & MEDELEG_MASK },
+	},
+	assembler.CSR_MIDELEG: {
+		read:  func(c *CPU) uint32 { return c.csr.mideleg },
+		write: func(c *CPU, v uint32) { c.csr.mideleg = v & MIP_SUPERVISOR },
+	},
+	assembler.CSR_MIE: {
+		read:  func(c *CPU) uint32 { return c.csr.mie },
+		write: func(c *CPU, v uint32) { c.csr.mie = v & (MIP_MSIP | MIP_MTIP | MIP_MEIP | MIP_SUPERVISOR) },
+	},
+	assembler.CSR_MTVEC: {
+		read:  func(c *CPU) uint32 { return c.csr.mtvec },
+		write: func(c *CPU, v uint32) { c.csr.mtvec = warlTvec(c.csr.mtvec, v) },
+	},
+	assembler.CSR_MSCRATCH: {
+		read:  func(c *CPU) uint32 { return c.csr.mscratch },
+		write: func(c *CPU, v uint32) { c.csr.mscratch = v },
+	},
+	assembler.CSR_MEPC: {
+		read:  func(c *CPU) uint32 { return c.csr.mepc },
+		write: func(c *CPU, v uint32) { c.csr.mepc = v &^ 1 }, // IALIGN=16 with the C extension
+	},
+	assembler.CSR_MCAUSE: {
+		read:  func(c *CPU) uint32 { return c.csr.mcause },
+		write: func(c *CPU, v uint32) { c.csr.mcause = v },
+	},
+	assembler.CSR_MTVAL: {
+		read:  func(c *CPU) uint32 { return c.csr.mtval },
+		write: func(c *CPU, v uint32) { c.csr.mtval = v },
+	},
+	// the machine-level pending bits are driven by the interrupt sources (see
+	// SetInterruptPending) and read-only here; M-mode software may raise the
+	// supervisor interrupts, e.g. to forward a timer interrupt to S-mode. SEIP
+	// reads as the software-written bit or'ed with the PLIC's line.
+	assembler.CSR_MIP: {
+		read:  func(c *CPU) uint32 { return c.pending() },
+		write: func(c *CPU, v uint32) { c.csr.mip = c.csr.mip&^MIP_SUPERVISOR | v&MIP_SUPERVISOR },
+	},
+}
+
+// readStatus returns mstatus with the SD summary bit filled in.
+func (c *CPU) readStatus() uint32 {
+	status := c.csr.mstatus
+	if status&MSTATUS_FS == FS_DIRTY {
+		status |= MSTATUS_SD
+	}
+	return status
+}
+
+// warlTvec applies the WARL constraints of mtvec and stvec: modes 0 (direct) and
+// 1 (vectored) exist; a reserved mode keeps the current one.
+func warlTvec(old, v uint32) uint32 {
+	if v&0x3 > 1 {
+		v = v&^0x3 | old&0x3
+	}
+	return v
+}
+
+// csrReadOnly reports whether a CSR address is in one of the read-only ranges (bits 11:10 set).
+func csrReadOnly(addr uint32) bool {
+	return addr>>10&0x3 == 0x3
+}
+
+// isFPCSR reports whether addr is one of the FP CSRs, which are only accessible
+// while the FP unit is enabled (mstatus.FS not Off).
+func isFPCSR(addr uint32) bool {
+	return addr >= assembler.CSR_FFLAGS && addr <= assembler.CSR_FCSR
+}
+
+// ReadCSR returns the value of the CSR at addr.
+func (c *CPU) ReadCSR(addr uint32) (uint32, error) {
+	def, ok := csrDefs[addr]
+	if !ok || (isFPCSR(addr) && !c.fpEnabled()) {
+		return 0, fmt.Errorf("illegal CSR access: %s", assembler.CSRName(addr))
+	}
+	return def.read(c), nil
+}
+
+// WriteCSR writes value to the CSR at addr, subject to the register's WARL constraints.
+func (c *CPU) WriteCSR(addr, value uint32) error {
+	def, ok := csrDefs[addr]
+	if !ok || (isFPCSR(addr) && !c.fpEnabled()) {
+		return fmt.Errorf("illegal CSR access: %s", assembler.CSRName(addr))
+	}
+	if def.write == nil || csrReadOnly(addr) {
+		return fmt.Errorf("illegal write to read-only CSR %s", assembler.CSRName(addr))
+	}
+	def.write(c, value)
+	return nil
+}
+
+// csrPrivilege returns the lowest privilege level that may access the CSR at addr (bits 9:8).
+func csrPrivilege(addr uint32) uint32 {
+	return addr >> 8 & 0x3
+}
+
+// execCSR executes the Zicsr instructions. Following the spec, CSRRW with rd=x0
+// does not read the CSR, and CSRRS/CSRRC with a zero source do not write it.
+func (c *CPU) execCSR(instr assembler.Instruction) error {
+	addr := uint32(instr.ImmI()) & 0xFFF
+	if csrPrivilege(addr) > c.Priv {
+		return fmt.Errorf("CSR %s not accessible from %s-mode", assembler.CSRName(addr), privNames[c.Priv])
+	}
+	if addr == assembler.CSR_SATP && c.Priv == PRIV_S && c.csr.mstatus&MSTATUS_TVM != 0 {
+		return fmt.Errorf("satp access trapped by mstatus.TVM")
+	}
+	rd, rs1, funct3 := instr.Rd(), instr.Rs1(), instr.Funct3()
+	source := c.Reg[rs1]
+	if funct3 >= assembler.FUNCT3_CSRRWI {
+		source = rs1 // 5-bit zero-extended immediate
+	}
+
+	var old uint32
+	var err error
+	if funct3 != assembler.FUNCT3_CSRRW && funct3 != assembler.FUNCT3_CSRRWI || rd != 0 {
+		if old, err = c.ReadCSR(addr); err != nil {
+			return err
+		}
+	}
+
+	switch funct3 {
+	case assembler.FUNCT3_CSRRW, assembler.FUNCT3_CSRRWI:
+		err = c.WriteCSR(addr, source)
+	case assembler.FUNCT3_CSRRS, assembler.FUNCT3_CSRRSI:
+		if rs1 != 0 {
+			err = c.WriteCSR(addr, old|source)
+		}
+	case assembler.FUNCT3_CSRRC, assembler.FUNCT3_CSRRCI:
+		if rs1 != 0 {
+			err = c.WriteCSR(addr, old&^source)
+		}
+	default:
+		return fmt.Errorf("unsupported SYSTEM instruction: 0x%08X", uint32(instr))
+	}
+	if err != nil {
+		return err
+	}
+	c.SetReg(RegIndex(rd), old)
+	return nil
+}
@@ -0,0 +1,111 @@
+package arch
+
+import (
+	"testing"
+
+	"github.com/malikwirin/riscvemu/assembler"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCPU_CSRInstructions(t *testing.T) {
+	cpu, mem := NewCPU(), NewMemory(256)
+	cpu.Reg[1] = 0x1234
+	cpu.Reg[2] = 0x00F0
+	runInstructions(t, cpu, mem,
+		"csrrw x3, mscratch, x1", // x3 = 0, mscratch = 0x1234
+		"csrrs x4, mscratch, x2", // x4 = 0x1234, mscratch = 0x12F4
+		"csrrc x5, mscratch, x1", // x5 = 0x12F4, mscratch = 0x00C0
+		"csrrsi x6, mscratch, 3", // x6 = 0x00C0, mscratch = 0x00C3
+		"csrrci x7, mscratch, 1", // x7 = 0x00C3, mscratch = 0x00C2
+		"csrrwi x8, mscratch, 7", // x8 = 0x00C2, mscratch = 7
+		"csrr x9, mscratch",
+	)
+	assert.Equal(t, []uint32{0, 0x1234, 0x12F4, 0xC0, 0xC3, 0xC2, 7}, cpu.Reg[3:10])
+}
+
+func TestCPU_CSRReadOnly(t *testing.T) {
+	cpu, mem := NewCPU(), NewMemory(256)
+	// reading a read-only CSR is fine, as is csrrs with x0 (no write)
+	runInstructions(t, cpu, mem,
+		"csrr x1, mhartid",
+		"csrrs x2, misa, x0",
+	)
+	assert.Equal(t, uint32(0), cpu.Reg[1])
+	assert.Equal(t, MISA, cpu.Reg[2])
+
+	for _, line := range []string{
+		"csrw mhartid, x1",     // write to a read-only CSR
+		"csrrsi x1, mimpid, 1", // set with a nonzero immediate writes
+		"csrr x1, 0x7C0",       // unimplemented CSR
+	} {
+		assert.NoError(t, mem.WriteWord(0, uint32(mustAssemble(t, line))))
+		cpu.PC = 0
+		assert.Errorf(t, cpu.Step(mem), "%q must be illegal", line)
+	}
+}
+
+func TestCPU_CSRWARL(t *testing.T) {
+	cpu := NewCPU()
+	cases := []struct {
+		name  string
+		csr   uint32
+		write uint32
+		want  uint32
+	}{
+		{"misa is fixed", assembler.CSR_MISA, 0, MISA},
+		{"mtvec keeps valid modes", assembler.CSR_MTVEC, 0x101, 0x101},
+		{"mtvec rejects reserved modes", assembler.CSR_MTVEC, 0x202, 0x201},
+		{"mepc is 2-byte aligned", assembler.CSR_MEPC, 0x1003, 0x1002},
+		{"mie has the machine and supervisor interrupt bits", assembler.CSR_MIE, 0xFFFFFFFF, MIP_MSIP | MIP_MTIP | MIP_MEIP | MIP_SUPERVISOR},
+		{"mip machine pending bits are read-only", assembler.CSR_MIP, 0xFFFFFFFF, MIP_SUPERVISOR},
+		{"mstatus MPP holds S", assembler.CSR_MSTATUS, MSTATUS_MIE | PRIV_S<<MSTATUS_MPP_SHIFT, MSTATUS_MIE | PRIV_S<<MSTATUS_MPP_SHIFT},
+		{"mstatus MPP rejects the reserved level", assembler.CSR_MSTATUS, 2 << MSTATUS_MPP_SHIFT, PRIV_S << MSTATUS_MPP_SHIFT},
+		{"mstatus SD summarizes a dirty FS", assembler.CSR_MSTATUS, FS_DIRTY, FS_DIRTY | MSTATUS_SD},
+		{"sstatus is a view of mstatus", assembler.CSR_SSTATUS, 0xFFFFFFFF, MSTATUS_SIE | MSTATUS_SPIE | MSTATUS_SPP | FS_DIRTY | MSTATUS_SUM | MSTATUS_MXR | MSTATUS_SD},
+		{"medeleg cannot delegate ecall from M-mode", assembler.CSR_MEDELEG, 0xFFFFFFFF, MEDELEG_MASK},
+		{"mideleg has the supervisor interrupt bits", assembler.CSR_MIDELEG, 0xFFFFFFFF, MIP_SUPERVISOR},
+		{"sie shows the delegated bits of mie", assembler.CSR_SIE, 0, 0},
+		{"satp has no ASID bits", assembler.CSR_SATP, 0xFFFFFFFF, SATP_MODE | SATP_PPN},
+		{"stvec rejects reserved modes", assembler.CSR_STVEC, 0x202, 0x200},
+		{"mcause is writable", assembler.CSR_MCAUSE, 0x8000000B, 0x8000000B},
+		{"mtval is writable", assembler.CSR_MTVAL, 0xDEADBEEF, 0xDEADBEEF},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.NoError(t, cpu.WriteCSR(tc.csr, tc.write))
+			got, err := cpu.ReadCSR(tc.csr)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestCPU_FPCSRs(t *testing.T) {
+	cpu, mem := NewCPU(), NewMemory(256)
+	cpu.Reg[1] = 0xFF
+	runInstructions(t, cpu, mem,
+		"csrw fcsr, x1",
+		"csrr x2, frm",
+		"csrr x3, fflags",
+		"csrwi frm, 1",
+		"csrci fflags, 0x10",
+		"csrr x4, fcsr",
+	)
+	assert.Equal(t, uint32(0x7), cpu.Reg[2])
+	assert.Equal(t, uint32(0x1F), cpu.Reg[3])
+	assert.Equal(t, uint32(0x2F), cpu.Reg[4])
+	assert.Equal(t, uint32(0x2F), cpu.FCSR)
+
+	status, _ := cpu.ReadCSR(assembler.CSR_MSTATUS)
+	assert.Equal(t, FS_DIRTY, status&MSTATUS_FS, "writing fcsr dirties the FP state")
+}
+
+func TestCPU_FPDisabled(t *testing.T) {
+	cpu, mem := NewCPU(), NewMemory(256)
+	assert.NoError(t, cpu.WriteCSR(assembler.CSR_MSTATUS, FS_OFF))
+	for _, line := range []string{"fadd.s f1, f2, f3", "csrr x1, fcsr"} {
+		assert.NoError(t, mem.WriteWord(0, uint32(mustAssemble(t, line))))
+		cpu.PC = 0
+		assert.Errorf(t, cpu.Step(mem), "%q must be illegal with mstatus.FS off", line)
+	}
+}
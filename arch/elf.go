@@ -0,0 +1,132 @@
+package arch
+
+import (
+	"bytes"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// IsELF reports whether data starts with the ELF magic number.
+func IsELF(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(elf.ELFMAG))
+}
+
+// Executable describes an ELF executable loaded by LoadELF.
+type Executable struct {
+	Entry uint32
+	// Segments are the loaded PT_LOAD segments: their physical address and size in memory
+	Segments []MemoryRange
+	// Symbols maps the names of the functions, objects and labels in the symbol table to their addresses
+	Symbols map[string]uint32
+}
+
+// LoadELF loads an ELF32 RISC-V executable into memory: each PT_LOAD segment is
+// copied to its physical address and the rest of it (.bss) is zeroed. The entry
+// point and symbol table are returned; setting the PC is up to the caller.
+func LoadELF(memory WordHandler, r io.ReaderAt) (*Executable, error) {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return nil, fmt.Errorf("ELF: %w", err)
+	}
+	defer f.Close()
+	switch {
+	case f.Class != elf.ELFCLASS32:
+		return nil, fmt.Errorf("ELF: %v not supported, need a 32-bit executable", f.Class)
+	case f.Data != elf.ELFDATA2LSB:
+		return nil, fmt.Errorf("ELF: %v not supported, need little-endian", f.Data)
+	case f.Machine != elf.EM_RISCV:
+		return nil, fmt.Errorf("ELF: machine %v is not RISC-V", f.Machine)
+	case f.Type != elf.ET_EXEC:
+		return nil, fmt.Errorf("ELF: %v is not an executable", f.Type)
+	}
+
+	exe := &Executable{Entry: uint32(f.Entry), Symbols: map[string]uint32{}}
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_LOAD || prog.Memsz == 0 {
+			continue
+		}
+		if prog.Filesz > prog.Memsz {
+			return nil, fmt.Errorf("ELF: segment at 0x%08x has a file size larger than its memory size", prog.Paddr)
+		}
+		if prog.Paddr > 1<<32 || prog.Memsz > 1<<32-prog.Paddr {
+			return nil, fmt.Errorf("ELF: segment at 0x%08x of 0x%x bytes exceeds the 32-bit address space", prog.Paddr, prog.Memsz)
+		}
+		addr := uint32(prog.Paddr)
+		if err := loadSegment(memory, addr, prog); err != nil {
+			return nil, err
+		}
+		exe.Segments = append(exe.Segments, MemoryRange{Base: addr, Size: uint32(prog.Memsz)})
+	}
+
+	symbols, err := f.Symbols()
+	if err != nil && !errors.Is(err, elf.ErrNoSymbols) {
+		return nil, fmt.Errorf("ELF: %w", err)
+	}
+	for _, sym := range symbols {
+		switch elf.ST_TYPE(sym.Info) {
+		case elf.STT_FUNC, elf.STT_OBJECT, elf.STT_NOTYPE:
+			if sym.Name != "" && sym.Section != elf.SHN_UNDEF {
+				exe.Symbols[sym.Name] = uint32(sym.Value)
+			}
+		}
+	}
+	return exe, nil
+}
+
+// segmentChunk is the most a segment's file data is read at a time.
+const segmentChunk = 64 << 10
+
+// loadSegment copies a segment's file data to memory at addr and zeroes the
+// rest of it (.bss). Neither needs a buffer of the segment's size, which is
+// taken from the file.
+func loadSegment(memory WordHandler, addr uint32, prog *elf.Prog) error {
+	r := prog.Open()
+	buf := make([]byte, min(prog.Filesz, segmentChunk))
+	for done := uint64(0); done < prog.Filesz; {
+		n := min(prog.Filesz-done, segmentChunk)
+		if _, err := io.ReadFull(r, buf[:n]); err != nil {
+			return fmt.Errorf("ELF: reading segment at 0x%08x: %w", addr, err)
+		}
+		if err := writeBytes(memory, addr+uint32(done), buf[:n]); err != nil {
+			return fmt.Errorf("ELF: loading segment at 0x%08x: %w", addr, err)
+		}
+		done += n
+	}
+	if err := zeroRange(memory, addr+uint32(prog.Filesz), uint32(prog.Memsz-prog.Filesz)); err != nil {
+		return fmt.Errorf("ELF: loading segment at 0x%08x: %w", addr, err)
+	}
+	return nil
+}
+
+// LoadELFFile loads the ELF executable at path (see LoadELF).
+func LoadELFFile(memory WordHandler, path string) (*Executable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadELF(memory, f)
+}
+
+// writeBytes copies data to memory at addr, using word accesses where aligned.
+func writeBytes(memory WordHandler, addr uint32, data []byte) error {
+	for i := 0; i < len(data); {
+		a := addr + uint32(i)
+		if a%4 == 0 && len(data)-i >= 4 {
+			word := uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16 | uint32(data[i+3])<<24
+			if err := memory.WriteWord(a, word); err != nil {
+				return err
+			}
+			i += 4
+			continue
+		}
+		if err := writeByte(memory, a, data[i]); err != nil {
+			return err
+		}
+		i++
+	}
+	return nil
+}
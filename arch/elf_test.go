@@ -0,0 +1,172 @@
+package arch
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type elfSegment struct {
+	paddr uint32
+	data  []byte
+	memsz uint32
+}
+
+// buildELF returns a minimal ELF32 RISC-V executable with the given segments
+// and (absolute) symbols.
+func buildELF(t *testing.T, entry uint32, segments []elfSegment, symbols map[string]uint32) []byte {
+	t.Helper()
+	const ehsize, phentsize, shentsize, symsize = 52, 32, 40, 16
+	var body bytes.Buffer // everything after the headers
+	offset := func() uint32 { return uint32(ehsize + phentsize*len(segments) + body.Len()) }
+
+	var progs []elf.Prog32
+	for _, seg := range segments {
+		progs = append(progs, elf.Prog32{
+			Type: uint32(elf.PT_LOAD), Off: offset(), Vaddr: seg.paddr, Paddr: seg.paddr,
+			Filesz: uint32(len(seg.data)), Memsz: seg.memsz, Flags: uint32(elf.PF_R | elf.PF_X), Align: 4,
+		})
+		body.Write(seg.data)
+	}
+
+	strtab := []byte{0}
+	syms := []elf.Sym32{{}}
+	for name, value := range symbols {
+		syms = append(syms, elf.Sym32{
+			Name: uint32(len(strtab)), Value: value,
+			Info: elf.ST_INFO(elf.STB_GLOBAL, elf.STT_FUNC), Shndx: uint16(elf.SHN_ABS),
+		})
+		strtab = append(strtab, name+"\x00"...)
+	}
+	shstrtab := []byte("\x00.symtab\x00.strtab\x00.shstrtab\x00")
+	symtabOff := offset()
+	assert.NoError(t, binary.Write(&body, binary.LittleEndian, syms))
+	strtabOff := offset()
+	body.Write(strtab)
+	shstrtabOff := offset()
+	body.Write(shstrtab)
+	shoff := offset()
+	sections := []elf.Section32{
+		{},
+		{Name: 1, Type: uint32(elf.SHT_SYMTAB), Off: symtabOff, Size: uint32(len(syms) * symsize), Link: 2, Info: 1, Entsize: symsize},
+		{Name: 9, Type: uint32(elf.SHT_STRTAB), Off: strtabOff, Size: uint32(len(strtab))},
+		{Name: 17, Type: uint32(elf.SHT_STRTAB), Off: shstrtabOff, Size: uint32(len(shstrtab))},
+	}
+	assert.NoError(t, binary.Write(&body, binary.LittleEndian, sections))
+
+	header := elf.Header32{
+		Type: uint16(elf.ET_EXEC), Machine: uint16(elf.EM_RISCV), Version: uint32(elf.EV_CURRENT),
+		Entry: entry, Phoff: ehsize, Shoff: shoff, Ehsize: ehsize,
+		Phentsize: phentsize, Phnum: uint16(len(segments)),
+		Shentsize: shentsize, Shnum: uint16(len(sections)), Shstrndx: 3,
+	}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS32)
+	header.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+
+	var out bytes.Buffer
+	assert.NoError(t, binary.Write(&out, binary.LittleEndian, header))
+	assert.NoError(t, binary.Write(&out, binary.LittleEndian, progs))
+	out.Write(body.Bytes())
+	return out.Bytes()
+}
+
+func TestLoadELF(t *testing.T) {
+	mem := NewMemory(0x200)
+	for i := range mem.Data {
+		mem.Data[i] = 0xEE
+	}
+	image := buildELF(t, 0x104, []elfSegment{
+		{paddr: 0x100, data: []byte{1, 2, 3, 4, 5, 6}, memsz: 6},
+		{paddr: 0x181, data: []byte{7}, memsz: 5}, // .bss follows the data
+	}, map[string]uint32{"_start": 0x104, "counter": 0x182})
+	assert.True(t, IsELF(image))
+
+	exe, err := LoadELF(mem, bytes.NewReader(image))
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0x104), exe.Entry)
+	assert.Equal(t, []MemoryRange{{0x100, 6}, {0x181, 5}}, exe.Segments)
+	assert.Equal(t, map[string]uint32{"_start": 0x104, "counter": 0x182}, exe.Symbols)
+	assert.Equal(t, []byte{1, 2, 3, 4, 5, 6, 0xEE}, mem.Data[0x100:0x107])
+	assert.Equal(t, []byte{0xEE, 7, 0, 0, 0, 0, 0xEE}, mem.Data[0x180:0x187], ".bss is zeroed")
+}
+
+func TestLoadELF_Rejects(t *testing.T) {
+	image := buildELF(t, 0, []elfSegment{{paddr: 0, data: []byte{1, 2, 3, 4}, memsz: 4}}, nil)
+	patch := func(offset int, value ...byte) []byte {
+		patched := bytes.Clone(image)
+		copy(patched[offset:], value)
+		return patched
+	}
+	cases := map[string][]byte{
+		"not ELF":       []byte("#!/bin/sh\n"),
+		"64-bit":        patch(elf.EI_CLASS, byte(elf.ELFCLASS64)),
+		"big-endian":    patch(elf.EI_DATA, byte(elf.ELFDATA2MSB)),
+		"not RISC-V":    patch(18, byte(elf.EM_386), 0),
+		"shared object": patch(16, byte(elf.ET_DYN), 0),
+	}
+	for name, data := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := LoadELF(NewMemory(64), bytes.NewReader(data))
+			assert.Error(t, err)
+		})
+	}
+
+	image = buildELF(t, 0, []elfSegment{{paddr: 0x1000, data: []byte{1, 2, 3, 4}, memsz: 4}}, nil)
+	_, err := LoadELF(NewMemory(64), bytes.NewReader(image))
+	assert.ErrorIs(t, err, ErrOutOfBounds, "segment outside memory")
+
+	image = buildELF(t, 0, []elfSegment{{paddr: 0xFFFFF000, data: []byte{1}, memsz: 0xFFFFFFFF}}, nil)
+	_, err = LoadELF(NewMemory(64), bytes.NewReader(image))
+	assert.ErrorContains(t, err, "exceeds the 32-bit address space", "rejected before allocating the segment")
+}
+
+func TestLoadELF_LargeBSS(t *testing.T) {
+	bus := NewBus()
+	ram := NewSparseMemory()
+	assert.NoError(t, bus.Map("dram", DRAM_BASE, DRAM_SIZE, ram))
+	assert.NoError(t, bus.WriteByteAt(DRAM_BASE+0x5001, 0xAA))
+
+	image := buildELF(t, DRAM_BASE, []elfSegment{{paddr: DRAM_BASE, data: []byte{1, 2, 3, 4}, memsz: 0x7FFFFFFF}}, nil)
+	exe, err := LoadELF(bus, bytes.NewReader(image))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []MemoryRange{{DRAM_BASE, 0x7FFFFFFF}}, exe.Segments)
+	v, _ := bus.ReadWord(DRAM_BASE)
+	assert.Equal(t, uint32(0x04030201), v)
+	b, _ := bus.ReadByteAt(DRAM_BASE + 0x5001)
+	assert.Equal(t, uint8(0), b, "written memory in .bss is zeroed")
+	assert.Equal(t, 2, ram.Pages(), ".bss allocates no pages")
+}
+
+func TestMachineLoadELF(t *testing.T) {
+	var code bytes.Buffer
+	for _, line := range []string{"addi x1, x0, 7", "addi x2, x1, 1"} {
+		assert.NoError(t, binary.Write(&code, binary.LittleEndian, uint32(mustAssemble(t, line))))
+	}
+	path := filepath.Join(t.TempDir(), "prog.elf")
+	image := buildELF(t, DRAM_BASE, []elfSegment{{paddr: DRAM_BASE, data: code.Bytes(), memsz: uint32(code.Len())}},
+		map[string]uint32{"_start": DRAM_BASE})
+	assert.NoError(t, os.WriteFile(path, image, 0o644))
+
+	m := NewMachine(64, WithSparseRAM(DRAM_BASE, DRAM_SIZE))
+	_, err := m.LoadELF(path)
+	assert.NoError(t, err)
+	assert.Equal(t, DRAM_BASE, m.CPU.PC)
+	assert.Equal(t, DRAM_BASE, m.Symbols["_start"])
+	assert.NoError(t, m.Step())
+	assert.NoError(t, m.Step())
+	assert.Equal(t, uint32(8), m.CPU.Reg[2])
+
+	assert.NoError(t, m.Reset())
+	assert.Nil(t, m.Symbols, "Reset drops the symbols")
+	_, err = m.LoadELF(filepath.Join(t.TempDir(), "missing.elf"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,144 @@
+package arch
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Environment emulates the execution environment a program requests services
+// from with ECALL, such as an operating system. If the CPU has one, ECALL calls
+// it instead of raising an environment-call exception, and execution continues
+// after the ECALL.
+type Environment interface {
+	// Ecall performs the request in the CPU's registers. Errors stop execution
+	// and are returned by Step unchanged; an *ExitError ends the program.
+	Ecall(c *CPU, memory WordHandler) error
+	// Reset closes files and drops any other state of the program.
+	Reset()
+}
+
+// ProgramLoader is implemented by environments that track the loaded program,
+// such as Linux for the program break. The Machine tells them about every
+// executable it loads.
+type ProgramLoader interface {
+	ProgramLoaded(exe *Executable)
+}
+
+// ExitError is returned by Step when the program asks its environment to exit.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("program exited with code %d", e.Code)
+}
+
+// environmentError marks errors of the Environment, which raise passes on
+// instead of reporting them as illegal instructions.
+type environmentError struct {
+	err error
+}
+
+func (e *environmentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *environmentError) Unwrap() error {
+	return e.err
+}
+
+// ecall hands an ECALL to the environment.
+func (c *CPU) ecall(memory WordHandler) error {
+	return wrapEnvironmentError(c.Env.Ecall(c, memory))
+}
+
+// wrapEnvironmentError marks err as an environmentError unless it is nil or an Exception.
+func wrapEnvironmentError(err error) error {
+	var exc *Exception
+	if err == nil || errors.As(err, &exc) {
+		return err
+	}
+	return &environmentError{err}
+}
+
+// maxTransfer is the most bytes a read or write call of an environment copies
+// at once. Larger requests transfer less and return the short count, so the
+// buffer a guest asks for is never allocated on the host in full.
+const maxTransfer = 64 << 10
+
+// maxStringLength is the longest string, such as a path, a program can pass to its environment.
+const maxStringLength = 4096
+
+// readGuest reads size bytes at the guest's virtual address addr.
+func (c *CPU) readGuest(memory WordHandler, addr, size uint32) ([]byte, error) {
+	return ReadRange(c.mmu(memory, accessLoad), addr, size)
+}
+
+// writeGuest writes data to the guest's virtual address addr.
+func (c *CPU) writeGuest(memory WordHandler, addr uint32, data []byte) error {
+	return writeBytes(c.mmu(memory, accessStore), addr, data)
+}
+
+// readGuestString reads the NUL-terminated string at the guest's virtual address addr.
+func (c *CPU) readGuestString(memory WordHandler, addr uint32) (string, error) {
+	view := c.mmu(memory, accessLoad)
+	var s []byte
+	for len(s) < maxStringLength {
+		b, err := readByte(view, addr+uint32(len(s)))
+		if err != nil {
+			return "", err
+		}
+		if b == 0 {
+			return string(s), nil
+		}
+		s = append(s, b)
+	}
+	return "", fmt.Errorf("string at 0x%08x is longer than %d bytes", addr, maxStringLength)
+}
+
+// sandboxPath maps a guest path to a host path below root with all symbolic
+// links resolved. Relative paths are relative to root, and neither ".." nor
+// symbolic links can lead out of it. A dangling symbolic link is refused, as
+// creating the file would create its target.
+func sandboxPath(root, path string) (string, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	host := filepath.Join(root, filepath.FromSlash(filepath.Clean("/"+path)))
+	resolved, err := filepath.EvalSymlinks(host)
+	if errors.Is(err, fs.ErrNotExist) {
+		if resolved, err = filepath.EvalSymlinks(filepath.Dir(host)); err != nil {
+			return "", err
+		}
+		resolved = filepath.Join(resolved, filepath.Base(host))
+		if _, err := os.Lstat(resolved); err == nil {
+			return "", fs.ErrPermission
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	if root, err = filepath.EvalSymlinks(root); err != nil {
+		return "", err
+	}
+	if resolved != root && !strings.HasPrefix(resolved, root+string(filepath.Separator)) {
+		return "", fs.ErrPermission
+	}
+	return resolved, nil
+}
+
+// openSandboxed opens a guest path below root like os.OpenFile. The path is
+// opened as checked by sandboxPath: a symbolic link put in its place in the
+// meantime is not followed.
+func openSandboxed(root, path string, flag int, perm fs.FileMode) (*os.File, error) {
+	host, err := sandboxPath(root, path)
+	if err != nil {
+		return nil, err
+	}
+	return os.OpenFile(host, flag|openNoFollow, perm)
+}
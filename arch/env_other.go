@@ -0,0 +1,6 @@
+//go:build !unix
+
+package arch
+
+// openNoFollow is not supported here; sandboxPath's checks still apply.
+const openNoFollow = 0
@@ -0,0 +1,8 @@
+//go:build unix
+
+package arch
+
+import "syscall"
+
+// openNoFollow makes opening a file fail if it is a symbolic link.
+const openNoFollow = syscall.O_NOFOLLOW
@@ -0,0 +1,345 @@
+package arch
+
+import (
+	"fmt"
+
+	"github.com/malikwirin/riscvemu/assembler"
+)
+
+// executor executes one decoded instruction. size is the length in bytes of the
+// instruction as it was fetched; executors of jumps and taken branches set the
+// PC themselves (see Step).
+type executor func(c *CPU, instr assembler.Instruction, size uint32, memory WordHandler) error
+
+// executors implements the instructions of assembler.Specs, by mnemonic. They
+// are registered with the specs at initialization.
+var executors = map[string]executor{
+	// RV32I
+	"lui":    execLUI,
+	"auipc":  execAUIPC,
+	"jal":    execJAL,
+	"jalr":   execJALR,
+	"beq":    branch(func(a, b uint32) bool { return a == b }),
+	"bne":    branch(func(a, b uint32) bool { return a != b }),
+	"blt":    branch(func(a, b uint32) bool { return int32(a) < int32(b) }),
+	"bge":    branch(func(a, b uint32) bool { return int32(a) >= int32(b) }),
+	"bltu":   branch(func(a, b uint32) bool { return a < b }),
+	"bgeu":   branch(func(a, b uint32) bool { return a >= b }),
+	"lb":     load(1, true),
+	"lh":     load(2, true),
+	"lw":     load(4, false),
+	"lbu":    load(1, false),
+	"lhu":    load(2, false),
+	"sb":     store(1),
+	"sh":     store(2),
+	"sw":     store(4),
+	"addi":   aluImm(add),
+	"slti":   aluImm(slt),
+	"sltiu":  aluImm(sltu),
+	"xori":   aluImm(xor),
+	"ori":    aluImm(or),
+	"andi":   aluImm(and),
+	"slli":   aluImm(sll),
+	"srli":   aluImm(srl),
+	"srai":   aluImm(sra),
+	"add":    alu(add),
+	"sub":    alu(func(a, b uint32) uint32 { return a - b }),
+	"sll":    alu(sll),
+	"slt":    alu(slt),
+	"sltu":   alu(sltu),
+	"xor":    alu(xor),
+	"srl":    alu(srl),
+	"sra":    alu(sra),
+	"or":     alu(or),
+	"and":    alu(and),
+	"fence":  nop, // memory accesses are performed in program order
+	"ecall":  execECALL,
+	"ebreak": execEBREAK,
+
+	// M extension
+	"mul":    execMulDiv,
+	"mulh":   execMulDiv,
+	"mulhsu": execMulDiv,
+	"mulhu":  execMulDiv,
+	"div":    execMulDiv,
+	"divu":   execMulDiv,
+	"rem":    execMulDiv,
+	"remu":   execMulDiv,
+
+	// A extension
+	"lr.w":      execAMO,
+	"sc.w":      execAMO,
+	"amoswap.w": execAMO,
+	"amoadd.w":  execAMO,
+	"amoxor.w":  execAMO,
+	"amoand.w":  execAMO,
+	"amoor.w":   execAMO,
+	"amomin.w":  execAMO,
+	"amomax.w":  execAMO,
+	"amominu.w": execAMO,
+	"amomaxu.w": execAMO,
+
+	// F and D extensions
+	"flw":       fpu(execFPLoad),
+	"fld":       fpu(execFPLoad),
+	"fsw":       fpu(execFPStore),
+	"fsd":       fpu(execFPStore),
+	"fmadd.s":   fpu(execFMA),
+	"fmsub.s":   fpu(execFMA),
+	"fnmsub.s":  fpu(execFMA),
+	"fnmadd.s":  fpu(execFMA),
+	"fmadd.d":   fpu(execFMA),
+	"fmsub.d":   fpu(execFMA),
+	"fnmsub.d":  fpu(execFMA),
+	"fnmadd.d":  fpu(execFMA),
+	"fadd.s":    fpu(execOpFP),
+	"fsub.s":    fpu(execOpFP),
+	"fmul.s":    fpu(execOpFP),
+	"fdiv.s":    fpu(execOpFP),
+	"fsqrt.s":   fpu(execOpFP),
+	"fsgnj.s":   fpu(execOpFP),
+	"fsgnjn.s":  fpu(execOpFP),
+	"fsgnjx.s":  fpu(execOpFP),
+	"fmin.s":    fpu(execOpFP),
+	"fmax.s":    fpu(execOpFP),
+	"fcvt.w.s":  fpu(execOpFP),
+	"fcvt.wu.s": fpu(execOpFP),
+	"fmv.x.w":   fpu(execOpFP),
+	"feq.s":     fpu(execOpFP),
+	"flt.s":     fpu(execOpFP),
+	"fle.s":     fpu(execOpFP),
+	"fclass.s":  fpu(execOpFP),
+	"fcvt.s.w":  fpu(execOpFP),
+	"fcvt.s.wu": fpu(execOpFP),
+	"fmv.w.x":   fpu(execOpFP),
+	"fadd.d":    fpu(execOpFP),
+	"fsub.d":    fpu(execOpFP),
+	"fmul.d":    fpu(execOpFP),
+	"fdiv.d":    fpu(execOpFP),
+	"fsqrt.d":   fpu(execOpFP),
+	"fsgnj.d":   fpu(execOpFP),
+	"fsgnjn.d":  fpu(execOpFP),
+	"fsgnjx.d":  fpu(execOpFP),
+	"fmin.d":    fpu(execOpFP),
+	"fmax.d":    fpu(execOpFP),
+	"fcvt.s.d":  fpu(execOpFP),
+	"fcvt.d.s":  fpu(execOpFP),
+	"feq.d":     fpu(execOpFP),
+	"flt.d":     fpu(execOpFP),
+	"fle.d":     fpu(execOpFP),
+	"fclass.d":  fpu(execOpFP),
+	"fcvt.w.d":  fpu(execOpFP),
+	"fcvt.wu.d": fpu(execOpFP),
+	"fcvt.d.w":  fpu(execOpFP),
+	"fcvt.d.wu": fpu(execOpFP),
+
+	// Zicsr
+	"csrrw":  execZicsr,
+	"csrrs":  execZicsr,
+	"csrrc":  execZicsr,
+	"csrrwi": execZicsr,
+	"csrrsi": execZicsr,
+	"csrrci": execZicsr,
+
+	// privileged instructions
+	"sret":       func(c *CPU, _ assembler.Instruction, _ uint32, _ WordHandler) error { return c.sret() },
+	"mret":       func(c *CPU, _ assembler.Instruction, _ uint32, _ WordHandler) error { return c.mret() },
+	"wfi":        nop, // the next Step checks for interrupts anyway
+	"sfence.vma": func(c *CPU, instr assembler.Instruction, _ uint32, _ WordHandler) error { return c.sfenceVMA(instr) },
+}
+
+// init registers the executors in assembler.Specs. An instruction without an
+// executor, or an executor without an instruction, is a programming error.
+func init() {
+	for i := range assembler.Specs {
+		spec := &assembler.Specs[i]
+		execute, ok := executors[spec.Mnemonic]
+		if !ok {
+			panic("no executor for " + spec.Mnemonic)
+		}
+		spec.SetExec(execute)
+	}
+	if len(executors) != len(assembler.Specs) {
+		panic(fmt.Sprintf("%d executors for %d instructions", len(executors), len(assembler.Specs)))
+	}
+}
+
+// the integer ALU operations, shared by the register and the immediate forms
+func add(a, b uint32) uint32  { return a + b }
+func sll(a, b uint32) uint32  { return a << (b & 0x1F) }
+func slt(a, b uint32) uint32  { return boolToUint32(int32(a) < int32(b)) }
+func sltu(a, b uint32) uint32 { return boolToUint32(a < b) }
+func xor(a, b uint32) uint32  { return a ^ b }
+func srl(a, b uint32) uint32  { return a >> (b & 0x1F) }
+func sra(a, b uint32) uint32  { return uint32(int32(a) >> (b & 0x1F)) }
+func or(a, b uint32) uint32   { return a | b }
+func and(a, b uint32) uint32  { return a & b }
+
+// alu returns the executor of a register-register operation: rd = op(rs1, rs2).
+func alu(op func(a, b uint32) uint32) executor {
+	return func(c *CPU, instr assembler.Instruction, _ uint32, _ WordHandler) error {
+		c.SetReg(RegIndex(instr.Rd()), op(c.Reg[instr.Rs1()], c.Reg[instr.Rs2()]))
+		return nil
+	}
+}
+
+// aluImm returns the executor of a register-immediate operation: rd = op(rs1, imm).
+// The shift amount of the immediate shifts is in the lower bits of imm.
+func aluImm(op func(a, b uint32) uint32) executor {
+	return func(c *CPU, instr assembler.Instruction, _ uint32, _ WordHandler) error {
+		c.SetReg(RegIndex(instr.Rd()), op(c.Reg[instr.Rs1()], uint32(instr.ImmI())))
+		return nil
+	}
+}
+
+func nop(*CPU, assembler.Instruction, uint32, WordHandler) error {
+	return nil
+}
+
+func execLUI(c *CPU, instr assembler.Instruction, _ uint32, _ WordHandler) error {
+	c.SetReg(RegIndex(instr.Rd()), uint32(instr.ImmU())<<12)
+	return nil
+}
+
+func execAUIPC(c *CPU, instr assembler.Instruction, _ uint32, _ WordHandler) error {
+	c.SetReg(RegIndex(instr.Rd()), c.PC+uint32(instr.ImmU())<<12)
+	return nil
+}
+
+func execJAL(c *CPU, instr assembler.Instruction, size uint32, _ WordHandler) error {
+	c.SetReg(RegIndex(instr.Rd()), c.PC+size)
+	c.PC = uint32(int32(c.PC) + instr.ImmJ())
+	return nil
+}
+
+func execJALR(c *CPU, instr assembler.Instruction, size uint32, _ WordHandler) error {
+	// the target is computed before rd is written, which may be rs1
+	target := (c.Reg[instr.Rs1()] + uint32(instr.ImmI())) &^ 1
+	c.SetReg(RegIndex(instr.Rd()), c.PC+size)
+	c.PC = target
+	return nil
+}
+
+// branch returns the executor of a conditional branch taken if taken(rs1, rs2).
+func branch(taken func(a, b uint32) bool) executor {
+	return func(c *CPU, instr assembler.Instruction, size uint32, _ WordHandler) error {
+		if taken(c.Reg[instr.Rs1()], c.Reg[instr.Rs2()]) {
+			c.PC = uint32(int32(c.PC) + instr.ImmB())
+		} else {
+			c.PC += size
+		}
+		return nil
+	}
+}
+
+// load returns the executor of a load of size bytes, sign-extended if signed.
+func load(size uint32, signed bool) executor {
+	return func(c *CPU, instr assembler.Instruction, _ uint32, memory WordHandler) error {
+		memory = c.mmu(memory, accessLoad)
+		addr := c.Reg[instr.Rs1()] + uint32(instr.ImmI())
+		var value uint32
+		var err error
+		switch size {
+		case 1:
+			var b uint8
+			b, err = readByte(memory, addr)
+			value = uint32(b)
+			if signed {
+				value = uint32(int32(int8(b)))
+			}
+		case 2:
+			var h uint16
+			h, err = readHalf(memory, addr)
+			value = uint32(h)
+			if signed {
+				value = uint32(int32(int16(h)))
+			}
+		default:
+			value, err = memory.ReadWord(addr)
+		}
+		if err != nil {
+			return accessFault(CAUSE_LOAD_ACCESS, addr, fmt.Errorf("LOAD failed: %w", err))
+		}
+		c.SetReg(RegIndex(instr.Rd()), value)
+		return nil
+	}
+}
+
+// store returns the executor of a store of the lower size bytes of rs2.
+func store(size uint32) executor {
+	return func(c *CPU, instr assembler.Instruction, _ uint32, memory WordHandler) error {
+		memory = c.mmu(memory, accessStore)
+		addr := c.Reg[instr.Rs1()] + uint32(instr.ImmS())
+		value := c.Reg[instr.Rs2()]
+		c.invalidateReservation(addr, size)
+		var err error
+		switch size {
+		case 1:
+			err = writeByte(memory, addr, uint8(value))
+		case 2:
+			err = writeHalf(memory, addr, uint16(value))
+		default:
+			err = memory.WriteWord(addr, value)
+		}
+		return accessFault(CAUSE_STORE_ACCESS, addr, err)
+	}
+}
+
+func execECALL(c *CPU, _ assembler.Instruction, _ uint32, memory WordHandler) error {
+	if c.Env != nil {
+		return c.ecall(memory)
+	}
+	// the causes for U-, S- and M-mode are consecutive, like the privilege levels
+	return &Exception{Cause: CAUSE_ECALL_U + c.Priv, Err: ErrEcall}
+}
+
+func execEBREAK(c *CPU, _ assembler.Instruction, _ uint32, memory WordHandler) error {
+	if c.Semihost != nil && c.isSemihostingCall(memory) {
+		return c.semihost(memory)
+	}
+	return &Exception{Cause: CAUSE_BREAKPOINT, Tval: c.PC, Err: ErrBreakpoint}
+}
+
+func execMulDiv(c *CPU, instr assembler.Instruction, _ uint32, _ WordHandler) error {
+	c.SetReg(RegIndex(instr.Rd()), mulDiv(instr.Funct3(), c.Reg[instr.Rs1()], c.Reg[instr.Rs2()]))
+	return nil
+}
+
+func execAMO(c *CPU, instr assembler.Instruction, _ uint32, memory WordHandler) error {
+	// AMOs need write permission for their read as well; only LR is a pure load
+	access := accessStore
+	if instr.Funct5() == assembler.FUNCT5_LR {
+		access = accessLoad
+	}
+	return c.execAtomic(instr, c.mmu(memory, access))
+}
+
+// fpu returns an executor that runs exec if the FP unit is on (mstatus.FS).
+func fpu(exec executor) executor {
+	return func(c *CPU, instr assembler.Instruction, size uint32, memory WordHandler) error {
+		if !c.fpEnabled() {
+			return fmt.Errorf("FP instruction while the FP unit is off (mstatus.FS): 0x%08X", uint32(instr))
+		}
+		return exec(c, instr, size, memory)
+	}
+}
+
+func execFPLoad(c *CPU, instr assembler.Instruction, _ uint32, memory WordHandler) error {
+	return c.execFPLoadStore(instr, c.mmu(memory, accessLoad))
+}
+
+func execFPStore(c *CPU, instr assembler.Instruction, _ uint32, memory WordHandler) error {
+	return c.execFPLoadStore(instr, c.mmu(memory, accessStore))
+}
+
+func execFMA(c *CPU, instr assembler.Instruction, _ uint32, _ WordHandler) error {
+	return c.execFMA(instr)
+}
+
+func execOpFP(c *CPU, instr assembler.Instruction, _ uint32, _ WordHandler) error {
+	return c.execOpFP(instr)
+}
+
+func execZicsr(c *CPU, instr assembler.Instruction, _ uint32, _ WordHandler) error {
+	return c.execCSR(instr)
+}
@@ -0,0 +1,35 @@
+package arch
+
+import (
+	"testing"
+
+	"github.com/malikwirin/riscvemu/assembler"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecutors(t *testing.T) {
+	specs := make(map[string]bool)
+	for i := range assembler.Specs {
+		s := &assembler.Specs[i]
+		specs[s.Mnemonic] = true
+		assert.NotNil(t, executors[s.Mnemonic], "no executor for %s", s.Mnemonic)
+		assert.NotNil(t, s.Exec(), "executor of %s not registered", s.Mnemonic)
+	}
+	for name := range executors {
+		assert.True(t, specs[name], "executor for unknown instruction %s", name)
+	}
+}
+
+func TestCPU_ExecInvalid(t *testing.T) {
+	cpu := NewCPU()
+	mem := NewMemory(64)
+	// jalr with funct3 1 is reserved: it must not jump
+	_ = mem.WriteWord(0, 0x000090E7)
+	err := cpu.Step(mem)
+	var exc *Exception
+	if assert.ErrorAs(t, err, &exc) {
+		assert.Equal(t, CAUSE_ILLEGAL_INSTRUCTION, exc.Cause)
+	}
+	assert.Equal(t, uint32(0), cpu.PC)
+	assert.Equal(t, uint32(0), cpu.Reg[1])
+}
@@ -0,0 +1,173 @@
+package arch
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Export-only image formats, in addition to FormatRaw, FormatIHex and FormatReadmemh.
+const (
+	FormatReadmemb = "readmemb" // Verilog $readmemb
+	FormatLogisim  = "logisim"  // Logisim "v2.0 raw" memory image
+)
+
+// ExportFormats lists the formats accepted by ExportImage.
+var ExportFormats = []string{FormatRaw, FormatIHex, FormatReadmemh, FormatReadmemb, FormatLogisim}
+
+// DetectExportFormat returns the export format for the file at path from its
+// extension, or "" if there is no obvious one. Unlike for loading, ".hex" means Intel HEX.
+func DetectExportFormat(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".hex" {
+		return FormatIHex
+	}
+	if format := imageExtensions[ext]; format != FormatSRec {
+		return format
+	}
+	return ""
+}
+
+// ReadRange reads size bytes of memory starting at addr.
+func ReadRange(memory WordHandler, addr, size uint32) ([]byte, error) {
+	data := make([]byte, size)
+	for i := uint32(0); i < size; i++ {
+		b, err := readByte(memory, addr+i)
+		if err != nil {
+			return nil, err
+		}
+		data[i] = b
+	}
+	return data, nil
+}
+
+// ExportImage writes data, which belongs at address base, as a memory image in
+// the given format. The word-oriented formats ($readmemh, $readmemb and
+// Logisim) use 32-bit little-endian words, so base must be word-aligned and a
+// partial last word is padded with zeros.
+func ExportImage(w io.Writer, format string, base uint32, data []byte) error {
+	out := bufio.NewWriter(w)
+	var err error
+	switch format {
+	case FormatRaw:
+		_, err = out.Write(data)
+	case FormatIHex:
+		err = exportIHex(out, base, data)
+	case FormatReadmemh, FormatReadmemb, FormatLogisim:
+		if base%4 != 0 {
+			return fmt.Errorf("%s: base address 0x%08x is not word-aligned", format, base)
+		}
+		err = exportWords(out, format, base, words(data))
+	default:
+		return fmt.Errorf("unknown export format %q (supported: %s)", format, strings.Join(ExportFormats, ", "))
+	}
+	if err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+// ExportImageFile writes the image to path (see ExportImage). An empty format
+// is detected with DetectExportFormat.
+func ExportImageFile(path, format string, base uint32, data []byte) error {
+	if format == "" {
+		if format = DetectExportFormat(path); format == "" {
+			return fmt.Errorf("%s: unknown image format, choose one of %s", path, strings.Join(ExportFormats, ", "))
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := ExportImage(f, format, base, data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// words splits data into little-endian words, padding the last one with zeros.
+func words(data []byte) []uint32 {
+	result := make([]uint32, (len(data)+3)/4)
+	for i, b := range data {
+		result[i/4] |= uint32(b) << (8 * (i % 4))
+	}
+	return result
+}
+
+func exportWords(w io.Writer, format string, base uint32, words []uint32) error {
+	var err error
+	printf := func(f string, args ...any) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, f, args...)
+		}
+	}
+	switch format {
+	case FormatReadmemh, FormatReadmemb:
+		if base != 0 {
+			printf("@%x\n", base/4) // in words, always hexadecimal
+		}
+		for _, word := range words {
+			if format == FormatReadmemh {
+				printf("%08x\n", word)
+			} else {
+				printf("%032b\n", word)
+			}
+		}
+	case FormatLogisim:
+		// Logisim images start at address 0; runs of equal words are written as "count*value"
+		printf("v2.0 raw\n")
+		if base != 0 {
+			printf("%d*0\n", base/4)
+		}
+		for i := 0; i < len(words); {
+			run := 1
+			for i+run < len(words) && words[i+run] == words[i] {
+				run++
+			}
+			if run >= 4 {
+				printf("%d*%x\n", run, words[i])
+				i += run
+				continue
+			}
+			printf("%x\n", words[i])
+			i++
+		}
+	}
+	return err
+}
+
+// exportIHex writes Intel HEX data records of up to 16 bytes, with extended
+// linear address records whenever the upper 16 address bits change.
+func exportIHex(w io.Writer, base uint32, data []byte) error {
+	record := func(kind byte, addr uint16, payload []byte) error {
+		sum := uint8(len(payload)) + uint8(addr>>8) + uint8(addr) + kind
+		line := fmt.Sprintf(":%02X%04X%02X", len(payload), addr, kind)
+		for _, b := range payload {
+			line += fmt.Sprintf("%02X", b)
+			sum += b
+		}
+		_, err := fmt.Fprintf(w, "%s%02X\n", line, -sum)
+		return err
+	}
+	upper := uint32(0)
+	for i := 0; i < len(data); {
+		addr := base + uint32(i)
+		if addr>>16 != upper {
+			upper = addr >> 16
+			if err := record(0x04, 0, []byte{uint8(upper >> 8), uint8(upper)}); err != nil {
+				return err
+			}
+		}
+		// records must not cross a 64 KiB boundary
+		n := min(16, len(data)-i, int(0x10000-addr&0xFFFF))
+		if err := record(0x00, uint16(addr), data[i:i+n]); err != nil {
+			return err
+		}
+		i += n
+	}
+	return record(0x01, 0, nil)
+}
@@ -0,0 +1,92 @@
+package arch
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func exportString(t *testing.T, format string, base uint32, data []byte) string {
+	t.Helper()
+	var out bytes.Buffer
+	assert.NoError(t, ExportImage(&out, format, base, data))
+	return out.String()
+}
+
+func TestExportImage_Words(t *testing.T) {
+	data := []byte{0x93, 0x00, 0x50, 0x00, 0x13, 0x01, 0xA0}
+	assert.Equal(t, "00500093\n00a00113\n", exportString(t, FormatReadmemh, 0, data), "last word is padded")
+	assert.Equal(t, "@4\n00500093\n00a00113\n", exportString(t, FormatReadmemh, 0x10, data))
+	assert.Equal(t, "@1\n00000000010100000000000010010011\n", exportString(t, FormatReadmemb, 4, data[:4]))
+
+	zeros := make([]byte, 20)
+	assert.Equal(t, "v2.0 raw\n2*0\n500093\n5*0\na00113\n",
+		exportString(t, FormatLogisim, 8, append(append(data[:4:4], zeros...), 0x13, 0x01, 0xA0, 0x00)))
+
+	var out bytes.Buffer
+	assert.Error(t, ExportImage(&out, FormatReadmemh, 2, data), "misaligned base")
+	assert.Error(t, ExportImage(&out, "coff", 0, data))
+}
+
+func TestExportImage_IHex(t *testing.T) {
+	assert.Equal(t, ":0B0010006164647265737320676170A7\n:00000001FF\n",
+		exportString(t, FormatIHex, 0x10, []byte("address gap")))
+
+	// crossing a 64 KiB boundary starts a new extended linear address
+	data := make([]byte, 40)
+	for i := range data {
+		data[i] = uint8(i)
+	}
+	text := exportString(t, FormatIHex, 0x8000FFF0, data)
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	assert.Equal(t, []string{":020000048000", ":10FFF000", ":020000048001", ":10000000", ":08001000", ":00000001FF"},
+		[]string{lines[0][:13], lines[1][:9], lines[2][:13], lines[3][:9], lines[4][:9], lines[5]})
+
+	// round trip through the loader
+	s := NewSparseMemory()
+	img, err := LoadImage(s, strings.NewReader(text), FormatIHex, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []MemoryRange{{0x8000FFF0, 40}}, img.Segments)
+	loaded, err := ReadRange(s, 0x8000FFF0, 40)
+	assert.NoError(t, err)
+	assert.Equal(t, data, loaded)
+}
+
+func TestExportImage_RoundTrip(t *testing.T) {
+	data := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	// raw images carry no address, the others are loaded relative to 0
+	for format, loadBase := range map[string]uint32{FormatRaw: 0x20, FormatIHex: 0, FormatReadmemh: 0} {
+		t.Run(format, func(t *testing.T) {
+			mem := NewMemory(64)
+			_, err := LoadImage(mem, strings.NewReader(exportString(t, format, 0x20, data)), format, loadBase)
+			assert.NoError(t, err)
+			assert.Equal(t, data, mem.Data[0x20:0x28])
+		})
+	}
+}
+
+func TestExportImageFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "prog.mem")
+	assert.NoError(t, ExportImageFile(path, "", 0, []byte{0x13, 0, 0, 0}))
+	text, _ := os.ReadFile(path)
+	assert.Equal(t, "00000013\n", string(text))
+	assert.Equal(t, FormatIHex, DetectExportFormat("prog.HEX"))
+	assert.Equal(t, FormatRaw, DetectExportFormat("prog.bin"))
+	assert.Equal(t, "", DetectExportFormat("prog.srec"), "S-records cannot be exported")
+	assert.Error(t, ExportImageFile(filepath.Join(dir, "prog.out"), "", 0, nil))
+}
+
+func TestReadRange(t *testing.T) {
+	mem := NewMemory(8)
+	assert.NoError(t, mem.WriteWord(4, 0x04030201))
+	data, err := ReadRange(mem, 3, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0, 1, 2, 3}, data)
+	_, err = ReadRange(mem, 6, 4)
+	assert.ErrorIs(t, err, ErrOutOfBounds)
+}
@@ -0,0 +1,293 @@
+package arch
+
+import (
+	"fmt"
+
+	"github.com/malikwirin/riscvemu/assembler"
+)
+
+// fcsr layout: accrued exception flags in bits 0-4, rounding mode (frm) in bits 5-7
+const (
+	FCSR_FFLAGS_MASK uint32 = 0x1F
+	FCSR_FRM_SHIFT          = 5
+	FCSR_FRM_MASK    uint32 = 0x7 << FCSR_FRM_SHIFT
+	FCSR_MASK               = FCSR_FFLAGS_MASK | FCSR_FRM_MASK
+)
+
+// nanBoxUpper are the upper 32 bits of a single-precision value held in a 64-bit FP register.
+const nanBoxUpper uint64 = 0xFFFFFFFF << 32
+
+// BoxSingle NaN-boxes a single-precision value for storage in an FP register.
+func BoxSingle(v uint32) uint64 {
+	return nanBoxUpper | uint64(v)
+}
+
+// IsBoxedSingle reports whether an FP register value is a properly NaN-boxed single-precision value.
+func IsBoxedSingle(v uint64) bool {
+	return v&nanBoxUpper == nanBoxUpper
+}
+
+// unboxSingle returns the single-precision value of an FP register. Values that are
+// not properly NaN-boxed are treated as the canonical NaN.
+func unboxSingle(v uint64) uint32 {
+	if !IsBoxedSingle(v) {
+		return uint32(singleFormat.canonicalNaN())
+	}
+	return uint32(v)
+}
+
+// Frm returns the dynamic rounding mode held in fcsr.
+func (c *CPU) Frm() uint32 {
+	return (c.FCSR & FCSR_FRM_MASK) >> FCSR_FRM_SHIFT
+}
+
+// FFlags returns the accrued exception flags held in fcsr.
+func (c *CPU) FFlags() uint32 {
+	return c.FCSR & FCSR_FFLAGS_MASK
+}
+
+func (c *CPU) raiseFlags(flags uint32) {
+	if flags != 0 {
+		c.setFCSR(c.FCSR | flags&FCSR_FFLAGS_MASK)
+	}
+}
+
+// setFCSR updates fcsr, which makes the FP state dirty.
+func (c *CPU) setFCSR(v uint32) {
+	c.FCSR = v
+	c.markFPDirty()
+}
+
+// fpEnabled reports whether FP instructions and CSRs are accessible (mstatus.FS is not Off).
+func (c *CPU) fpEnabled() bool {
+	return c.csr.mstatus&MSTATUS_FS != FS_OFF
+}
+
+// markFPDirty records in mstatus.FS that the FP state was modified.
+func (c *CPU) markFPDirty() {
+	c.csr.mstatus = c.csr.mstatus&^MSTATUS_FS | FS_DIRTY
+}
+
+// roundingMode resolves the rm field of an instruction, substituting frm for RM_DYN.
+func (c *CPU) roundingMode(rm uint32) (uint32, error) {
+	if rm == assembler.RM_DYN {
+		rm = c.Frm()
+	}
+	if rm > assembler.RM_RMM {
+		return 0, fmt.Errorf("invalid rounding mode: %d", rm)
+	}
+	return rm, nil
+}
+
+// fpFormatOf returns the format selected by the fmt field of an instruction.
+func fpFormatOf(instr assembler.Instruction) (fpFormat, error) {
+	switch instr.Fmt() {
+	case assembler.FMT_S:
+		return singleFormat, nil
+	case assembler.FMT_D:
+		return doubleFormat, nil
+	}
+	return fpFormat{}, fmt.Errorf("unsupported FP format: %d", instr.Fmt())
+}
+
+// readF reads an FP register as a value of format f.
+func (c *CPU) readF(idx uint32, f fpFormat) uint64 {
+	if f == singleFormat {
+		return uint64(unboxSingle(c.FReg[idx]))
+	}
+	return c.FReg[idx]
+}
+
+// writeF writes a value of format f to an FP register.
+func (c *CPU) writeF(idx uint32, f fpFormat, v uint64) {
+	if f == singleFormat {
+		v = BoxSingle(uint32(v))
+	}
+	c.FReg[idx] = v
+	c.markFPDirty()
+}
+
+// execFPLoadStore executes FLW, FLD, FSW and FSD.
+func (c *CPU) execFPLoadStore(instr assembler.Instruction, memory WordHandler) error {
+	if instr.Opcode() == assembler.OPCODE_LOAD_FP {
+		addr := c.Reg[instr.Rs1()] + uint32(instr.ImmI())
+		lo, err := memory.ReadWord(addr)
+		if err != nil {
+			return accessFault(CAUSE_LOAD_ACCESS, addr, fmt.Errorf("LOAD-FP failed: %w", err))
+		}
+		switch instr.Funct3() {
+		case assembler.FUNCT3_FLW:
+			c.writeF(instr.Rd(), singleFormat, uint64(lo))
+		case assembler.FUNCT3_FLD:
+			hi, err := memory.ReadWord(addr + 4)
+			if err != nil {
+				return accessFault(CAUSE_LOAD_ACCESS, addr, fmt.Errorf("LOAD-FP failed: %w", err))
+			}
+			c.writeF(instr.Rd(), doubleFormat, uint64(hi)<<32|uint64(lo))
+		default:
+			return fmt.Errorf("unsupported LOAD-FP funct3: 0x%X", instr.Funct3())
+		}
+		return nil
+	}
+
+	addr := c.Reg[instr.Rs1()] + uint32(instr.ImmS())
+	value := c.FReg[instr.Rs2()]
+	switch instr.Funct3() {
+	case assembler.FUNCT3_FSW:
+		c.invalidateReservation(addr, 4)
+		return accessFault(CAUSE_STORE_ACCESS, addr, memory.WriteWord(addr, uint32(value)))
+	case assembler.FUNCT3_FSD:
+		c.invalidateReservation(addr, 4)
+		c.invalidateReservation(addr+4, 4)
+		if err := memory.WriteWord(addr, uint32(value)); err != nil {
+			return accessFault(CAUSE_STORE_ACCESS, addr, err)
+		}
+		return accessFault(CAUSE_STORE_ACCESS, addr, memory.WriteWord(addr+4, uint32(value>>32)))
+	default:
+		return fmt.Errorf("unsupported STORE-FP funct3: 0x%X", instr.Funct3())
+	}
+}
+
+// execFMA executes the fused multiply-add instructions.
+func (c *CPU) execFMA(instr assembler.Instruction) error {
+	f, err := fpFormatOf(instr)
+	if err != nil {
+		return err
+	}
+	rm, err := c.roundingMode(instr.Funct3())
+	if err != nil {
+		return err
+	}
+	var negProduct, negAddend bool
+	switch instr.Opcode() {
+	case assembler.OPCODE_FMSUB:
+		negAddend = true
+	case assembler.OPCODE_FNMSUB:
+		negProduct = true
+	case assembler.OPCODE_FNMADD:
+		negProduct, negAddend = true, true
+	}
+	a, b, d := c.readF(instr.Rs1(), f), c.readF(instr.Rs2(), f), c.readF(instr.Rs3(), f)
+	result, flags := fpFMA(f, a, b, d, negProduct, negAddend, rm)
+	c.writeF(instr.Rd(), f, result)
+	c.raiseFlags(flags)
+	return nil
+}
+
+// execOpFP executes the OP-FP instructions.
+func (c *CPU) execOpFP(instr assembler.Instruction) error {
+	f, err := fpFormatOf(instr)
+	if err != nil {
+		return err
+	}
+	rd, rs1, rs2, funct3 := instr.Rd(), instr.Rs1(), instr.Rs2(), instr.Funct3()
+	a, b := c.readF(rs1, f), c.readF(rs2, f)
+	unknown := fmt.Errorf("unsupported OP-FP instruction: 0x%08X", uint32(instr))
+
+	var result uint64
+	var flags uint32
+	switch funct5 := instr.Funct5(); funct5 {
+	case assembler.FUNCT5_FADD, assembler.FUNCT5_FSUB, assembler.FUNCT5_FMUL, assembler.FUNCT5_FDIV, assembler.FUNCT5_FSQRT:
+		rm, err := c.roundingMode(funct3)
+		if err != nil {
+			return err
+		}
+		switch funct5 {
+		case assembler.FUNCT5_FADD:
+			result, flags = fpAdd(f, a, b, rm)
+		case assembler.FUNCT5_FSUB:
+			result, flags = fpSub(f, a, b, rm)
+		case assembler.FUNCT5_FMUL:
+			result, flags = fpMul(f, a, b, rm)
+		case assembler.FUNCT5_FDIV:
+			result, flags = fpDiv(f, a, b, rm)
+		default:
+			if rs2 != 0 {
+				return unknown
+			}
+			result, flags = fpSqrt(f, a, rm)
+		}
+	case assembler.FUNCT5_FSGNJ:
+		sign := b & f.signBit()
+		switch funct3 {
+		case assembler.FUNCT3_FSGNJ:
+		case assembler.FUNCT3_FSGNJN:
+			sign ^= f.signBit()
+		case assembler.FUNCT3_FSGNJX:
+			sign ^= a & f.signBit()
+		default:
+			return unknown
+		}
+		result = a&^f.signBit() | sign
+	case assembler.FUNCT5_FMINMAX:
+		if funct3 > assembler.FUNCT3_FMAX {
+			return unknown
+		}
+		result, flags = fpMinMax(f, a, b, funct3 == assembler.FUNCT3_FMAX)
+	case assembler.FUNCT5_FCVT_FMT:
+		rm, err := c.roundingMode(funct3)
+		if err != nil {
+			return err
+		}
+		switch {
+		case f == singleFormat && rs2 == assembler.FMT_D:
+			result, flags = fpConvert(doubleFormat, singleFormat, c.readF(rs1, doubleFormat), rm)
+		case f == doubleFormat && rs2 == assembler.FMT_S:
+			result, flags = fpConvert(singleFormat, doubleFormat, c.readF(rs1, singleFormat), rm)
+		default:
+			return unknown
+		}
+	case assembler.FUNCT5_FCMP:
+		if funct3 > assembler.FUNCT3_FEQ {
+			return unknown
+		}
+		res, flags := fpCompare(f, a, b, funct3)
+		c.SetReg(RegIndex(rd), boolToUint32(res))
+		c.raiseFlags(flags)
+		return nil
+	case assembler.FUNCT5_FCVT_TO_X:
+		rm, err := c.roundingMode(funct3)
+		if err != nil {
+			return err
+		}
+		if rs2 > 1 {
+			return unknown
+		}
+		res, flags := fpToInt(f, a, rs2 == 1, rm)
+		c.SetReg(RegIndex(rd), res)
+		c.raiseFlags(flags)
+		return nil
+	case assembler.FUNCT5_FCVT_TO_F:
+		rm, err := c.roundingMode(funct3)
+		if err != nil {
+			return err
+		}
+		if rs2 > 1 {
+			return unknown
+		}
+		result, flags = fpFromInt(f, c.Reg[rs1], rs2 == 1, rm)
+	case assembler.FUNCT5_FMV_X:
+		switch {
+		case rs2 != 0:
+			return unknown
+		case funct3 == assembler.FUNCT3_FCLASS:
+			c.SetReg(RegIndex(rd), fpClass(f, a))
+		case funct3 == assembler.FUNCT3_FMV_X && f == singleFormat:
+			// moves the raw register bits, without checking the NaN-boxing
+			c.SetReg(RegIndex(rd), uint32(c.FReg[rs1]))
+		default:
+			return unknown
+		}
+		return nil
+	case assembler.FUNCT5_FMV_F:
+		if f != singleFormat || rs2 != 0 || funct3 != 0 {
+			return unknown
+		}
+		result = uint64(c.Reg[rs1])
+	default:
+		return unknown
+	}
+	c.writeF(rd, f, result)
+	c.raiseFlags(flags)
+	return nil
+}
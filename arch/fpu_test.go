@@ -0,0 +1,143 @@
+package arch
+
+import (
+	"math"
+	"testing"
+
+	"github.com/malikwirin/riscvemu/assembler"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCPU_FPLoadArithmeticStore(t *testing.T) {
+	cpu, mem := NewCPU(), NewMemory(256)
+	assert.NoError(t, mem.WriteWord(128, math.Float32bits(1.5)))
+	assert.NoError(t, mem.WriteWord(132, math.Float32bits(2.25)))
+	cpu.Reg[2] = 128
+	runInstructions(t, cpu, mem,
+		"flw f1, 0(x2)",
+		"flw f2, 4(x2)",
+		"fadd.s f3, f1, f2",
+		"fmul.s fa0, f3, f2",
+		"fsw fa0, 8(x2)",
+	)
+	assert.Equal(t, BoxSingle(math.Float32bits(3.75)), cpu.FReg[3], "single results are NaN-boxed")
+	got, _ := mem.ReadWord(136)
+	assert.Equal(t, float32(8.4375), math.Float32frombits(got))
+	assert.Equal(t, uint32(0), cpu.FFlags(), "exact results raise no flags")
+}
+
+func TestCPU_FPDouble(t *testing.T) {
+	cpu, mem := NewCPU(), NewMemory(256)
+	bits := math.Float64bits(2.0)
+	assert.NoError(t, mem.WriteWord(128, uint32(bits)))
+	assert.NoError(t, mem.WriteWord(132, uint32(bits>>32)))
+	cpu.Reg[2] = 128
+	runInstructions(t, cpu, mem,
+		"fld f1, 0(x2)",
+		"fsqrt.d f2, f1",
+		"fcvt.s.d f3, f2",
+		"fcvt.d.s f4, f3",
+		"fsd f2, 8(x2)",
+	)
+	assert.Equal(t, math.Sqrt2, math.Float64frombits(cpu.FReg[2]))
+	assert.Equal(t, BoxSingle(math.Float32bits(float32(math.Sqrt2))), cpu.FReg[3])
+	assert.Equal(t, float64(float32(math.Sqrt2)), math.Float64frombits(cpu.FReg[4]))
+	lo, _ := mem.ReadWord(136)
+	hi, _ := mem.ReadWord(140)
+	assert.Equal(t, math.Float64bits(math.Sqrt2), uint64(hi)<<32|uint64(lo))
+	assert.Equal(t, FFLAG_NX, cpu.FFlags())
+}
+
+func TestCPU_FPConversionsAndMoves(t *testing.T) {
+	cpu, mem := NewCPU(), NewMemory(256)
+	cpu.Reg[1] = uint32(0xFFFFFFF9) // -7
+	runInstructions(t, cpu, mem,
+		"fcvt.s.w f1, x1",
+		"fcvt.d.w f2, x1",
+		"fmv.x.w x2, f1",
+		"fcvt.w.d x3, f2",
+		"fcvt.wu.s x4, f1",
+		"fmv.w.x f5, x2",
+		"feq.s x5, f1, f5",
+		"flt.d x6, f2, f2",
+		"fclass.s x7, f1",
+		"fsgnjn.s f6, f1, f1",
+	)
+	assert.Equal(t, BoxSingle(math.Float32bits(-7)), cpu.FReg[1])
+	assert.Equal(t, math.Float64bits(-7), cpu.FReg[2])
+	assert.Equal(t, math.Float32bits(-7), cpu.Reg[2], "fmv.x.w moves the raw bits")
+	assert.Equal(t, uint32(0xFFFFFFF9), cpu.Reg[3])
+	assert.Equal(t, uint32(0), cpu.Reg[4], "negative values saturate to 0 for fcvt.wu")
+	assert.Equal(t, uint32(1), cpu.Reg[5])
+	assert.Equal(t, uint32(0), cpu.Reg[6])
+	assert.Equal(t, uint32(1<<1), cpu.Reg[7], "negative normal")
+	assert.Equal(t, BoxSingle(math.Float32bits(7)), cpu.FReg[6])
+	assert.Equal(t, FFLAG_NV, cpu.FFlags(), "fcvt.wu of a negative value is invalid")
+}
+
+func TestCPU_FPRoundingModes(t *testing.T) {
+	cpu, mem := NewCPU(), NewMemory(256)
+	cpu.FReg[1] = BoxSingle(math.Float32bits(2.5))
+	cpu.FCSR = assembler.RM_RUP << FCSR_FRM_SHIFT
+	runInstructions(t, cpu, mem,
+		"fcvt.w.s x1, f1",      // dynamic: rup
+		"fcvt.w.s x2, f1, rne", // static
+		"fcvt.w.s x3, f1, rmm",
+		"fcvt.w.s x4, f1, rdn",
+	)
+	assert.Equal(t, uint32(3), cpu.Reg[1])
+	assert.Equal(t, uint32(2), cpu.Reg[2])
+	assert.Equal(t, uint32(3), cpu.Reg[3])
+	assert.Equal(t, uint32(2), cpu.Reg[4])
+	assert.Equal(t, FFLAG_NX, cpu.FFlags())
+
+	// an invalid frm makes instructions using the dynamic rounding mode illegal
+	cpu.FCSR = 5 << FCSR_FRM_SHIFT
+	assert.NoError(t, mem.WriteWord(0, uint32(mustAssemble(t, "fadd.s f1, f1, f1"))))
+	cpu.PC = 0
+	assert.Error(t, cpu.Step(mem))
+}
+
+func TestCPU_FPNaNBoxing(t *testing.T) {
+	cpu, mem := NewCPU(), NewMemory(256)
+	cpu.FReg[1] = math.Float64bits(1.0) // not a boxed single
+	cpu.FReg[2] = BoxSingle(math.Float32bits(1.0))
+	runInstructions(t, cpu, mem,
+		"fadd.s f3, f1, f2",
+		"fclass.s x1, f1",
+	)
+	assert.Equal(t, BoxSingle(0x7FC00000), cpu.FReg[3], "improperly boxed inputs are the canonical NaN")
+	assert.Equal(t, uint32(1<<9), cpu.Reg[1], "quiet NaN")
+}
+
+func TestCPU_FPFusedMultiplyAdd(t *testing.T) {
+	cpu, mem := NewCPU(), NewMemory(256)
+	cpu.FReg[1] = math.Float64bits(2)
+	cpu.FReg[2] = math.Float64bits(3)
+	cpu.FReg[3] = math.Float64bits(1)
+	runInstructions(t, cpu, mem,
+		"fmadd.d f4, f1, f2, f3",
+		"fmsub.d f5, f1, f2, f3",
+		"fnmsub.d f6, f1, f2, f3",
+		"fnmadd.d f7, f1, f2, f3",
+	)
+	assert.Equal(t, 7.0, math.Float64frombits(cpu.FReg[4]))
+	assert.Equal(t, 5.0, math.Float64frombits(cpu.FReg[5]))
+	assert.Equal(t, -5.0, math.Float64frombits(cpu.FReg[6]))
+	assert.Equal(t, -7.0, math.Float64frombits(cpu.FReg[7]))
+}
+
+func TestCPU_CompressedFPLoadStore(t *testing.T) {
+	cpu, mem := NewCPU(), NewMemory(256)
+	cpu.Reg[2] = 128 // sp
+	cpu.Reg[8] = 128
+	cpu.FReg[9] = BoxSingle(math.Float32bits(4.5))
+	writeHalves(t, mem,
+		uint16(mustAssemble(t, "c.fswsp f9, 8(x2)")),
+		uint16(mustAssemble(t, "c.flw f10, 8(x8)")),
+	)
+	cpu.PC = 0
+	assert.NoError(t, cpu.Step(mem))
+	assert.NoError(t, cpu.Step(mem))
+	assert.Equal(t, BoxSingle(math.Float32bits(4.5)), cpu.FReg[10])
+}
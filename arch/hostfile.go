@@ -0,0 +1,130 @@
+package arch
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// hostFiles is the file table of an environment that gives programs access to
+// host files, shared by Linux and Semihosting. Descriptors 0, 1 and 2 are the
+// console: Stdin, Stdout and Stderr. Files opened on the host get the lowest
+// free descriptor from 3 on. Errors are returned as negated Linux error numbers.
+type hostFiles struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	files map[int32]*os.File
+}
+
+// newHostFiles returns a file table on the host's console.
+func newHostFiles() hostFiles {
+	return hostFiles{
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+		files:  make(map[int32]*os.File),
+	}
+}
+
+// isConsole reports whether fd is one of the console's descriptors.
+func isConsole(fd int32) bool {
+	return fd >= 0 && fd <= 2
+}
+
+// add enters f into the table and returns its descriptor.
+func (h *hostFiles) add(f *os.File) int32 {
+	fd := int32(3)
+	for h.files[fd] != nil {
+		fd++
+	}
+	h.files[fd] = f
+	return fd
+}
+
+// file returns the host file with descriptor fd.
+func (h *hostFiles) file(fd int32) (*os.File, bool) {
+	f, ok := h.files[fd]
+	return f, ok
+}
+
+// close closes fd. The console stays open.
+func (h *hostFiles) close(fd int32) int32 {
+	if isConsole(fd) {
+		return 0
+	}
+	f, ok := h.files[fd]
+	if !ok {
+		return -EBADF
+	}
+	delete(h.files, fd)
+	if err := f.Close(); err != nil {
+		return errno(err)
+	}
+	return 0
+}
+
+// closeAll closes all host files.
+func (h *hostFiles) closeAll() {
+	for fd, f := range h.files {
+		f.Close()
+		delete(h.files, fd)
+	}
+}
+
+// read reads up to count bytes, but at most maxTransfer, from fd to the guest's
+// buf and returns the number of bytes read.
+func (h *hostFiles) read(c *CPU, memory WordHandler, fd int32, buf, count uint32) int32 {
+	var r io.Reader
+	switch fd {
+	case 0:
+		r = h.Stdin
+	case 1, 2:
+		return -EBADF
+	default:
+		f, ok := h.files[fd]
+		if !ok {
+			return -EBADF
+		}
+		r = f
+	}
+	data := make([]byte, min(count, maxTransfer))
+	n, err := r.Read(data)
+	if err != nil && !errors.Is(err, io.EOF) && n == 0 {
+		return errno(err)
+	}
+	if err := c.writeGuest(memory, buf, data[:n]); err != nil {
+		return -EFAULT
+	}
+	return int32(n)
+}
+
+// write writes up to count bytes, but at most maxTransfer, from the guest's buf
+// to fd and returns the number of bytes written.
+func (h *hostFiles) write(c *CPU, memory WordHandler, fd int32, buf, count uint32) int32 {
+	var w io.Writer
+	switch fd {
+	case 0:
+		return -EBADF
+	case 1:
+		w = h.Stdout
+	case 2:
+		w = h.Stderr
+	default:
+		f, ok := h.files[fd]
+		if !ok {
+			return -EBADF
+		}
+		w = f
+	}
+	data, err := c.readGuest(memory, buf, min(count, maxTransfer))
+	if err != nil {
+		return -EFAULT
+	}
+	n, err := w.Write(data)
+	if err != nil && n == 0 {
+		return errno(err)
+	}
+	return int32(n)
+}
@@ -0,0 +1,324 @@
+package arch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Memory image formats accepted by LoadImage.
+const (
+	FormatRaw      = "bin"      // flat binary
+	FormatIHex     = "ihex"     // Intel HEX
+	FormatSRec     = "srec"     // Motorola S-record
+	FormatReadmemh = "readmemh" // Verilog $readmemh
+)
+
+// ImageFormats lists the supported image formats.
+var ImageFormats = []string{FormatRaw, FormatIHex, FormatSRec, FormatReadmemh}
+
+var imageExtensions = map[string]string{
+	".bin":  FormatRaw,
+	".raw":  FormatRaw,
+	".ihex": FormatIHex,
+	".ihx":  FormatIHex,
+	".srec": FormatSRec,
+	".s19":  FormatSRec,
+	".s28":  FormatSRec,
+	".s37":  FormatSRec,
+	".mot":  FormatSRec,
+	".mem":  FormatReadmemh,
+	".vmem": FormatReadmemh,
+}
+
+// DetectImageFormat returns the image format of the file at path from its
+// extension, or "" if it is not an image. ".hex" is used both for Intel HEX and
+// for $readmemh files, so these are told apart by their first character.
+func DetectImageFormat(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext != ".hex" {
+		return imageExtensions[ext]
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(":")) {
+		return FormatIHex
+	}
+	return FormatReadmemh
+}
+
+// Image describes a memory image loaded by LoadImage.
+type Image struct {
+	// Segments are the written address ranges, in file order
+	Segments []MemoryRange
+	// Entry is the start address given by the image, valid if HasEntry is set
+	Entry    uint32
+	HasEntry bool
+}
+
+// add records a write of size bytes at addr, extending the last segment if they are contiguous.
+func (img *Image) add(addr, size uint32) {
+	if n := len(img.Segments); n > 0 && img.Segments[n-1].Base+img.Segments[n-1].Size == addr {
+		img.Segments[n-1].Size += size
+		return
+	}
+	img.Segments = append(img.Segments, MemoryRange{Base: addr, Size: size})
+}
+
+// imageLoader writes the data of an image to memory, relative to base.
+type imageLoader struct {
+	memory WordHandler
+	base   uint32
+	image  Image
+}
+
+func (l *imageLoader) write(addr uint32, data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	if err := writeBytes(l.memory, l.base+addr, data); err != nil {
+		return err
+	}
+	l.image.add(l.base+addr, uint32(len(data)))
+	return nil
+}
+
+// LoadImage loads a memory image in the given format to memory. Addresses in the
+// image (and its entry point, if any) are relative to base; a raw binary is
+// loaded at base.
+func LoadImage(memory WordHandler, r io.Reader, format string, base uint32) (*Image, error) {
+	l := &imageLoader{memory: memory, base: base}
+	var err error
+	switch format {
+	case FormatRaw:
+		var data []byte
+		if data, err = io.ReadAll(r); err == nil {
+			err = l.write(0, data)
+		}
+	case FormatIHex:
+		err = l.loadIHex(r)
+	case FormatSRec:
+		err = l.loadSRec(r)
+	case FormatReadmemh:
+		err = l.loadReadmemh(r)
+	default:
+		return nil, fmt.Errorf("unknown image format %q (supported: %s)", format, strings.Join(ImageFormats, ", "))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", format, err)
+	}
+	if l.image.HasEntry {
+		l.image.Entry += base
+	}
+	return &l.image, nil
+}
+
+// LoadImageFile loads the image at path (see LoadImage). An empty format is
+// detected with DetectImageFormat.
+func LoadImageFile(memory WordHandler, path, format string, base uint32) (*Image, error) {
+	if format == "" {
+		if format = DetectImageFormat(path); format == "" {
+			return nil, fmt.Errorf("%s: unknown image format", path)
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadImage(memory, f, format, base)
+}
+
+// decodeRecord decodes the hex digits of an Intel HEX or S-record line.
+func decodeRecord(digits string) ([]byte, error) {
+	record, err := hex.DecodeString(digits)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex digits: %w", err)
+	}
+	return record, nil
+}
+
+// loadIHex reads Intel HEX: records ":LLAAAATT<data>CC" with data (00), end of
+// file (01), extended segment (02) and linear (04) addresses and start
+// addresses (03, 05).
+func (l *imageLoader) loadIHex(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	var upper uint32 // from records 02 and 04
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if line[0] != ':' {
+			return fmt.Errorf("line %d: record does not start with ':'", n)
+		}
+		record, err := decodeRecord(line[1:])
+		if err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+		if len(record) < 5 || len(record) != 5+int(record[0]) {
+			return fmt.Errorf("line %d: invalid record length", n)
+		}
+		var sum uint8
+		for _, b := range record {
+			sum += b
+		}
+		if sum != 0 {
+			return fmt.Errorf("line %d: checksum mismatch", n)
+		}
+		addr := uint32(record[1])<<8 | uint32(record[2])
+		data := record[4 : len(record)-1]
+		value := uint32(0)
+		for _, b := range data {
+			value = value<<8 | uint32(b)
+		}
+		switch kind := record[3]; {
+		case kind == 0x00:
+			if err := l.write(upper+addr, data); err != nil {
+				return fmt.Errorf("line %d: %w", n, err)
+			}
+		case kind == 0x01:
+			return nil
+		case kind == 0x02 && len(data) == 2:
+			upper = value << 4
+		case kind == 0x03 && len(data) == 4:
+			l.image.Entry, l.image.HasEntry = (value>>16)<<4+value&0xFFFF, true
+		case kind == 0x04 && len(data) == 2:
+			upper = value << 16
+		case kind == 0x05 && len(data) == 4:
+			l.image.Entry, l.image.HasEntry = value, true
+		default:
+			return fmt.Errorf("line %d: invalid record type %02X", n, kind)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("missing end-of-file record")
+}
+
+// srecAddressSize is the size of the address field of each S-record type.
+var srecAddressSize = map[byte]int{'0': 2, '1': 2, '2': 3, '3': 4, '5': 2, '6': 3, '7': 4, '8': 3, '9': 2}
+
+// loadSRec reads Motorola S-records: "S<type><count><address><data><checksum>"
+// with header (S0), data (S1-S3), count (S5, S6) and start address (S7-S9) records.
+func (l *imageLoader) loadSRec(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if len(line) < 2 || line[0] != 'S' && line[0] != 's' || srecAddressSize[line[1]] == 0 {
+			return fmt.Errorf("line %d: invalid record type", n)
+		}
+		kind, addrSize := line[1], srecAddressSize[line[1]]
+		record, err := decodeRecord(line[2:])
+		if err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+		if len(record) < 1+addrSize+1 || len(record) != 1+int(record[0]) {
+			return fmt.Errorf("line %d: invalid record length", n)
+		}
+		var sum uint8
+		for _, b := range record {
+			sum += b
+		}
+		if sum != 0xFF {
+			return fmt.Errorf("line %d: checksum mismatch", n)
+		}
+		var addr uint32
+		for _, b := range record[1 : 1+addrSize] {
+			addr = addr<<8 | uint32(b)
+		}
+		data := record[1+addrSize : len(record)-1]
+		switch kind {
+		case '1', '2', '3':
+			if err := l.write(addr, data); err != nil {
+				return fmt.Errorf("line %d: %w", n, err)
+			}
+		case '7', '8', '9':
+			l.image.Entry, l.image.HasEntry = addr, true
+		}
+	}
+	return scanner.Err()
+}
+
+// loadReadmemh reads a Verilog $readmemh file: whitespace-separated hex words,
+// "@<hex>" to set the address, and // and /* */ comments. The width of the
+// words is that of the first one (2, 4 or 8 digits: 8, 16 or 32 bits) and
+// addresses count words, as in a Verilog memory of that width.
+func (l *imageLoader) loadReadmemh(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	text := string(data)
+	var addr, width uint32 // width in bytes
+	for n := 1; text != ""; {
+		switch {
+		case text[0] == '\n':
+			n++
+			text = text[1:]
+		case text[0] == ' ' || text[0] == '\t' || text[0] == '\r':
+			text = text[1:]
+		case strings.HasPrefix(text, "//"):
+			end := strings.IndexByte(text, '\n')
+			if end < 0 {
+				end = len(text)
+			}
+			text = text[end:]
+		case strings.HasPrefix(text, "/*"):
+			end := strings.Index(text, "*/")
+			if end < 0 {
+				return fmt.Errorf("line %d: unterminated comment", n)
+			}
+			n += strings.Count(text[:end], "\n")
+			text = text[end+2:]
+		default:
+			end := strings.IndexAny(text, " \t\r\n")
+			if end < 0 {
+				end = len(text)
+			}
+			token := strings.ReplaceAll(text[:end], "_", "")
+			text = text[end:]
+			if strings.HasPrefix(token, "@") {
+				v, err := strconv.ParseUint(token[1:], 16, 32)
+				if err != nil {
+					return fmt.Errorf("line %d: invalid address %q", n, token)
+				}
+				addr = uint32(v)
+				continue
+			}
+			if width == 0 {
+				switch len(token) {
+				case 2, 4, 8:
+					width = uint32(len(token)) / 2
+				default:
+					return fmt.Errorf("line %d: words must have 2, 4 or 8 hex digits, got %q", n, token)
+				}
+			}
+			v, err := strconv.ParseUint(token, 16, int(width)*8)
+			if err != nil {
+				return fmt.Errorf("line %d: invalid %d-bit word %q", n, width*8, token)
+			}
+			word := make([]byte, width)
+			for i := range word {
+				word[i] = uint8(v >> (8 * i))
+			}
+			if err := l.write(addr*width, word); err != nil {
+				return fmt.Errorf("line %d: %w", n, err)
+			}
+			addr++
+		}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package arch
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func loadImageString(t *testing.T, mem *Memory, format, text string, base uint32) (*Image, error) {
+	t.Helper()
+	return LoadImage(mem, strings.NewReader(text), format, base)
+}
+
+func TestLoadImage_Raw(t *testing.T) {
+	mem := NewMemory(64)
+	img, err := loadImageString(t, mem, FormatRaw, "\x13\x00\x00\x00\xAA", 8)
+	assert.NoError(t, err)
+	assert.Equal(t, []MemoryRange{{8, 5}}, img.Segments)
+	assert.False(t, img.HasEntry)
+	assert.Equal(t, []byte{0x13, 0, 0, 0, 0xAA}, mem.Data[8:13])
+
+	_, err = loadImageString(t, mem, FormatRaw, "too long", 60)
+	assert.ErrorIs(t, err, ErrOutOfBounds)
+	_, err = loadImageString(t, mem, "coff", "", 0)
+	assert.Error(t, err)
+}
+
+func TestLoadImage_IHex(t *testing.T) {
+	mem := NewMemory(0x100)
+	img, err := loadImageString(t, mem, FormatIHex, strings.Join([]string{
+		":0B0010006164647265737320676170A7",
+		":0400000508000000EF", // start linear address 0x08000000
+		":00000001FF",
+		":0B0010006164647265737320676170A7", // after the end: ignored
+	}, "\n"), 0x20)
+	assert.NoError(t, err)
+	assert.Equal(t, []MemoryRange{{0x30, 11}}, img.Segments)
+	assert.Equal(t, "address gap", string(mem.Data[0x30:0x3B]))
+	assert.True(t, img.HasEntry)
+	assert.Equal(t, uint32(0x08000020), img.Entry, "entry is relative to the base too")
+
+	s := NewSparseMemory()
+	img, err = LoadImage(s, strings.NewReader(":020000040800F2\n:0B0010006164647265737320676170A7\n:00000001FF\n"), FormatIHex, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []MemoryRange{{0x08000010, 11}}, img.Segments, "extended linear address")
+
+	for name, text := range map[string]string{
+		"checksum":       ":0B0010006164647265737320676170A8\n:00000001FF",
+		"no colon":       "0B0010006164647265737320676170A7",
+		"length":         ":0C0010006164647265737320676170A7",
+		"odd digits":     ":0B0010006164647265737320676170A",
+		"no end":         ":0B0010006164647265737320676170A7",
+		"unknown record": ":00000006FA",
+	} {
+		_, err := loadImageString(t, NewMemory(0x100), FormatIHex, text, 0)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestLoadImage_SRec(t *testing.T) {
+	mem := NewMemory(0x100)
+	img, err := loadImageString(t, mem, FormatSRec, strings.Join([]string{
+		"S00F000068656C6C6F202020202000003C",
+		"S11F00007C0802A6900100049421FFF07C6C1B787C8C23783C6000003863000026",
+		"S11F001C4BFFFFE5398000007D83637880010014382100107C0803A64E800020E9",
+		"S111003848656C6C6F20776F726C642E0A0042",
+		"S5030003F9",
+		"S9030000FC",
+	}, "\r\n"), 0x80)
+	assert.NoError(t, err)
+	assert.Equal(t, []MemoryRange{{0x80, 70}}, img.Segments, "contiguous records form one segment")
+	assert.Equal(t, "Hello world.\n", string(mem.Data[0xB8:0xC5]))
+	assert.Equal(t, []byte{0x7C, 0x08, 0x02, 0xA6}, mem.Data[0x80:0x84])
+	assert.True(t, img.HasEntry)
+	assert.Equal(t, uint32(0x80), img.Entry)
+
+	_, err = loadImageString(t, mem, FormatSRec, "S111003848656C6C6F20776F726C642E0A0043", 0)
+	assert.Error(t, err, "checksum")
+	_, err = loadImageString(t, mem, FormatSRec, "S4030003F9", 0)
+	assert.Error(t, err, "record type")
+}
+
+func TestLoadImage_Readmemh(t *testing.T) {
+	mem := NewMemory(0x200)
+	img, err := loadImageString(t, mem, FormatReadmemh, `// program
+@10
+00000013 deadbeef
+/* block
+   comment */ 1234_5678
+@0 0badf00d`, 0x100)
+	assert.NoError(t, err)
+	assert.Equal(t, []MemoryRange{{0x140, 12}, {0x100, 4}}, img.Segments)
+	w, _ := mem.ReadWord(0x144)
+	assert.Equal(t, uint32(0xDEADBEEF), w)
+	w, _ = mem.ReadWord(0x148)
+	assert.Equal(t, uint32(0x12345678), w)
+	w, _ = mem.ReadWord(0x100)
+	assert.Equal(t, uint32(0x0BADF00D), w)
+
+	img, err = loadImageString(t, mem, FormatReadmemh, "@4 aa bb", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []MemoryRange{{4, 2}}, img.Segments, "byte-wide memory")
+	assert.Equal(t, []byte{0xAA, 0xBB}, mem.Data[4:6])
+
+	for name, text := range map[string]string{
+		"odd width":         "abc",
+		"wider than first":  "aa bbcc",
+		"unknown value":     "0000000x",
+		"bad address":       "@zz 00",
+		"unterminated note": "/* 00",
+	} {
+		_, err := loadImageString(t, NewMemory(0x100), FormatReadmemh, text, 0)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestDetectImageFormat(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+		return path
+	}
+	assert.Equal(t, FormatRaw, DetectImageFormat("prog.bin"))
+	assert.Equal(t, FormatSRec, DetectImageFormat("prog.S19"))
+	assert.Equal(t, FormatReadmemh, DetectImageFormat("prog.mem"))
+	assert.Equal(t, FormatIHex, DetectImageFormat(write("a.hex", "\n:00000001FF\n")))
+	assert.Equal(t, FormatReadmemh, DetectImageFormat(write("b.hex", "00000013\n")))
+	assert.Equal(t, "", DetectImageFormat("prog.asm"))
+}
+
+func TestMachineLoadImage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prog.mem")
+	assert.NoError(t, os.WriteFile(path, []byte("00700093\n"), 0o644)) // addi x1, x0, 7
+	m := NewMachine(256)
+	_, err := m.LoadImage(path, "", 0x40)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0x40), m.CPU.PC)
+	assert.NoError(t, m.Step())
+	assert.Equal(t, uint32(7), m.CPU.Reg[1])
+
+	_, err = m.LoadImage(path, FormatIHex, 0)
+	assert.Error(t, err, "forced format")
+}
@@ -5,5 +5,25 @@ type WordHandler interface {
 	WriteWord(addr uint32, value uint32) error
 }
 
+// SubWordHandler is implemented by memories and devices that support byte and
+// halfword accesses natively. Other WordHandlers are accessed through the word
+// containing the data (see readByte and friends). The byte accessors are not
+// called ReadByte/WriteByte as go vet reserves those names for io.ByteReader
+// and io.ByteWriter.
+type SubWordHandler interface {
+	ReadByteAt(addr uint32) (uint8, error)
+	ReadHalf(addr uint32) (uint16, error)
+	WriteByteAt(addr uint32, value uint8) error
+	WriteHalf(addr uint32, value uint16) error
+}
+
+// Zeroer is implemented by memories that clear a range more efficiently than
+// by writing zeros to it, such as SparseMemory, which leaves unallocated pages
+// alone. Other WordHandlers are cleared through zeroRange.
+type Zeroer interface {
+	Zero(addr, size uint32) error
+}
+
 // Compile-time check: *Memory implements interfaces
 var _ WordHandler = (*Memory)(nil)
+var _ SubWordHandler = (*Memory)(nil)
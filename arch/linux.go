@@ -0,0 +1,267 @@
+package arch
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+)
+
+// Linux system call numbers for RISC-V (asm-generic), as used by newlib and picolibc.
+const (
+	SYS_OPENAT          = 56
+	SYS_CLOSE           = 57
+	SYS_LSEEK           = 62
+	SYS_READ            = 63
+	SYS_WRITE           = 64
+	SYS_FSTAT           = 80
+	SYS_EXIT            = 93
+	SYS_EXIT_GROUP      = 94
+	SYS_CLOCK_GETTIME   = 113
+	SYS_BRK             = 214
+	SYS_CLOCK_GETTIME64 = 403
+)
+
+// Linux error numbers returned (negated) in a0.
+const (
+	ENOENT = 2
+	EIO    = 5
+	EBADF  = 9
+	EACCES = 13
+	EFAULT = 14
+	EEXIST = 17
+	EINVAL = 22
+	ESPIPE = 29
+	ENOSYS = 38
+)
+
+// openat flags and special values
+const (
+	AT_FDCWD = -100
+
+	linuxAccessMode = 0x3
+	linuxCreate     = 0x40
+	linuxExclusive  = 0x80
+	linuxTruncate   = 0x200
+	linuxAppend     = 0x400
+)
+
+// file mode types of struct stat
+const (
+	linuxIFCHR = 0o020000
+	linuxIFDIR = 0o040000
+	linuxIFREG = 0o100000
+)
+
+// kernelStatSize is the size of newlib's struct kernel_stat for RV32.
+const kernelStatSize = 128
+
+// Linux emulates the Linux user-mode system calls that C libraries such as
+// newlib and picolibc need: console and file I/O, brk and the clock. The
+// number is passed in a7, the arguments in a0-a5 and the result, or a negated
+// error number, is returned in a0. Files are opened relative to Root, and
+// paths cannot leave it.
+type Linux struct {
+	Root string
+	hostFiles
+	// Now returns the time for clock_gettime
+	Now func() time.Time
+
+	// brk is the program break; brkStart is the lowest value it can be set to
+	brk, brkStart uint32
+}
+
+// NewLinux returns a Linux environment on the host's console with its files in root.
+func NewLinux(root string) *Linux {
+	return &Linux{
+		Root:      root,
+		hostFiles: newHostFiles(),
+		Now:       time.Now,
+	}
+}
+
+// Reset closes all files and forgets the program break.
+func (l *Linux) Reset() {
+	l.closeAll()
+	l.brk, l.brkStart = 0, 0
+}
+
+// ProgramLoaded places the program break after the highest segment of exe.
+func (l *Linux) ProgramLoaded(exe *Executable) {
+	var end uint32
+	for _, seg := range exe.Segments {
+		end = max(end, seg.Base+seg.Size)
+	}
+	end = (end + PAGE_SIZE - 1) &^ (PAGE_SIZE - 1)
+	l.brk, l.brkStart = end, end
+}
+
+// Ecall performs the system call in a7.
+func (l *Linux) Ecall(c *CPU, memory WordHandler) error {
+	a := func(i int) uint32 { return c.Reg[REG_A0+RegIndex(i)] }
+	var result int32
+	switch c.Reg[REG_A7] {
+	case SYS_EXIT, SYS_EXIT_GROUP:
+		return &ExitError{Code: int(int32(a(0)))}
+	case SYS_READ:
+		result = l.read(c, memory, int32(a(0)), a(1), a(2))
+	case SYS_WRITE:
+		result = l.write(c, memory, int32(a(0)), a(1), a(2))
+	case SYS_OPENAT:
+		result = l.openat(c, memory, int32(a(0)), a(1), a(2), a(3))
+	case SYS_CLOSE:
+		result = l.close(int32(a(0)))
+	case SYS_LSEEK:
+		result = l.lseek(int32(a(0)), int32(a(1)), int(a(2)))
+	case SYS_FSTAT:
+		result = l.fstat(c, memory, int32(a(0)), a(1))
+	case SYS_BRK:
+		result = int32(l.setBreak(a(0)))
+	case SYS_CLOCK_GETTIME, SYS_CLOCK_GETTIME64:
+		result = l.clockGettime(c, memory, a(1), c.Reg[REG_A7] == SYS_CLOCK_GETTIME64)
+	default:
+		result = -ENOSYS
+	}
+	c.SetReg(REG_A0, uint32(result))
+	return nil
+}
+
+// errno converts a host error to a negated Linux error number.
+func errno(err error) int32 {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return -ENOENT
+	case errors.Is(err, fs.ErrExist):
+		return -EEXIST
+	case errors.Is(err, fs.ErrPermission):
+		return -EACCES
+	case errors.Is(err, fs.ErrInvalid):
+		return -EINVAL
+	}
+	return -EIO
+}
+
+func (l *Linux) openat(c *CPU, memory WordHandler, dirfd int32, pathAddr, flags, mode uint32) int32 {
+	path, err := c.readGuestString(memory, pathAddr)
+	if err != nil {
+		return -EFAULT
+	}
+	if dirfd != AT_FDCWD && !strings.HasPrefix(path, "/") {
+		return -EBADF // only paths relative to the working directory (Root) are supported
+	}
+	var hostFlags int
+	switch flags & linuxAccessMode {
+	case 0:
+		hostFlags = os.O_RDONLY
+	case 1:
+		hostFlags = os.O_WRONLY
+	case 2:
+		hostFlags = os.O_RDWR
+	default:
+		return -EINVAL
+	}
+	for flag, hostFlag := range map[uint32]int{linuxCreate: os.O_CREATE, linuxExclusive: os.O_EXCL, linuxTruncate: os.O_TRUNC, linuxAppend: os.O_APPEND} {
+		if flags&flag != 0 {
+			hostFlags |= hostFlag
+		}
+	}
+	f, err := openSandboxed(l.Root, path, hostFlags, fs.FileMode(mode&0o777))
+	if err != nil {
+		return errno(err)
+	}
+	return l.add(f)
+}
+
+func (l *Linux) lseek(fd, offset int32, whence int) int32 {
+	f, ok := l.file(fd)
+	if !ok {
+		if isConsole(fd) {
+			return -ESPIPE
+		}
+		return -EBADF
+	}
+	if whence > io.SeekEnd {
+		return -EINVAL
+	}
+	pos, err := f.Seek(int64(offset), whence)
+	if err != nil {
+		return -EINVAL
+	}
+	return int32(pos)
+}
+
+// fstat fills newlib's struct kernel_stat. Only the mode, size, block size and
+// times are meaningful.
+func (l *Linux) fstat(c *CPU, memory WordHandler, fd int32, statAddr uint32) int32 {
+	st := make([]byte, kernelStatSize)
+	le := binary.LittleEndian
+	le.PutUint32(st[20:], 1) // st_nlink
+	le.PutUint32(st[56:], 4096)
+	if isConsole(fd) {
+		le.PutUint32(st[16:], linuxIFCHR|0o620)
+	} else {
+		f, ok := l.file(fd)
+		if !ok {
+			return -EBADF
+		}
+		info, err := f.Stat()
+		if err != nil {
+			return errno(err)
+		}
+		mode := uint32(info.Mode().Perm())
+		if info.IsDir() {
+			mode |= linuxIFDIR
+		} else {
+			mode |= linuxIFREG
+		}
+		le.PutUint32(st[16:], mode)
+		le.PutUint64(st[48:], uint64(info.Size()))
+		le.PutUint64(st[64:], uint64(info.Size()+511)/512) // st_blocks
+		mtime := info.ModTime()
+		for _, offset := range []int{72, 88, 104} { // st_atim, st_mtim, st_ctim
+			le.PutUint64(st[offset:], uint64(mtime.Unix()))
+			le.PutUint32(st[offset+8:], uint32(mtime.Nanosecond()))
+		}
+	}
+	if err := c.writeGuest(memory, statAddr, st); err != nil {
+		return -EFAULT
+	}
+	return 0
+}
+
+// setBreak implements brk: it moves the program break to addr if that is above
+// its start and returns the (new) break. brk(0) queries it.
+func (l *Linux) setBreak(addr uint32) uint32 {
+	if l.brkStart == 0 && addr != 0 {
+		// no program was loaded from an ELF file: the first request sets the start
+		l.brkStart = addr
+	}
+	if addr >= l.brkStart && addr != 0 {
+		l.brk = addr
+	}
+	return l.brk
+}
+
+// clockGettime writes the current time as a struct timespec: 32-bit seconds and
+// nanoseconds for clock_gettime, 64-bit seconds for clock_gettime64.
+func (l *Linux) clockGettime(c *CPU, memory WordHandler, tsAddr uint32, time64 bool) int32 {
+	now := l.Now()
+	var ts []byte
+	if time64 {
+		ts = binary.LittleEndian.AppendUint64(ts, uint64(now.Unix()))
+		ts = binary.LittleEndian.AppendUint32(ts, uint32(now.Nanosecond()))
+	} else {
+		ts = binary.LittleEndian.AppendUint32(ts, uint32(now.Unix()))
+		ts = binary.LittleEndian.AppendUint32(ts, uint32(now.Nanosecond()))
+	}
+	if err := c.writeGuest(memory, tsAddr, ts); err != nil {
+		return -EFAULT
+	}
+	return 0
+}
+
+var _ Environment = (*Linux)(nil)
+var _ ProgramLoader = (*Linux)(nil)
@@ -0,0 +1,188 @@
+package arch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// atFDCWD is AT_FDCWD as passed in a register
+const atFDCWD = uint32(1<<32 + AT_FDCWD)
+
+type linuxTest struct {
+	cpu    *CPU
+	mem    *Memory
+	linux  *Linux
+	stdout bytes.Buffer
+	stderr bytes.Buffer
+}
+
+func newLinuxTest(t *testing.T) *linuxTest {
+	lt := &linuxTest{cpu: NewCPU(), mem: NewMemory(0x1000), linux: NewLinux(t.TempDir())}
+	lt.linux.Stdin = strings.NewReader("input")
+	lt.linux.Stdout, lt.linux.Stderr = &lt.stdout, &lt.stderr
+	lt.linux.Now = func() time.Time { return time.Unix(1700000000, 123456789) }
+	lt.cpu.Env = lt.linux
+	t.Cleanup(lt.linux.Reset)
+	return lt
+}
+
+// syscall performs system call number with args and returns a0.
+func (lt *linuxTest) syscall(t *testing.T, number uint32, args ...uint32) int32 {
+	t.Helper()
+	lt.cpu.Reg[REG_A7] = number
+	for i, arg := range args {
+		lt.cpu.Reg[REG_A0+RegIndex(i)] = arg
+	}
+	assert.NoError(t, lt.linux.Ecall(lt.cpu, lt.mem))
+	return int32(lt.cpu.Reg[REG_A0])
+}
+
+// str writes s as a C string to addr.
+func (lt *linuxTest) str(addr uint32, s string) uint32 {
+	copy(lt.mem.Data[addr:], s+"\x00")
+	return addr
+}
+
+func TestLinux_Console(t *testing.T) {
+	lt := newLinuxTest(t)
+	lt.str(0x100, "hello\n")
+	assert.Equal(t, int32(6), lt.syscall(t, SYS_WRITE, 1, 0x100, 6))
+	assert.Equal(t, int32(2), lt.syscall(t, SYS_WRITE, 2, 0x100, 2))
+	assert.Equal(t, "hello\n", lt.stdout.String())
+	assert.Equal(t, "he", lt.stderr.String())
+
+	assert.Equal(t, int32(5), lt.syscall(t, SYS_READ, 0, 0x200, 16))
+	assert.Equal(t, "input", string(lt.mem.Data[0x200:0x205]))
+	assert.Equal(t, int32(0), lt.syscall(t, SYS_READ, 0, 0x200, 16), "end of input")
+
+	assert.Equal(t, int32(-EBADF), lt.syscall(t, SYS_WRITE, 0, 0x100, 1))
+	assert.Equal(t, int32(-EBADF), lt.syscall(t, SYS_WRITE, 7, 0x100, 1))
+	assert.Equal(t, int32(-EFAULT), lt.syscall(t, SYS_WRITE, 1, 0xFFF0, 4))
+	assert.Equal(t, int32(-ESPIPE), lt.syscall(t, SYS_LSEEK, 1, 0, 0))
+	assert.Equal(t, int32(-ENOSYS), lt.syscall(t, 999))
+}
+
+func TestLinux_Files(t *testing.T) {
+	lt := newLinuxTest(t)
+	assert.NoError(t, os.WriteFile(filepath.Join(lt.linux.Root, "in.txt"), []byte("0123456789"), 0o644))
+
+	fd := lt.syscall(t, SYS_OPENAT, atFDCWD, lt.str(0x100, "in.txt"), 0, 0)
+	assert.Equal(t, int32(3), fd)
+	assert.Equal(t, int32(4), lt.syscall(t, SYS_READ, uint32(fd), 0x200, 4))
+	assert.Equal(t, "0123", string(lt.mem.Data[0x200:0x204]))
+	assert.Equal(t, int32(8), lt.syscall(t, SYS_LSEEK, uint32(fd), uint32(0xFFFFFFFE), 2), "SEEK_END - 2")
+	assert.Equal(t, int32(2), lt.syscall(t, SYS_READ, uint32(fd), 0x200, 4))
+	assert.Equal(t, "89", string(lt.mem.Data[0x200:0x202]))
+
+	assert.Equal(t, int32(0), lt.syscall(t, SYS_FSTAT, uint32(fd), 0x300))
+	mode := binary.LittleEndian.Uint32(lt.mem.Data[0x300+16:])
+	assert.Equal(t, uint32(linuxIFREG|0o644), mode)
+	assert.Equal(t, uint64(10), binary.LittleEndian.Uint64(lt.mem.Data[0x300+48:]), "st_size")
+	assert.Equal(t, int32(0), lt.syscall(t, SYS_CLOSE, uint32(fd)))
+	assert.Equal(t, int32(-EBADF), lt.syscall(t, SYS_CLOSE, uint32(fd)))
+
+	// O_WRONLY|O_CREAT|O_TRUNC
+	fd = lt.syscall(t, SYS_OPENAT, atFDCWD, lt.str(0x100, "/sub/../out.txt"), 0x241, 0o600)
+	assert.Equal(t, int32(3), fd, "fds are reused")
+	lt.str(0x200, "written")
+	assert.Equal(t, int32(7), lt.syscall(t, SYS_WRITE, uint32(fd), 0x200, 7))
+	assert.Equal(t, int32(0), lt.syscall(t, SYS_CLOSE, uint32(fd)))
+	data, err := os.ReadFile(filepath.Join(lt.linux.Root, "out.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "written", string(data))
+
+	assert.Equal(t, int32(-ENOENT), lt.syscall(t, SYS_OPENAT, atFDCWD, lt.str(0x100, "missing"), 0, 0))
+	assert.Equal(t, int32(-EEXIST), lt.syscall(t, SYS_OPENAT, atFDCWD, lt.str(0x100, "out.txt"), 0xC1, 0o600), "O_CREAT|O_EXCL")
+
+	assert.Equal(t, int32(0), lt.syscall(t, SYS_FSTAT, 1, 0x300))
+	assert.Equal(t, uint32(linuxIFCHR), binary.LittleEndian.Uint32(lt.mem.Data[0x300+16:])&0o170000, "the console is a character device")
+}
+
+func TestLinux_LargeTransfers(t *testing.T) {
+	lt := newLinuxTest(t)
+	lt.mem = NewMemory(2 * maxTransfer)
+	lt.linux.Stdin = bytes.NewReader(bytes.Repeat([]byte("x"), 2*maxTransfer))
+	assert.Equal(t, int32(maxTransfer), lt.syscall(t, SYS_READ, 0, 0, 0xFFFFFFFF), "a short read")
+	assert.Equal(t, int32(maxTransfer), lt.syscall(t, SYS_WRITE, 1, 0, 0xFFFFFFFF), "a short write")
+	assert.Equal(t, maxTransfer, lt.stdout.Len())
+}
+
+func TestLinux_Sandbox(t *testing.T) {
+	lt := newLinuxTest(t)
+	outside := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(outside, "secret"), []byte("x"), 0o644))
+	assert.NoError(t, os.Symlink(outside, filepath.Join(lt.linux.Root, "link")))
+
+	rel, err := filepath.Rel(lt.linux.Root, filepath.Join(outside, "secret"))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(-ENOENT), lt.syscall(t, SYS_OPENAT, atFDCWD, lt.str(0x100, rel), 0, 0), ".. stays in the root")
+	assert.Equal(t, int32(-EACCES), lt.syscall(t, SYS_OPENAT, atFDCWD, lt.str(0x100, "link/secret"), 0, 0), "symlinks out of the root")
+	assert.Equal(t, int32(-EACCES), lt.syscall(t, SYS_OPENAT, atFDCWD, lt.str(0x100, "link/new"), 0x41, 0o600))
+
+	assert.NoError(t, os.Symlink(filepath.Join(outside, "created"), filepath.Join(lt.linux.Root, "dangling")))
+	assert.Equal(t, int32(-EACCES), lt.syscall(t, SYS_OPENAT, atFDCWD, lt.str(0x100, "dangling"), 0x41, 0o600), "O_WRONLY|O_CREAT")
+	assert.NoFileExists(t, filepath.Join(outside, "created"), "a dangling link is not followed")
+}
+
+func TestLinux_Brk(t *testing.T) {
+	lt := newLinuxTest(t)
+	lt.linux.ProgramLoaded(&Executable{Segments: []MemoryRange{{0x100, 0x10}, {0x2000, 0x804}}})
+	assert.Equal(t, int32(0x3000), lt.syscall(t, SYS_BRK, 0), "break after the last segment, page aligned")
+	assert.Equal(t, int32(0x3400), lt.syscall(t, SYS_BRK, 0x3400))
+	assert.Equal(t, int32(0x3400), lt.syscall(t, SYS_BRK, 0x1000), "cannot go below the start")
+	assert.Equal(t, int32(0x3000), lt.syscall(t, SYS_BRK, 0x3000))
+
+	lt.linux.Reset()
+	assert.Equal(t, int32(0), lt.syscall(t, SYS_BRK, 0))
+	assert.Equal(t, int32(0x800), lt.syscall(t, SYS_BRK, 0x800), "without a program the first request sets the start")
+}
+
+func TestLinux_Clock(t *testing.T) {
+	lt := newLinuxTest(t)
+	assert.Equal(t, int32(0), lt.syscall(t, SYS_CLOCK_GETTIME, 0, 0x100))
+	assert.Equal(t, uint32(1700000000), binary.LittleEndian.Uint32(lt.mem.Data[0x100:]))
+	assert.Equal(t, uint32(123456789), binary.LittleEndian.Uint32(lt.mem.Data[0x104:]))
+	assert.Equal(t, int32(0), lt.syscall(t, SYS_CLOCK_GETTIME64, 0, 0x200))
+	assert.Equal(t, uint64(1700000000), binary.LittleEndian.Uint64(lt.mem.Data[0x200:]))
+	assert.Equal(t, uint32(123456789), binary.LittleEndian.Uint32(lt.mem.Data[0x208:]))
+	assert.Equal(t, int32(-EFAULT), lt.syscall(t, SYS_CLOCK_GETTIME, 0, 0x2000))
+}
+
+func TestLinux_Program(t *testing.T) {
+	lt := newLinuxTest(t)
+	lt.str(0x100, "hi")
+	writeLines(t, lt.mem, 0,
+		"addi x10, x0, 1", // fd
+		"addi x11, x0, 256",
+		"addi x12, x0, 2",
+		"addi x17, x0, 64", // write
+		"ecall",
+		"addi x10, x0, 3",
+		"addi x17, x0, 93", // exit
+		"ecall",
+	)
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, lt.cpu.Step(lt.mem))
+	}
+	assert.Equal(t, "hi", lt.stdout.String())
+	assert.Equal(t, uint32(2), lt.cpu.Reg[10], "write returns the byte count")
+	assert.NoError(t, lt.cpu.Step(lt.mem))
+	assert.NoError(t, lt.cpu.Step(lt.mem))
+	err := lt.cpu.Step(lt.mem)
+	var exit *ExitError
+	if assert.ErrorAs(t, err, &exit) {
+		assert.Equal(t, 3, exit.Code)
+	}
+	assert.Equal(t, uint32(32), lt.cpu.PC, "PC after the ECALL")
+
+	lt.cpu.Traps = true
+	lt.cpu.PC = 28
+	assert.ErrorAs(t, lt.cpu.Step(lt.mem), &exit, "exit stops even with traps enabled")
+}
@@ -3,35 +3,166 @@ package arch
 import (
 	"fmt"
 	"github.com/malikwirin/riscvemu/assembler"
+	"io"
 )
 
+// Machine is a single-hart system: the CPU and its physical address space, the
+// Bus. RAM (Memory) is mapped at address 0, followed by the CLINT and PLIC at
+// their standard addresses; further devices can be attached with Bus.Map.
+// RAM and UART are nil unless the machine was created WithSparseRAM and WithUART.
 type Machine struct {
 	CPU    *CPU
+	Bus    *Bus
 	Memory *Memory
+	RAM    *SparseMemory
+	CLINT  *CLINT
+	PLIC   *PLIC
+	UART   *UART
+	// Symbols holds the symbol table of the last ELF executable or assembled program loaded
+	Symbols map[string]uint32
 }
 
-func NewMachine(memSize int) *Machine {
-	return &Machine{
+// Option configures a Machine created by NewMachine.
+type Option func(*Machine)
+
+// WithTraps makes exceptions trap to the handler at mtvec instead of stopping execution.
+func WithTraps() Option {
+	return func(m *Machine) {
+		m.CPU.Traps = true
+	}
+}
+
+// WithClock drives the CLINT's mtime from the host clock at frequency ticks per
+// second instead of counting retired instructions.
+func WithClock(frequency uint64) Option {
+	return func(m *Machine) {
+		m.CLINT.UseClock(frequency)
+	}
+}
+
+// WithEnvironment makes env serve the program's ECALLs (see Environment).
+func WithEnvironment(env Environment) Option {
+	return func(m *Machine) {
+		m.CPU.Env = env
+	}
+}
+
+// WithSemihosting makes s serve the program's semihosting calls.
+func WithSemihosting(s *Semihosting) Option {
+	return func(m *Machine) {
+		m.CPU.Semihost = s
+	}
+}
+
+// WithMisaligned sets how the RAM at address 0 and the sparse RAM handle
+// misaligned accesses.
+func WithMisaligned(policy MisalignedPolicy) Option {
+	return func(m *Machine) {
+		m.Memory.Misaligned = policy
+		if m.RAM != nil {
+			m.RAM.Misaligned = policy
+		}
+	}
+}
+
+// WithSparseRAM maps sparse memory at [base, base+size), such as DRAM_BASE and
+// DRAM_SIZE; pages are only allocated when written, so size may be large. It
+// handles misaligned accesses like the RAM at address 0.
+func WithSparseRAM(base, size uint32) Option {
+	return func(m *Machine) {
+		m.RAM = NewSparseMemory()
+		m.RAM.Misaligned = m.Memory.Misaligned
+		if err := m.Bus.Map("dram", base, size, m.RAM); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// WithUART attaches a UART at base that transmits to out and receives from in
+// (which may be nil). Its interrupt is PLIC source UART_IRQ.
+func WithUART(base uint32, out io.Writer, in io.Reader) Option {
+	return func(m *Machine) {
+		m.UART = NewUART(out, in)
+		if err := m.Bus.Map("uart", base, UART_SIZE, m.UART); err != nil {
+			panic(err)
+		}
+		m.UART.SetInterruptLine(m.PLIC.Line(UART_IRQ))
+	}
+}
+
+func NewMachine(memSize int, opts ...Option) *Machine {
+	m := &Machine{
 		CPU:    NewCPU(),
+		Bus:    NewBus(),
 		Memory: NewMemory(memSize),
+		CLINT:  NewCLINT(),
+		PLIC:   NewPLIC(),
 	}
+	// the standard regions cannot overlap unless memSize reaches into the CLINT
+	for _, err := range []error{
+		m.Bus.Map("ram", 0, uint32(memSize), m.Memory),
+		m.Bus.Map("clint", CLINT_BASE, CLINT_SIZE, m.CLINT),
+		m.Bus.Map("plic", PLIC_BASE, PLIC_SIZE, m.PLIC),
+	} {
+		if err != nil {
+			panic(err)
+		}
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
 }
 
+// Step updates the CPU's interrupt lines from the devices, CLINT and PLIC and
+// executes one instruction. Unless a clock drives mtime, every successful step advances it by one.
 func (m *Machine) Step() error {
-	return m.CPU.Step(m.Memory)
+	if m.UART != nil {
+		m.UART.Update()
+	}
+	m.CPU.SetInterruptPending(MIP_MTIP, m.CLINT.TimerPending())
+	m.CPU.SetInterruptPending(MIP_MSIP, m.CLINT.SoftwarePending())
+	m.CPU.SetInterruptPending(MIP_MEIP, m.PLIC.ExternalPending(PLIC_CONTEXT_M))
+	m.CPU.SetInterruptPending(MIP_SEIP, m.PLIC.ExternalPending(PLIC_CONTEXT_S))
+	if err := m.CPU.Step(m.Bus); err != nil {
+		return err
+	}
+	m.CLINT.Tick()
+	return nil
 }
 
+// Reset restores the CPU, memory, devices and environment to their initial
+// state. The trap and clock settings, the environment and the UART's
+// connections are kept.
 func (m *Machine) Reset() error {
+	traps, env, semihost := m.CPU.Traps, m.CPU.Env, m.CPU.Semihost
 	m.CPU = NewCPU()
-	m.Memory = NewMemory(len(m.Memory.Data))
+	m.CPU.Traps, m.CPU.Env, m.CPU.Semihost = traps, env, semihost
+	if semihost != nil {
+		semihost.Reset()
+	}
+	if env != nil {
+		env.Reset()
+	}
+	clear(m.Memory.Data)
+	if m.RAM != nil {
+		m.RAM.Reset()
+	}
+	m.Symbols = nil
+	m.CLINT.Reset()
+	if m.UART != nil {
+		m.UART.Reset()
+	}
+	m.PLIC.Reset()
 	return nil
 }
 
-// WriteProgramWords writes a slice of instructions (uint32) into memory at startAddr.
+// WriteProgramWords writes a slice of instructions (uint32) to the bus at startAddr.
+// Programs containing compressed instructions are expected to be packed into
+// words already, as produced by assembler.Segment.Words.
 func (m *Machine) WriteProgramWords(prog []assembler.Instruction, startAddr uint32) error {
 	for i, instr := range prog {
-		fmt.Printf("WriteProgramWords: Instr %d @ 0x%08x: 0x%08x\n", i, startAddr+uint32(i*4), uint32(instr))
-		if err := m.Memory.WriteWord(startAddr+uint32(i*4), uint32(instr)); err != nil {
+		if err := m.Bus.WriteWord(startAddr+uint32(i*4), uint32(instr)); err != nil {
 			return err
 		}
 	}
@@ -46,3 +177,57 @@ func (m *Machine) LoadProgram(prog []assembler.Instruction, startAddr uint32) er
 	m.CPU.PC = startAddr
 	return nil
 }
+
+// LoadAssembly writes the text and data segments of an assembled program, with
+// its .bss zeroed, sets the PC to its entry point and keeps its labels in Symbols.
+// Like LoadELF, it tells the environment about the program.
+func (m *Machine) LoadAssembly(prog *assembler.Program) (*Executable, error) {
+	exe := &Executable{Entry: prog.Entry, Symbols: prog.Symbols}
+	for _, seg := range []assembler.Segment{prog.Text, prog.Data} {
+		if seg.Size == 0 {
+			continue
+		}
+		data := make([]byte, seg.Size)
+		copy(data, seg.Data)
+		if err := writeBytes(m.Bus, seg.Base, data); err != nil {
+			return nil, fmt.Errorf("loading segment at 0x%08x: %w", seg.Base, err)
+		}
+		exe.Segments = append(exe.Segments, MemoryRange{Base: seg.Base, Size: seg.Size})
+	}
+	m.CPU.PC = exe.Entry
+	m.Symbols = exe.Symbols
+	if env, ok := m.CPU.Env.(ProgramLoader); ok {
+		env.ProgramLoaded(exe)
+	}
+	return exe, nil
+}
+
+// LoadELF loads the ELF executable at path (see arch.LoadELF), sets the PC to
+// its entry point and keeps its symbol table in Symbols. An environment that
+// tracks the program, such as Linux for the program break, is told about it.
+func (m *Machine) LoadELF(path string) (*Executable, error) {
+	exe, err := LoadELFFile(m.Bus, path)
+	if err != nil {
+		return nil, err
+	}
+	m.CPU.PC = exe.Entry
+	m.Symbols = exe.Symbols
+	if env, ok := m.CPU.Env.(ProgramLoader); ok {
+		env.ProgramLoaded(exe)
+	}
+	return exe, nil
+}
+
+// LoadImage loads a memory image (see arch.LoadImageFile) at base and sets the
+// PC to the image's entry point, or to base if it has none.
+func (m *Machine) LoadImage(path, format string, base uint32) (*Image, error) {
+	img, err := LoadImageFile(m.Bus, path, format, base)
+	if err != nil {
+		return nil, err
+	}
+	m.CPU.PC = base
+	if img.HasEntry {
+		m.CPU.PC = img.Entry
+	}
+	return img, nil
+}
@@ -1,6 +1,7 @@
 package arch
 
 import (
+	"bytes"
 	"testing"
 
 	"github.com/malikwirin/riscvemu/assembler"
@@ -64,3 +65,206 @@ func TestMachineLoadProgram(t *testing.T) {
 
 	assert.Equal(t, startAddr, m.CPU.PC, "Expected PC to be set to startAddr after LoadProgram")
 }
+
+func TestMachineLoadAssembly(t *testing.T) {
+	m := NewMachine(64)
+	m.Memory.Data[40] = 0xFF // stale .bss
+	prog := &assembler.Program{
+		Text:    assembler.Segment{Base: 8, Data: []byte{0x13, 0, 0, 0, 0x13, 0, 0, 0}, Size: 8},
+		Data:    assembler.Segment{Base: 16, Data: []byte{1, 2, 3}, Size: 32},
+		Entry:   12,
+		Symbols: map[string]uint32{"_start": 12},
+	}
+	exe, err := m.LoadAssembly(prog)
+	assert.NoError(t, err)
+	assert.Equal(t, []MemoryRange{{Base: 8, Size: 8}, {Base: 16, Size: 32}}, exe.Segments)
+
+	word, _ := m.Memory.ReadWord(12)
+	assert.Equal(t, uint32(0x13), word)
+	word, _ = m.Memory.ReadWord(16)
+	assert.Equal(t, uint32(0x030201), word)
+	assert.Equal(t, byte(0), m.Memory.Data[40], ".bss is zeroed")
+	assert.Equal(t, uint32(12), m.CPU.PC)
+	assert.Equal(t, prog.Symbols, m.Symbols)
+
+	prog.Data.Base = 60
+	_, err = m.LoadAssembly(prog)
+	assert.Error(t, err, "data segment beyond memory")
+}
+
+func TestMachineWithTraps(t *testing.T) {
+	m := NewMachine(64, WithTraps())
+	assert.True(t, m.CPU.Traps)
+	assert.NoError(t, m.Reset())
+	assert.True(t, m.CPU.Traps, "Reset keeps the trap setting")
+	assert.False(t, NewMachine(64).CPU.Traps, "stop on fault is the default")
+}
+
+func TestMachineTimerInterrupt(t *testing.T) {
+	m := NewMachine(256)
+	prog := []string{
+		"lui x10, 0x2004", // x10 = mtimecmp
+		"addi x1, x0, 10", // interrupt at mtime 10
+		"sw x1, 0(x10)",
+		"sw x0, 4(x10)",
+		"addi x1, x0, 128", // mie.MTIE
+		"csrw mie, x1",
+		"csrsi mstatus, 8", // mstatus.MIE
+		"jal x0, 0",        // spin
+	}
+	for i, line := range prog {
+		instr, err := assembler.ParseInstruction(line)
+		assert.NoError(t, err)
+		assert.NoError(t, m.Memory.WriteWord(uint32(i*4), uint32(instr)))
+	}
+	assert.NoError(t, m.CPU.WriteCSR(assembler.CSR_MTVEC, 0x80))
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, m.Step())
+		assert.NotEqual(t, uint32(0x80), m.CPU.PC, "interrupt before mtimecmp (step %d)", i)
+	}
+	assert.NoError(t, m.Step())
+	assert.Equal(t, uint32(0x80), m.CPU.PC)
+	mcause, _ := m.CPU.ReadCSR(assembler.CSR_MCAUSE)
+	assert.Equal(t, CAUSE_INTERRUPT|IRQ_M_TIMER, mcause)
+
+	assert.NoError(t, m.Bus.WriteWord(CLINT_BASE+CLINT_MSIP, 1))
+	assert.NoError(t, m.Reset())
+	assert.False(t, m.CLINT.SoftwarePending(), "Reset clears the CLINT")
+}
+
+func TestMachineExternalInterrupt(t *testing.T) {
+	m := NewMachine(256)
+	nop := uint32(0x00000013)
+	assert.NoError(t, m.Memory.WriteWord(0, nop))
+	assert.NoError(t, m.Memory.WriteWord(0x80, nop))
+	assert.NoError(t, m.Bus.WriteWord(PLIC_BASE+PLIC_PRIORITY+4*10, 1))
+	assert.NoError(t, m.Bus.WriteWord(PLIC_BASE+PLIC_ENABLE, 1<<10))
+	assert.NoError(t, m.CPU.WriteCSR(assembler.CSR_MTVEC, 0x80))
+	assert.NoError(t, m.CPU.WriteCSR(assembler.CSR_MIE, MIP_MEIP))
+	assert.NoError(t, m.CPU.WriteCSR(assembler.CSR_MSTATUS, MSTATUS_MIE))
+
+	assert.NoError(t, m.Step())
+	assert.Equal(t, uint32(4), m.CPU.PC, "no interrupt while the line is low")
+	m.PLIC.Line(10)(true)
+	assert.NoError(t, m.Step())
+	assert.Equal(t, uint32(0x80), m.CPU.PC)
+	mcause, _ := m.CPU.ReadCSR(assembler.CSR_MCAUSE)
+	assert.Equal(t, CAUSE_INTERRUPT|IRQ_M_EXTERNAL, mcause)
+	mip, _ := m.CPU.ReadCSR(assembler.CSR_MIP)
+	assert.NotZero(t, mip&MIP_MEIP)
+
+	claim, err := m.Bus.ReadWord(PLIC_BASE + PLIC_CLAIM)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(10), claim)
+	assert.NoError(t, m.Step())
+	mip, _ = m.CPU.ReadCSR(assembler.CSR_MIP)
+	assert.Zero(t, mip&MIP_MEIP, "claiming clears MEIP")
+}
+
+func TestMachineBus(t *testing.T) {
+	m := NewMachine(256)
+	assert.NoError(t, m.Bus.Map("rom", 0x10000, 0x100, NewROM(make([]byte, 0x100))))
+	prog := []string{
+		"lui x1, 0x10",  // x1 = 0x10000 (rom)
+		"sw x0, 0(x1)",  // store to ROM
+		"lui x1, 0x100", // x1 = 0x100000 (unmapped)
+		"lw x2, 0(x1)",
+	}
+	for i, line := range prog {
+		instr, err := assembler.ParseInstruction(line)
+		assert.NoError(t, err)
+		assert.NoError(t, m.Memory.WriteWord(uint32(i*4), uint32(instr)))
+	}
+	assert.NoError(t, m.Step())
+	err := m.Step()
+	var exc *Exception
+	assert.ErrorAs(t, err, &exc)
+	assert.Equal(t, CAUSE_STORE_ACCESS, exc.Cause)
+	assert.ErrorIs(t, err, ErrReadOnly)
+
+	m.CPU.PC += 4
+	assert.NoError(t, m.Step())
+	err = m.Step()
+	assert.ErrorAs(t, err, &exc)
+	assert.Equal(t, CAUSE_LOAD_ACCESS, exc.Cause)
+	assert.Equal(t, uint32(0x100000), exc.Tval)
+	assert.ErrorIs(t, err, ErrUnmapped)
+}
+
+func TestMachineUART(t *testing.T) {
+	var out bytes.Buffer
+	m := NewMachine(256, WithUART(0x20000, &out, nil))
+	assert.NoError(t, m.Bus.WriteByteAt(0x20000+UART_THR, '!'))
+	assert.Equal(t, "!", out.String())
+
+	// receive interrupt through the PLIC
+	assert.NoError(t, m.Bus.WriteWord(PLIC_BASE+PLIC_PRIORITY+4*UART_IRQ, 1))
+	assert.NoError(t, m.Bus.WriteWord(PLIC_BASE+PLIC_ENABLE, 1<<UART_IRQ))
+	assert.NoError(t, m.Bus.WriteByteAt(0x20000+UART_IER, UART_IER_RDA))
+	assert.NoError(t, m.Memory.WriteWord(0, 0x00000013)) // nop
+	assert.NoError(t, m.Memory.WriteWord(4, 0x00000013))
+	assert.NoError(t, m.Step())
+	assert.False(t, m.PLIC.ExternalPending(PLIC_CONTEXT_M))
+	m.UART.Receive([]byte("x"))
+	assert.NoError(t, m.Step())
+	assert.True(t, m.PLIC.ExternalPending(PLIC_CONTEXT_M))
+
+	assert.NoError(t, m.Reset())
+	assert.NotNil(t, m.Bus.Region(0x20000), "Reset keeps the UART mapped")
+	assert.False(t, m.PLIC.ExternalPending(PLIC_CONTEXT_M), "Reset clears the UART")
+}
+
+func TestMachineSparseRAM(t *testing.T) {
+	m := NewMachine(64, WithSparseRAM(DRAM_BASE, DRAM_SIZE))
+	prog := []assembler.Instruction{
+		mustAssemble(t, "addi x1, x0, 5"),
+		mustAssemble(t, "sw x1, 16(x0)"),
+	}
+	assert.NoError(t, m.LoadProgram(prog, DRAM_BASE))
+	assert.Equal(t, DRAM_BASE, m.CPU.PC)
+	assert.NoError(t, m.Step())
+	assert.Equal(t, uint32(5), m.CPU.Reg[1])
+	assert.NoError(t, m.Step(), "low RAM stays mapped at 0")
+	word, _ := m.Memory.ReadWord(16)
+	assert.Equal(t, uint32(5), word)
+
+	assert.NoError(t, m.Reset())
+	assert.Zero(t, m.RAM.Pages(), "Reset frees the sparse RAM")
+}
+
+func TestMachineWithMisaligned(t *testing.T) {
+	m := NewMachine(64, WithMisaligned(MisalignedSplit))
+	assert.Equal(t, MisalignedSplit, m.Memory.Misaligned)
+
+	m = NewMachine(64, WithMisaligned(MisalignedTrap), WithSparseRAM(DRAM_BASE, DRAM_SIZE))
+	_, err := m.Bus.ReadWord(DRAM_BASE + 2)
+	assert.ErrorIs(t, err, ErrMisaligned, "the policy applies to the sparse RAM")
+	m = NewMachine(64, WithSparseRAM(DRAM_BASE, DRAM_SIZE), WithMisaligned(MisalignedTrap))
+	assert.ErrorIs(t, m.Bus.WriteWord(DRAM_BASE+2, 0), ErrMisaligned, "in either order")
+}
+
+func TestMachineWithEnvironment(t *testing.T) {
+	var out bytes.Buffer
+	linux := NewLinux(t.TempDir())
+	linux.Stdout = &out
+	m := NewMachine(64, WithEnvironment(linux))
+	writeLines(t, m.Memory, 0, "addi x10, x0, 7", "addi x17, x0, 93", "ecall")
+	assert.NoError(t, m.Step())
+	assert.NoError(t, m.Step())
+	var exit *ExitError
+	assert.ErrorAs(t, m.Step(), &exit)
+	assert.Equal(t, 7, exit.Code)
+
+	linux.setBreak(0x1000)
+	assert.NoError(t, m.Reset())
+	assert.Same(t, linux, m.CPU.Env, "Reset keeps the environment")
+	assert.Zero(t, linux.setBreak(0), "Reset resets the environment")
+}
+
+func TestMachineWithSemihosting(t *testing.T) {
+	s := NewSemihosting(t.TempDir())
+	m := NewMachine(64, WithSemihosting(s))
+	assert.Same(t, s, m.CPU.Semihost)
+	assert.NoError(t, m.Reset())
+	assert.Same(t, s, m.CPU.Semihost, "Reset keeps semihosting")
+}
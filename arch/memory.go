@@ -1,12 +1,87 @@
 package arch
 
 import (
-	"encoding/binary"
+	"errors"
 	"fmt"
 )
 
+// ErrOutOfBounds is reported for accesses beyond the end of a memory.
+var ErrOutOfBounds = errors.New("out of bounds")
+
+// ErrMisaligned is reported for misaligned accesses under MisalignedTrap. The
+// CPU raises it as a load/store address-misaligned exception.
+var ErrMisaligned = errors.New("misaligned access")
+
+// MemoryError describes a failed memory access.
+type MemoryError struct {
+	Addr  uint32
+	Size  uint32 // access size in bytes
+	Write bool
+	Err   error
+}
+
+func (e *MemoryError) Error() string {
+	op := "read"
+	if e.Write {
+		op = "write"
+	}
+	return fmt.Sprintf("memory: %s of %d byte(s) at 0x%08x: %v", op, e.Size, e.Addr, e.Err)
+}
+
+func (e *MemoryError) Unwrap() error {
+	return e.Err
+}
+
+// MisalignedPolicy selects how Memory and SparseMemory handle halfword and word accesses whose
+// address is not a multiple of their size.
+type MisalignedPolicy int
+
+const (
+	// MisalignedAllow performs the access like an aligned one (the default)
+	MisalignedAllow MisalignedPolicy = iota
+	// MisalignedTrap fails the access with ErrMisaligned
+	MisalignedTrap
+	// MisalignedSplit performs the access as a sequence of byte accesses, so a
+	// failing byte leaves the preceding ones written
+	MisalignedSplit
+)
+
+// readMisaligned performs a misaligned read of size bytes at addr under a
+// policy other than MisalignedAllow, reading single bytes with read.
+func readMisaligned(policy MisalignedPolicy, addr, size uint32, read func(addr, size uint32) (uint32, error)) (uint32, error) {
+	if policy == MisalignedTrap {
+		return 0, &MemoryError{Addr: addr, Size: size, Err: ErrMisaligned}
+	}
+	var value uint32
+	for i := uint32(0); i < size; i++ {
+		b, err := read(addr+i, 1)
+		if err != nil {
+			return 0, err
+		}
+		value |= b << (8 * i)
+	}
+	return value, nil
+}
+
+// writeMisaligned performs a misaligned write of size bytes at addr under a
+// policy other than MisalignedAllow, writing single bytes with write.
+func writeMisaligned(policy MisalignedPolicy, addr, size, value uint32, write func(addr, size, value uint32) error) error {
+	if policy == MisalignedTrap {
+		return &MemoryError{Addr: addr, Size: size, Write: true, Err: ErrMisaligned}
+	}
+	for i := uint32(0); i < size; i++ {
+		if err := write(addr+i, 1, value>>(8*i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Memory is RAM backed by a byte slice, accessed little-endian. The byte
+// accessors are called ReadByteAt and WriteByteAt (see SubWordHandler).
 type Memory struct {
-	Data []byte
+	Data       []byte
+	Misaligned MisalignedPolicy
 }
 
 func NewMemory(size int) *Memory {
@@ -15,34 +90,72 @@ func NewMemory(size int) *Memory {
 	}
 }
 
-func (m *Memory) LoadWord(addr uint32) (int32, error) {
-	if addr+4 > uint32(len(m.Data)) {
-		return 0, fmt.Errorf("address %d out of bounds", addr)
+// read returns the size bytes at addr as a little-endian value.
+func (m *Memory) read(addr, size uint32) (uint32, error) {
+	if addr%size != 0 && m.Misaligned != MisalignedAllow {
+		return readMisaligned(m.Misaligned, addr, size, m.read)
 	}
-	return int32(binary.LittleEndian.Uint32(m.Data[addr : addr+4])), nil
+	if uint64(addr)+uint64(size) > uint64(len(m.Data)) {
+		return 0, &MemoryError{Addr: addr, Size: size, Err: ErrOutOfBounds}
+	}
+	var value uint32
+	for i := uint32(0); i < size; i++ {
+		value |= uint32(m.Data[addr+i]) << (8 * i)
+	}
+	return value, nil
 }
 
-func (m *Memory) StoreWord(addr uint32, value int32) error {
-	if addr+4 > uint32(len(m.Data)) {
-		return fmt.Errorf("address %d out of bounds", addr)
+// write stores the low size bytes of value at addr, little-endian.
+func (m *Memory) write(addr, size, value uint32) error {
+	if addr%size != 0 && m.Misaligned != MisalignedAllow {
+		return writeMisaligned(m.Misaligned, addr, size, value, m.write)
+	}
+	if uint64(addr)+uint64(size) > uint64(len(m.Data)) {
+		return &MemoryError{Addr: addr, Size: size, Write: true, Err: ErrOutOfBounds}
+	}
+	for i := uint32(0); i < size; i++ {
+		m.Data[addr+i] = uint8(value >> (8 * i))
 	}
-	binary.LittleEndian.PutUint32(m.Data[addr:addr+4], uint32(value))
 	return nil
 }
 
+// LoadWord reads a word as a signed value.
+//
+// Deprecated: use ReadWord.
+func (m *Memory) LoadWord(addr uint32) (int32, error) {
+	v, err := m.read(addr, 4)
+	return int32(v), err
+}
+
+// StoreWord writes a signed word.
+//
+// Deprecated: use WriteWord.
+func (m *Memory) StoreWord(addr uint32, value int32) error {
+	return m.write(addr, 4, uint32(value))
+}
+
 func (m *Memory) WriteWord(addr uint32, value uint32) error {
-	if addr+4 > uint32(len(m.Data)) {
-		return fmt.Errorf("address %d out of bounds", addr)
-	}
-	binary.LittleEndian.PutUint32(m.Data[addr:addr+4], value)
-	return nil
+	return m.write(addr, 4, value)
 }
 
 func (m *Memory) ReadWord(addr uint32) (uint32, error) {
-	if addr+4 > uint32(len(m.Data)) {
-		return 0, fmt.Errorf("address %d out of bounds", addr)
-	}
-	val := binary.LittleEndian.Uint32(m.Data[addr : addr+4])
-	fmt.Printf("[MEMORY] ReadWord(%#x) = %#x\n", addr, val)
-	return val, nil
+	return m.read(addr, 4)
+}
+
+func (m *Memory) ReadHalf(addr uint32) (uint16, error) {
+	v, err := m.read(addr, 2)
+	return uint16(v), err
+}
+
+func (m *Memory) WriteHalf(addr uint32, value uint16) error {
+	return m.write(addr, 2, uint32(value))
+}
+
+func (m *Memory) ReadByteAt(addr uint32) (uint8, error) {
+	v, err := m.read(addr, 1)
+	return uint8(v), err
+}
+
+func (m *Memory) WriteByteAt(addr uint32, value uint8) error {
+	return m.write(addr, 1, uint32(value))
 }
@@ -83,3 +83,67 @@ func TestMemory_ReadWordReturnsWrittenInstruction(t *testing.T) {
 	assert.NotEqual(t, uint32(0x544F5245), actual, "Unexpected ASCII value 'TORE' read from memory")
 	assert.Equalf(t, expected, actual, "Expected 0x%X at address %d", expected, addr)
 }
+
+func TestMemoryByteAndHalf(t *testing.T) {
+	mem := NewMemory(16)
+	assert.NoError(t, mem.WriteWord(0, 0x44332211))
+	b, err := mem.ReadByteAt(1)
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(0x22), b)
+	h, err := mem.ReadHalf(2)
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(0x4433), h)
+
+	assert.NoError(t, mem.WriteByteAt(0, 0xAA))
+	assert.NoError(t, mem.WriteHalf(2, 0xBBCC))
+	w, _ := mem.ReadWord(0)
+	assert.Equal(t, uint32(0xBBCC22AA), w)
+
+	_, err = mem.ReadByteAt(16)
+	assert.Error(t, err)
+	assert.Error(t, mem.WriteHalf(15, 0))
+}
+
+func TestMemoryErrors(t *testing.T) {
+	mem := NewMemory(16)
+	err := mem.WriteWord(14, 0)
+	var memErr *MemoryError
+	if assert.ErrorAs(t, err, &memErr) {
+		assert.Equal(t, MemoryError{Addr: 14, Size: 4, Write: true, Err: ErrOutOfBounds}, *memErr)
+	}
+	assert.ErrorIs(t, err, ErrOutOfBounds)
+	assert.EqualError(t, err, "memory: write of 4 byte(s) at 0x0000000e: out of bounds")
+
+	_, err = mem.ReadHalf(0xFFFFFFFF)
+	assert.ErrorIs(t, err, ErrOutOfBounds, "no wrap-around at the end of the address space")
+}
+
+func TestMemoryMisalignedPolicy(t *testing.T) {
+	mem := NewMemory(16)
+	assert.NoError(t, mem.WriteWord(2, 0x44332211), "misaligned accesses are allowed by default")
+	w, err := mem.ReadWord(2)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0x44332211), w)
+
+	mem.Misaligned = MisalignedTrap
+	_, err = mem.ReadWord(2)
+	var memErr *MemoryError
+	if assert.ErrorAs(t, err, &memErr) {
+		assert.Equal(t, MemoryError{Addr: 2, Size: 4, Err: ErrMisaligned}, *memErr)
+	}
+	assert.ErrorIs(t, mem.WriteHalf(1, 0), ErrMisaligned)
+	assert.NoError(t, mem.WriteByteAt(1, 0), "bytes are always aligned")
+	_, err = mem.ReadWord(4)
+	assert.NoError(t, err)
+
+	mem.Misaligned = MisalignedSplit
+	w, err = mem.ReadWord(2)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0x44332211), w)
+	err = mem.WriteWord(14, 0xFFFFFFFF)
+	if assert.ErrorAs(t, err, &memErr) {
+		assert.Equal(t, uint32(16), memErr.Addr, "the first byte beyond the end fails")
+		assert.Equal(t, uint32(1), memErr.Size)
+	}
+	assert.Equal(t, []byte{0xFF, 0xFF}, mem.Data[14:], "bytes before the failing one are written")
+}
@@ -0,0 +1,297 @@
+package arch
+
+import (
+	"fmt"
+
+	"github.com/malikwirin/riscvemu/assembler"
+)
+
+// satp fields
+const (
+	SATP_MODE uint32 = 1 << 31 // 0: bare (no translation), 1: Sv32
+	SATP_ASID uint32 = 0x1FF << 22
+	SATP_PPN  uint32 = 0x3FFFFF
+)
+
+// Sv32 page table entry bits
+const (
+	PTE_V uint32 = 1 << 0
+	PTE_R uint32 = 1 << 1
+	PTE_W uint32 = 1 << 2
+	PTE_X uint32 = 1 << 3
+	PTE_U uint32 = 1 << 4
+	PTE_G uint32 = 1 << 5
+	PTE_A uint32 = 1 << 6
+	PTE_D uint32 = 1 << 7
+
+	PTE_PPN_SHIFT = 10
+)
+
+const (
+	PAGE_SHIFT = 12
+	PAGE_SIZE  = 1 << PAGE_SHIFT
+
+	// tlbSize bounds the number of cached translations; the TLB is flushed when it is full
+	tlbSize = 64
+)
+
+// accessType is the kind of memory access being translated.
+type accessType int
+
+const (
+	accessFetch accessType = iota
+	accessLoad
+	accessStore // stores and AMOs
+)
+
+// pageFaultCauses and accessFaultCauses map an access type to its exception causes.
+var (
+	pageFaultCauses   = [...]uint32{CAUSE_FETCH_PAGE_FAULT, CAUSE_LOAD_PAGE_FAULT, CAUSE_STORE_PAGE_FAULT}
+	accessFaultCauses = [...]uint32{CAUSE_FETCH_ACCESS, CAUSE_LOAD_ACCESS, CAUSE_STORE_ACCESS}
+)
+
+// tlbEntry is a cached translation of one 4 KiB virtual page. The leaf PTE is
+// kept so that permissions can be checked on every access.
+type tlbEntry struct {
+	ppn      uint32
+	pte      uint32
+	megapage bool
+}
+
+// mmu returns the view of memory used for an access of the given type: memory
+// itself if no address translation applies, otherwise a virtualMemory that
+// translates through the Sv32 page tables.
+func (c *CPU) mmu(memory WordHandler, access accessType) WordHandler {
+	if c.csr.satp&SATP_MODE == 0 || c.effectivePriv(access) == PRIV_M {
+		return memory
+	}
+	return virtualMemory{cpu: c, memory: memory, access: access}
+}
+
+// effectivePriv returns the privilege level used for an access: loads and stores
+// in M-mode with mstatus.MPRV set use the level in MPP.
+func (c *CPU) effectivePriv(access accessType) uint32 {
+	if access != accessFetch && c.Priv == PRIV_M && c.csr.mstatus&MSTATUS_MPRV != 0 {
+		return c.csr.mstatus & MSTATUS_MPP >> MSTATUS_MPP_SHIFT
+	}
+	return c.Priv
+}
+
+// translate maps the virtual address vaddr to a physical address, consulting the
+// TLB first and walking the page tables on a miss.
+func (c *CPU) translate(memory WordHandler, vaddr uint32, access accessType) (uint32, error) {
+	vpn := vaddr >> PAGE_SHIFT
+	offset := vaddr & (PAGE_SIZE - 1)
+	// a store to a page that is not yet dirty takes the slow path to set D
+	if e, ok := c.tlb[vpn]; ok && c.permitted(e.pte, access) && (access != accessStore || e.pte&PTE_D != 0) {
+		return e.ppn<<PAGE_SHIFT | offset, nil
+	}
+	e, err := c.walk(memory, vaddr, access)
+	if err != nil {
+		return 0, err
+	}
+	if c.tlb == nil || len(c.tlb) >= tlbSize {
+		c.tlb = make(map[uint32]tlbEntry, tlbSize)
+	}
+	c.tlb[vpn] = e
+	return e.ppn<<PAGE_SHIFT | offset, nil
+}
+
+// walk performs the Sv32 page table walk for vaddr. The accessed and dirty bits
+// of the leaf PTE are updated in memory.
+func (c *CPU) walk(memory WordHandler, vaddr uint32, access accessType) (tlbEntry, error) {
+	pageFault := &Exception{Cause: pageFaultCauses[access], Tval: vaddr}
+	accessFault := func(err error) error {
+		return &Exception{Cause: accessFaultCauses[access], Tval: vaddr, Err: err}
+	}
+
+	vpn := [2]uint32{vaddr >> PAGE_SHIFT & 0x3FF, vaddr >> 22}
+	table := uint64(c.csr.satp&SATP_PPN) << PAGE_SHIFT
+	level := 1
+	var pte, pteAddr uint32
+	var err error
+	for {
+		addr := table + uint64(vpn[level])*4
+		if addr > 0xFFFFFFFF {
+			return tlbEntry{}, accessFault(fmt.Errorf("page table entry at 0x%x beyond the physical address space", addr))
+		}
+		pteAddr = uint32(addr)
+		if pte, err = memory.ReadWord(pteAddr); err != nil {
+			return tlbEntry{}, accessFault(fmt.Errorf("page table walk failed: %w", err))
+		}
+		if pte&PTE_V == 0 || pte&PTE_R == 0 && pte&PTE_W != 0 {
+			return tlbEntry{}, pageFault
+		}
+		if pte&(PTE_R|PTE_X) != 0 {
+			break // leaf
+		}
+		if level == 0 {
+			return tlbEntry{}, pageFault
+		}
+		level--
+		table = uint64(pte>>PTE_PPN_SHIFT) << PAGE_SHIFT
+	}
+
+	ppn := pte >> PTE_PPN_SHIFT
+	if !c.permitted(pte, access) {
+		return tlbEntry{}, pageFault
+	}
+	if level == 1 {
+		// a megapage must be aligned to 4 MiB
+		if ppn&0x3FF != 0 {
+			return tlbEntry{}, pageFault
+		}
+		ppn |= vpn[0]
+	}
+
+	updated := pte | PTE_A
+	if access == accessStore {
+		updated |= PTE_D
+	}
+	if updated != pte {
+		if err := memory.WriteWord(pteAddr, updated); err != nil {
+			return tlbEntry{}, accessFault(fmt.Errorf("page table update failed: %w", err))
+		}
+	}
+	if ppn >= 1<<(32-PAGE_SHIFT) {
+		return tlbEntry{}, accessFault(fmt.Errorf("physical page 0x%x beyond the physical address space", ppn))
+	}
+	return tlbEntry{ppn: ppn, pte: updated, megapage: level == 1}, nil
+}
+
+// permitted checks the permission bits of a leaf PTE for an access at the effective privilege level.
+func (c *CPU) permitted(pte uint32, access accessType) bool {
+	status := c.csr.mstatus
+	switch priv := c.effectivePriv(access); {
+	case priv == PRIV_U && pte&PTE_U == 0:
+		return false
+	case priv == PRIV_S && pte&PTE_U != 0 && (access == accessFetch || status&MSTATUS_SUM == 0):
+		return false
+	}
+	switch access {
+	case accessFetch:
+		return pte&PTE_X != 0
+	case accessLoad:
+		return pte&PTE_R != 0 || status&MSTATUS_MXR != 0 && pte&PTE_X != 0
+	default:
+		return pte&PTE_W != 0
+	}
+}
+
+// FlushTLB drops all cached address translations.
+func (c *CPU) FlushTLB() {
+	c.tlb = nil
+}
+
+// sfenceVMA executes SFENCE.VMA. A nonzero rs1 limits the flush to the page
+// containing that address; rs2 selects an address space, which is ignored as
+// no ASIDs are implemented.
+func (c *CPU) sfenceVMA(instr assembler.Instruction) error {
+	if c.Priv == PRIV_U || c.Priv == PRIV_S && c.csr.mstatus&MSTATUS_TVM != 0 {
+		return fmt.Errorf("SFENCE.VMA in %s-mode", privNames[c.Priv])
+	}
+	if instr.Rs1() == 0 {
+		c.FlushTLB()
+		return nil
+	}
+	// a megapage may be cached under any of its 4 KiB pages
+	vpn := c.Reg[instr.Rs1()] >> PAGE_SHIFT
+	for cached, e := range c.tlb {
+		if cached == vpn || e.megapage && cached>>10 == vpn>>10 {
+			delete(c.tlb, cached)
+		}
+	}
+	return nil
+}
+
+// virtualMemory is the memory seen by an instruction while address translation
+// is active. Every access is translated for one access type; an access crossing
+// a page boundary is split into bytes so that each part is translated on its own.
+type virtualMemory struct {
+	cpu    *CPU
+	memory WordHandler
+	access accessType
+}
+
+func (v virtualMemory) ReadWord(addr uint32) (uint32, error) {
+	if addr%PAGE_SIZE <= PAGE_SIZE-4 {
+		paddr, err := v.cpu.translate(v.memory, addr, v.access)
+		if err != nil {
+			return 0, err
+		}
+		return v.memory.ReadWord(paddr)
+	}
+	var word uint32
+	for i := uint32(0); i < 4; i++ {
+		b, err := v.ReadByteAt(addr + i)
+		if err != nil {
+			return 0, err
+		}
+		word |= uint32(b) << (8 * i)
+	}
+	return word, nil
+}
+
+func (v virtualMemory) WriteWord(addr uint32, value uint32) error {
+	if addr%PAGE_SIZE <= PAGE_SIZE-4 {
+		paddr, err := v.cpu.translate(v.memory, addr, v.access)
+		if err != nil {
+			return err
+		}
+		return v.memory.WriteWord(paddr, value)
+	}
+	for i := uint32(0); i < 4; i++ {
+		if err := v.WriteByteAt(addr+i, uint8(value>>(8*i))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v virtualMemory) ReadHalf(addr uint32) (uint16, error) {
+	if addr%PAGE_SIZE == PAGE_SIZE-1 {
+		lo, err := v.ReadByteAt(addr)
+		if err != nil {
+			return 0, err
+		}
+		hi, err := v.ReadByteAt(addr + 1)
+		return uint16(lo) | uint16(hi)<<8, err
+	}
+	paddr, err := v.cpu.translate(v.memory, addr, v.access)
+	if err != nil {
+		return 0, err
+	}
+	return readHalf(v.memory, paddr)
+}
+
+func (v virtualMemory) WriteHalf(addr uint32, value uint16) error {
+	if addr%PAGE_SIZE == PAGE_SIZE-1 {
+		if err := v.WriteByteAt(addr, uint8(value)); err != nil {
+			return err
+		}
+		return v.WriteByteAt(addr+1, uint8(value>>8))
+	}
+	paddr, err := v.cpu.translate(v.memory, addr, v.access)
+	if err != nil {
+		return err
+	}
+	return writeHalf(v.memory, paddr, value)
+}
+
+func (v virtualMemory) ReadByteAt(addr uint32) (uint8, error) {
+	paddr, err := v.cpu.translate(v.memory, addr, v.access)
+	if err != nil {
+		return 0, err
+	}
+	return readByte(v.memory, paddr)
+}
+
+func (v virtualMemory) WriteByteAt(addr uint32, value uint8) error {
+	paddr, err := v.cpu.translate(v.memory, addr, v.access)
+	if err != nil {
+		return err
+	}
+	return writeByte(v.memory, paddr, value)
+}
+
+var _ SubWordHandler = virtualMemory{}
@@ -0,0 +1,195 @@
+package arch
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/malikwirin/riscvemu/assembler"
+	"github.com/stretchr/testify/assert"
+)
+
+// Physical layout of the page tables used by the tests: the root table at
+// 0x1000 maps the first 4 MiB as a supervisor megapage (identity) and points
+// to a second-level table at 0x2000 for the user pages at 0x400000.
+const (
+	testRootTable = 0x1000
+	testLeafTable = 0x2000
+	testUserCode  = 0x400000 // -> 0x3000
+	testUserData  = 0x401000 // -> 0x4000
+	testReadOnly  = 0x402000 // -> 0x5000
+)
+
+func pte(ppn, flags uint32) uint32 {
+	return ppn<<PTE_PPN_SHIFT | flags | PTE_V
+}
+
+// newPagedCPU returns a CPU with Sv32 enabled and the test page tables in mem.
+func newPagedCPU(t *testing.T) (*CPU, *Memory) {
+	cpu, mem := NewCPU(), NewMemory(0x8000)
+	assert.NoError(t, mem.WriteWord(testRootTable+0*4, pte(0, PTE_R|PTE_W|PTE_X)))
+	assert.NoError(t, mem.WriteWord(testRootTable+1*4, pte(testLeafTable>>PAGE_SHIFT, 0)))
+	assert.NoError(t, mem.WriteWord(testLeafTable+0*4, pte(0x3, PTE_U|PTE_R|PTE_X)))
+	assert.NoError(t, mem.WriteWord(testLeafTable+1*4, pte(0x4, PTE_U|PTE_R|PTE_W)))
+	assert.NoError(t, mem.WriteWord(testLeafTable+2*4, pte(0x5, PTE_U|PTE_R)))
+	assert.NoError(t, cpu.WriteCSR(assembler.CSR_SATP, SATP_MODE|testRootTable>>PAGE_SHIFT))
+	return cpu, mem
+}
+
+// assertPageFault checks that err is an exception with the given cause and tval.
+func assertPageFault(t *testing.T, err error, cause, tval uint32) {
+	t.Helper()
+	var exc *Exception
+	if assert.True(t, errors.As(err, &exc), "expected an exception, got %v", err) {
+		assert.Equal(t, cause, exc.Cause, "cause")
+		assert.Equal(t, tval, exc.Tval, "tval")
+	}
+}
+
+func TestMMU_Translate(t *testing.T) {
+	cpu, mem := newPagedCPU(t)
+	cpu.Priv = PRIV_S
+	paddr, err := cpu.translate(mem, 0x1234, accessLoad)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0x1234), paddr, "megapage identity mapping")
+
+	cpu.Priv = PRIV_U
+	paddr, err = cpu.translate(mem, testUserData+0x10, accessStore)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0x4010), paddr)
+	leaf, _ := mem.ReadWord(testLeafTable + 1*4)
+	assert.Equal(t, PTE_A|PTE_D, leaf&(PTE_A|PTE_D), "store sets A and D")
+
+	paddr, err = cpu.translate(mem, testUserCode+0x8, accessFetch)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0x3008), paddr)
+	leaf, _ = mem.ReadWord(testLeafTable + 0*4)
+	assert.Equal(t, PTE_A, leaf&(PTE_A|PTE_D), "fetch sets only A")
+}
+
+func TestMMU_PageFaults(t *testing.T) {
+	cases := []struct {
+		name   string
+		priv   uint32
+		status uint32
+		vaddr  uint32
+		access accessType
+		cause  uint32
+	}{
+		{"unmapped", PRIV_S, 0, 0x800000, accessLoad, CAUSE_LOAD_PAGE_FAULT},
+		{"unmapped second level", PRIV_U, 0, 0x403000, accessFetch, CAUSE_FETCH_PAGE_FAULT},
+		{"user access to supervisor page", PRIV_U, 0, 0x100, accessLoad, CAUSE_LOAD_PAGE_FAULT},
+		{"store to read-only page", PRIV_U, 0, testReadOnly, accessStore, CAUSE_STORE_PAGE_FAULT},
+		{"fetch from non-executable page", PRIV_U, 0, testUserData, accessFetch, CAUSE_FETCH_PAGE_FAULT},
+		{"store to execute-only page", PRIV_U, 0, testUserCode, accessStore, CAUSE_STORE_PAGE_FAULT},
+		{"supervisor load from user page", PRIV_S, 0, testUserData, accessLoad, CAUSE_LOAD_PAGE_FAULT},
+		{"supervisor fetch from user page", PRIV_S, MSTATUS_SUM, testUserCode, accessFetch, CAUSE_FETCH_PAGE_FAULT},
+		{"MPRV applies MPP to loads", PRIV_M, MSTATUS_MPRV, 0x100, accessLoad, CAUSE_LOAD_PAGE_FAULT},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cpu, mem := newPagedCPU(t)
+			cpu.Priv = tc.priv
+			cpu.csr.mstatus = tc.status // MPP = U
+			_, err := cpu.translate(mem, tc.vaddr, tc.access)
+			assertPageFault(t, err, tc.cause, tc.vaddr)
+		})
+	}
+}
+
+func TestMMU_Permissions(t *testing.T) {
+	cpu, mem := newPagedCPU(t)
+	cpu.Priv = PRIV_S
+	cpu.csr.mstatus |= MSTATUS_SUM
+	_, err := cpu.translate(mem, testUserData, accessLoad)
+	assert.NoError(t, err, "SUM permits supervisor access to user pages")
+
+	cpu.Priv = PRIV_U
+	cpu.csr.mstatus |= MSTATUS_MXR
+	_, err = cpu.translate(mem, testUserCode, accessLoad)
+	assert.NoError(t, err, "MXR permits loads from executable pages")
+
+	cpu.Priv = PRIV_M
+	cpu.csr.satp = 0
+	assert.Equal(t, WordHandler(mem), cpu.mmu(mem, accessLoad), "M-mode uses physical addresses")
+}
+
+func TestMMU_MisalignedMegapage(t *testing.T) {
+	cpu, mem := newPagedCPU(t)
+	assert.NoError(t, mem.WriteWord(testRootTable+2*4, pte(0x1, PTE_R)))
+	cpu.Priv = PRIV_S
+	_, err := cpu.translate(mem, 0x800000, accessLoad)
+	assertPageFault(t, err, CAUSE_LOAD_PAGE_FAULT, 0x800000)
+}
+
+func TestMMU_TLB(t *testing.T) {
+	cpu, mem := newPagedCPU(t)
+	cpu.Priv = PRIV_S
+	assert.NoError(t, mem.WriteWord(0x4000, 0x11111111))
+	assert.NoError(t, mem.WriteWord(0x5000, 0x22222222))
+	cpu.csr.mstatus |= MSTATUS_SUM
+	view := cpu.mmu(mem, accessLoad)
+	v, err := view.ReadWord(testUserData)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0x11111111), v)
+
+	// remapping the page is not visible until SFENCE.VMA
+	assert.NoError(t, mem.WriteWord(testLeafTable+1*4, pte(0x5, PTE_U|PTE_R)))
+	v, _ = view.ReadWord(testUserData)
+	assert.Equal(t, uint32(0x11111111), v, "stale TLB entry")
+
+	cpu.Reg[1] = testUserData
+	assert.NoError(t, cpu.sfenceVMA(mustAssemble(t, "sfence.vma x1")))
+	v, _ = view.ReadWord(testUserData)
+	assert.Equal(t, uint32(0x22222222), v)
+
+	cpu.Priv = PRIV_U
+	assert.Error(t, cpu.sfenceVMA(mustAssemble(t, "sfence.vma")), "SFENCE.VMA is illegal in U-mode")
+}
+
+func TestMMU_PageCrossing(t *testing.T) {
+	cpu, mem := newPagedCPU(t)
+	cpu.Priv = PRIV_U
+	// the data page (0x4000) and the read-only page (0x5000) are adjacent in
+	// virtual memory; map them in reverse physical order to see the split
+	assert.NoError(t, mem.WriteWord(testLeafTable+1*4, pte(0x5, PTE_U|PTE_R|PTE_W)))
+	assert.NoError(t, mem.WriteWord(testLeafTable+2*4, pte(0x4, PTE_U|PTE_R)))
+	assert.NoError(t, mem.WriteWord(0x5FFC, 0xDDCCBBAA))
+	assert.NoError(t, mem.WriteWord(0x4000, 0x44332211))
+	v, err := cpu.mmu(mem, accessLoad).ReadWord(testUserData + PAGE_SIZE - 2)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0x2211DDCC), v)
+
+	err = cpu.mmu(mem, accessStore).WriteWord(testUserData+PAGE_SIZE-2, 0)
+	assertPageFault(t, err, CAUSE_STORE_PAGE_FAULT, testReadOnly)
+}
+
+func TestCPU_UserMode(t *testing.T) {
+	cpu, mem := newPagedCPU(t)
+	cpu.Traps = true
+	writeLines(t, mem, 0x3000,
+		"addi x1, x0, 7",
+		"lui x2, 0x401",
+		"sw x1, 4(x2)",
+		"lw x3, 4(x2)",
+		"ecall",
+	)
+	writeLines(t, mem, trapHandlerAddr, "csrr x5, mcause")
+	assert.NoError(t, cpu.WriteCSR(assembler.CSR_MTVEC, trapHandlerAddr))
+	assert.NoError(t, cpu.WriteCSR(assembler.CSR_MEPC, testUserCode))
+	// drop from M-mode into the user program
+	cpu.csr.mstatus &^= MSTATUS_MPP
+	writeLines(t, mem, 0, "mret")
+
+	for i := 0; i < 7; i++ {
+		assert.NoError(t, cpu.Step(mem))
+	}
+	assert.Equal(t, PRIV_M, cpu.Priv)
+	assert.Equal(t, uint32(trapHandlerAddr+4), cpu.PC)
+	assert.Equal(t, CAUSE_ECALL_U, cpu.Reg[5])
+	assert.Equal(t, uint32(7), cpu.Reg[3])
+	word, _ := mem.ReadWord(0x4004)
+	assert.Equal(t, uint32(7), word, "store went to the mapped physical page")
+	mepc, _ := cpu.ReadCSR(assembler.CSR_MEPC)
+	assert.Equal(t, uint32(testUserCode+16), mepc, "mepc holds the virtual PC")
+	assert.Equal(t, PRIV_U<<MSTATUS_MPP_SHIFT, cpu.csr.mstatus&MSTATUS_MPP)
+}
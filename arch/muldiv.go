@@ -0,0 +1,51 @@
+package arch
+
+import (
+	"math"
+
+	"github.com/malikwirin/riscvemu/assembler"
+)
+
+// mulDiv executes an M extension operation selected by funct3.
+// Division never traps: the results for division by zero and for the
+// signed overflow case (MinInt32 / -1) are the ones mandated by the spec.
+func mulDiv(funct3 uint32, a, b uint32) uint32 {
+	switch funct3 {
+	case assembler.FUNCT3_MUL:
+		return a * b
+	case assembler.FUNCT3_MULH:
+		return uint32((int64(int32(a)) * int64(int32(b))) >> 32)
+	case assembler.FUNCT3_MULHSU:
+		return uint32((int64(int32(a)) * int64(b)) >> 32)
+	case assembler.FUNCT3_MULHU:
+		return uint32((uint64(a) * uint64(b)) >> 32)
+	case assembler.FUNCT3_DIV:
+		switch {
+		case b == 0:
+			return 0xFFFFFFFF
+		case int32(a) == math.MinInt32 && int32(b) == -1:
+			return a
+		default:
+			return uint32(int32(a) / int32(b))
+		}
+	case assembler.FUNCT3_DIVU:
+		if b == 0 {
+			return 0xFFFFFFFF
+		}
+		return a / b
+	case assembler.FUNCT3_REM:
+		switch {
+		case b == 0:
+			return a
+		case int32(a) == math.MinInt32 && int32(b) == -1:
+			return 0
+		default:
+			return uint32(int32(a) % int32(b))
+		}
+	default: // FUNCT3_REMU
+		if b == 0 {
+			return a
+		}
+		return a % b
+	}
+}
@@ -0,0 +1,180 @@
+package arch
+
+import "fmt"
+
+// PLIC register layout (SiFive/QEMU virt compatible), relative to the PLIC's
+// base address on the bus. Context 0 is the hart's M-mode, context 1 its S-mode.
+const (
+	PLIC_BASE uint32 = 0x0C000000
+	PLIC_SIZE uint32 = 0x4000000
+
+	PLIC_PRIORITY       uint32 = 0x000000 // + 4*source
+	PLIC_PENDING        uint32 = 0x001000
+	PLIC_ENABLE         uint32 = 0x002000 // + 0x80*context
+	PLIC_THRESHOLD      uint32 = 0x200000 // + 0x1000*context
+	PLIC_CLAIM          uint32 = 0x200004 // + 0x1000*context, also used to complete
+	PLIC_ENABLE_STRIDE  uint32 = 0x80
+	PLIC_CONTEXT_STRIDE uint32 = 0x1000
+
+	// PLIC_SOURCES is the number of interrupt sources; source 0 means "no interrupt"
+	PLIC_SOURCES = 32
+	// PLIC_MAX_PRIORITY is the highest priority (and threshold) value
+	PLIC_MAX_PRIORITY = 7
+
+	PLIC_CONTEXT_M = 0
+	PLIC_CONTEXT_S = 1
+	plicContexts   = 2
+)
+
+// InterruptLine is a device's connection to an interrupt controller: the device
+// calls it with true to raise its interrupt and with false to lower it.
+type InterruptLine func(level bool)
+
+// PLIC is the platform-level interrupt controller. It collects the interrupt
+// lines of the devices (level triggered) and signals the highest-priority
+// enabled one to the hart as a machine (MEIP) or supervisor (SEIP) external
+// interrupt, depending on the context it is enabled for.
+type PLIC struct {
+	priority  [PLIC_SOURCES]uint32
+	level     uint32 // current state of the lines, one bit per source
+	pending   uint32
+	claimed   uint32 // claimed but not yet completed
+	enable    [plicContexts]uint32
+	threshold [plicContexts]uint32
+}
+
+func NewPLIC() *PLIC {
+	return &PLIC{}
+}
+
+// Reset clears all registers. The state of the interrupt lines is kept, as it
+// belongs to the devices.
+func (p *PLIC) Reset() {
+	level := p.level
+	*p = PLIC{}
+	for source := uint32(1); source < PLIC_SOURCES; source++ {
+		if level>>source&1 != 0 {
+			p.SetLevel(source, true)
+		}
+	}
+}
+
+// Line returns the interrupt line of source, to be handed to a device.
+func (p *PLIC) Line(source uint32) InterruptLine {
+	return func(level bool) {
+		p.SetLevel(source, level)
+	}
+}
+
+// SetLevel raises or lowers the interrupt line of source. A raised line becomes
+// pending unless the source is being serviced (claimed, but not completed).
+func (p *PLIC) SetLevel(source uint32, level bool) {
+	if source == 0 || source >= PLIC_SOURCES {
+		return
+	}
+	bit := uint32(1) << source
+	if level {
+		p.level |= bit
+		if p.claimed&bit == 0 {
+			p.pending |= bit
+		}
+	} else {
+		p.level &^= bit
+		p.pending &^= bit
+	}
+}
+
+// best returns the pending source enabled for context with the highest priority
+// above the context's threshold, or 0. Ties go to the lowest source ID.
+func (p *PLIC) best(context int) uint32 {
+	var best, bestPriority uint32
+	candidates := p.pending & p.enable[context]
+	for source := uint32(1); source < PLIC_SOURCES; source++ {
+		priority := p.priority[source]
+		if candidates>>source&1 != 0 && priority > p.threshold[context] && priority > bestPriority {
+			best, bestPriority = source, priority
+		}
+	}
+	return best
+}
+
+// ExternalPending reports whether context has an interrupt to take.
+func (p *PLIC) ExternalPending(context int) bool {
+	return p.best(context) != 0
+}
+
+// Claim returns the source to be serviced by context and marks it as claimed.
+// It returns 0 if no interrupt is pending.
+func (p *PLIC) Claim(context int) uint32 {
+	source := p.best(context)
+	if source != 0 {
+		p.pending &^= 1 << source
+		p.claimed |= 1 << source
+	}
+	return source
+}
+
+// Complete signals that context has serviced source. If its line is still
+// raised, the source becomes pending again.
+func (p *PLIC) Complete(context int, source uint32) {
+	if source == 0 || source >= PLIC_SOURCES || p.enable[context]>>source&1 == 0 {
+		return // ignored, like on real hardware
+	}
+	p.claimed &^= 1 << source
+	p.SetLevel(source, p.level>>source&1 != 0)
+}
+
+// contextRegister decodes the offset of a per-context register relative to
+// base; it returns -1 if offset does not address one.
+func contextRegister(offset, base, stride uint32) int {
+	if offset < base || (offset-base)%stride != 0 || (offset-base)/stride >= plicContexts {
+		return -1
+	}
+	return int((offset - base) / stride)
+}
+
+func (p *PLIC) ReadWord(offset uint32) (uint32, error) {
+	switch {
+	case offset < 4*PLIC_SOURCES && offset%4 == 0:
+		return p.priority[offset/4], nil
+	case offset == PLIC_PENDING:
+		return p.pending, nil
+	}
+	if context := contextRegister(offset, PLIC_ENABLE, PLIC_ENABLE_STRIDE); context >= 0 {
+		return p.enable[context], nil
+	}
+	if context := contextRegister(offset, PLIC_THRESHOLD, PLIC_CONTEXT_STRIDE); context >= 0 {
+		return p.threshold[context], nil
+	}
+	if context := contextRegister(offset, PLIC_CLAIM, PLIC_CONTEXT_STRIDE); context >= 0 {
+		return p.Claim(context), nil
+	}
+	return 0, fmt.Errorf("PLIC: no register at offset 0x%06x", offset)
+}
+
+func (p *PLIC) WriteWord(offset uint32, value uint32) error {
+	switch {
+	case offset < 4*PLIC_SOURCES && offset%4 == 0:
+		if offset != 0 { // source 0 does not exist
+			p.priority[offset/4] = value & PLIC_MAX_PRIORITY
+		}
+		return nil
+	case offset == PLIC_PENDING:
+		return nil // read-only
+	}
+	if context := contextRegister(offset, PLIC_ENABLE, PLIC_ENABLE_STRIDE); context >= 0 {
+		p.enable[context] = value &^ 1
+		return nil
+	}
+	if context := contextRegister(offset, PLIC_THRESHOLD, PLIC_CONTEXT_STRIDE); context >= 0 {
+		p.threshold[context] = value & PLIC_MAX_PRIORITY
+		return nil
+	}
+	if context := contextRegister(offset, PLIC_CLAIM, PLIC_CONTEXT_STRIDE); context >= 0 {
+		p.Complete(context, value)
+		return nil
+	}
+	return fmt.Errorf("PLIC: no register at offset 0x%06x", offset)
+}
+
+var _ WordHandler = (*PLIC)(nil)
@@ -0,0 +1,83 @@
+package arch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// plicWrite writes a PLIC register given by its offset.
+func plicWrite(t *testing.T, p *PLIC, offset, value uint32) {
+	t.Helper()
+	assert.NoError(t, p.WriteWord(offset, value))
+}
+
+func plicRead(t *testing.T, p *PLIC, offset uint32) uint32 {
+	t.Helper()
+	v, err := p.ReadWord(offset)
+	assert.NoError(t, err)
+	return v
+}
+
+func TestPLIC_ClaimComplete(t *testing.T) {
+	p := NewPLIC()
+	plicWrite(t, p, PLIC_PRIORITY+4*3, 1)
+	plicWrite(t, p, PLIC_PRIORITY+4*5, 2)
+	plicWrite(t, p, PLIC_ENABLE, 1<<3|1<<5)
+
+	uart, disk := p.Line(3), p.Line(5)
+	uart(true)
+	disk(true)
+	assert.Equal(t, uint32(1<<3|1<<5), plicRead(t, p, PLIC_PENDING))
+	assert.True(t, p.ExternalPending(PLIC_CONTEXT_M))
+	assert.False(t, p.ExternalPending(PLIC_CONTEXT_S), "not enabled for S-mode")
+
+	assert.Equal(t, uint32(5), plicRead(t, p, PLIC_CLAIM), "highest priority first")
+	assert.Equal(t, uint32(3), plicRead(t, p, PLIC_CLAIM))
+	assert.Equal(t, uint32(0), plicRead(t, p, PLIC_CLAIM), "nothing left to claim")
+	assert.False(t, p.ExternalPending(PLIC_CONTEXT_M))
+
+	// a claimed source stays quiet until completed, then re-pends while its line is raised
+	disk(true)
+	assert.False(t, p.ExternalPending(PLIC_CONTEXT_M))
+	plicWrite(t, p, PLIC_CLAIM, 5)
+	assert.True(t, p.ExternalPending(PLIC_CONTEXT_M))
+	uart(false)
+	plicWrite(t, p, PLIC_CLAIM, 3)
+	assert.Equal(t, uint32(1<<5), plicRead(t, p, PLIC_PENDING))
+}
+
+func TestPLIC_PriorityAndThreshold(t *testing.T) {
+	p := NewPLIC()
+	plicWrite(t, p, PLIC_PRIORITY+4*2, 3)
+	plicWrite(t, p, PLIC_PRIORITY+4*4, 3)
+	plicWrite(t, p, PLIC_ENABLE+PLIC_ENABLE_STRIDE, 1<<2|1<<4) // S-mode context
+	p.SetLevel(4, true)
+	p.SetLevel(2, true)
+
+	plicWrite(t, p, PLIC_THRESHOLD+PLIC_CONTEXT_STRIDE, 3)
+	assert.False(t, p.ExternalPending(PLIC_CONTEXT_S), "priority must exceed the threshold")
+	plicWrite(t, p, PLIC_THRESHOLD+PLIC_CONTEXT_STRIDE, 2)
+	assert.True(t, p.ExternalPending(PLIC_CONTEXT_S))
+	assert.Equal(t, uint32(2), p.Claim(PLIC_CONTEXT_S), "ties go to the lowest ID")
+
+	plicWrite(t, p, PLIC_PRIORITY+4*4, 0xFF)
+	assert.Equal(t, uint32(PLIC_MAX_PRIORITY), plicRead(t, p, PLIC_PRIORITY+4*4))
+	plicWrite(t, p, PLIC_PRIORITY, 5)
+	assert.Equal(t, uint32(0), plicRead(t, p, PLIC_PRIORITY), "source 0 does not exist")
+	plicWrite(t, p, PLIC_ENABLE, 0xFFFFFFFF)
+	assert.Equal(t, uint32(0xFFFFFFFE), plicRead(t, p, PLIC_ENABLE))
+
+	_, err := p.ReadWord(0x3000)
+	assert.Error(t, err)
+}
+
+func TestPLIC_Reset(t *testing.T) {
+	p := NewPLIC()
+	plicWrite(t, p, PLIC_PRIORITY+4*1, 1)
+	plicWrite(t, p, PLIC_ENABLE, 1<<1)
+	p.SetLevel(1, true)
+	p.Reset()
+	assert.Equal(t, uint32(0), plicRead(t, p, PLIC_ENABLE))
+	assert.Equal(t, uint32(1<<1), plicRead(t, p, PLIC_PENDING), "a raised line stays pending")
+}
@@ -0,0 +1,282 @@
+package arch
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ECALL service numbers of the RARS simulator (a7).
+const (
+	RARS_PRINT_INT          = 1
+	RARS_PRINT_FLOAT        = 2
+	RARS_PRINT_DOUBLE       = 3
+	RARS_PRINT_STRING       = 4
+	RARS_READ_INT           = 5
+	RARS_READ_FLOAT         = 6
+	RARS_READ_DOUBLE        = 7
+	RARS_READ_STRING        = 8
+	RARS_SBRK               = 9
+	RARS_EXIT               = 10
+	RARS_PRINT_CHAR         = 11
+	RARS_READ_CHAR          = 12
+	RARS_MARS_EXIT2         = 17 // MARS's number for RARS_EXIT2
+	RARS_TIME               = 30
+	RARS_PRINT_INT_HEX      = 34
+	RARS_PRINT_INT_BINARY   = 35
+	RARS_PRINT_INT_UNSIGNED = 36
+	RARS_RAND_SEED          = 40
+	RARS_RAND_INT           = 41
+	RARS_RAND_INT_RANGE     = 42
+	RARS_EXIT2              = 93
+)
+
+// RARS provides the console, heap, time and random number services of the RARS
+// and MARS simulators used in teaching: the service number is passed in a7,
+// arguments in a0-a2 (fa0 for floating-point values) and results are returned
+// in a0 (and a1, or fa0). Unlike Linux, an unknown service or malformed input
+// stops execution with an error, as it does in RARS.
+type RARS struct {
+	In  io.Reader
+	Out io.Writer
+	// HeapBase is where sbrk allocates from if no program was loaded above it
+	HeapBase uint32
+	// Now returns the time for the time service and the default random seeds
+	Now func() time.Time
+
+	in   *bufio.Reader // buffers In
+	heap uint32        // the next address sbrk returns, 0 before the first call
+	// rand holds the random number generators, selected by the id in a0
+	rand map[uint32]*rand.Rand
+}
+
+// NewRARS returns a RARS environment on the given console. The heap starts at
+// DRAM_BASE, so the machine needs RAM there for programs that use sbrk.
+func NewRARS(in io.Reader, out io.Writer) *RARS {
+	return &RARS{
+		In:       in,
+		Out:      out,
+		HeapBase: DRAM_BASE,
+		Now:      time.Now,
+		rand:     make(map[uint32]*rand.Rand),
+	}
+}
+
+// Reset frees the heap and forgets the random number generators.
+func (r *RARS) Reset() {
+	r.heap = 0
+	r.rand = make(map[uint32]*rand.Rand)
+}
+
+// ProgramLoaded moves the heap after the program if it was loaded above HeapBase.
+func (r *RARS) ProgramLoaded(exe *Executable) {
+	end := r.HeapBase
+	for _, seg := range exe.Segments {
+		end = max(end, seg.Base+seg.Size)
+	}
+	r.heap = (end + PAGE_SIZE - 1) &^ (PAGE_SIZE - 1)
+}
+
+// Ecall performs the service in a7.
+func (r *RARS) Ecall(c *CPU, memory WordHandler) error {
+	a0, a1 := c.Reg[REG_A0], c.Reg[REG_A1]
+	service := c.Reg[REG_A7]
+	switch service {
+	case RARS_PRINT_INT:
+		return r.print(strconv.Itoa(int(int32(a0))))
+	case RARS_PRINT_INT_HEX:
+		return r.print(fmt.Sprintf("0x%08x", a0))
+	case RARS_PRINT_INT_BINARY:
+		return r.print(fmt.Sprintf("0b%032b", a0))
+	case RARS_PRINT_INT_UNSIGNED:
+		return r.print(strconv.FormatUint(uint64(a0), 10))
+	case RARS_PRINT_FLOAT:
+		v := math.Float32frombits(uint32(c.readF(uint32(REG_FA0), singleFormat)))
+		return r.print(formatJavaFloat(float64(v), 32))
+	case RARS_PRINT_DOUBLE:
+		return r.print(formatJavaFloat(math.Float64frombits(c.FReg[REG_FA0]), 64))
+	case RARS_PRINT_CHAR:
+		return r.print(string([]byte{byte(a0)}))
+	case RARS_PRINT_STRING:
+		s, err := c.readGuestString(memory, a0)
+		if err != nil {
+			return err
+		}
+		return r.print(s)
+	case RARS_READ_INT:
+		line, err := r.readLine()
+		if err != nil {
+			return err
+		}
+		v, err := strconv.ParseInt(line, 0, 32)
+		if err != nil {
+			return fmt.Errorf("read int: invalid integer %q", line)
+		}
+		c.SetReg(REG_A0, uint32(v))
+	case RARS_READ_FLOAT, RARS_READ_DOUBLE:
+		line, err := r.readLine()
+		if err != nil {
+			return err
+		}
+		if service == RARS_READ_FLOAT {
+			v, err := strconv.ParseFloat(line, 32)
+			if err != nil {
+				return fmt.Errorf("read float: invalid number %q", line)
+			}
+			c.writeF(uint32(REG_FA0), singleFormat, uint64(math.Float32bits(float32(v))))
+		} else {
+			v, err := strconv.ParseFloat(line, 64)
+			if err != nil {
+				return fmt.Errorf("read double: invalid number %q", line)
+			}
+			c.writeF(uint32(REG_FA0), doubleFormat, math.Float64bits(v))
+		}
+	case RARS_READ_STRING:
+		return r.readString(c, memory, a0, int32(a1))
+	case RARS_READ_CHAR:
+		b, err := r.reader().ReadByte()
+		if err != nil {
+			return fmt.Errorf("read char: %w", err)
+		}
+		c.SetReg(REG_A0, uint32(b))
+	case RARS_SBRK:
+		addr, err := r.sbrk(int32(a0))
+		if err != nil {
+			return err
+		}
+		c.SetReg(REG_A0, addr)
+	case RARS_EXIT:
+		return &ExitError{Code: 0}
+	case RARS_EXIT2, RARS_MARS_EXIT2:
+		return &ExitError{Code: int(int32(a0))}
+	case RARS_TIME:
+		ms := uint64(r.Now().UnixMilli())
+		c.SetReg(REG_A0, uint32(ms))
+		c.SetReg(REG_A1, uint32(ms>>32))
+	case RARS_RAND_SEED:
+		r.rand[a0] = rand.New(rand.NewSource(int64(a1)))
+	case RARS_RAND_INT:
+		c.SetReg(REG_A0, r.random(a0).Uint32())
+	case RARS_RAND_INT_RANGE:
+		if int32(a1) <= 0 {
+			return fmt.Errorf("random int range: upper bound %d is not positive", int32(a1))
+		}
+		c.SetReg(REG_A0, uint32(r.random(a0).Int31n(int32(a1))))
+	default:
+		return fmt.Errorf("unknown RARS ecall service %d", service)
+	}
+	return nil
+}
+
+func (r *RARS) print(s string) error {
+	_, err := io.WriteString(r.Out, s)
+	return err
+}
+
+func (r *RARS) reader() *bufio.Reader {
+	if r.in == nil {
+		in := r.In
+		if in == nil {
+			in = os.Stdin
+		}
+		r.in = bufio.NewReader(in)
+	}
+	return r.in
+}
+
+// readLine reads a line of input without its line ending.
+func (r *RARS) readLine() (string, error) {
+	line, err := r.reader().ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", fmt.Errorf("reading input: %w", err)
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// readString implements the read string service like fgets: it reads up to
+// max-1 characters, stopping after a newline, and appends a NUL.
+func (r *RARS) readString(c *CPU, memory WordHandler, buf uint32, max int32) error {
+	if max < 1 {
+		return nil
+	}
+	var s []byte
+	for int32(len(s)) < max-1 {
+		b, err := r.reader().ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read string: %w", err)
+		}
+		s = append(s, b)
+		if b == '\n' {
+			break
+		}
+	}
+	return c.writeGuest(memory, buf, append(s, 0))
+}
+
+// sbrk allocates size bytes on the heap and returns their address. The heap
+// stays word-aligned.
+func (r *RARS) sbrk(size int32) (uint32, error) {
+	if size < 0 {
+		return 0, fmt.Errorf("sbrk: negative size %d", size)
+	}
+	if r.heap == 0 {
+		r.heap = r.HeapBase
+	}
+	addr := r.heap
+	end := uint64(addr) + (uint64(size)+3)&^3
+	if end > math.MaxUint32 {
+		return 0, fmt.Errorf("sbrk: out of memory allocating %d bytes", size)
+	}
+	r.heap = uint32(end)
+	return addr, nil
+}
+
+// random returns the random number generator id, seeding a new one from the clock.
+func (r *RARS) random(id uint32) *rand.Rand {
+	rng, ok := r.rand[id]
+	if !ok {
+		rng = rand.New(rand.NewSource(r.Now().UnixNano()))
+		r.rand[id] = rng
+	}
+	return rng
+}
+
+// formatJavaFloat formats v the way RARS (Java) prints floats: the shortest
+// representation, always with a decimal point or an exponent.
+func formatJavaFloat(v float64, bits int) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "Infinity"
+	case math.IsInf(v, -1):
+		return "-Infinity"
+	}
+	abs := math.Abs(v)
+	if abs != 0 && (abs < 1e-3 || abs >= 1e7) {
+		s := strconv.FormatFloat(v, 'E', -1, bits)
+		mantissa, exp, _ := strings.Cut(s, "E")
+		if !strings.Contains(mantissa, ".") {
+			mantissa += ".0"
+		}
+		e, _ := strconv.Atoi(exp)
+		return fmt.Sprintf("%sE%d", mantissa, e)
+	}
+	s := strconv.FormatFloat(v, 'f', -1, bits)
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	return s
+}
+
+var _ Environment = (*RARS)(nil)
+var _ ProgramLoader = (*RARS)(nil)
@@ -0,0 +1,137 @@
+package arch
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newRARSTest(input string) (*RARS, *CPU, *Memory, *bytes.Buffer) {
+	var out bytes.Buffer
+	r := NewRARS(strings.NewReader(input), &out)
+	r.Now = func() time.Time { return time.UnixMilli(0x123456789AB) }
+	cpu := NewCPU()
+	cpu.Env = r
+	return r, cpu, NewMemory(0x1000), &out
+}
+
+// service calls RARS service number with a0 and a1.
+func service(r *RARS, cpu *CPU, memory *Memory, number, a0, a1 uint32) error {
+	cpu.Reg[REG_A7], cpu.Reg[REG_A0], cpu.Reg[REG_A1] = number, a0, a1
+	return r.Ecall(cpu, memory)
+}
+
+func TestRARS_Print(t *testing.T) {
+	r, cpu, mem, out := newRARSTest("")
+	copy(mem.Data[0x100:], "hello\x00")
+	assert.NoError(t, service(r, cpu, mem, RARS_PRINT_INT, uint32(0xFFFFFFF9), 0))
+	assert.NoError(t, service(r, cpu, mem, RARS_PRINT_CHAR, ' ', 0))
+	assert.NoError(t, service(r, cpu, mem, RARS_PRINT_STRING, 0x100, 0))
+	assert.NoError(t, service(r, cpu, mem, RARS_PRINT_INT_HEX, 0xBEEF, 0))
+	assert.NoError(t, service(r, cpu, mem, RARS_PRINT_INT_BINARY, 5, 0))
+	assert.NoError(t, service(r, cpu, mem, RARS_PRINT_INT_UNSIGNED, uint32(0xFFFFFFFF), 0))
+	assert.Equal(t, "-7 hello0x0000beef0b000000000000000000000000000001014294967295", out.String())
+
+	out.Reset()
+	cpu.FReg[REG_FA0] = BoxSingle(math.Float32bits(1.5))
+	assert.NoError(t, service(r, cpu, mem, RARS_PRINT_FLOAT, 0, 0))
+	cpu.FReg[REG_FA0] = math.Float64bits(3)
+	assert.NoError(t, service(r, cpu, mem, RARS_PRINT_DOUBLE, 0, 0))
+	assert.Equal(t, "1.53.0", out.String())
+
+	assert.Error(t, service(r, cpu, mem, RARS_PRINT_STRING, 0x2000, 0))
+	assert.ErrorContains(t, service(r, cpu, mem, 1000, 0, 0), "unknown RARS ecall service 1000")
+}
+
+func TestFormatJavaFloat(t *testing.T) {
+	for v, want := range map[float64]string{
+		0: "0.0", 2: "2.0", -0.25: "-0.25", 1e7: "1.0E7", 1.5e-5: "1.5E-5",
+		math.Inf(1): "Infinity", math.NaN(): "NaN",
+	} {
+		assert.Equal(t, want, formatJavaFloat(v, 64))
+	}
+	assert.Equal(t, "0.1", formatJavaFloat(float64(float32(0.1)), 32))
+}
+
+func TestRARS_Read(t *testing.T) {
+	r, cpu, mem, _ := newRARSTest("-42\n2.5\n0.125\nxyz\nabcdefgh\nbad\n")
+	assert.NoError(t, service(r, cpu, mem, RARS_READ_INT, 0, 0))
+	assert.Equal(t, uint32(0xFFFFFFD6), cpu.Reg[REG_A0])
+	assert.NoError(t, service(r, cpu, mem, RARS_READ_FLOAT, 0, 0))
+	assert.Equal(t, BoxSingle(math.Float32bits(2.5)), cpu.FReg[REG_FA0])
+	assert.NoError(t, service(r, cpu, mem, RARS_READ_DOUBLE, 0, 0))
+	assert.Equal(t, math.Float64bits(0.125), cpu.FReg[REG_FA0])
+
+	assert.NoError(t, service(r, cpu, mem, RARS_READ_CHAR, 0, 0))
+	assert.Equal(t, uint32('x'), cpu.Reg[REG_A0])
+	assert.NoError(t, service(r, cpu, mem, RARS_READ_STRING, 0x100, 10))
+	assert.Equal(t, "yz\n\x00", string(mem.Data[0x100:0x104]), "stops after a newline")
+	assert.NoError(t, service(r, cpu, mem, RARS_READ_STRING, 0x100, 4))
+	assert.Equal(t, "abc\x00", string(mem.Data[0x100:0x104]), "at most length-1 characters")
+	assert.NoError(t, service(r, cpu, mem, RARS_READ_STRING, 0x100, 10))
+
+	assert.ErrorContains(t, service(r, cpu, mem, RARS_READ_INT, 0, 0), "invalid integer")
+	assert.Error(t, service(r, cpu, mem, RARS_READ_INT, 0, 0), "end of input")
+}
+
+func TestRARS_Sbrk(t *testing.T) {
+	r, cpu, mem, _ := newRARSTest("")
+	assert.NoError(t, service(r, cpu, mem, RARS_SBRK, 5, 0))
+	assert.Equal(t, uint32(DRAM_BASE), cpu.Reg[REG_A0])
+	assert.NoError(t, service(r, cpu, mem, RARS_SBRK, 4, 0))
+	assert.Equal(t, uint32(DRAM_BASE+8), cpu.Reg[REG_A0], "the heap stays word-aligned")
+	assert.Error(t, service(r, cpu, mem, RARS_SBRK, uint32(0xFFFFFFFF), 0))
+
+	r.ProgramLoaded(&Executable{Segments: []MemoryRange{{DRAM_BASE, 0x1234}}})
+	assert.NoError(t, service(r, cpu, mem, RARS_SBRK, 4, 0))
+	assert.Equal(t, uint32(DRAM_BASE+0x2000), cpu.Reg[REG_A0], "the heap starts after the program")
+
+	r.Reset()
+	assert.NoError(t, service(r, cpu, mem, RARS_SBRK, 4, 0))
+	assert.Equal(t, uint32(DRAM_BASE), cpu.Reg[REG_A0])
+}
+
+func TestRARS_TimeAndRandom(t *testing.T) {
+	r, cpu, mem, _ := newRARSTest("")
+	assert.NoError(t, service(r, cpu, mem, RARS_TIME, 0, 0))
+	assert.Equal(t, uint32(0x456789AB), cpu.Reg[REG_A0])
+	assert.Equal(t, uint32(0x123), cpu.Reg[REG_A1])
+
+	draw := func(id uint32) []uint32 {
+		var values []uint32
+		for i := 0; i < 5; i++ {
+			assert.NoError(t, service(r, cpu, mem, RARS_RAND_INT_RANGE, id, 10))
+			assert.Less(t, cpu.Reg[REG_A0], uint32(10))
+			values = append(values, cpu.Reg[REG_A0])
+		}
+		return values
+	}
+	assert.NoError(t, service(r, cpu, mem, RARS_RAND_SEED, 1, 99))
+	first := draw(1)
+	assert.NoError(t, service(r, cpu, mem, RARS_RAND_SEED, 1, 99))
+	assert.Equal(t, first, draw(1), "the same seed gives the same numbers")
+	assert.NoError(t, service(r, cpu, mem, RARS_RAND_INT, 2, 0))
+	assert.Error(t, service(r, cpu, mem, RARS_RAND_INT_RANGE, 1, 0))
+}
+
+func TestRARS_Exit(t *testing.T) {
+	r, cpu, mem, _ := newRARSTest("")
+	var exit *ExitError
+	assert.ErrorAs(t, service(r, cpu, mem, RARS_EXIT, 5, 0), &exit)
+	assert.Equal(t, 0, exit.Code)
+	assert.ErrorAs(t, service(r, cpu, mem, RARS_EXIT2, uint32(0xFFFFFFFF), 0), &exit)
+	assert.Equal(t, -1, exit.Code)
+	assert.ErrorAs(t, service(r, cpu, mem, RARS_MARS_EXIT2, 3, 0), &exit)
+	assert.Equal(t, 3, exit.Code)
+
+	// errors stop Step after the ECALL
+	writeLines(t, mem, 0, "ecall")
+	cpu.Reg[REG_A7] = 1000
+	err := cpu.Step(mem)
+	assert.ErrorContains(t, err, "unknown RARS ecall service")
+	assert.Equal(t, uint32(4), cpu.PC)
+}
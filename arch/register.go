@@ -1,3 +1,16 @@
 package arch
 
 type RegIndex uint8
+
+// Registers with a role in the calling convention
+const (
+	REG_RA RegIndex = 1
+	REG_SP RegIndex = 2
+	REG_A0 RegIndex = 10 // first argument and return value
+	REG_A1 RegIndex = 11
+	REG_A2 RegIndex = 12
+	REG_A3 RegIndex = 13
+	REG_A7 RegIndex = 17 // system call number
+
+	REG_FA0 RegIndex = 10 // first FP argument and return value (f10)
+)
@@ -0,0 +1,304 @@
+package arch
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Semihosting operation numbers (a0), shared with Arm semihosting.
+const (
+	SEMIHOST_OPEN          = 0x01
+	SEMIHOST_CLOSE         = 0x02
+	SEMIHOST_WRITEC        = 0x03
+	SEMIHOST_WRITE0        = 0x04
+	SEMIHOST_WRITE         = 0x05
+	SEMIHOST_READ          = 0x06
+	SEMIHOST_ISTTY         = 0x09
+	SEMIHOST_SEEK          = 0x0A
+	SEMIHOST_FLEN          = 0x0C
+	SEMIHOST_CLOCK         = 0x10
+	SEMIHOST_TIME          = 0x11
+	SEMIHOST_ERRNO         = 0x13
+	SEMIHOST_EXIT          = 0x18
+	SEMIHOST_EXIT_EXTENDED = 0x20
+)
+
+// ADP_Stopped_ApplicationExit is the SEMIHOST_EXIT reason of a normal exit.
+const ADP_Stopped_ApplicationExit = 0x20026
+
+// The instructions around the EBREAK of a semihosting call.
+const (
+	semihostEntry = 0x01F01013 // slli x0, x0, 0x1f
+	semihostBreak = 0x00100073 // ebreak
+	semihostExit  = 0x40705013 // srai x0, x0, 7
+)
+
+// semihostModes maps the fopen modes of SEMIHOST_OPEN ("r", "rb", "r+", "r+b",
+// "w", ... in pairs) to host flags.
+var semihostModes = [...]int{
+	os.O_RDONLY, os.O_RDONLY, os.O_RDWR, os.O_RDWR,
+	os.O_WRONLY | os.O_CREATE | os.O_TRUNC, os.O_WRONLY | os.O_CREATE | os.O_TRUNC,
+	os.O_RDWR | os.O_CREATE | os.O_TRUNC, os.O_RDWR | os.O_CREATE | os.O_TRUNC,
+	os.O_WRONLY | os.O_CREATE | os.O_APPEND, os.O_WRONLY | os.O_CREATE | os.O_APPEND,
+	os.O_RDWR | os.O_CREATE | os.O_APPEND, os.O_RDWR | os.O_CREATE | os.O_APPEND,
+}
+
+// Semihosting implements the RISC-V semihosting convention: an EBREAK between
+// "slli x0, x0, 0x1f" and "srai x0, x0, 7" requests the operation in a0 with
+// the parameter block at the address in a1 (or the parameter itself) and
+// returns the result in a0. Files are opened relative to Root, and the special
+// file ":tt" is the console: handle 0 for reading, 1 for writing and 2 for
+// appending (stderr).
+type Semihosting struct {
+	Root string
+	hostFiles
+	// Now returns the time for SEMIHOST_CLOCK and SEMIHOST_TIME
+	Now func() time.Time
+
+	start time.Time // SEMIHOST_CLOCK counts from here
+	errno int32     // error number of the last failed operation
+}
+
+// NewSemihosting returns a semihosting host on the host's console with its files in root.
+func NewSemihosting(root string) *Semihosting {
+	s := &Semihosting{
+		Root:      root,
+		hostFiles: newHostFiles(),
+		Now:       time.Now,
+	}
+	s.start = s.Now()
+	return s
+}
+
+// Reset closes all files and restarts the clock.
+func (s *Semihosting) Reset() {
+	s.closeAll()
+	s.start = s.Now()
+	s.errno = 0
+}
+
+// isSemihostingCall reports whether the EBREAK at PC is a semihosting call.
+// All three instructions must be uncompressed.
+func (c *CPU) isSemihostingCall(memory WordHandler) bool {
+	view := c.mmu(memory, accessFetch)
+	for i, want := range []uint32{semihostEntry, semihostBreak, semihostExit} {
+		if word, err := readWordAt(view, c.PC-4+uint32(4*i)); err != nil || word != want {
+			return false
+		}
+	}
+	return true
+}
+
+// readWordAt reads a word that may be only halfword-aligned, as instructions can be.
+func readWordAt(memory WordHandler, addr uint32) (uint32, error) {
+	if addr%4 == 0 {
+		return memory.ReadWord(addr)
+	}
+	lo, err := readHalf(memory, addr)
+	if err != nil {
+		return 0, err
+	}
+	hi, err := readHalf(memory, addr+2)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(lo) | uint32(hi)<<16, nil
+}
+
+// semihost performs a semihosting call.
+func (c *CPU) semihost(memory WordHandler) error {
+	return wrapEnvironmentError(c.Semihost.Call(c, memory))
+}
+
+// Call performs the operation in a0. Errors stop execution; SEMIHOST_EXIT
+// returns an *ExitError.
+func (s *Semihosting) Call(c *CPU, memory WordHandler) error {
+	op, arg := c.Reg[REG_A0], c.Reg[REG_A1]
+	// params reads the n words of the parameter block
+	params := func(n uint32) ([]uint32, error) {
+		data, err := c.readGuest(memory, arg, 4*n)
+		if err != nil {
+			return nil, fmt.Errorf("semihosting: reading parameters at 0x%08x: %w", arg, err)
+		}
+		words := make([]uint32, n)
+		for i := range words {
+			words[i] = binary.LittleEndian.Uint32(data[4*i:])
+		}
+		return words, nil
+	}
+	var result int32
+	switch op {
+	case SEMIHOST_EXIT:
+		// RV32 passes the reason itself, without a subcode
+		if arg == ADP_Stopped_ApplicationExit {
+			return &ExitError{Code: 0}
+		}
+		return &ExitError{Code: 1}
+	case SEMIHOST_EXIT_EXTENDED:
+		p, err := params(2)
+		if err != nil {
+			return err
+		}
+		if p[0] == ADP_Stopped_ApplicationExit {
+			return &ExitError{Code: int(int32(p[1]))}
+		}
+		return &ExitError{Code: 1}
+	case SEMIHOST_WRITEC:
+		b, err := c.readGuest(memory, arg, 1)
+		if err != nil {
+			return fmt.Errorf("semihosting: %w", err)
+		}
+		_, err = s.Stdout.Write(b)
+		return err
+	case SEMIHOST_WRITE0:
+		str, err := c.readGuestString(memory, arg)
+		if err != nil {
+			return fmt.Errorf("semihosting: %w", err)
+		}
+		_, err = io.WriteString(s.Stdout, str)
+		return err
+	case SEMIHOST_OPEN:
+		p, err := params(3)
+		if err != nil {
+			return err
+		}
+		result = s.open(c, memory, p[0], p[1], p[2])
+	case SEMIHOST_CLOSE:
+		p, err := params(1)
+		if err != nil {
+			return err
+		}
+		result = s.close(int32(p[0]))
+	case SEMIHOST_WRITE:
+		p, err := params(3)
+		if err != nil {
+			return err
+		}
+		result = s.write(c, memory, int32(p[0]), p[1], p[2])
+	case SEMIHOST_READ:
+		p, err := params(3)
+		if err != nil {
+			return err
+		}
+		result = s.read(c, memory, int32(p[0]), p[1], p[2])
+	case SEMIHOST_ISTTY:
+		p, err := params(1)
+		if err != nil {
+			return err
+		}
+		result = s.isatty(int32(p[0]))
+	case SEMIHOST_SEEK:
+		p, err := params(2)
+		if err != nil {
+			return err
+		}
+		result = s.seek(int32(p[0]), int64(p[1]))
+	case SEMIHOST_FLEN:
+		p, err := params(1)
+		if err != nil {
+			return err
+		}
+		result = s.flen(int32(p[0]))
+	case SEMIHOST_CLOCK:
+		result = int32(s.Now().Sub(s.start) / (10 * time.Millisecond))
+	case SEMIHOST_TIME:
+		result = int32(s.Now().Unix())
+	case SEMIHOST_ERRNO:
+		result = s.errno
+	default:
+		result = s.fail(-ENOSYS)
+	}
+	c.SetReg(REG_A0, uint32(result))
+	return nil
+}
+
+// fail records the negated error number err for SEMIHOST_ERRNO and returns -1.
+func (s *Semihosting) fail(err int32) int32 {
+	s.errno = -err
+	return -1
+}
+
+func (s *Semihosting) open(c *CPU, memory WordHandler, pathAddr, mode, length uint32) int32 {
+	if length > maxStringLength {
+		return s.fail(-EINVAL)
+	}
+	data, err := c.readGuest(memory, pathAddr, length)
+	if err != nil {
+		return s.fail(-EFAULT)
+	}
+	path := string(data)
+	if mode >= uint32(len(semihostModes)) {
+		return s.fail(-EINVAL)
+	}
+	if path == ":tt" {
+		return int32(mode / 4) // read: stdin, write: stdout, append: stderr
+	}
+	f, err := openSandboxed(s.Root, path, semihostModes[mode], 0o644)
+	if err != nil {
+		return s.fail(errno(err))
+	}
+	return s.add(f)
+}
+
+func (s *Semihosting) close(handle int32) int32 {
+	if result := s.hostFiles.close(handle); result < 0 {
+		return s.fail(result)
+	}
+	return 0
+}
+
+// write returns the number of bytes that were not written.
+func (s *Semihosting) write(c *CPU, memory WordHandler, handle int32, buf, count uint32) int32 {
+	n := s.hostFiles.write(c, memory, handle, buf, count)
+	if n < 0 {
+		s.fail(n)
+		n = 0
+	}
+	return int32(count) - n
+}
+
+// read returns the number of bytes that were not read: count at the end of the file.
+func (s *Semihosting) read(c *CPU, memory WordHandler, handle int32, buf, count uint32) int32 {
+	n := s.hostFiles.read(c, memory, handle, buf, count)
+	if n < 0 {
+		return s.fail(n)
+	}
+	return int32(count) - n
+}
+
+func (s *Semihosting) isatty(handle int32) int32 {
+	if isConsole(handle) {
+		return 1
+	}
+	if _, ok := s.file(handle); !ok {
+		return s.fail(-EBADF)
+	}
+	return 0
+}
+
+// seek moves to the absolute position pos.
+func (s *Semihosting) seek(handle int32, pos int64) int32 {
+	f, ok := s.file(handle)
+	if !ok {
+		return s.fail(-EBADF)
+	}
+	if _, err := f.Seek(pos, io.SeekStart); err != nil {
+		return s.fail(-EINVAL)
+	}
+	return 0
+}
+
+func (s *Semihosting) flen(handle int32) int32 {
+	f, ok := s.file(handle)
+	if !ok {
+		return s.fail(-EBADF)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return s.fail(errno(err))
+	}
+	return int32(info.Size())
+}
@@ -0,0 +1,180 @@
+package arch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type semihostTest struct {
+	cpu    *CPU
+	mem    *Memory
+	s      *Semihosting
+	stdout bytes.Buffer
+	stderr bytes.Buffer
+	now    time.Time
+}
+
+func newSemihostTest(t *testing.T) *semihostTest {
+	st := &semihostTest{cpu: NewCPU(), mem: NewMemory(0x1000), now: time.Unix(1700000000, 0)}
+	st.s = NewSemihosting(t.TempDir())
+	st.s.Stdin = strings.NewReader("typed")
+	st.s.Stdout, st.s.Stderr = &st.stdout, &st.stderr
+	st.s.Now = func() time.Time { return st.now }
+	st.s.Reset()
+	st.cpu.Semihost = st.s
+	t.Cleanup(st.s.Reset)
+	writeLines(t, st.mem, 0, "slli x0, x0, 31", "ebreak", "srai x0, x0, 7")
+	return st
+}
+
+// call executes the semihosting EBREAK with op in a0 and the parameter words
+// at 0x100 (or arg itself if there are none) in a1, and returns a0.
+func (st *semihostTest) call(t *testing.T, op uint32, params ...uint32) (int32, error) {
+	t.Helper()
+	st.cpu.Reg[REG_A0], st.cpu.Reg[REG_A1] = op, 0x100
+	for i, p := range params {
+		binary.LittleEndian.PutUint32(st.mem.Data[0x100+4*i:], p)
+	}
+	st.cpu.PC = 4
+	err := st.cpu.Step(st.mem)
+	if err == nil {
+		assert.Equal(t, uint32(8), st.cpu.PC, "execution continues after the EBREAK")
+	}
+	return int32(st.cpu.Reg[REG_A0]), err
+}
+
+func (st *semihostTest) mustCall(t *testing.T, op uint32, params ...uint32) int32 {
+	t.Helper()
+	result, err := st.call(t, op, params...)
+	assert.NoError(t, err)
+	return result
+}
+
+// str writes s to addr and returns its address and length.
+func (st *semihostTest) str(addr uint32, s string) (uint32, uint32) {
+	copy(st.mem.Data[addr:], s+"\x00")
+	return addr, uint32(len(s))
+}
+
+func TestSemihosting_Console(t *testing.T) {
+	st := newSemihostTest(t)
+	st.mem.Data[0x200] = 'A'
+	st.cpu.Reg[REG_A1] = 0x200
+	st.cpu.Reg[REG_A0], st.cpu.PC = SEMIHOST_WRITEC, 4
+	assert.NoError(t, st.cpu.Step(st.mem))
+	st.str(0x200, "bc\n")
+	st.cpu.Reg[REG_A0], st.cpu.PC = SEMIHOST_WRITE0, 4
+	assert.NoError(t, st.cpu.Step(st.mem))
+	assert.Equal(t, "Abc\n", st.stdout.String())
+
+	path, length := st.str(0x300, ":tt")
+	assert.Equal(t, int32(0), st.mustCall(t, SEMIHOST_OPEN, path, 0, length), "\"r\" is stdin")
+	assert.Equal(t, int32(1), st.mustCall(t, SEMIHOST_OPEN, path, 4, length), "\"w\" is stdout")
+	assert.Equal(t, int32(2), st.mustCall(t, SEMIHOST_OPEN, path, 8, length), "\"a\" is stderr")
+	assert.Equal(t, int32(1), st.mustCall(t, SEMIHOST_ISTTY, 1))
+
+	buf, n := st.str(0x200, "out")
+	assert.Equal(t, int32(0), st.mustCall(t, SEMIHOST_WRITE, 2, buf, n), "all bytes written")
+	assert.Equal(t, "out", st.stderr.String())
+	assert.Equal(t, int32(5), st.mustCall(t, SEMIHOST_READ, 0, 0x200, 10), "5 of 10 bytes not read")
+	assert.Equal(t, "typed", string(st.mem.Data[0x200:0x205]))
+	assert.Equal(t, int32(10), st.mustCall(t, SEMIHOST_READ, 0, 0x200, 10), "end of input")
+}
+
+func TestSemihosting_Files(t *testing.T) {
+	st := newSemihostTest(t)
+	path, length := st.str(0x300, "dir/../out.txt")
+	handle := st.mustCall(t, SEMIHOST_OPEN, path, 4, length) // "w"
+	assert.Equal(t, int32(3), handle)
+	assert.Equal(t, int32(0), st.mustCall(t, SEMIHOST_ISTTY, uint32(handle)))
+	buf, n := st.str(0x200, "0123456789")
+	assert.Equal(t, int32(0), st.mustCall(t, SEMIHOST_WRITE, uint32(handle), buf, n))
+	assert.Equal(t, int32(10), st.mustCall(t, SEMIHOST_FLEN, uint32(handle)))
+	assert.Equal(t, int32(0), st.mustCall(t, SEMIHOST_CLOSE, uint32(handle)))
+	assert.Equal(t, int32(-1), st.mustCall(t, SEMIHOST_CLOSE, uint32(handle)))
+	assert.Equal(t, int32(EBADF), st.mustCall(t, SEMIHOST_ERRNO))
+
+	data, err := os.ReadFile(filepath.Join(st.s.Root, "out.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "0123456789", string(data))
+
+	handle = st.mustCall(t, SEMIHOST_OPEN, path, 1, length) // "rb"
+	assert.Equal(t, int32(0), st.mustCall(t, SEMIHOST_SEEK, uint32(handle), 6))
+	assert.Equal(t, int32(6), st.mustCall(t, SEMIHOST_READ, uint32(handle), 0x400, 10))
+	assert.Equal(t, "6789", string(st.mem.Data[0x400:0x404]))
+
+	path, length = st.str(0x300, "missing")
+	assert.Equal(t, int32(-1), st.mustCall(t, SEMIHOST_OPEN, path, 0, length))
+	assert.Equal(t, int32(ENOENT), st.mustCall(t, SEMIHOST_ERRNO))
+	assert.Equal(t, int32(-1), st.mustCall(t, SEMIHOST_OPEN, path, 12, length), "invalid mode")
+
+	outside := t.TempDir()
+	assert.NoError(t, os.Symlink(outside, filepath.Join(st.s.Root, "link")))
+	path, length = st.str(0x300, "link/x")
+	assert.Equal(t, int32(-1), st.mustCall(t, SEMIHOST_OPEN, path, 4, length))
+	assert.Equal(t, int32(EACCES), st.mustCall(t, SEMIHOST_ERRNO), "files stay in the root")
+
+	assert.NoError(t, os.Symlink(filepath.Join(outside, "created"), filepath.Join(st.s.Root, "dangling")))
+	path, length = st.str(0x300, "dangling")
+	assert.Equal(t, int32(-1), st.mustCall(t, SEMIHOST_OPEN, path, 4, length))
+	assert.Equal(t, int32(EACCES), st.mustCall(t, SEMIHOST_ERRNO))
+	assert.NoFileExists(t, filepath.Join(outside, "created"), "a dangling link is not followed")
+}
+
+func TestSemihosting_LargeTransfers(t *testing.T) {
+	st := newSemihostTest(t)
+	st.mem = NewMemory(4 * maxTransfer)
+	writeLines(t, st.mem, 0, "slli x0, x0, 31", "ebreak", "srai x0, x0, 7")
+	st.s.Stdin = bytes.NewReader(bytes.Repeat([]byte("x"), 2*maxTransfer))
+
+	assert.Equal(t, int32(maxTransfer), st.mustCall(t, SEMIHOST_READ, 0, 0x1000, 2*maxTransfer), "the rest is not read")
+	assert.Equal(t, int32(maxTransfer), st.mustCall(t, SEMIHOST_WRITE, 1, 0x1000, 2*maxTransfer), "the rest is not written")
+	assert.Equal(t, maxTransfer, st.stdout.Len())
+
+	assert.Equal(t, int32(-1), st.mustCall(t, SEMIHOST_OPEN, 0x1000, 0, 0xFFFFFFFF), "path length")
+	assert.Equal(t, int32(EINVAL), st.mustCall(t, SEMIHOST_ERRNO))
+}
+
+func TestSemihosting_Clock(t *testing.T) {
+	st := newSemihostTest(t)
+	st.now = st.now.Add(1500 * time.Millisecond)
+	assert.Equal(t, int32(150), st.mustCall(t, SEMIHOST_CLOCK), "centiseconds since the start")
+	assert.Equal(t, int32(1700000001), st.mustCall(t, SEMIHOST_TIME))
+	st.s.Reset()
+	assert.Equal(t, int32(0), st.mustCall(t, SEMIHOST_CLOCK))
+	assert.Equal(t, int32(-1), st.mustCall(t, 0x99))
+	assert.Equal(t, int32(ENOSYS), st.mustCall(t, SEMIHOST_ERRNO))
+}
+
+func TestSemihosting_Exit(t *testing.T) {
+	st := newSemihostTest(t)
+	var exit *ExitError
+	st.cpu.Reg[REG_A0], st.cpu.Reg[REG_A1], st.cpu.PC = SEMIHOST_EXIT, ADP_Stopped_ApplicationExit, 4
+	assert.ErrorAs(t, st.cpu.Step(st.mem), &exit)
+	assert.Equal(t, 0, exit.Code)
+	st.cpu.Reg[REG_A0], st.cpu.Reg[REG_A1], st.cpu.PC = SEMIHOST_EXIT, 0x20023, 4
+	assert.ErrorAs(t, st.cpu.Step(st.mem), &exit)
+	assert.Equal(t, 1, exit.Code)
+
+	_, err := st.call(t, SEMIHOST_EXIT_EXTENDED, ADP_Stopped_ApplicationExit, 42)
+	assert.ErrorAs(t, err, &exit)
+	assert.Equal(t, 42, exit.Code)
+}
+
+func TestSemihosting_PlainEbreak(t *testing.T) {
+	st := newSemihostTest(t)
+	writeLines(t, st.mem, 0x20, "addi x0, x0, 0", "ebreak", "srai x0, x0, 7")
+	st.cpu.PC = 0x24
+	assert.ErrorIs(t, st.cpu.Step(st.mem), ErrBreakpoint, "an EBREAK without the markers is a breakpoint")
+
+	st.cpu.Semihost = nil
+	st.cpu.PC = 4
+	assert.ErrorIs(t, st.cpu.Step(st.mem), ErrBreakpoint, "semihosting is off")
+}
@@ -0,0 +1,457 @@
+package arch
+
+import (
+	"math"
+	"math/big"
+
+	"github.com/malikwirin/riscvemu/assembler"
+)
+
+// This file implements IEEE-754 binary32/binary64 arithmetic on raw bit patterns
+// with all RISC-V rounding modes and exception flags. Operations are computed on
+// big.Float values with enough precision to know the exact result's leading bits
+// plus a sticky bit, which are then rounded into the destination format.
+
+// Accrued exception flags (the fflags CSR field)
+const (
+	FFLAG_NX uint32 = 1 << 0 // inexact
+	FFLAG_UF uint32 = 1 << 1 // underflow
+	FFLAG_OF uint32 = 1 << 2 // overflow
+	FFLAG_DZ uint32 = 1 << 3 // divide by zero
+	FFLAG_NV uint32 = 1 << 4 // invalid operation
+)
+
+// workPrec is the precision of intermediate results. It exceeds twice the
+// binary64 significand, so products are exact and every other result keeps
+// enough correct leading bits for a correctly rounded final result.
+const workPrec = 128
+
+// fpFormat describes an IEEE-754 binary interchange format.
+type fpFormat struct {
+	prec    uint // significand precision including the hidden bit
+	expBits uint
+}
+
+var (
+	singleFormat = fpFormat{prec: 24, expBits: 8}
+	doubleFormat = fpFormat{prec: 53, expBits: 11}
+)
+
+func (f fpFormat) bias() int        { return 1<<(f.expBits-1) - 1 }
+func (f fpFormat) emin() int        { return 1 - f.bias() }
+func (f fpFormat) emax() int        { return f.bias() }
+func (f fpFormat) signBit() uint64  { return 1 << (f.prec + f.expBits - 1) }
+func (f fpFormat) expMask() uint64  { return (1<<f.expBits - 1) << (f.prec - 1) }
+func (f fpFormat) fracMask() uint64 { return 1<<(f.prec-1) - 1 }
+func (f fpFormat) quietBit() uint64 { return 1 << (f.prec - 2) }
+
+func (f fpFormat) canonicalNaN() uint64 { return f.expMask() | f.quietBit() }
+
+func (f fpFormat) inf(neg bool) uint64 {
+	return f.withSign(f.expMask(), neg)
+}
+
+func (f fpFormat) zero(neg bool) uint64 {
+	return f.withSign(0, neg)
+}
+
+func (f fpFormat) maxFinite(neg bool) uint64 {
+	return f.withSign(f.expMask()-(1<<(f.prec-1))|f.fracMask(), neg)
+}
+
+func (f fpFormat) withSign(v uint64, neg bool) uint64 {
+	if neg {
+		return v | f.signBit()
+	}
+	return v
+}
+
+func (f fpFormat) isNeg(v uint64) bool  { return v&f.signBit() != 0 }
+func (f fpFormat) isNaN(v uint64) bool  { return v&f.expMask() == f.expMask() && v&f.fracMask() != 0 }
+func (f fpFormat) isSNaN(v uint64) bool { return f.isNaN(v) && v&f.quietBit() == 0 }
+func (f fpFormat) isInf(v uint64) bool  { return v&^f.signBit() == f.expMask() }
+func (f fpFormat) isZero(v uint64) bool { return v&^f.signBit() == 0 }
+
+// toBig converts a finite value to an exact big.Float.
+func (f fpFormat) toBig(v uint64) *big.Float {
+	biased := int((v & f.expMask()) >> (f.prec - 1))
+	mant := v & f.fracMask()
+	exp := f.emin() - int(f.prec-1)
+	if biased != 0 {
+		mant |= 1 << (f.prec - 1)
+		exp = biased - f.bias() - int(f.prec-1)
+	}
+	x := new(big.Float).SetPrec(workPrec).SetUint64(mant)
+	x.SetMantExp(x, exp)
+	if f.isNeg(v) {
+		x.Neg(x)
+	}
+	return x
+}
+
+// toFloat64 converts a value to float64 exactly (used for comparisons).
+func (f fpFormat) toFloat64(v uint64) float64 {
+	if f == singleFormat {
+		return float64(math.Float32frombits(uint32(v)))
+	}
+	return math.Float64frombits(v)
+}
+
+// roundScaled rounds |x| * 2^-q to an integer according to rm. sticky reports
+// that the exact magnitude is slightly larger than |x|. neg is the sign of the
+// exact value, which matters for the directed rounding modes.
+func roundScaled(x *big.Float, q int, sticky bool, rm uint32, neg bool) (n *big.Int, inexact bool) {
+	scaled := new(big.Float).SetPrec(x.Prec()).Abs(x)
+	scaled.SetMantExp(scaled, -q)
+	n, _ = scaled.Int(nil)
+	frac := new(big.Float).SetPrec(x.Prec()).Sub(scaled, new(big.Float).SetInt(n))
+	cmpHalf := frac.Cmp(big.NewFloat(0.5))
+	inexact = frac.Sign() != 0 || sticky
+
+	var up bool
+	switch rm {
+	case assembler.RM_RNE:
+		up = cmpHalf > 0 || (cmpHalf == 0 && (sticky || n.Bit(0) == 1))
+	case assembler.RM_RMM:
+		up = cmpHalf >= 0
+	case assembler.RM_RDN:
+		up = inexact && neg
+	case assembler.RM_RUP:
+		up = inexact && !neg
+	}
+	if up {
+		n.Add(n, big.NewInt(1))
+	}
+	return n, inexact
+}
+
+// round rounds a finite, nonzero x to format f. See roundScaled for sticky.
+func (f fpFormat) round(x *big.Float, sticky bool, rm uint32) (uint64, uint32) {
+	neg := x.Signbit()
+	e := x.MantExp(nil) - 1 // 2^e <= |x| < 2^(e+1)
+	q := max(e, f.emin()) - int(f.prec-1)
+	n, inexact := roundScaled(x, q, sticky, rm, neg)
+
+	var flags uint32
+	if inexact {
+		flags |= FFLAG_NX
+		// Tininess is detected after rounding: the result would be below the
+		// normal range even with an unbounded exponent.
+		if e < f.emin() {
+			unbounded, _ := roundScaled(x, e-int(f.prec-1), sticky, rm, neg)
+			if e < f.emin()-1 || unbounded.BitLen() <= int(f.prec) {
+				flags |= FFLAG_UF
+			}
+		}
+	}
+
+	mant := n.Uint64()
+	if mant == 1<<f.prec { // rounding carried into the next binade
+		mant >>= 1
+		q++
+	}
+	if mant < 1<<(f.prec-1) { // subnormal or zero
+		return f.withSign(mant, neg), flags
+	}
+	biased := q + int(f.prec-1) + f.bias()
+	if biased >= 1<<f.expBits-1 {
+		flags |= FFLAG_OF | FFLAG_NX
+		switch {
+		case rm == assembler.RM_RTZ, rm == assembler.RM_RDN && !neg, rm == assembler.RM_RUP && neg:
+			return f.maxFinite(neg), flags
+		default:
+			return f.inf(neg), flags
+		}
+	}
+	return f.withSign(uint64(biased)<<(f.prec-1)|mant&f.fracMask(), neg), flags
+}
+
+// roundResult rounds the result z of a big.Float operation performed in big.ToZero mode.
+// If z is an exact zero, zeroNeg gives its sign.
+func (f fpFormat) roundResult(z *big.Float, zeroNeg bool, rm uint32) (uint64, uint32) {
+	if z.Sign() == 0 {
+		return f.zero(zeroNeg), 0
+	}
+	return f.round(z, z.Acc() != big.Exact, rm)
+}
+
+func newWork() *big.Float {
+	return new(big.Float).SetPrec(workPrec).SetMode(big.ToZero)
+}
+
+// exactZeroSumSign returns the sign of an exact zero sum of addends with the given signs.
+func exactZeroSumSign(negA, negB bool, rm uint32) bool {
+	if negA == negB {
+		return negA
+	}
+	return rm == assembler.RM_RDN
+}
+
+// nanResult returns the canonical NaN, raising NV if any operand is a signaling NaN.
+func (f fpFormat) nanResult(ops ...uint64) (uint64, uint32) {
+	var flags uint32
+	for _, v := range ops {
+		if f.isSNaN(v) {
+			flags |= FFLAG_NV
+		}
+	}
+	return f.canonicalNaN(), flags
+}
+
+func (f fpFormat) anyNaN(ops ...uint64) bool {
+	for _, v := range ops {
+		if f.isNaN(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func fpAdd(f fpFormat, a, b uint64, rm uint32) (uint64, uint32) {
+	if f.anyNaN(a, b) {
+		return f.nanResult(a, b)
+	}
+	if f.isInf(a) || f.isInf(b) {
+		if f.isInf(a) && f.isInf(b) && f.isNeg(a) != f.isNeg(b) {
+			return f.canonicalNaN(), FFLAG_NV
+		}
+		if f.isInf(a) {
+			return a, 0
+		}
+		return b, 0
+	}
+	z := newWork().Add(f.toBig(a), f.toBig(b))
+	return f.roundResult(z, exactZeroSumSign(f.isNeg(a), f.isNeg(b), rm), rm)
+}
+
+func fpSub(f fpFormat, a, b uint64, rm uint32) (uint64, uint32) {
+	if f.isNaN(b) {
+		return fpAdd(f, a, b, rm)
+	}
+	return fpAdd(f, a, b^f.signBit(), rm)
+}
+
+func fpMul(f fpFormat, a, b uint64, rm uint32) (uint64, uint32) {
+	if f.anyNaN(a, b) {
+		return f.nanResult(a, b)
+	}
+	neg := f.isNeg(a) != f.isNeg(b)
+	if f.isInf(a) || f.isInf(b) {
+		if f.isZero(a) || f.isZero(b) {
+			return f.canonicalNaN(), FFLAG_NV
+		}
+		return f.inf(neg), 0
+	}
+	z := newWork().Mul(f.toBig(a), f.toBig(b))
+	return f.roundResult(z, neg, rm)
+}
+
+func fpDiv(f fpFormat, a, b uint64, rm uint32) (uint64, uint32) {
+	if f.anyNaN(a, b) {
+		return f.nanResult(a, b)
+	}
+	neg := f.isNeg(a) != f.isNeg(b)
+	switch {
+	case f.isInf(a) && f.isInf(b), f.isZero(a) && f.isZero(b):
+		return f.canonicalNaN(), FFLAG_NV
+	case f.isInf(a):
+		return f.inf(neg), 0
+	case f.isInf(b):
+		return f.zero(neg), 0
+	case f.isZero(b):
+		return f.inf(neg), FFLAG_DZ
+	}
+	z := newWork().Quo(f.toBig(a), f.toBig(b))
+	return f.roundResult(z, neg, rm)
+}
+
+func fpSqrt(f fpFormat, a uint64, rm uint32) (uint64, uint32) {
+	switch {
+	case f.isNaN(a):
+		return f.nanResult(a)
+	case f.isZero(a):
+		return a, 0
+	case f.isNeg(a):
+		return f.canonicalNaN(), FFLAG_NV
+	case f.isInf(a):
+		return a, 0
+	}
+	x := f.toBig(a)
+	z := newWork().Sqrt(x)
+	// big.Float.Sqrt does not report its accuracy: determine it by squaring,
+	// making sure z does not exceed the exact root.
+	ulp := new(big.Float).SetMantExp(big.NewFloat(1), z.MantExp(nil)-workPrec)
+	sq := new(big.Float).SetPrec(2*workPrec).Mul(z, z)
+	for sq.Cmp(x) > 0 {
+		z.Sub(z, ulp)
+		sq.Mul(z, z)
+	}
+	return f.round(z, sq.Cmp(x) != 0, rm)
+}
+
+// fpFMA computes (±a*b) ± c with a single rounding.
+func fpFMA(f fpFormat, a, b, c uint64, negProduct, negAddend bool, rm uint32) (uint64, uint32) {
+	if (f.isInf(a) && f.isZero(b)) || (f.isZero(a) && f.isInf(b)) {
+		// invalid even if the addend is a quiet NaN
+		return f.canonicalNaN(), FFLAG_NV
+	}
+	if f.anyNaN(a, b, c) {
+		return f.nanResult(a, b, c)
+	}
+	prodNeg := f.isNeg(a) != f.isNeg(b) != negProduct
+	if negAddend {
+		c ^= f.signBit()
+	}
+	if f.isInf(a) || f.isInf(b) {
+		if f.isInf(c) && f.isNeg(c) != prodNeg {
+			return f.canonicalNaN(), FFLAG_NV
+		}
+		return f.inf(prodNeg), 0
+	}
+	if f.isInf(c) {
+		return c, 0
+	}
+	prod := new(big.Float).SetPrec(2*workPrec).Mul(f.toBig(a), f.toBig(b))
+	if negProduct {
+		prod.Neg(prod)
+	}
+	z := newWork().Add(prod, f.toBig(c))
+	return f.roundResult(z, exactZeroSumSign(prodNeg, f.isNeg(c), rm), rm)
+}
+
+// fpToInt converts to a signed or unsigned 32-bit integer, saturating out-of-range values.
+func fpToInt(f fpFormat, a uint64, unsigned bool, rm uint32) (uint32, uint32) {
+	maxVal, minVal := uint32(math.MaxInt32), uint32(1<<31)
+	if unsigned {
+		maxVal, minVal = math.MaxUint32, 0
+	}
+	switch {
+	case f.isNaN(a):
+		return maxVal, FFLAG_NV
+	case f.isInf(a) && f.isNeg(a):
+		return minVal, FFLAG_NV
+	case f.isInf(a):
+		return maxVal, FFLAG_NV
+	case f.isZero(a):
+		return 0, 0
+	}
+	x := f.toBig(a)
+	n, inexact := roundScaled(x, 0, false, rm, x.Signbit())
+	if x.Signbit() {
+		n.Neg(n)
+	}
+	lo, hi := big.NewInt(math.MinInt32), big.NewInt(math.MaxInt32)
+	if unsigned {
+		lo, hi = big.NewInt(0), big.NewInt(math.MaxUint32)
+	}
+	if n.Cmp(lo) < 0 {
+		return minVal, FFLAG_NV
+	}
+	if n.Cmp(hi) > 0 {
+		return maxVal, FFLAG_NV
+	}
+	var flags uint32
+	if inexact {
+		flags = FFLAG_NX
+	}
+	return uint32(n.Int64()), flags
+}
+
+// fpFromInt converts a signed or unsigned 32-bit integer.
+func fpFromInt(f fpFormat, v uint32, unsigned bool, rm uint32) (uint64, uint32) {
+	x := newWork()
+	if unsigned {
+		x.SetUint64(uint64(v))
+	} else {
+		x.SetInt64(int64(int32(v)))
+	}
+	return f.roundResult(x, false, rm)
+}
+
+// fpConvert converts between formats.
+func fpConvert(from, to fpFormat, a uint64, rm uint32) (uint64, uint32) {
+	switch {
+	case from.isNaN(a):
+		var flags uint32
+		if from.isSNaN(a) {
+			flags = FFLAG_NV
+		}
+		return to.canonicalNaN(), flags
+	case from.isInf(a):
+		return to.inf(from.isNeg(a)), 0
+	case from.isZero(a):
+		return to.zero(from.isNeg(a)), 0
+	}
+	return to.round(from.toBig(a), false, rm)
+}
+
+// fpCompare implements feq (quiet) and flt/fle (signaling).
+func fpCompare(f fpFormat, a, b uint64, funct3 uint32) (bool, uint32) {
+	if f.anyNaN(a, b) {
+		if funct3 != assembler.FUNCT3_FEQ || f.isSNaN(a) || f.isSNaN(b) {
+			return false, FFLAG_NV
+		}
+		return false, 0
+	}
+	x, y := f.toFloat64(a), f.toFloat64(b)
+	switch funct3 {
+	case assembler.FUNCT3_FEQ:
+		return x == y, 0
+	case assembler.FUNCT3_FLT:
+		return x < y, 0
+	default:
+		return x <= y, 0
+	}
+}
+
+// fpMinMax implements fmin/fmax: a single NaN operand is ignored and -0 < +0.
+func fpMinMax(f fpFormat, a, b uint64, isMax bool) (uint64, uint32) {
+	var flags uint32
+	if f.isSNaN(a) || f.isSNaN(b) {
+		flags = FFLAG_NV
+	}
+	switch {
+	case f.isNaN(a) && f.isNaN(b):
+		return f.canonicalNaN(), flags
+	case f.isNaN(a):
+		return b, flags
+	case f.isNaN(b):
+		return a, flags
+	}
+	x, y := f.toFloat64(a), f.toFloat64(b)
+	if x == y { // only differ in the sign of zero
+		if f.isNeg(a) != isMax {
+			return a, flags
+		}
+		return b, flags
+	}
+	if (x > y) == isMax {
+		return a, flags
+	}
+	return b, flags
+}
+
+// fpClass returns the fclass bit mask of a value.
+func fpClass(f fpFormat, a uint64) uint32 {
+	neg := f.isNeg(a)
+	biased := a & f.expMask()
+	var bit uint
+	switch {
+	case f.isNaN(a) && f.isSNaN(a):
+		bit = 8
+	case f.isNaN(a):
+		bit = 9
+	case f.isInf(a):
+		bit = 7
+	case f.isZero(a):
+		bit = 4
+	case biased == 0:
+		bit = 5
+	default:
+		bit = 6
+	}
+	if neg && bit <= 7 {
+		bit = 7 - bit
+	}
+	return 1 << bit
+}
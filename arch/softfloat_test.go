@@ -0,0 +1,181 @@
+package arch
+
+import (
+	"math"
+	"testing"
+
+	"github.com/malikwirin/riscvemu/assembler"
+	"github.com/stretchr/testify/assert"
+)
+
+func f32(v float32) uint64 { return uint64(math.Float32bits(v)) }
+func f64(v float64) uint64 { return math.Float64bits(v) }
+
+const (
+	sNaN32 uint64 = 0x7F800001
+	qNaN32 uint64 = 0x7FC00000
+)
+
+func TestSoftFloat_ArithmeticFlags(t *testing.T) {
+	s, d := singleFormat, doubleFormat
+	cases := []struct {
+		name      string
+		got       func() (uint64, uint32)
+		want      uint64
+		wantFlags uint32
+	}{
+		{"exact add", func() (uint64, uint32) { return fpAdd(s, f32(1.5), f32(2.25), assembler.RM_RNE) }, f32(3.75), 0},
+		{"inexact div", func() (uint64, uint32) { return fpDiv(d, f64(1), f64(3), assembler.RM_RNE) }, f64(1.0 / 3), FFLAG_NX},
+		{"divide by zero", func() (uint64, uint32) { return fpDiv(s, f32(-1), f32(0), assembler.RM_RNE) }, s.inf(true), FFLAG_DZ},
+		{"zero by zero", func() (uint64, uint32) { return fpDiv(s, f32(0), f32(0), assembler.RM_RNE) }, qNaN32, FFLAG_NV},
+		{"inf minus inf", func() (uint64, uint32) { return fpSub(d, d.inf(false), d.inf(false), assembler.RM_RNE) }, d.canonicalNaN(), FFLAG_NV},
+		{"zero times inf", func() (uint64, uint32) { return fpMul(s, f32(0), s.inf(false), assembler.RM_RNE) }, qNaN32, FFLAG_NV},
+		{"sqrt of negative", func() (uint64, uint32) { return fpSqrt(d, f64(-4), assembler.RM_RNE) }, d.canonicalNaN(), FFLAG_NV},
+		{"sqrt of -0", func() (uint64, uint32) { return fpSqrt(d, d.zero(true), assembler.RM_RNE) }, d.zero(true), 0},
+		{"signaling NaN", func() (uint64, uint32) { return fpAdd(s, sNaN32, f32(1), assembler.RM_RNE) }, qNaN32, FFLAG_NV},
+		{"quiet NaN", func() (uint64, uint32) { return fpAdd(s, 0xFFC12345, f32(1), assembler.RM_RNE) }, qNaN32, 0},
+		{"overflow to inf", func() (uint64, uint32) { return fpMul(s, f32(math.MaxFloat32), f32(2), assembler.RM_RNE) }, s.inf(false), FFLAG_OF | FFLAG_NX},
+		{"overflow rtz", func() (uint64, uint32) { return fpMul(s, f32(math.MaxFloat32), f32(2), assembler.RM_RTZ) }, f32(math.MaxFloat32), FFLAG_OF | FFLAG_NX},
+		{"overflow rdn negative", func() (uint64, uint32) { return fpMul(s, f32(math.MaxFloat32), f32(-2), assembler.RM_RDN) }, s.inf(true), FFLAG_OF | FFLAG_NX},
+		{"overflow rup negative", func() (uint64, uint32) { return fpMul(s, f32(math.MaxFloat32), f32(-2), assembler.RM_RUP) }, f32(-math.MaxFloat32), FFLAG_OF | FFLAG_NX},
+		{"underflow", func() (uint64, uint32) { return fpMul(d, f64(0x1p-1000), f64(0x1.8p-74), assembler.RM_RNE) }, 0x0000000000000002, FFLAG_UF | FFLAG_NX},
+		{"exact subnormal", func() (uint64, uint32) { return fpMul(d, f64(0x1p-1000), f64(0x1p-70), assembler.RM_RNE) }, 0x0000000000000010, 0},
+		// rounds to the smallest normal with an unbounded exponent: not tiny after rounding
+		{"no underflow after rounding", func() (uint64, uint32) {
+			return fpFMA(d, f64(0x1p-539), f64(-0x1p-538), f64(0x1p-1022), false, false, assembler.RM_RNE)
+		}, f64(0x1p-1022), FFLAG_NX},
+		{"underflow to zero", func() (uint64, uint32) { return fpMul(s, f32(0x1p-100), f32(-0x1p-100), assembler.RM_RNE) }, s.zero(true), FFLAG_UF | FFLAG_NX},
+		{"underflow rup", func() (uint64, uint32) { return fpMul(s, f32(0x1p-100), f32(0x1p-100), assembler.RM_RUP) }, 1, FFLAG_UF | FFLAG_NX},
+		{"x - x is +0", func() (uint64, uint32) { return fpSub(s, f32(1), f32(1), assembler.RM_RNE) }, s.zero(false), 0},
+		{"x - x is -0 rounding down", func() (uint64, uint32) { return fpSub(s, f32(1), f32(1), assembler.RM_RDN) }, s.zero(true), 0},
+		{"fma single rounding", func() (uint64, uint32) {
+			// (1+2^-12)^2 - 1 needs the unrounded product
+			return fpFMA(s, f32(1+0x1p-12), f32(1+0x1p-12), f32(-1), false, false, assembler.RM_RNE)
+		}, f32(0x1p-11 + 0x1p-24), 0},
+		{"fma invalid with quiet NaN addend", func() (uint64, uint32) {
+			return fpFMA(d, d.inf(false), f64(0), d.canonicalNaN(), false, false, assembler.RM_RNE)
+		}, d.canonicalNaN(), FFLAG_NV},
+		{"fnmadd", func() (uint64, uint32) { return fpFMA(d, f64(2), f64(3), f64(1), true, true, assembler.RM_RNE) }, f64(-7), 0},
+		{"fmsub", func() (uint64, uint32) { return fpFMA(d, f64(2), f64(3), f64(1), false, true, assembler.RM_RNE) }, f64(5), 0},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, flags := tc.got()
+			assert.Equalf(t, tc.want, got, "result 0x%X", got)
+			assert.Equal(t, tc.wantFlags, flags, "flags")
+		})
+	}
+}
+
+func TestSoftFloat_RoundingModes(t *testing.T) {
+	// 1 + 2^-24 is exactly halfway between two singles, 1 + 3*2^-25 is above halfway
+	tie, above := f64(1+0x1p-24), f64(1+3*0x1p-25)
+	cases := []struct {
+		rm   uint32
+		in   uint64
+		want float32
+	}{
+		{assembler.RM_RNE, tie, 1},
+		{assembler.RM_RMM, tie, 1 + 0x1p-23},
+		{assembler.RM_RTZ, above, 1},
+		{assembler.RM_RDN, above, 1},
+		{assembler.RM_RUP, above, 1 + 0x1p-23},
+		{assembler.RM_RNE, above, 1 + 0x1p-23},
+		{assembler.RM_RDN, above | d64Sign, -1 - 0x1p-23},
+		{assembler.RM_RUP, above | d64Sign, -1},
+	}
+	for _, tc := range cases {
+		got, flags := fpConvert(doubleFormat, singleFormat, tc.in, tc.rm)
+		assert.Equalf(t, f32(tc.want), got, "rm %d of 0x%X", tc.rm, tc.in)
+		assert.Equal(t, FFLAG_NX, flags)
+	}
+}
+
+const d64Sign = uint64(1) << 63
+
+func TestSoftFloat_IntConversions(t *testing.T) {
+	s, d := singleFormat, doubleFormat
+	cases := []struct {
+		name      string
+		in        uint64
+		format    fpFormat
+		unsigned  bool
+		rm        uint32
+		want      uint32
+		wantFlags uint32
+	}{
+		{"round to even", f32(2.5), s, false, assembler.RM_RNE, 2, FFLAG_NX},
+		{"round away", f32(2.5), s, false, assembler.RM_RMM, 3, FFLAG_NX},
+		{"truncate negative", f64(-2.7), d, false, assembler.RM_RTZ, uint32(0xFFFFFFFE), FFLAG_NX},
+		{"floor negative", f64(-2.2), d, false, assembler.RM_RDN, uint32(0xFFFFFFFD), FFLAG_NX},
+		{"exact", f64(-7), d, false, assembler.RM_RNE, uint32(0xFFFFFFF9), 0},
+		{"positive overflow", f32(3e9), s, false, assembler.RM_RNE, 0x7FFFFFFF, FFLAG_NV},
+		{"negative overflow", s.inf(true), s, false, assembler.RM_RNE, 0x80000000, FFLAG_NV},
+		{"NaN", sNaN32, s, false, assembler.RM_RNE, 0x7FFFFFFF, FFLAG_NV},
+		{"unsigned", f32(3e9), s, true, assembler.RM_RNE, 3000000000, 0},
+		{"unsigned negative", f64(-1), d, true, assembler.RM_RNE, 0, FFLAG_NV},
+		{"unsigned rounds to zero", f64(-0.3), d, true, assembler.RM_RNE, 0, FFLAG_NX},
+		{"unsigned NaN", d.canonicalNaN(), d, true, assembler.RM_RNE, 0xFFFFFFFF, FFLAG_NV},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, flags := fpToInt(tc.format, tc.in, tc.unsigned, tc.rm)
+			assert.Equal(t, tc.want, got)
+			assert.Equal(t, tc.wantFlags, flags, "flags")
+		})
+	}
+
+	got, flags := fpFromInt(s, 16777217, false, assembler.RM_RNE)
+	assert.Equal(t, f32(16777216), got, "2^24+1 is not representable as single")
+	assert.Equal(t, FFLAG_NX, flags)
+	got, flags = fpFromInt(d, 0xFFFFFFFF, false, assembler.RM_RNE)
+	assert.Equal(t, f64(-1), got)
+	assert.Equal(t, uint32(0), flags)
+	got, _ = fpFromInt(d, 0xFFFFFFFF, true, assembler.RM_RNE)
+	assert.Equal(t, f64(4294967295), got)
+}
+
+func TestSoftFloat_CompareMinMaxClass(t *testing.T) {
+	s := singleFormat
+	eq, flags := fpCompare(s, f32(0), s.zero(true), assembler.FUNCT3_FEQ)
+	assert.True(t, eq, "+0 == -0")
+	assert.Equal(t, uint32(0), flags)
+	_, flags = fpCompare(s, qNaN32, f32(1), assembler.FUNCT3_FEQ)
+	assert.Equal(t, uint32(0), flags, "feq is quiet")
+	_, flags = fpCompare(s, sNaN32, f32(1), assembler.FUNCT3_FEQ)
+	assert.Equal(t, FFLAG_NV, flags, "feq signals on sNaN")
+	lt, flags := fpCompare(s, qNaN32, f32(1), assembler.FUNCT3_FLT)
+	assert.False(t, lt)
+	assert.Equal(t, FFLAG_NV, flags, "flt signals on any NaN")
+	le, _ := fpCompare(s, f32(1), f32(1), assembler.FUNCT3_FLE)
+	assert.True(t, le)
+
+	min, _ := fpMinMax(s, f32(0), s.zero(true), false)
+	assert.Equal(t, s.zero(true), min, "min(+0, -0) = -0")
+	max, _ := fpMinMax(s, s.zero(true), f32(0), true)
+	assert.Equal(t, f32(0), max, "max(-0, +0) = +0")
+	min, flags = fpMinMax(s, sNaN32, f32(3), false)
+	assert.Equal(t, f32(3), min, "a single NaN operand is ignored")
+	assert.Equal(t, FFLAG_NV, flags)
+	max, _ = fpMinMax(s, qNaN32, qNaN32|1, true)
+	assert.Equal(t, qNaN32, max, "two NaNs give the canonical NaN")
+
+	classes := []struct {
+		in   uint64
+		want uint32
+	}{
+		{s.inf(true), 1 << 0},
+		{f32(-1), 1 << 1},
+		{0x80000001, 1 << 2},
+		{s.zero(true), 1 << 3},
+		{f32(0), 1 << 4},
+		{0x00000001, 1 << 5},
+		{f32(1), 1 << 6},
+		{s.inf(false), 1 << 7},
+		{sNaN32, 1 << 8},
+		{qNaN32, 1 << 9},
+	}
+	for _, tc := range classes {
+		assert.Equalf(t, tc.want, fpClass(s, tc.in), "fclass(0x%X)", tc.in)
+	}
+}
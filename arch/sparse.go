@@ -0,0 +1,178 @@
+package arch
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Standard placement of the main memory on RISC-V systems (QEMU virt, Spike):
+// from DRAM_BASE to the end of the address space.
+const (
+	DRAM_BASE uint32 = 0x80000000
+	DRAM_SIZE uint32 = 0x80000000
+)
+
+// MemoryRange is an address range [Base, Base+Size).
+type MemoryRange struct {
+	Base uint32
+	Size uint32
+}
+
+// Contains reports whether the size bytes at addr lie within the range.
+func (r MemoryRange) Contains(addr, size uint32) bool {
+	return addr >= r.Base && uint64(addr-r.Base)+uint64(size) <= uint64(r.Size)
+}
+
+// SparseMemory is memory for the full 32-bit address space that allocates its
+// pages on the first write. Unwritten memory reads as zero. Without mapped
+// ranges every address is accessible; once ranges are added with Map, accesses
+// outside them fail.
+type SparseMemory struct {
+	Misaligned MisalignedPolicy
+
+	pages  map[uint32]*[PAGE_SIZE]byte // by page number
+	ranges []MemoryRange               // sorted by Base
+}
+
+func NewSparseMemory() *SparseMemory {
+	return &SparseMemory{pages: make(map[uint32]*[PAGE_SIZE]byte)}
+}
+
+// Map makes [base, base+size) accessible.
+func (s *SparseMemory) Map(base, size uint32) error {
+	if size == 0 || base+size-1 < base {
+		return fmt.Errorf("invalid range: base 0x%08x, size 0x%x", base, size)
+	}
+	for _, r := range s.ranges {
+		if base <= r.Base+r.Size-1 && r.Base <= base+size-1 {
+			return fmt.Errorf("range at 0x%08x overlaps range at 0x%08x", base, r.Base)
+		}
+	}
+	s.ranges = append(s.ranges, MemoryRange{Base: base, Size: size})
+	sort.Slice(s.ranges, func(i, j int) bool { return s.ranges[i].Base < s.ranges[j].Base })
+	return nil
+}
+
+// Ranges returns the mapped ranges in address order.
+func (s *SparseMemory) Ranges() []MemoryRange {
+	return s.ranges
+}
+
+// Mapped reports whether the size bytes at addr are accessible.
+func (s *SparseMemory) Mapped(addr, size uint32) bool {
+	if uint64(addr)+uint64(size) > 1<<32 {
+		return false
+	}
+	if len(s.ranges) == 0 {
+		return true
+	}
+	for _, r := range s.ranges {
+		if r.Contains(addr, size) {
+			return true
+		}
+	}
+	return false
+}
+
+// Pages returns the number of allocated pages.
+func (s *SparseMemory) Pages() int {
+	return len(s.pages)
+}
+
+// Reset frees all pages, so the memory reads as zero again. The mapped ranges are kept.
+func (s *SparseMemory) Reset() {
+	clear(s.pages)
+}
+
+// page returns the page containing addr, allocating it if alloc is set.
+// Otherwise it returns nil for pages that were never written.
+func (s *SparseMemory) page(addr uint32, alloc bool) *[PAGE_SIZE]byte {
+	number := addr >> PAGE_SHIFT
+	p := s.pages[number]
+	if p == nil && alloc {
+		p = new([PAGE_SIZE]byte)
+		s.pages[number] = p
+	}
+	return p
+}
+
+// read returns the size bytes at addr as a little-endian value.
+func (s *SparseMemory) read(addr, size uint32) (uint32, error) {
+	if addr%size != 0 && s.Misaligned != MisalignedAllow {
+		return readMisaligned(s.Misaligned, addr, size, s.read)
+	}
+	if !s.Mapped(addr, size) {
+		return 0, &MemoryError{Addr: addr, Size: size, Err: ErrOutOfBounds}
+	}
+	var value uint32
+	for i := uint32(0); i < size; i++ {
+		if p := s.page(addr+i, false); p != nil {
+			value |= uint32(p[(addr+i)%PAGE_SIZE]) << (8 * i)
+		}
+	}
+	return value, nil
+}
+
+// write stores the low size bytes of value at addr, little-endian.
+func (s *SparseMemory) write(addr, size, value uint32) error {
+	if addr%size != 0 && s.Misaligned != MisalignedAllow {
+		return writeMisaligned(s.Misaligned, addr, size, value, s.write)
+	}
+	if !s.Mapped(addr, size) {
+		return &MemoryError{Addr: addr, Size: size, Write: true, Err: ErrOutOfBounds}
+	}
+	for i := uint32(0); i < size; i++ {
+		s.page(addr+i, true)[(addr+i)%PAGE_SIZE] = uint8(value >> (8 * i))
+	}
+	return nil
+}
+
+// Zero clears size bytes at addr. Pages that were never written already read
+// as zero and stay unallocated.
+func (s *SparseMemory) Zero(addr, size uint32) error {
+	if size == 0 {
+		return nil
+	}
+	if !s.Mapped(addr, size) {
+		return &MemoryError{Addr: addr, Size: size, Write: true, Err: ErrOutOfBounds}
+	}
+	start, end := uint64(addr), uint64(addr)+uint64(size)
+	for number, p := range s.pages {
+		base := uint64(number) << PAGE_SHIFT
+		lo, hi := max(start, base), min(end, base+PAGE_SIZE)
+		if lo < hi {
+			clear(p[lo-base : hi-base])
+		}
+	}
+	return nil
+}
+
+func (s *SparseMemory) ReadWord(addr uint32) (uint32, error) {
+	return s.read(addr, 4)
+}
+
+func (s *SparseMemory) WriteWord(addr uint32, value uint32) error {
+	return s.write(addr, 4, value)
+}
+
+func (s *SparseMemory) ReadHalf(addr uint32) (uint16, error) {
+	v, err := s.read(addr, 2)
+	return uint16(v), err
+}
+
+func (s *SparseMemory) WriteHalf(addr uint32, value uint16) error {
+	return s.write(addr, 2, uint32(value))
+}
+
+func (s *SparseMemory) ReadByteAt(addr uint32) (uint8, error) {
+	v, err := s.read(addr, 1)
+	return uint8(v), err
+}
+
+func (s *SparseMemory) WriteByteAt(addr uint32, value uint8) error {
+	return s.write(addr, 1, uint32(value))
+}
+
+var _ SubWordHandler = (*SparseMemory)(nil)
+var _ WordHandler = (*SparseMemory)(nil)
+var _ Zeroer = (*SparseMemory)(nil)
@@ -0,0 +1,119 @@
+package arch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSparseMemory_ReadWrite(t *testing.T) {
+	s := NewSparseMemory()
+	v, err := s.ReadWord(0x80000000)
+	assert.NoError(t, err)
+	assert.Zero(t, v, "unwritten memory reads as zero")
+	assert.Zero(t, s.Pages(), "reads do not allocate")
+
+	assert.NoError(t, s.WriteWord(0x80000000, 0xDEADBEEF))
+	assert.NoError(t, s.WriteWord(0xFFFFFFFC, 0x12345678))
+	assert.Equal(t, 2, s.Pages())
+	v, _ = s.ReadWord(0x80000000)
+	assert.Equal(t, uint32(0xDEADBEEF), v)
+	h, _ := s.ReadHalf(0x80000002)
+	assert.Equal(t, uint16(0xDEAD), h)
+	b, _ := s.ReadByteAt(0xFFFFFFFF)
+	assert.Equal(t, uint8(0x12), b)
+
+	assert.NoError(t, s.WriteHalf(0x80000001, 0xAAAA))
+	assert.NoError(t, s.WriteByteAt(0x80000000, 0x55))
+	v, _ = s.ReadWord(0x80000000)
+	assert.Equal(t, uint32(0xDEAAAA55), v)
+
+	_, err = s.ReadWord(0xFFFFFFFE)
+	assert.Error(t, err, "access wrapping around the address space")
+
+	s.Reset()
+	assert.Zero(t, s.Pages())
+	v, _ = s.ReadWord(0x80000000)
+	assert.Zero(t, v)
+}
+
+func TestSparseMemory_PageCrossing(t *testing.T) {
+	s := NewSparseMemory()
+	assert.NoError(t, s.WriteWord(0x1FFE, 0x44332211))
+	assert.Equal(t, 2, s.Pages())
+	v, err := s.ReadWord(0x1FFE)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0x44332211), v)
+	h, _ := s.ReadHalf(0x2000)
+	assert.Equal(t, uint16(0x4433), h)
+}
+
+func TestSparseMemory_Misaligned(t *testing.T) {
+	s := NewSparseMemory()
+	assert.NoError(t, s.Map(0x1000, 0x2000))
+	s.Misaligned = MisalignedTrap
+	_, err := s.ReadWord(0x1FFE)
+	assert.ErrorIs(t, err, ErrMisaligned)
+	assert.ErrorIs(t, s.WriteHalf(0x1001, 0), ErrMisaligned)
+	assert.Zero(t, s.Pages())
+
+	s.Misaligned = MisalignedSplit
+	assert.NoError(t, s.WriteWord(0x1FFE, 0x44332211))
+	v, err := s.ReadWord(0x1FFE)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0x44332211), v)
+	var memErr *MemoryError
+	if assert.ErrorAs(t, s.WriteWord(0x2FFE, 0xFFFFFFFF), &memErr) {
+		assert.Equal(t, uint32(0x3000), memErr.Addr, "the first byte beyond the range fails")
+	}
+	b, _ := s.ReadByteAt(0x2FFF)
+	assert.Equal(t, uint8(0xFF), b, "bytes before the failing one are written")
+}
+
+func TestSparseMemory_Zero(t *testing.T) {
+	s := NewSparseMemory()
+	assert.NoError(t, s.WriteWord(0x80000FFC, 0x11223344))
+	assert.NoError(t, s.WriteWord(0x80001000, 0x55667788))
+	assert.NoError(t, s.Zero(0x80000FFE, 0x7FFFF000))
+	v, _ := s.ReadWord(0x80000FFC)
+	assert.Equal(t, uint32(0x3344), v, "bytes before the range are kept")
+	v, _ = s.ReadWord(0x80001000)
+	assert.Zero(t, v)
+	assert.Equal(t, 2, s.Pages(), "no pages are allocated")
+
+	assert.NoError(t, s.Map(0x1000, 0x1000))
+	assert.ErrorIs(t, s.Zero(0x1800, 0x1000), ErrOutOfBounds)
+}
+
+func TestSparseMemory_Ranges(t *testing.T) {
+	s := NewSparseMemory()
+	assert.NoError(t, s.Map(0x80000000, 0x1000))
+	assert.NoError(t, s.Map(0x1000, 0x100))
+	assert.Error(t, s.Map(0x80000800, 0x1000), "overlap")
+	assert.Error(t, s.Map(0xFFFFF000, 0x2000), "wraps around")
+	assert.Error(t, s.Map(0, 0), "empty")
+	assert.Equal(t, []MemoryRange{{0x1000, 0x100}, {0x80000000, 0x1000}}, s.Ranges())
+
+	assert.NoError(t, s.WriteWord(0x80000FFC, 1))
+	assert.Error(t, s.WriteWord(0x80000FFE, 1), "crosses the end of the range")
+	_, err := s.ReadWord(0x2000)
+	assert.Error(t, err, "unmapped")
+	assert.NoError(t, s.WriteByteAt(0x10FF, 1))
+	assert.True(t, s.Mapped(0x1000, 0x100))
+	assert.False(t, s.Mapped(0x1000, 0x101))
+}
+
+func TestSparseMemory_CPU(t *testing.T) {
+	cpu, s := NewCPU(), NewSparseMemory()
+	cpu.PC = DRAM_BASE
+	writeLines(t, s, DRAM_BASE,
+		"lui x1, 0xFFFFF", // x1 = 0xFFFFF000
+		"addi x2, x0, 99",
+		"sb x2, 0xFF(x1)",
+		"lbu x3, 0xFF(x1)",
+	)
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, cpu.Step(s))
+	}
+	assert.Equal(t, uint32(99), cpu.Reg[3])
+}
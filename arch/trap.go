@@ -0,0 +1,265 @@
+package arch
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/malikwirin/riscvemu/assembler"
+)
+
+// Privilege levels
+const (
+	PRIV_U uint32 = 0
+	PRIV_S uint32 = 1
+	PRIV_M uint32 = 3
+)
+
+var privNames = map[uint32]string{PRIV_U: "U", PRIV_S: "S", PRIV_M: "M"}
+
+// PrivName returns the letter naming a privilege level ("M", "S" or "U").
+func PrivName(priv uint32) string {
+	if name, ok := privNames[priv]; ok {
+		return name
+	}
+	return fmt.Sprintf("%d", priv)
+}
+
+// Exception causes, as written to mcause or scause
+const (
+	CAUSE_MISALIGNED_FETCH    uint32 = 0
+	CAUSE_FETCH_ACCESS        uint32 = 1
+	CAUSE_ILLEGAL_INSTRUCTION uint32 = 2
+	CAUSE_BREAKPOINT          uint32 = 3
+	CAUSE_MISALIGNED_LOAD     uint32 = 4
+	CAUSE_LOAD_ACCESS         uint32 = 5
+	CAUSE_MISALIGNED_STORE    uint32 = 6 // also used for AMOs
+	CAUSE_STORE_ACCESS        uint32 = 7 // also used for AMOs
+	CAUSE_ECALL_U             uint32 = 8
+	CAUSE_ECALL_S             uint32 = 9
+	CAUSE_ECALL_M             uint32 = 11
+	CAUSE_FETCH_PAGE_FAULT    uint32 = 12
+	CAUSE_LOAD_PAGE_FAULT     uint32 = 13
+	CAUSE_STORE_PAGE_FAULT    uint32 = 15 // also used for AMOs
+)
+
+// CAUSE_INTERRUPT is set in mcause/scause for interrupts; the remaining bits
+// hold the interrupt code, which is also the interrupt's bit in mip and mie.
+const CAUSE_INTERRUPT uint32 = 1 << 31
+
+// Interrupt codes
+const (
+	IRQ_S_SOFTWARE uint32 = 1
+	IRQ_M_SOFTWARE uint32 = 3
+	IRQ_S_TIMER    uint32 = 5
+	IRQ_M_TIMER    uint32 = 7
+	IRQ_S_EXTERNAL uint32 = 9
+	IRQ_M_EXTERNAL uint32 = 11
+)
+
+// interruptPriority lists the interrupt codes from highest to lowest priority.
+var interruptPriority = []uint32{
+	IRQ_M_EXTERNAL, IRQ_M_SOFTWARE, IRQ_M_TIMER,
+	IRQ_S_EXTERNAL, IRQ_S_SOFTWARE, IRQ_S_TIMER,
+}
+
+var causeNames = map[uint32]string{
+	CAUSE_INTERRUPT | IRQ_S_SOFTWARE: "supervisor software interrupt",
+	CAUSE_INTERRUPT | IRQ_M_SOFTWARE: "machine software interrupt",
+	CAUSE_INTERRUPT | IRQ_S_TIMER:    "supervisor timer interrupt",
+	CAUSE_INTERRUPT | IRQ_M_TIMER:    "machine timer interrupt",
+	CAUSE_INTERRUPT | IRQ_S_EXTERNAL: "supervisor external interrupt",
+	CAUSE_INTERRUPT | IRQ_M_EXTERNAL: "machine external interrupt",
+
+	CAUSE_MISALIGNED_FETCH:    "instruction address misaligned",
+	CAUSE_FETCH_ACCESS:        "instruction access fault",
+	CAUSE_ILLEGAL_INSTRUCTION: "illegal instruction",
+	CAUSE_BREAKPOINT:          "breakpoint",
+	CAUSE_MISALIGNED_LOAD:     "load address misaligned",
+	CAUSE_LOAD_ACCESS:         "load access fault",
+	CAUSE_MISALIGNED_STORE:    "store/AMO address misaligned",
+	CAUSE_STORE_ACCESS:        "store/AMO access fault",
+	CAUSE_ECALL_U:             "environment call from U-mode",
+	CAUSE_ECALL_S:             "environment call from S-mode",
+	CAUSE_ECALL_M:             "environment call from M-mode",
+	CAUSE_FETCH_PAGE_FAULT:    "instruction page fault",
+	CAUSE_LOAD_PAGE_FAULT:     "load page fault",
+	CAUSE_STORE_PAGE_FAULT:    "store/AMO page fault",
+}
+
+// Exception is a synchronous exception raised by an instruction. Depending on
+// CPU.Traps it is either taken as a trap or returned by Step. Interrupts are
+// represented as an Exception with CAUSE_INTERRUPT set; they are always taken.
+type Exception struct {
+	Cause uint32
+	Tval  uint32 // the value written to mtval/stval: the faulting address or instruction
+	Err   error  // the underlying error, if any
+}
+
+func (e *Exception) Error() string {
+	name, ok := causeNames[e.Cause]
+	if !ok {
+		name = fmt.Sprintf("exception %d", e.Cause)
+	}
+	if e.Err != nil {
+		return fmt.Sprintf("%s (tval 0x%08x): %v", name, e.Tval, e.Err)
+	}
+	return fmt.Sprintf("%s (tval 0x%08x)", name, e.Tval)
+}
+
+func (e *Exception) Unwrap() error {
+	return e.Err
+}
+
+// misalignedCauses maps access-fault causes to the address-misaligned cause of the same access.
+var misalignedCauses = map[uint32]uint32{
+	CAUSE_FETCH_ACCESS: CAUSE_MISALIGNED_FETCH,
+	CAUSE_LOAD_ACCESS:  CAUSE_MISALIGNED_LOAD,
+	CAUSE_STORE_ACCESS: CAUSE_MISALIGNED_STORE,
+}
+
+// accessFault wraps a failed memory access at addr as an exception with the given
+// cause, or as an address-misaligned exception if the memory rejected the
+// alignment (ErrMisaligned). It returns nil if err is nil.
+func accessFault(cause, addr uint32, err error) error {
+	if err == nil {
+		return nil
+	}
+	var exc *Exception
+	if errors.As(err, &exc) {
+		return err
+	}
+	if errors.Is(err, ErrMisaligned) {
+		cause = misalignedCauses[cause]
+	}
+	return &Exception{Cause: cause, Tval: addr, Err: err}
+}
+
+// illegalInstruction wraps an error from decoding or executing instr as an illegal-instruction exception.
+func illegalInstruction(instr assembler.Instruction, err error) *Exception {
+	return &Exception{Cause: CAUSE_ILLEGAL_INSTRUCTION, Tval: uint32(instr), Err: err}
+}
+
+// takeTrap enters a trap handler. Traps raised in S- or U-mode that are
+// delegated in medeleg (exceptions) or mideleg (interrupts) go to the supervisor
+// handler at stvec, all others to the machine handler at mtvec. The faulting PC,
+// cause and trap value are saved in the xepc, xcause and xtval CSRs of the target
+// mode and its interrupts are disabled.
+func (c *CPU) takeTrap(exc *Exception) {
+	code := exc.Cause &^ CAUSE_INTERRUPT
+	deleg := c.csr.medeleg
+	if exc.Cause&CAUSE_INTERRUPT != 0 {
+		deleg = c.csr.mideleg
+	}
+	status := c.csr.mstatus
+	if c.Priv <= PRIV_S && deleg>>code&1 != 0 {
+		c.csr.sepc = c.PC
+		c.csr.scause = exc.Cause
+		c.csr.stval = exc.Tval
+		spie := (status & MSTATUS_SIE) << 4 // SIE (bit 1) moves to SPIE (bit 5)
+		c.csr.mstatus = status&^(MSTATUS_SIE|MSTATUS_SPIE|MSTATUS_SPP) | spie | c.Priv<<MSTATUS_SPP_SHIFT
+		c.Priv = PRIV_S
+		c.PC = trapVector(c.csr.stvec, exc.Cause)
+		return
+	}
+	c.csr.mepc = c.PC
+	c.csr.mcause = exc.Cause
+	c.csr.mtval = exc.Tval
+	mpie := (status & MSTATUS_MIE) << 4 // MIE (bit 3) moves to MPIE (bit 7)
+	c.csr.mstatus = status&^(MSTATUS_MIE|MSTATUS_MPIE|MSTATUS_MPP) | mpie | c.Priv<<MSTATUS_MPP_SHIFT
+	c.Priv = PRIV_M
+	c.PC = trapVector(c.csr.mtvec, exc.Cause)
+}
+
+// trapVector returns the handler address for cause: in vectored mode (tvec mode 1)
+// interrupts jump to base + 4*code, everything else to base.
+func trapVector(tvec, cause uint32) uint32 {
+	base := tvec &^ 0x3
+	if tvec&0x3 == 1 && cause&CAUSE_INTERRUPT != 0 {
+		return base + 4*(cause&^CAUSE_INTERRUPT)
+	}
+	return base
+}
+
+// SetInterruptPending raises or lowers the interrupt lines in mask, which show up
+// as pending bits in mip. It is used by the interrupt sources (CLINT, PLIC).
+func (c *CPU) SetInterruptPending(mask uint32, pending bool) {
+	if pending {
+		c.csr.irq |= mask
+	} else {
+		c.csr.irq &^= mask
+	}
+}
+
+// pending returns the value of mip: the interrupt lines and the software-written bits.
+func (c *CPU) pending() uint32 {
+	return c.csr.mip | c.csr.irq
+}
+
+// pendingInterrupt returns the highest-priority interrupt that is pending,
+// enabled and not masked at the current privilege level. Machine interrupts are
+// enabled in M-mode if mstatus.MIE is set and always in lower modes; supervisor
+// interrupts (delegated in mideleg) likewise depend on SIE and are never taken in M-mode.
+func (c *CPU) pendingInterrupt() (uint32, bool) {
+	pending := c.pending() & c.csr.mie
+	if pending == 0 {
+		return 0, false
+	}
+	status := c.csr.mstatus
+	machine := pending &^ c.csr.mideleg
+	if c.Priv == PRIV_M && status&MSTATUS_MIE == 0 {
+		machine = 0
+	}
+	supervisor := pending & c.csr.mideleg
+	if c.Priv == PRIV_M || c.Priv == PRIV_S && status&MSTATUS_SIE == 0 {
+		supervisor = 0
+	}
+	for _, enabled := range []uint32{machine, supervisor} {
+		for _, code := range interruptPriority {
+			if enabled>>code&1 != 0 {
+				return CAUSE_INTERRUPT | code, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// mret returns from a machine-mode trap handler to mepc in the privilege level
+// saved in MPP, restoring the interrupt enable.
+func (c *CPU) mret() error {
+	if c.Priv != PRIV_M {
+		return fmt.Errorf("MRET in %s-mode", privNames[c.Priv])
+	}
+	status := c.csr.mstatus
+	c.Priv = status & MSTATUS_MPP >> MSTATUS_MPP_SHIFT
+	mie := (status & MSTATUS_MPIE) >> 4
+	status = status&^(MSTATUS_MIE|MSTATUS_MPP) | mie | MSTATUS_MPIE // MPP becomes U
+	if c.Priv != PRIV_M {
+		status &^= MSTATUS_MPRV
+	}
+	c.csr.mstatus = status
+	c.PC = c.csr.mepc
+	return nil
+}
+
+// sret returns from a supervisor trap handler to sepc in the privilege level
+// saved in SPP, restoring the interrupt enable.
+func (c *CPU) sret() error {
+	if c.Priv == PRIV_U || c.Priv == PRIV_S && c.csr.mstatus&MSTATUS_TSR != 0 {
+		return fmt.Errorf("SRET in %s-mode", privNames[c.Priv])
+	}
+	status := c.csr.mstatus
+	c.Priv = status & MSTATUS_SPP >> MSTATUS_SPP_SHIFT
+	sie := (status & MSTATUS_SPIE) >> 4
+	c.csr.mstatus = status&^(MSTATUS_SIE|MSTATUS_SPP|MSTATUS_MPRV) | sie | MSTATUS_SPIE // SPP becomes U
+	c.PC = c.csr.sepc
+	return nil
+}
+
+// isTrapReturn reports whether instr is MRET or SRET.
+func isTrapReturn(instr assembler.Instruction) bool {
+	if instr.Opcode() != assembler.OPCODE_SYSTEM || instr.Funct3() != assembler.FUNCT3_PRIV {
+		return false
+	}
+	funct12 := uint32(instr.ImmI()) & 0xFFF
+	return funct12 == assembler.FUNCT12_MRET || funct12 == assembler.FUNCT12_SRET
+}
@@ -0,0 +1,312 @@
+package arch
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/malikwirin/riscvemu/assembler"
+	"github.com/stretchr/testify/assert"
+)
+
+// trapHandler records mcause and mtval in x5/x6, skips the faulting
+// instruction (assumed to be 4 bytes) and returns.
+var trapHandler = []string{
+	"csrr x5, mcause",
+	"csrr x6, mtval",
+	"csrr x7, mepc",
+	"addi x7, x7, 4",
+	"csrw mepc, x7",
+	"mret",
+}
+
+const trapHandlerAddr = 0x80
+
+// writeLines assembles lines into mem starting at addr.
+func writeLines(t *testing.T, mem WordHandler, addr uint32, lines ...string) {
+	t.Helper()
+	for i, line := range lines {
+		assert.NoError(t, mem.WriteWord(addr+uint32(i*4), uint32(mustAssemble(t, line))))
+	}
+}
+
+// runTrap installs trapHandler, executes the single instruction line at 0 and
+// then steps through the handler back to address 4.
+func runTrap(t *testing.T, cpu *CPU, mem *Memory, line string) {
+	t.Helper()
+	cpu.Traps = true
+	assert.NoError(t, cpu.WriteCSR(assembler.CSR_MTVEC, trapHandlerAddr))
+	writeLines(t, mem, trapHandlerAddr, trapHandler...)
+	writeLines(t, mem, 0, line)
+	cpu.PC = 0
+	assert.NoError(t, cpu.Step(mem), line)
+	assert.Equal(t, uint32(trapHandlerAddr), cpu.PC, "PC after trap")
+	for range trapHandler {
+		assert.NoError(t, cpu.Step(mem))
+	}
+	assert.Equal(t, uint32(4), cpu.PC, "PC after mret")
+}
+
+func TestCPU_Traps(t *testing.T) {
+	illegal := uint32(mustAssemble(t, "csrw mhartid, x1"))
+	cases := []struct {
+		name  string
+		asm   string
+		setup func(cpu *CPU)
+		cause uint32
+		tval  uint32
+	}{
+		{"ecall", "ecall", nil, CAUSE_ECALL_M, 0},
+		{"ebreak", "ebreak", nil, CAUSE_BREAKPOINT, 0},
+		{"illegal instruction", "csrw mhartid, x1", nil, CAUSE_ILLEGAL_INSTRUCTION, illegal},
+		{"load access fault", "lw x1, 0(x2)", func(cpu *CPU) { cpu.Reg[2] = 0x1000 }, CAUSE_LOAD_ACCESS, 0x1000},
+		{"store access fault", "sb x1, 1(x2)", func(cpu *CPU) { cpu.Reg[2] = 0x2000 }, CAUSE_STORE_ACCESS, 0x2001},
+		{"misaligned AMO", "amoadd.w x1, x3, (x2)", func(cpu *CPU) { cpu.Reg[2] = 0x42 }, CAUSE_MISALIGNED_STORE, 0x42},
+		{"misaligned LR", "lr.w x1, (x2)", func(cpu *CPU) { cpu.Reg[2] = 0x41 }, CAUSE_MISALIGNED_LOAD, 0x41},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cpu, mem := NewCPU(), NewMemory(256)
+			if tc.setup != nil {
+				tc.setup(cpu)
+			}
+			runTrap(t, cpu, mem, tc.asm)
+			assert.Equal(t, tc.cause, cpu.Reg[5], "mcause")
+			assert.Equal(t, tc.tval, cpu.Reg[6], "mtval")
+			assert.Equal(t, uint32(4), cpu.Reg[7], "mepc + 4")
+		})
+	}
+}
+
+func TestCPU_TrapFetchFault(t *testing.T) {
+	cpu, mem := NewCPU(), NewMemory(256)
+	cpu.Traps = true
+	assert.NoError(t, cpu.WriteCSR(assembler.CSR_MTVEC, trapHandlerAddr))
+	cpu.PC = 0x1000
+	assert.NoError(t, cpu.Step(mem))
+	assert.Equal(t, uint32(trapHandlerAddr), cpu.PC)
+	mcause, _ := cpu.ReadCSR(assembler.CSR_MCAUSE)
+	mepc, _ := cpu.ReadCSR(assembler.CSR_MEPC)
+	mtval, _ := cpu.ReadCSR(assembler.CSR_MTVAL)
+	assert.Equal(t, CAUSE_FETCH_ACCESS, mcause)
+	assert.Equal(t, uint32(0x1000), mepc)
+	assert.Equal(t, uint32(0x1000), mtval)
+}
+
+func TestCPU_TrapStatus(t *testing.T) {
+	cpu, mem := NewCPU(), NewMemory(256)
+	assert.NoError(t, cpu.WriteCSR(assembler.CSR_MSTATUS, MSTATUS_MIE))
+	cpu.Traps = true
+	assert.NoError(t, cpu.WriteCSR(assembler.CSR_MTVEC, trapHandlerAddr))
+	writeLines(t, mem, 0, "ecall")
+	writeLines(t, mem, trapHandlerAddr, "mret")
+
+	assert.NoError(t, cpu.Step(mem))
+	status, _ := cpu.ReadCSR(assembler.CSR_MSTATUS)
+	assert.Zero(t, status&MSTATUS_MIE, "MIE is cleared on trap entry")
+	assert.NotZero(t, status&MSTATUS_MPIE, "MPIE holds the previous MIE")
+	assert.Equal(t, MSTATUS_MPP_M, status&MSTATUS_MPP)
+
+	// mret returns to mepc (the ecall itself here) and restores MIE
+	assert.NoError(t, cpu.Step(mem))
+	assert.Equal(t, uint32(0), cpu.PC)
+	status, _ = cpu.ReadCSR(assembler.CSR_MSTATUS)
+	assert.NotZero(t, status&MSTATUS_MIE)
+	assert.NotZero(t, status&MSTATUS_MPIE)
+}
+
+func TestCPU_StopOnFault(t *testing.T) {
+	cpu, mem := NewCPU(), NewMemory(256)
+	assert.NoError(t, cpu.WriteCSR(assembler.CSR_MTVEC, trapHandlerAddr))
+	cpu.Reg[2] = 0x1000
+	writeLines(t, mem, 0, "lw x1, 0(x2)")
+	err := cpu.Step(mem)
+	var exc *Exception
+	assert.True(t, errors.As(err, &exc), "faults are returned as *Exception")
+	assert.Equal(t, CAUSE_LOAD_ACCESS, exc.Cause)
+	assert.Equal(t, uint32(0x1000), exc.Tval)
+	assert.Equal(t, uint32(0), cpu.PC, "PC stays at the faulting instruction")
+	mcause, _ := cpu.ReadCSR(assembler.CSR_MCAUSE)
+	assert.Zero(t, mcause, "no trap is taken")
+
+	writeLines(t, mem, 0, "csrw mhartid, x1")
+	err = cpu.Step(mem)
+	assert.True(t, errors.As(err, &exc))
+	assert.Equal(t, CAUSE_ILLEGAL_INSTRUCTION, exc.Cause)
+}
+
+func TestCPU_MisalignedAccess(t *testing.T) {
+	cases := []struct {
+		line  string
+		cause uint32
+		addr  uint32
+	}{
+		{"lw x1, 2(x2)", CAUSE_MISALIGNED_LOAD, 0x42},
+		{"lh x1, 1(x2)", CAUSE_MISALIGNED_LOAD, 0x41},
+		{"sw x1, 1(x2)", CAUSE_MISALIGNED_STORE, 0x41},
+		{"sh x1, 3(x2)", CAUSE_MISALIGNED_STORE, 0x43},
+	}
+	for _, tc := range cases {
+		t.Run(tc.line, func(t *testing.T) {
+			cpu, mem := NewCPU(), NewMemory(256)
+			mem.Misaligned = MisalignedTrap
+			cpu.Reg[2] = 0x40
+			writeLines(t, mem, 0, tc.line)
+			err := cpu.Step(mem)
+			assertPageFault(t, err, tc.cause, tc.addr)
+			assert.ErrorIs(t, err, ErrMisaligned)
+		})
+	}
+}
+
+func TestCPU_TrapDelegation(t *testing.T) {
+	cpu, mem := NewCPU(), NewMemory(512)
+	cpu.Traps = true
+	assert.NoError(t, cpu.WriteCSR(assembler.CSR_MTVEC, 0x100))
+	assert.NoError(t, cpu.WriteCSR(assembler.CSR_STVEC, 0x180))
+	assert.NoError(t, cpu.WriteCSR(assembler.CSR_MEDELEG, 1<<CAUSE_ECALL_U))
+	assert.NoError(t, cpu.WriteCSR(assembler.CSR_SSTATUS, MSTATUS_SIE))
+	writeLines(t, mem, 0, "ecall", "ecall")
+	writeLines(t, mem, 0x180,
+		"csrr x5, scause",
+		"csrr x6, sepc",
+		"addi x6, x6, 4",
+		"csrw sepc, x6",
+		"sret",
+	)
+
+	// a delegated ecall from U-mode goes to S-mode
+	cpu.Priv = PRIV_U
+	assert.NoError(t, cpu.Step(mem))
+	assert.Equal(t, PRIV_S, cpu.Priv)
+	assert.Equal(t, uint32(0x180), cpu.PC)
+	status, _ := cpu.ReadCSR(assembler.CSR_SSTATUS)
+	assert.Equal(t, MSTATUS_SPIE, status&(MSTATUS_SIE|MSTATUS_SPIE|MSTATUS_SPP), "SPP=U, SIE saved in SPIE")
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, cpu.Step(mem))
+	}
+	assert.Equal(t, CAUSE_ECALL_U, cpu.Reg[5])
+	assert.Equal(t, PRIV_U, cpu.Priv, "sret returns to U-mode")
+	assert.Equal(t, uint32(4), cpu.PC)
+	status, _ = cpu.ReadCSR(assembler.CSR_SSTATUS)
+	assert.NotZero(t, status&MSTATUS_SIE, "sret restores SIE")
+
+	// an ecall from S-mode is not delegated
+	cpu.Priv = PRIV_S
+	assert.NoError(t, cpu.Step(mem))
+	assert.Equal(t, PRIV_M, cpu.Priv)
+	assert.Equal(t, uint32(0x100), cpu.PC)
+	mcause, _ := cpu.ReadCSR(assembler.CSR_MCAUSE)
+	assert.Equal(t, CAUSE_ECALL_S, mcause)
+	status, _ = cpu.ReadCSR(assembler.CSR_MSTATUS)
+	assert.Equal(t, PRIV_S<<MSTATUS_MPP_SHIFT, status&MSTATUS_MPP)
+}
+
+func TestCPU_PrivilegedInstructions(t *testing.T) {
+	cases := []struct {
+		asm  string
+		priv uint32
+	}{
+		{"csrr x1, mstatus", PRIV_S},
+		{"csrr x1, sstatus", PRIV_U},
+		{"mret", PRIV_S},
+		{"sret", PRIV_U},
+		{"sfence.vma", PRIV_U},
+	}
+	for _, tc := range cases {
+		cpu, mem := NewCPU(), NewMemory(64)
+		writeLines(t, mem, 0, tc.asm)
+		cpu.Priv = tc.priv
+		var exc *Exception
+		err := cpu.Step(mem)
+		if assert.True(t, errors.As(err, &exc), "%s in %s-mode", tc.asm, privNames[tc.priv]) {
+			assert.Equal(t, CAUSE_ILLEGAL_INSTRUCTION, exc.Cause)
+		}
+		assert.Equal(t, tc.priv, cpu.Priv)
+	}
+
+	// TSR and TVM trap SRET, satp and SFENCE.VMA in S-mode
+	for _, asm := range []string{"sret", "csrr x1, satp", "sfence.vma"} {
+		cpu, mem := NewCPU(), NewMemory(64)
+		cpu.csr.mstatus |= MSTATUS_TSR | MSTATUS_TVM
+		writeLines(t, mem, 0, asm)
+		cpu.Priv = PRIV_S
+		assert.Error(t, cpu.Step(mem), asm)
+	}
+}
+
+func TestCPU_Interrupts(t *testing.T) {
+	cpu, mem := NewCPU(), NewMemory(256)
+	writeLines(t, mem, 0, "addi x1, x1, 1", "addi x1, x1, 1")
+	assert.NoError(t, cpu.WriteCSR(assembler.CSR_MTVEC, trapHandlerAddr|1)) // vectored
+	cpu.SetInterruptPending(MIP_MTIP, true)
+
+	// pending but not enabled
+	assert.NoError(t, cpu.Step(mem))
+	assert.Equal(t, uint32(4), cpu.PC)
+	assert.NoError(t, cpu.WriteCSR(assembler.CSR_MIE, MIP_MTIP))
+	assert.NoError(t, cpu.Step(mem))
+	assert.Equal(t, uint32(8), cpu.PC, "M-mode interrupts need mstatus.MIE")
+
+	assert.NoError(t, cpu.WriteCSR(assembler.CSR_MSTATUS, MSTATUS_MIE))
+	cpu.PC = 4
+	assert.NoError(t, cpu.Step(mem))
+	assert.Equal(t, uint32(trapHandlerAddr+4*IRQ_M_TIMER), cpu.PC, "vectored mode")
+	mcause, _ := cpu.ReadCSR(assembler.CSR_MCAUSE)
+	mepc, _ := cpu.ReadCSR(assembler.CSR_MEPC)
+	assert.Equal(t, CAUSE_INTERRUPT|IRQ_M_TIMER, mcause)
+	assert.Equal(t, uint32(4), mepc, "mepc is the interrupted instruction")
+	assert.Equal(t, uint32(2), cpu.Reg[1])
+}
+
+func TestCPU_InterruptPriority(t *testing.T) {
+	cases := []struct {
+		name    string
+		priv    uint32
+		status  uint32
+		mideleg uint32
+		pending uint32
+		want    uint32
+		ok      bool
+	}{
+		{"external before software before timer", PRIV_M, MSTATUS_MIE, 0, MIP_MEIP | MIP_MSIP | MIP_MTIP, IRQ_M_EXTERNAL, true},
+		{"software before timer", PRIV_M, MSTATUS_MIE, 0, MIP_MSIP | MIP_MTIP, IRQ_M_SOFTWARE, true},
+		{"machine before supervisor", PRIV_S, MSTATUS_SIE, MIP_SUPERVISOR, MIP_SEIP | MIP_MTIP, IRQ_M_TIMER, true},
+		{"machine interrupts are always enabled below M", PRIV_U, 0, 0, MIP_MTIP, IRQ_M_TIMER, true},
+		{"delegated interrupts are masked in M-mode", PRIV_M, MSTATUS_MIE | MSTATUS_SIE, MIP_SUPERVISOR, MIP_STIP, 0, false},
+		{"delegated interrupts need SIE in S-mode", PRIV_S, 0, MIP_SUPERVISOR, MIP_STIP, 0, false},
+		{"delegated interrupts are enabled in U-mode", PRIV_U, 0, MIP_SUPERVISOR, MIP_STIP, IRQ_S_TIMER, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cpu := NewCPU()
+			cpu.Priv = tc.priv
+			cpu.csr.mstatus = tc.status
+			cpu.csr.mideleg = tc.mideleg
+			cpu.csr.mie = 0xFFF
+			cpu.SetInterruptPending(tc.pending, true)
+			cause, ok := cpu.pendingInterrupt()
+			assert.Equal(t, tc.ok, ok)
+			if tc.ok {
+				assert.Equal(t, CAUSE_INTERRUPT|tc.want, cause)
+			}
+		})
+	}
+}
+
+func TestCPU_DelegatedInterrupt(t *testing.T) {
+	cpu, mem := NewCPU(), NewMemory(256)
+	assert.NoError(t, cpu.WriteCSR(assembler.CSR_STVEC, 0x40))
+	assert.NoError(t, cpu.WriteCSR(assembler.CSR_MIDELEG, MIP_STIP))
+	assert.NoError(t, cpu.WriteCSR(assembler.CSR_MIE, MIP_STIP))
+	// M-mode forwards a timer interrupt to S-mode by raising STIP in mip
+	assert.NoError(t, cpu.WriteCSR(assembler.CSR_MIP, MIP_STIP))
+	cpu.Priv = PRIV_U
+	cpu.PC = 0x10
+	assert.NoError(t, cpu.Step(mem))
+	assert.Equal(t, PRIV_S, cpu.Priv)
+	assert.Equal(t, uint32(0x40), cpu.PC)
+	scause, _ := cpu.ReadCSR(assembler.CSR_SCAUSE)
+	sepc, _ := cpu.ReadCSR(assembler.CSR_SEPC)
+	assert.Equal(t, CAUSE_INTERRUPT|IRQ_S_TIMER, scause)
+	assert.Equal(t, uint32(0x10), sepc)
+}
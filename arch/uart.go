@@ -0,0 +1,251 @@
+package arch
+
+import (
+	"io"
+	"sync"
+)
+
+// Default placement of the UART (as on QEMU's virt machine).
+const (
+	UART_BASE uint32 = 0x10000000
+	UART_SIZE uint32 = 0x100
+	UART_IRQ  uint32 = 10 // PLIC source
+)
+
+// 16550 register offsets. With DLAB set in LCR, offsets 0 and 1 address the divisor latch.
+const (
+	UART_RBR uint32 = 0 // receive buffer (read)
+	UART_THR uint32 = 0 // transmit holding (write)
+	UART_IER uint32 = 1 // interrupt enable
+	UART_IIR uint32 = 2 // interrupt identification (read)
+	UART_FCR uint32 = 2 // FIFO control (write)
+	UART_LCR uint32 = 3 // line control
+	UART_MCR uint32 = 4 // modem control
+	UART_LSR uint32 = 5 // line status
+	UART_MSR uint32 = 6 // modem status
+	UART_SCR uint32 = 7 // scratch
+)
+
+// Register bits
+const (
+	UART_IER_RDA  uint8 = 1 << 0 // received data available interrupt
+	UART_IER_THRE uint8 = 1 << 1 // transmit holding register empty interrupt
+
+	UART_IIR_NONE  uint8 = 0x01
+	UART_IIR_THRE  uint8 = 0x02
+	UART_IIR_RDA   uint8 = 0x04
+	UART_IIR_FIFOS uint8 = 0xC0 // FIFOs enabled
+
+	UART_FCR_ENABLE   uint8 = 1 << 0
+	UART_FCR_CLEAR_RX uint8 = 1 << 1
+
+	UART_LCR_DLAB uint8 = 1 << 7
+
+	UART_LSR_DR   uint8 = 1 << 0 // data ready
+	UART_LSR_THRE uint8 = 1 << 5 // transmit holding register empty
+	UART_LSR_TEMT uint8 = 1 << 6 // transmitter empty
+
+	// CTS, DSR and DCD asserted: a terminal is always connected
+	uartMSR uint8 = 0xB0
+)
+
+// UART is a 16550-compatible serial port. Transmitted bytes are written to the
+// output immediately, so the transmitter is always empty; received bytes come
+// from an input reader (read in the background) or from Receive. An optional
+// interrupt line signals received data and an empty transmitter.
+type UART struct {
+	out io.Writer
+	irq InterruptLine
+
+	mu    sync.Mutex
+	input []byte // received by the background reader, not yet moved to rx
+
+	rx          []byte
+	ier         uint8
+	lcr         uint8
+	mcr         uint8
+	scr         uint8
+	fcr         uint8
+	dll, dlm    uint8
+	threPending bool
+}
+
+// NewUART creates a UART writing to out. If in is not nil, it is read in the
+// background and its bytes are received in order.
+func NewUART(out io.Writer, in io.Reader) *UART {
+	u := &UART{out: out}
+	if in != nil {
+		go u.readFrom(in)
+	}
+	return u
+}
+
+func (u *UART) readFrom(in io.Reader) {
+	buf := make([]byte, 256)
+	for {
+		n, err := in.Read(buf)
+		if n > 0 {
+			u.Receive(buf[:n])
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// SetInterruptLine connects the UART to an interrupt controller.
+func (u *UART) SetInterruptLine(irq InterruptLine) {
+	u.irq = irq
+	u.Update()
+}
+
+// Receive queues data as if it had arrived on the serial line. It may be called
+// from any goroutine; the data becomes visible to the guest with the next Update
+// or register access.
+func (u *UART) Receive(data []byte) {
+	u.mu.Lock()
+	u.input = append(u.input, data...)
+	u.mu.Unlock()
+}
+
+// Update moves received data into the receive buffer and updates the interrupt
+// line. The machine calls it before every step.
+func (u *UART) Update() {
+	u.mu.Lock()
+	u.rx = append(u.rx, u.input...)
+	u.input = u.input[:0]
+	u.mu.Unlock()
+	if u.irq != nil {
+		u.irq(u.interrupt() != UART_IIR_NONE)
+	}
+}
+
+// Reset restores the registers and drops any received data.
+func (u *UART) Reset() {
+	u.mu.Lock()
+	u.input = nil
+	u.mu.Unlock()
+	u.rx = nil
+	u.ier, u.lcr, u.mcr, u.scr, u.fcr, u.dll, u.dlm = 0, 0, 0, 0, 0, 0, 0
+	u.threPending = false
+	u.Update()
+}
+
+// interrupt returns the highest-priority pending interrupt as an IIR value.
+func (u *UART) interrupt() uint8 {
+	switch {
+	case u.ier&UART_IER_RDA != 0 && len(u.rx) > 0:
+		return UART_IIR_RDA
+	case u.ier&UART_IER_THRE != 0 && u.threPending:
+		return UART_IIR_THRE
+	}
+	return UART_IIR_NONE
+}
+
+func (u *UART) ReadByteAt(offset uint32) (uint8, error) {
+	u.Update()
+	defer u.Update()
+	dlab := u.lcr&UART_LCR_DLAB != 0
+	switch offset {
+	case UART_RBR:
+		if dlab {
+			return u.dll, nil
+		}
+		if len(u.rx) == 0 {
+			return 0, nil
+		}
+		b := u.rx[0]
+		u.rx = u.rx[1:]
+		return b, nil
+	case UART_IER:
+		if dlab {
+			return u.dlm, nil
+		}
+		return u.ier, nil
+	case UART_IIR:
+		iir := u.interrupt()
+		if iir == UART_IIR_THRE {
+			u.threPending = false // reading IIR acknowledges THRE
+		}
+		if u.fcr&UART_FCR_ENABLE != 0 {
+			iir |= UART_IIR_FIFOS
+		}
+		return iir, nil
+	case UART_LCR:
+		return u.lcr, nil
+	case UART_MCR:
+		return u.mcr, nil
+	case UART_LSR:
+		lsr := UART_LSR_THRE | UART_LSR_TEMT
+		if len(u.rx) > 0 {
+			lsr |= UART_LSR_DR
+		}
+		return lsr, nil
+	case UART_MSR:
+		return uartMSR, nil
+	case UART_SCR:
+		return u.scr, nil
+	}
+	return 0, nil // unused registers read as zero
+}
+
+func (u *UART) WriteByteAt(offset uint32, value uint8) error {
+	u.Update()
+	defer u.Update()
+	dlab := u.lcr&UART_LCR_DLAB != 0
+	switch offset {
+	case UART_THR:
+		if dlab {
+			u.dll = value
+			return nil
+		}
+		u.threPending = true
+		_, err := u.out.Write([]byte{value})
+		return err
+	case UART_IER:
+		if dlab {
+			u.dlm = value
+			return nil
+		}
+		if value&UART_IER_THRE != 0 && u.ier&UART_IER_THRE == 0 {
+			u.threPending = true // enabling THRE with an empty transmitter interrupts at once
+		}
+		u.ier = value & (UART_IER_RDA | UART_IER_THRE)
+	case UART_FCR:
+		u.fcr = value & UART_FCR_ENABLE
+		if value&UART_FCR_CLEAR_RX != 0 {
+			u.rx = nil
+		}
+	case UART_LCR:
+		u.lcr = value
+	case UART_MCR:
+		u.mcr = value
+	case UART_SCR:
+		u.scr = value
+	}
+	return nil // writes to read-only registers are ignored
+}
+
+// The UART's registers are bytes; wider accesses address the register at their
+// offset and use the low byte.
+
+func (u *UART) ReadHalf(offset uint32) (uint16, error) {
+	b, err := u.ReadByteAt(offset)
+	return uint16(b), err
+}
+
+func (u *UART) ReadWord(offset uint32) (uint32, error) {
+	b, err := u.ReadByteAt(offset)
+	return uint32(b), err
+}
+
+func (u *UART) WriteHalf(offset uint32, value uint16) error {
+	return u.WriteByteAt(offset, uint8(value))
+}
+
+func (u *UART) WriteWord(offset uint32, value uint32) error {
+	return u.WriteByteAt(offset, uint8(value))
+}
+
+var _ SubWordHandler = (*UART)(nil)
+var _ WordHandler = (*UART)(nil)
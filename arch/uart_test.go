@@ -0,0 +1,101 @@
+package arch
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func uartRead(t *testing.T, u *UART, offset uint32) uint8 {
+	t.Helper()
+	v, err := u.ReadByteAt(offset)
+	assert.NoError(t, err)
+	return v
+}
+
+func TestUART_Transmit(t *testing.T) {
+	var out bytes.Buffer
+	u := NewUART(&out, nil)
+	assert.NoError(t, u.WriteByteAt(UART_THR, 'h'))
+	assert.NoError(t, u.WriteWord(UART_THR, 'i'), "word access uses the low byte")
+	assert.Equal(t, "hi", out.String())
+	assert.Equal(t, UART_LSR_THRE|UART_LSR_TEMT, uartRead(t, u, UART_LSR))
+}
+
+func TestUART_Receive(t *testing.T) {
+	u := NewUART(&bytes.Buffer{}, nil)
+	assert.Zero(t, uartRead(t, u, UART_LSR)&UART_LSR_DR)
+	assert.Zero(t, uartRead(t, u, UART_RBR), "empty receiver reads as zero")
+
+	u.Receive([]byte("ab"))
+	assert.Equal(t, UART_LSR_DR, uartRead(t, u, UART_LSR)&UART_LSR_DR)
+	assert.Equal(t, uint8('a'), uartRead(t, u, UART_RBR))
+	assert.Equal(t, uint8('b'), uartRead(t, u, UART_RBR))
+	assert.Zero(t, uartRead(t, u, UART_LSR)&UART_LSR_DR)
+
+	u.Receive([]byte("c"))
+	assert.NoError(t, u.WriteByteAt(UART_FCR, UART_FCR_ENABLE|UART_FCR_CLEAR_RX))
+	assert.Zero(t, uartRead(t, u, UART_LSR)&UART_LSR_DR, "FCR clears the receiver")
+}
+
+func TestUART_ReceiveFromReader(t *testing.T) {
+	u := NewUART(&bytes.Buffer{}, strings.NewReader("xyz"))
+	var got []byte
+	assert.Eventually(t, func() bool {
+		if uartRead(t, u, UART_LSR)&UART_LSR_DR != 0 {
+			got = append(got, uartRead(t, u, UART_RBR))
+		}
+		return len(got) == 3
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, "xyz", string(got))
+}
+
+func TestUART_Registers(t *testing.T) {
+	u := NewUART(&bytes.Buffer{}, nil)
+	assert.NoError(t, u.WriteByteAt(UART_SCR, 0x5A))
+	assert.Equal(t, uint8(0x5A), uartRead(t, u, UART_SCR))
+
+	// divisor latch
+	assert.NoError(t, u.WriteByteAt(UART_LCR, UART_LCR_DLAB|0x03))
+	assert.NoError(t, u.WriteByteAt(UART_THR, 0x01))
+	assert.NoError(t, u.WriteByteAt(UART_IER, 0x02))
+	assert.Equal(t, uint8(0x01), uartRead(t, u, UART_RBR))
+	assert.Equal(t, uint8(0x02), uartRead(t, u, UART_IER))
+	assert.NoError(t, u.WriteByteAt(UART_LCR, 0x03))
+	assert.Zero(t, uartRead(t, u, UART_IER), "DLM does not alias IER")
+
+	assert.Equal(t, UART_IIR_NONE, uartRead(t, u, UART_IIR))
+	assert.NoError(t, u.WriteByteAt(UART_FCR, UART_FCR_ENABLE))
+	assert.Equal(t, UART_IIR_FIFOS|UART_IIR_NONE, uartRead(t, u, UART_IIR))
+
+	u.Receive([]byte("q"))
+	u.Reset()
+	assert.Zero(t, uartRead(t, u, UART_SCR))
+	assert.Zero(t, uartRead(t, u, UART_LSR)&UART_LSR_DR, "Reset drops received data")
+}
+
+func TestUART_Interrupts(t *testing.T) {
+	var level bool
+	u := NewUART(&bytes.Buffer{}, nil)
+	u.SetInterruptLine(func(l bool) { level = l })
+
+	u.Receive([]byte("a"))
+	u.Update()
+	assert.False(t, level, "receive interrupt disabled")
+
+	assert.NoError(t, u.WriteByteAt(UART_IER, UART_IER_RDA))
+	assert.True(t, level)
+	assert.Equal(t, UART_IIR_RDA, uartRead(t, u, UART_IIR))
+	uartRead(t, u, UART_RBR)
+	assert.False(t, level, "reading the data lowers the line")
+
+	assert.NoError(t, u.WriteByteAt(UART_IER, UART_IER_RDA|UART_IER_THRE))
+	assert.True(t, level, "enabling THRE interrupts with an empty transmitter")
+	assert.Equal(t, UART_IIR_THRE, uartRead(t, u, UART_IIR))
+	assert.False(t, level, "reading IIR acknowledges THRE")
+	assert.NoError(t, u.WriteByteAt(UART_THR, 'x'))
+	assert.True(t, level, "transmitting empties the THR again")
+}
@@ -0,0 +1,587 @@
+package assembler
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// COMPRESSED_SIZE is the size in bytes of an RVC instruction.
+const COMPRESSED_SIZE = 2
+
+// IsCompressed reports whether the low halfword of an instruction stream starts a
+// 16-bit RVC instruction. 32-bit instructions always have both low bits set.
+func IsCompressed(half uint16) bool {
+	return half&0x3 != 0x3
+}
+
+// Size returns the length in bytes of the instruction: COMPRESSED_SIZE for a
+// 16-bit RVC instruction and INSTRUCTION_SIZE otherwise.
+func (i Instruction) Size() int {
+	if IsCompressed(uint16(i)) {
+		return COMPRESSED_SIZE
+	}
+	return INSTRUCTION_SIZE
+}
+
+// bits extracts bits hi..lo (inclusive) of v.
+func bits(v uint32, hi, lo uint) uint32 {
+	return (v >> lo) & ((1 << (hi - lo + 1)) - 1)
+}
+
+// signExtend sign-extends the lowest n bits of v.
+func signExtend(v uint32, n uint) int32 {
+	shift := 32 - n
+	return int32(v<<shift) >> shift
+}
+
+func newRType(funct3, funct7, rd, rs1, rs2 uint32) Instruction {
+	var instr Instruction
+	instr.SetOpcode(OPCODE_R_TYPE)
+	instr.SetRd(rd)
+	instr.SetRs1(rs1)
+	instr.SetRs2(rs2)
+	instr.SetFunct3(funct3)
+	instr.SetFunct7(funct7)
+	return instr
+}
+
+func newIType(opcode Opcode, funct3, rd, rs1 uint32, imm int32) Instruction {
+	var instr Instruction
+	instr.SetOpcode(opcode)
+	instr.SetRd(rd)
+	instr.SetRs1(rs1)
+	instr.SetFunct3(funct3)
+	instr.SetImmI(imm)
+	return instr
+}
+
+func newSType(funct3, rs1, rs2 uint32, imm int32) Instruction {
+	var instr Instruction
+	instr.SetOpcode(OPCODE_STORE)
+	instr.SetRs1(rs1)
+	instr.SetRs2(rs2)
+	instr.SetFunct3(funct3)
+	instr.SetImmS(imm)
+	return instr
+}
+
+func newFPSType(funct3, rs1, rs2 uint32, imm int32) Instruction {
+	instr := newSType(funct3, rs1, rs2, imm)
+	instr.SetOpcode(OPCODE_STORE_FP)
+	return instr
+}
+
+func newBType(funct3, rs1, rs2 uint32, imm int32) Instruction {
+	var instr Instruction
+	instr.SetOpcode(OPCODE_BRANCH)
+	instr.SetRs1(rs1)
+	instr.SetRs2(rs2)
+	instr.SetFunct3(funct3)
+	instr.SetImmB(imm)
+	return instr
+}
+
+func newJType(rd uint32, imm int32) Instruction {
+	var instr Instruction
+	instr.SetOpcode(OPCODE_JAL)
+	instr.SetRd(rd)
+	instr.SetImmJ(imm)
+	return instr
+}
+
+// cjOffset decodes the jump offset of C.J/C.JAL: offset[11|4|9:8|10|6|7|3:1|5] in bits 12-2.
+func cjOffset(v uint32) int32 {
+	imm := bits(v, 12, 12)<<11 | bits(v, 11, 11)<<4 | bits(v, 10, 9)<<8 | bits(v, 8, 8)<<10 |
+		bits(v, 7, 7)<<6 | bits(v, 6, 6)<<7 | bits(v, 5, 3)<<1 | bits(v, 2, 2)<<5
+	return signExtend(imm, 12)
+}
+
+func cjScatter(imm int32) uint32 {
+	u := uint32(imm)
+	return bits(u, 11, 11)<<12 | bits(u, 4, 4)<<11 | bits(u, 9, 8)<<9 | bits(u, 10, 10)<<8 |
+		bits(u, 6, 6)<<7 | bits(u, 7, 7)<<6 | bits(u, 3, 1)<<3 | bits(u, 5, 5)<<2
+}
+
+// cbOffset decodes the branch offset of C.BEQZ/C.BNEZ: offset[8|4:3] in bits 12-10, offset[7:6|2:1|5] in bits 6-2.
+func cbOffset(v uint32) int32 {
+	imm := bits(v, 12, 12)<<8 | bits(v, 11, 10)<<3 | bits(v, 6, 5)<<6 | bits(v, 4, 3)<<1 | bits(v, 2, 2)<<5
+	return signExtend(imm, 9)
+}
+
+func cbScatter(imm int32) uint32 {
+	u := uint32(imm)
+	return bits(u, 8, 8)<<12 | bits(u, 4, 3)<<10 | bits(u, 7, 6)<<5 | bits(u, 2, 1)<<3 | bits(u, 5, 5)<<2
+}
+
+// ciImm decodes the 6-bit signed immediate of the CI format: imm[5] in bit 12, imm[4:0] in bits 6-2.
+func ciImm(v uint32) int32 {
+	return signExtend(bits(v, 12, 12)<<5|bits(v, 6, 2), 6)
+}
+
+func ciScatter(imm int32) uint32 {
+	u := uint32(imm)
+	return bits(u, 5, 5)<<12 | bits(u, 4, 0)<<2
+}
+
+// clWordOffset decodes the offset of the word-sized CL/CS loads and stores: offset[5:3] in bits 12-10, offset[2|6] in bits 6-5.
+func clWordOffset(v uint32) uint32 {
+	return bits(v, 12, 10)<<3 | bits(v, 6, 6)<<2 | bits(v, 5, 5)<<6
+}
+
+func clWordScatter(offset uint32) uint32 {
+	return bits(offset, 5, 3)<<10 | bits(offset, 2, 2)<<6 | bits(offset, 6, 6)<<5
+}
+
+// clDoubleOffset decodes the offset of C.FLD/C.FSD: offset[5:3] in bits 12-10, offset[7:6] in bits 6-5.
+func clDoubleOffset(v uint32) uint32 {
+	return bits(v, 12, 10)<<3 | bits(v, 6, 5)<<6
+}
+
+func clDoubleScatter(offset uint32) uint32 {
+	return bits(offset, 5, 3)<<10 | bits(offset, 7, 6)<<5
+}
+
+// Expand converts a 16-bit RVC instruction into its 32-bit RV32 equivalent.
+func Expand(half uint16) (Instruction, error) {
+	v := uint32(half)
+	funct3 := bits(v, 15, 13)
+	rd := bits(v, 11, 7)      // rd/rs1 of the CR and CI formats
+	rs2 := bits(v, 6, 2)      // rs2 of the CR and CSS formats
+	rdc := 8 + bits(v, 4, 2)  // rd'/rs2' of the CIW, CL, CS and CA formats
+	rs1c := 8 + bits(v, 9, 7) // rs1'/rd' of the CL, CS, CA and CB formats
+	bit12 := bits(v, 12, 12)
+
+	switch v & 0x3 {
+	case 0x0:
+		switch funct3 {
+		case 0x0: // C.ADDI4SPN
+			imm := bits(v, 12, 11)<<4 | bits(v, 10, 7)<<6 | bits(v, 6, 6)<<2 | bits(v, 5, 5)<<3
+			if imm != 0 {
+				return newIType(OPCODE_I_TYPE, FUNCT3_ADDI, rdc, 2, int32(imm)), nil
+			}
+		case 0x1: // C.FLD
+			return newIType(OPCODE_LOAD_FP, FUNCT3_FLD, rdc, rs1c, int32(clDoubleOffset(v))), nil
+		case 0x2: // C.LW
+			imm := bits(v, 12, 10)<<3 | bits(v, 6, 6)<<2 | bits(v, 5, 5)<<6
+			return newIType(OPCODE_LOAD, FUNCT3_LW, rdc, rs1c, int32(imm)), nil
+		case 0x3: // C.FLW
+			return newIType(OPCODE_LOAD_FP, FUNCT3_FLW, rdc, rs1c, int32(clWordOffset(v))), nil
+		case 0x5: // C.FSD
+			return newFPSType(FUNCT3_FSD, rs1c, rdc, int32(clDoubleOffset(v))), nil
+		case 0x6: // C.SW
+			imm := bits(v, 12, 10)<<3 | bits(v, 6, 6)<<2 | bits(v, 5, 5)<<6
+			return newSType(FUNCT3_SW, rs1c, rdc, int32(imm)), nil
+		case 0x7: // C.FSW
+			return newFPSType(FUNCT3_FSW, rs1c, rdc, int32(clWordOffset(v))), nil
+		}
+	case 0x1:
+		switch funct3 {
+		case 0x0: // C.ADDI, C.NOP
+			return newIType(OPCODE_I_TYPE, FUNCT3_ADDI, rd, rd, ciImm(v)), nil
+		case 0x1: // C.JAL
+			return newJType(1, cjOffset(v)), nil
+		case 0x2: // C.LI
+			return newIType(OPCODE_I_TYPE, FUNCT3_ADDI, rd, 0, ciImm(v)), nil
+		case 0x3:
+			if rd == 2 { // C.ADDI16SP
+				imm := signExtend(bit12<<9|bits(v, 6, 6)<<4|bits(v, 5, 5)<<6|bits(v, 4, 3)<<7|bits(v, 2, 2)<<5, 10)
+				if imm != 0 {
+					return newIType(OPCODE_I_TYPE, FUNCT3_ADDI, 2, 2, imm), nil
+				}
+			} else if imm := ciImm(v); imm != 0 { // C.LUI
+				var instr Instruction
+				instr.SetOpcode(OPCODE_LUI)
+				instr.SetRd(rd)
+				instr.SetImmU(imm)
+				return instr, nil
+			}
+		case 0x4:
+			switch bits(v, 11, 10) {
+			case 0x0: // C.SRLI
+				if bit12 == 0 {
+					return newShiftImm(FUNCT3_SRLI_SRAI, FUNCT7_SRL, rs1c, rs2), nil
+				}
+			case 0x1: // C.SRAI
+				if bit12 == 0 {
+					return newShiftImm(FUNCT3_SRLI_SRAI, FUNCT7_SRA, rs1c, rs2), nil
+				}
+			case 0x2: // C.ANDI
+				return newIType(OPCODE_I_TYPE, FUNCT3_ANDI, rs1c, rs1c, ciImm(v)), nil
+			case 0x3:
+				if bit12 == 0 {
+					switch bits(v, 6, 5) {
+					case 0x0: // C.SUB
+						return newRType(FUNCT3_ADD_SUB, FUNCT7_SUB, rs1c, rs1c, rdc), nil
+					case 0x1: // C.XOR
+						return newRType(FUNCT3_XOR, 0, rs1c, rs1c, rdc), nil
+					case 0x2: // C.OR
+						return newRType(FUNCT3_OR, 0, rs1c, rs1c, rdc), nil
+					case 0x3: // C.AND
+						return newRType(FUNCT3_AND, 0, rs1c, rs1c, rdc), nil
+					}
+				}
+			}
+		case 0x5: // C.J
+			return newJType(0, cjOffset(v)), nil
+		case 0x6: // C.BEQZ
+			return newBType(FUNCT3_BEQ, rs1c, 0, cbOffset(v)), nil
+		case 0x7: // C.BNEZ
+			return newBType(FUNCT3_BNE, rs1c, 0, cbOffset(v)), nil
+		}
+	case 0x2:
+		switch funct3 {
+		case 0x0: // C.SLLI
+			if bit12 == 0 {
+				return newShiftImm(FUNCT3_SLLI, 0, rd, rs2), nil
+			}
+		case 0x1: // C.FLDSP
+			imm := bit12<<5 | bits(v, 6, 5)<<3 | bits(v, 4, 2)<<6
+			return newIType(OPCODE_LOAD_FP, FUNCT3_FLD, rd, 2, int32(imm)), nil
+		case 0x3: // C.FLWSP
+			imm := bit12<<5 | bits(v, 6, 4)<<2 | bits(v, 3, 2)<<6
+			return newIType(OPCODE_LOAD_FP, FUNCT3_FLW, rd, 2, int32(imm)), nil
+		case 0x5: // C.FSDSP
+			imm := bits(v, 12, 10)<<3 | bits(v, 9, 7)<<6
+			return newFPSType(FUNCT3_FSD, 2, rs2, int32(imm)), nil
+		case 0x7: // C.FSWSP
+			imm := bits(v, 12, 9)<<2 | bits(v, 8, 7)<<6
+			return newFPSType(FUNCT3_FSW, 2, rs2, int32(imm)), nil
+		case 0x2: // C.LWSP
+			if rd != 0 {
+				imm := bit12<<5 | bits(v, 6, 4)<<2 | bits(v, 3, 2)<<6
+				return newIType(OPCODE_LOAD, FUNCT3_LW, rd, 2, int32(imm)), nil
+			}
+		case 0x4:
+			switch {
+			case bit12 == 0 && rs2 == 0: // C.JR
+				if rd != 0 {
+					return newIType(OPCODE_JALR, FUNCT3_JALR, 0, rd, 0), nil
+				}
+			case bit12 == 0: // C.MV
+				return newRType(FUNCT3_ADD_SUB, FUNCT7_ADD, rd, 0, rs2), nil
+			case rd == 0 && rs2 == 0: // C.EBREAK
+				return newIType(OPCODE_SYSTEM, FUNCT3_PRIV, 0, 0, int32(FUNCT12_EBREAK)), nil
+			case rs2 == 0: // C.JALR
+				return newIType(OPCODE_JALR, FUNCT3_JALR, 1, rd, 0), nil
+			default: // C.ADD
+				return newRType(FUNCT3_ADD_SUB, FUNCT7_ADD, rd, rd, rs2), nil
+			}
+		case 0x6: // C.SWSP
+			imm := bits(v, 12, 9)<<2 | bits(v, 8, 7)<<6
+			return newSType(FUNCT3_SW, 2, rs2, int32(imm)), nil
+		}
+	}
+	return 0, fmt.Errorf("illegal compressed instruction: 0x%04X", half)
+}
+
+func newShiftImm(funct3, funct7, rd, shamt uint32) Instruction {
+	instr := newIType(OPCODE_I_TYPE, funct3, rd, rd, int32(shamt))
+	instr.SetFunct7(funct7)
+	return instr
+}
+
+// compressedForm describes one RVC mnemonic: template rewrites its operands into
+// the equivalent 32-bit instruction ($1, $2 refer to the original operands) and
+// encode produces the 16-bit encoding of such an instruction, if it fits this form.
+type compressedForm struct {
+	mnemonic string
+	template string
+	encode   func(i Instruction) (uint16, bool)
+}
+
+// isCompactReg reports whether r is one of x8-x15, the registers addressable by the 3-bit RVC register fields.
+func isCompactReg(r uint32) bool {
+	return r >= 8 && r <= 15
+}
+
+func fitsSigned(imm int32, n uint) bool {
+	return imm >= -(1<<(n-1)) && imm < 1<<(n-1)
+}
+
+func isAddi(i Instruction) bool {
+	return i.Opcode() == OPCODE_I_TYPE && i.Funct3() == FUNCT3_ADDI
+}
+
+func isRType(i Instruction, funct3, funct7 uint32) bool {
+	return i.Opcode() == OPCODE_R_TYPE && i.Funct3() == funct3 && i.Funct7() == funct7
+}
+
+func encodeCA(i Instruction, funct3, funct7, bits6to5 uint32) (uint16, bool) {
+	if !isRType(i, funct3, funct7) || i.Rd() != i.Rs1() || !isCompactReg(i.Rd()) || !isCompactReg(i.Rs2()) {
+		return 0, false
+	}
+	return uint16(0x8C01 | (i.Rd()-8)<<7 | bits6to5<<5 | (i.Rs2()-8)<<2), true
+}
+
+func encodeCBShift(i Instruction, funct3, funct7, bits11to10 uint32) (uint16, bool) {
+	shamt := uint32(i.ImmI()) & 0x1F
+	if i.Opcode() != OPCODE_I_TYPE || i.Funct3() != funct3 || i.Funct7() != funct7 ||
+		i.Rd() != i.Rs1() || !isCompactReg(i.Rd()) || shamt == 0 {
+		return 0, false
+	}
+	return uint16(0x8001 | bits11to10<<10 | (i.Rd()-8)<<7 | shamt<<2), true
+}
+
+func encodeCBBranch(i Instruction, funct3, cfunct3 uint32) (uint16, bool) {
+	imm := i.ImmB()
+	if i.Opcode() != OPCODE_BRANCH || i.Funct3() != funct3 || i.Rs2() != 0 || !isCompactReg(i.Rs1()) || !fitsSigned(imm, 9) {
+		return 0, false
+	}
+	return uint16(cfunct3<<13 | (i.Rs1()-8)<<7 | cbScatter(imm) | 0x1), true
+}
+
+func encodeCJ(i Instruction, rd, cfunct3 uint32) (uint16, bool) {
+	imm := i.ImmJ()
+	if i.Opcode() != OPCODE_JAL || i.Rd() != rd || !fitsSigned(imm, 12) {
+		return 0, false
+	}
+	return uint16(cfunct3<<13 | cjScatter(imm) | 0x1), true
+}
+
+// compressedForms lists all RVC forms in the order they are tried by Compress.
+var compressedForms = []compressedForm{
+	{"c.nop", "addi x0, x0, 0", func(i Instruction) (uint16, bool) {
+		return 0x0001, i == newIType(OPCODE_I_TYPE, FUNCT3_ADDI, 0, 0, 0)
+	}},
+	{"c.addi", "addi $1, $1, $2", func(i Instruction) (uint16, bool) {
+		if !isAddi(i) || i.Rd() != i.Rs1() || i.Rd() == 0 || i.ImmI() == 0 || !fitsSigned(i.ImmI(), 6) {
+			return 0, false
+		}
+		return uint16(0x0001 | i.Rd()<<7 | ciScatter(i.ImmI())), true
+	}},
+	{"c.li", "addi $1, x0, $2", func(i Instruction) (uint16, bool) {
+		if !isAddi(i) || i.Rs1() != 0 || i.Rd() == 0 || !fitsSigned(i.ImmI(), 6) {
+			return 0, false
+		}
+		return uint16(0x4001 | i.Rd()<<7 | ciScatter(i.ImmI())), true
+	}},
+	{"c.addi16sp", "addi $1, $1, $2", func(i Instruction) (uint16, bool) {
+		imm := i.ImmI()
+		if !isAddi(i) || i.Rd() != 2 || i.Rs1() != 2 || imm == 0 || imm%16 != 0 || !fitsSigned(imm, 10) {
+			return 0, false
+		}
+		u := uint32(imm)
+		return uint16(0x6101 | bits(u, 9, 9)<<12 | bits(u, 4, 4)<<6 | bits(u, 6, 6)<<5 | bits(u, 8, 7)<<3 | bits(u, 5, 5)<<2), true
+	}},
+	{"c.addi4spn", "addi $1, $2, $3", func(i Instruction) (uint16, bool) {
+		imm := i.ImmI()
+		if !isAddi(i) || i.Rs1() != 2 || !isCompactReg(i.Rd()) || imm <= 0 || imm%4 != 0 || imm >= 1024 {
+			return 0, false
+		}
+		u := uint32(imm)
+		return uint16(bits(u, 5, 4)<<11 | bits(u, 9, 6)<<7 | bits(u, 2, 2)<<6 | bits(u, 3, 3)<<5 | (i.Rd()-8)<<2), true
+	}},
+	{"c.lui", "lui $1, $2", func(i Instruction) (uint16, bool) {
+		imm := signExtend(uint32(i.ImmU()), 20)
+		if i.Opcode() != OPCODE_LUI || i.Rd() == 0 || i.Rd() == 2 || imm == 0 || !fitsSigned(imm, 6) {
+			return 0, false
+		}
+		return uint16(0x6001 | i.Rd()<<7 | ciScatter(imm)), true
+	}},
+	{"c.lw", "lw $1, $2", func(i Instruction) (uint16, bool) {
+		imm := i.ImmI()
+		if i.Opcode() != OPCODE_LOAD || i.Funct3() != FUNCT3_LW || !isCompactReg(i.Rd()) || !isCompactReg(i.Rs1()) ||
+			imm < 0 || imm > 124 || imm%4 != 0 {
+			return 0, false
+		}
+		u := uint32(imm)
+		return uint16(0x4000 | bits(u, 5, 3)<<10 | (i.Rs1()-8)<<7 | bits(u, 2, 2)<<6 | bits(u, 6, 6)<<5 | (i.Rd()-8)<<2), true
+	}},
+	{"c.sw", "sw $1, $2", func(i Instruction) (uint16, bool) {
+		imm := i.ImmS()
+		if i.Opcode() != OPCODE_STORE || i.Funct3() != FUNCT3_SW || !isCompactReg(i.Rs2()) || !isCompactReg(i.Rs1()) ||
+			imm < 0 || imm > 124 || imm%4 != 0 {
+			return 0, false
+		}
+		u := uint32(imm)
+		return uint16(0xC000 | bits(u, 5, 3)<<10 | (i.Rs1()-8)<<7 | bits(u, 2, 2)<<6 | bits(u, 6, 6)<<5 | (i.Rs2()-8)<<2), true
+	}},
+	{"c.lwsp", "lw $1, $2", func(i Instruction) (uint16, bool) {
+		imm := i.ImmI()
+		if i.Opcode() != OPCODE_LOAD || i.Funct3() != FUNCT3_LW || i.Rd() == 0 || i.Rs1() != 2 ||
+			imm < 0 || imm > 252 || imm%4 != 0 {
+			return 0, false
+		}
+		u := uint32(imm)
+		return uint16(0x4002 | bits(u, 5, 5)<<12 | i.Rd()<<7 | bits(u, 4, 2)<<4 | bits(u, 7, 6)<<2), true
+	}},
+	{"c.swsp", "sw $1, $2", func(i Instruction) (uint16, bool) {
+		imm := i.ImmS()
+		if i.Opcode() != OPCODE_STORE || i.Funct3() != FUNCT3_SW || i.Rs1() != 2 || imm < 0 || imm > 252 || imm%4 != 0 {
+			return 0, false
+		}
+		u := uint32(imm)
+		return uint16(0xC002 | bits(u, 5, 2)<<9 | bits(u, 7, 6)<<7 | i.Rs2()<<2), true
+	}},
+	{"c.srli", "srli $1, $1, $2", func(i Instruction) (uint16, bool) {
+		return encodeCBShift(i, FUNCT3_SRLI_SRAI, FUNCT7_SRL, 0x0)
+	}},
+	{"c.srai", "srai $1, $1, $2", func(i Instruction) (uint16, bool) {
+		return encodeCBShift(i, FUNCT3_SRLI_SRAI, FUNCT7_SRA, 0x1)
+	}},
+	{"c.andi", "andi $1, $1, $2", func(i Instruction) (uint16, bool) {
+		if i.Opcode() != OPCODE_I_TYPE || i.Funct3() != FUNCT3_ANDI || i.Rd() != i.Rs1() || !isCompactReg(i.Rd()) || !fitsSigned(i.ImmI(), 6) {
+			return 0, false
+		}
+		return uint16(0x8801 | (i.Rd()-8)<<7 | ciScatter(i.ImmI())), true
+	}},
+	{"c.slli", "slli $1, $1, $2", func(i Instruction) (uint16, bool) {
+		shamt := uint32(i.ImmI()) & 0x1F
+		if i.Opcode() != OPCODE_I_TYPE || i.Funct3() != FUNCT3_SLLI || i.Funct7() != 0 || i.Rd() != i.Rs1() || i.Rd() == 0 || shamt == 0 {
+			return 0, false
+		}
+		return uint16(0x0002 | i.Rd()<<7 | shamt<<2), true
+	}},
+	{"c.sub", "sub $1, $1, $2", func(i Instruction) (uint16, bool) { return encodeCA(i, FUNCT3_ADD_SUB, FUNCT7_SUB, 0x0) }},
+	{"c.xor", "xor $1, $1, $2", func(i Instruction) (uint16, bool) { return encodeCA(i, FUNCT3_XOR, 0, 0x1) }},
+	{"c.or", "or $1, $1, $2", func(i Instruction) (uint16, bool) { return encodeCA(i, FUNCT3_OR, 0, 0x2) }},
+	{"c.and", "and $1, $1, $2", func(i Instruction) (uint16, bool) { return encodeCA(i, FUNCT3_AND, 0, 0x3) }},
+	{"c.mv", "add $1, x0, $2", func(i Instruction) (uint16, bool) {
+		if !isRType(i, FUNCT3_ADD_SUB, FUNCT7_ADD) || i.Rd() == 0 || i.Rs1() != 0 || i.Rs2() == 0 {
+			return 0, false
+		}
+		return uint16(0x8002 | i.Rd()<<7 | i.Rs2()<<2), true
+	}},
+	{"c.add", "add $1, $1, $2", func(i Instruction) (uint16, bool) {
+		if !isRType(i, FUNCT3_ADD_SUB, FUNCT7_ADD) || i.Rd() == 0 || i.Rd() != i.Rs1() || i.Rs2() == 0 {
+			return 0, false
+		}
+		return uint16(0x9002 | i.Rd()<<7 | i.Rs2()<<2), true
+	}},
+	{"c.jr", "jalr x0, 0($1)", func(i Instruction) (uint16, bool) {
+		if i.Opcode() != OPCODE_JALR || i.Rd() != 0 || i.Rs1() == 0 || i.ImmI() != 0 {
+			return 0, false
+		}
+		return uint16(0x8002 | i.Rs1()<<7), true
+	}},
+	{"c.jalr", "jalr x1, 0($1)", func(i Instruction) (uint16, bool) {
+		if i.Opcode() != OPCODE_JALR || i.Rd() != 1 || i.Rs1() == 0 || i.ImmI() != 0 {
+			return 0, false
+		}
+		return uint16(0x9002 | i.Rs1()<<7), true
+	}},
+	{"c.ebreak", "ebreak", func(i Instruction) (uint16, bool) {
+		return 0x9002, i == newIType(OPCODE_SYSTEM, FUNCT3_PRIV, 0, 0, int32(FUNCT12_EBREAK))
+	}},
+	{"c.j", "jal x0, $1", func(i Instruction) (uint16, bool) { return encodeCJ(i, 0, 0x5) }},
+	{"c.jal", "jal x1, $1", func(i Instruction) (uint16, bool) { return encodeCJ(i, 1, 0x1) }},
+	{"c.beqz", "beq $1, x0, $2", func(i Instruction) (uint16, bool) { return encodeCBBranch(i, FUNCT3_BEQ, 0x6) }},
+	{"c.bnez", "bne $1, x0, $2", func(i Instruction) (uint16, bool) { return encodeCBBranch(i, FUNCT3_BNE, 0x7) }},
+	{"c.flw", "flw $1, $2", func(i Instruction) (uint16, bool) {
+		return encodeCFPLoadStore(i, OPCODE_LOAD_FP, FUNCT3_FLW, 0x3, 4)
+	}},
+	{"c.fsw", "fsw $1, $2", func(i Instruction) (uint16, bool) {
+		return encodeCFPLoadStore(i, OPCODE_STORE_FP, FUNCT3_FSW, 0x7, 4)
+	}},
+	{"c.fld", "fld $1, $2", func(i Instruction) (uint16, bool) {
+		return encodeCFPLoadStore(i, OPCODE_LOAD_FP, FUNCT3_FLD, 0x1, 8)
+	}},
+	{"c.fsd", "fsd $1, $2", func(i Instruction) (uint16, bool) {
+		return encodeCFPLoadStore(i, OPCODE_STORE_FP, FUNCT3_FSD, 0x5, 8)
+	}},
+	{"c.flwsp", "flw $1, $2", func(i Instruction) (uint16, bool) {
+		return encodeCFPStack(i, OPCODE_LOAD_FP, FUNCT3_FLW, 0x3, 4)
+	}},
+	{"c.fswsp", "fsw $1, $2", func(i Instruction) (uint16, bool) {
+		return encodeCFPStack(i, OPCODE_STORE_FP, FUNCT3_FSW, 0x7, 4)
+	}},
+	{"c.fldsp", "fld $1, $2", func(i Instruction) (uint16, bool) {
+		return encodeCFPStack(i, OPCODE_LOAD_FP, FUNCT3_FLD, 0x1, 8)
+	}},
+	{"c.fsdsp", "fsd $1, $2", func(i Instruction) (uint16, bool) {
+		return encodeCFPStack(i, OPCODE_STORE_FP, FUNCT3_FSD, 0x5, 8)
+	}},
+}
+
+// encodeCFPLoadStore encodes the FP loads and stores with a register base (C.FLW, C.FSW, C.FLD, C.FSD).
+// scale is the access size, which determines the offset layout.
+func encodeCFPLoadStore(i Instruction, opcode Opcode, funct3, cfunct3 uint32, scale int32) (uint16, bool) {
+	imm, reg := i.ImmI(), i.Rd()
+	if opcode == OPCODE_STORE_FP {
+		imm, reg = i.ImmS(), i.Rs2()
+	}
+	if i.Opcode() != opcode || i.Funct3() != funct3 || !isCompactReg(reg) || !isCompactReg(i.Rs1()) ||
+		imm < 0 || imm >= 32*scale || imm%scale != 0 {
+		return 0, false
+	}
+	offset := clWordScatter(uint32(imm))
+	if scale == 8 {
+		offset = clDoubleScatter(uint32(imm))
+	}
+	return uint16(cfunct3<<13 | offset | (i.Rs1()-8)<<7 | (reg-8)<<2), true
+}
+
+// encodeCFPStack encodes the stack-pointer based FP loads and stores (C.FLWSP, C.FSWSP, C.FLDSP, C.FSDSP).
+func encodeCFPStack(i Instruction, opcode Opcode, funct3, cfunct3 uint32, scale int32) (uint16, bool) {
+	imm := i.ImmI()
+	if opcode == OPCODE_STORE_FP {
+		imm = i.ImmS()
+	}
+	if i.Opcode() != opcode || i.Funct3() != funct3 || i.Rs1() != 2 || imm < 0 || imm >= 64*scale || imm%scale != 0 {
+		return 0, false
+	}
+	u := uint32(imm)
+	var fields uint32
+	switch {
+	case opcode == OPCODE_LOAD_FP && scale == 4:
+		fields = bits(u, 5, 5)<<12 | i.Rd()<<7 | bits(u, 4, 2)<<4 | bits(u, 7, 6)<<2
+	case opcode == OPCODE_LOAD_FP:
+		fields = bits(u, 5, 5)<<12 | i.Rd()<<7 | bits(u, 4, 3)<<5 | bits(u, 8, 6)<<2
+	case scale == 4:
+		fields = bits(u, 5, 2)<<9 | bits(u, 7, 6)<<7 | i.Rs2()<<2
+	default:
+		fields = bits(u, 5, 3)<<10 | bits(u, 8, 6)<<7 | i.Rs2()<<2
+	}
+	return uint16(cfunct3<<13 | fields | 0x2), true
+}
+
+// Compress returns the 16-bit RVC encoding of a 32-bit instruction, if one exists.
+func Compress(instr Instruction) (uint16, bool) {
+	for _, form := range compressedForms {
+		if half, ok := form.encode(instr); ok {
+			return half, true
+		}
+	}
+	return 0, false
+}
+
+// templateOperand matches the $n placeholders of a compressedForm template.
+var templateOperand = regexp.MustCompile(`\$(\d)`)
+
+// parseCompressed encodes an explicit "c.*" mnemonic by rewriting it to the
+// equivalent 32-bit instruction and encoding that in the requested form.
+func parseCompressed(mnemonic, operands string) (Instruction, error) {
+	for _, form := range compressedForms {
+		if form.mnemonic != mnemonic {
+			continue
+		}
+		var args []string
+		if operands != "" {
+			args = strings.Split(operands, ",")
+		}
+		wantArgs := 0
+		for _, m := range templateOperand.FindAllStringSubmatch(form.template, -1) {
+			n, _ := strconv.Atoi(m[1])
+			wantArgs = max(wantArgs, n)
+		}
+		if len(args) != wantArgs {
+			return 0, fmt.Errorf("invalid %s operands: %q", mnemonic, operands)
+		}
+		line := templateOperand.ReplaceAllStringFunc(form.template, func(ref string) string {
+			n, _ := strconv.Atoi(ref[1:])
+			return args[n-1]
+		})
+		instr, err := ParseInstruction(line)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s operands: %q: %w", mnemonic, operands, err)
+		}
+		half, ok := form.encode(instr)
+		if !ok {
+			return 0, fmt.Errorf("operands not encodable as %s: %q", mnemonic, operands)
+		}
+		return Instruction(half), nil
+	}
+	return 0, fmt.Errorf("unsupported instruction: %q", mnemonic)
+}
@@ -0,0 +1,142 @@
+package assembler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsCompressedAndSize(t *testing.T) {
+	assert.True(t, IsCompressed(0x0001))
+	assert.True(t, IsCompressed(0x8082))
+	assert.False(t, IsCompressed(0x0013))
+	assert.Equal(t, COMPRESSED_SIZE, Instruction(0x8082).Size())
+	assert.Equal(t, INSTRUCTION_SIZE, mustParse("addi x1, x0, 5").Size())
+}
+
+// Reference encodings as produced by the GNU assembler for rv32imc.
+func TestParseCompressed_Encodings(t *testing.T) {
+	cases := []struct {
+		asm      string
+		want     uint16
+		expanded string
+	}{
+		{"c.nop", 0x0001, "addi x0, x0, 0"},
+		{"c.addi x2, -16", 0x1141, "addi x2, x2, -16"},
+		{"c.addi x2, -32", 0x1101, "addi x2, x2, -32"},
+		{"c.addi16sp x2, -48", 0x7179, "addi x2, x2, -48"},
+		{"c.addi4spn x10, x2, 12", 0x0068, "addi x10, x2, 12"},
+		{"c.addi4spn s0, sp, 4", 0x0040, "addi x8, x2, 4"},
+		{"c.li x10, 0", 0x4501, "addi x10, x0, 0"},
+		{"c.lui x15, 0x10", 0x67C1, "lui x15, 0x10"},
+		{"c.lui x15, 0xFFFFF", 0x77FD, "lui x15, 0xFFFFF"},
+		{"c.mv x10, x11", 0x852E, "add x10, x0, x11"},
+		{"c.add x10, x12", 0x9532, "add x10, x10, x12"},
+		{"c.jr x1", 0x8082, "jalr x0, 0(x1)"},
+		{"c.jalr x15", 0x9782, "jalr x1, 0(x15)"},
+		{"c.ebreak", 0x9002, "ebreak"},
+		{"c.lwsp x1, 12(x2)", 0x40B2, "lw x1, 12(x2)"},
+		{"c.swsp x1, 12(x2)", 0xC606, "sw x1, 12(x2)"},
+		{"c.lw x10, 0(x10)", 0x4108, "lw x10, 0(x10)"},
+		{"c.sw x15, 4(x14)", 0xC35C, "sw x15, 4(x14)"},
+		{"c.slli x10, 2", 0x050A, "slli x10, x10, 2"},
+		{"c.srli x15, 1", 0x8385, "srli x15, x15, 1"},
+		{"c.srai x15, 31", 0x87FD, "srai x15, x15, 31"},
+		{"c.andi x10, 15", 0x893D, "andi x10, x10, 15"},
+		{"c.sub x10, x11", 0x8D0D, "sub x10, x10, x11"},
+		{"c.xor x10, x11", 0x8D2D, "xor x10, x10, x11"},
+		{"c.or x10, x11", 0x8D4D, "or x10, x10, x11"},
+		{"c.and x10, x11", 0x8D6D, "and x10, x10, x11"},
+		{"c.j -2", 0xBFFD, "jal x0, -2"},
+		{"c.jal 16", 0x2801, "jal x1, 16"},
+		{"c.beqz x10, 8", 0xC501, "beq x10, x0, 8"},
+		{"c.bnez x15, -4", 0xFFF5, "bne x15, x0, -4"},
+		{"c.flw f10, 4(x10)", 0x6148, "flw f10, 4(x10)"},
+		{"c.fsw fs1, 64(x8)", 0xE024, "fsw f9, 64(x8)"},
+		{"c.fld fs0, 8(x9)", 0x2480, "fld f8, 8(x9)"},
+		{"c.fsd fa5, 16(x14)", 0xAB1C, "fsd f15, 16(x14)"},
+		{"c.flwsp f1, 12(x2)", 0x60B2, "flw f1, 12(x2)"},
+		{"c.fswsp f1, 12(x2)", 0xE606, "fsw f1, 12(x2)"},
+		{"c.fldsp f1, 8(x2)", 0x20A2, "fld f1, 8(x2)"},
+		{"c.fsdsp f1, 8(x2)", 0xA406, "fsd f1, 8(x2)"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.asm, func(t *testing.T) {
+			instr, err := ParseInstruction(tc.asm)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equalf(t, uint32(tc.want), uint32(instr), "encoding 0x%04x", uint32(instr))
+			expanded, err := Expand(uint16(instr))
+			assert.NoError(t, err)
+			assert.Equal(t, mustParse(tc.expanded), expanded, "Expand")
+			half, ok := Compress(mustParse(tc.expanded))
+			assert.True(t, ok, "Compress")
+			assert.Equal(t, tc.want, half, "Compress")
+		})
+	}
+}
+
+func TestCompressedForms_ExpandToSpecs(t *testing.T) {
+	for _, form := range compressedForms {
+		mnemonic := strings.Fields(form.template)[0]
+		_, ok := Lookup(mnemonic)
+		assert.Truef(t, ok, "%s expands to unknown instruction %s", form.mnemonic, mnemonic)
+	}
+}
+
+func TestParseCompressed_Errors(t *testing.T) {
+	cases := []string{
+		"c.addi x1, 32",         // immediate too large
+		"c.addi x1, 0",          // zero immediate is a hint, not c.addi
+		"c.lw x1, 0(x10)",       // x1 is not a compressed register
+		"c.lw x10, 2(x10)",      // offset not word aligned
+		"c.addi4spn x10, x2, 0", // zero immediate is reserved
+		"c.addi4spn x10, x3, 4", // the base must be sp
+		"c.addi4spn x10, 4",     // sp is not implied
+		"c.addi16sp x2, 8",      // not a multiple of 16
+		"c.lui x2, 1",           // x2 is reserved for c.addi16sp
+		"c.beqz x10, 256",       // out of range
+		"c.jr x0",               // reserved
+		"c.add x1",              // missing operand
+		"c.foo x1, x2",          // unknown
+		"c.mv x1, x2, x3",       // too many operands
+		"c.slli x10, 32",        // shift out of range
+		"c.j 2048",              // out of range
+	}
+	for _, asm := range cases {
+		_, err := ParseInstruction(asm)
+		assert.Errorf(t, err, "ParseInstruction(%q) should fail", asm)
+	}
+}
+
+func TestExpand_Illegal(t *testing.T) {
+	cases := []uint16{
+		0x0000, // all zero
+		0x6101, // c.addi16sp with zero immediate
+		0x4002, // c.lwsp with rd=x0
+		0x8002, // c.jr with rs1=x0
+		0x1006, // c.slli with shamt[5] set
+		0x9C01, // RV64 c.subw
+	}
+	for _, half := range cases {
+		_, err := Expand(half)
+		assert.Errorf(t, err, "Expand(0x%04x) should fail", half)
+	}
+}
+
+func TestCompress_NotCompressible(t *testing.T) {
+	cases := []string{
+		"addi x1, x2, 5",
+		"lw x1, 256(x2)",
+		"sub x1, x2, x3",
+		"beq x10, x11, 8",
+		"slt x1, x2, x3",
+		"jal x5, 16",
+	}
+	for _, asm := range cases {
+		_, ok := Compress(mustParse(asm))
+		assert.Falsef(t, ok, "Compress(%q)", asm)
+	}
+}
@@ -0,0 +1,146 @@
+package assembler
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// CSR addresses
+const (
+	// floating-point CSRs
+	CSR_FFLAGS uint32 = 0x001
+	CSR_FRM    uint32 = 0x002
+	CSR_FCSR   uint32 = 0x003
+
+	// supervisor trap setup
+	CSR_SSTATUS uint32 = 0x100
+	CSR_SIE     uint32 = 0x104
+	CSR_STVEC   uint32 = 0x105
+
+	// supervisor trap handling
+	CSR_SSCRATCH uint32 = 0x140
+	CSR_SEPC     uint32 = 0x141
+	CSR_SCAUSE   uint32 = 0x142
+	CSR_STVAL    uint32 = 0x143
+	CSR_SIP      uint32 = 0x144
+
+	// supervisor address translation and protection
+	CSR_SATP uint32 = 0x180
+
+	// machine information registers (read-only)
+	CSR_MVENDORID uint32 = 0xF11
+	CSR_MARCHID   uint32 = 0xF12
+	CSR_MIMPID    uint32 = 0xF13
+	CSR_MHARTID   uint32 = 0xF14
+
+	// machine trap setup
+	CSR_MSTATUS uint32 = 0x300
+	CSR_MISA    uint32 = 0x301
+	CSR_MEDELEG uint32 = 0x302
+	CSR_MIDELEG uint32 = 0x303
+	CSR_MIE     uint32 = 0x304
+	CSR_MTVEC   uint32 = 0x305
+
+	// machine trap handling
+	CSR_MSCRATCH uint32 = 0x340
+	CSR_MEPC     uint32 = 0x341
+	CSR_MCAUSE   uint32 = 0x342
+	CSR_MTVAL    uint32 = 0x343
+	CSR_MIP      uint32 = 0x344
+)
+
+// Funct3 field values of the Zicsr instructions (SYSTEM opcode)
+const (
+	FUNCT3_CSRRW  uint32 = 0x1
+	FUNCT3_CSRRS  uint32 = 0x2
+	FUNCT3_CSRRC  uint32 = 0x3
+	FUNCT3_CSRRWI uint32 = 0x5
+	FUNCT3_CSRRSI uint32 = 0x6
+	FUNCT3_CSRRCI uint32 = 0x7
+)
+
+// csrNames maps the CSR names accepted as assembler operands to their addresses.
+var csrNames = map[string]uint32{
+	"fflags":    CSR_FFLAGS,
+	"frm":       CSR_FRM,
+	"fcsr":      CSR_FCSR,
+	"sstatus":   CSR_SSTATUS,
+	"sie":       CSR_SIE,
+	"stvec":     CSR_STVEC,
+	"sscratch":  CSR_SSCRATCH,
+	"sepc":      CSR_SEPC,
+	"scause":    CSR_SCAUSE,
+	"stval":     CSR_STVAL,
+	"sip":       CSR_SIP,
+	"satp":      CSR_SATP,
+	"mvendorid": CSR_MVENDORID,
+	"marchid":   CSR_MARCHID,
+	"mimpid":    CSR_MIMPID,
+	"mhartid":   CSR_MHARTID,
+	"mstatus":   CSR_MSTATUS,
+	"misa":      CSR_MISA,
+	"medeleg":   CSR_MEDELEG,
+	"mideleg":   CSR_MIDELEG,
+	"mie":       CSR_MIE,
+	"mtvec":     CSR_MTVEC,
+	"mscratch":  CSR_MSCRATCH,
+	"mepc":      CSR_MEPC,
+	"mcause":    CSR_MCAUSE,
+	"mtval":     CSR_MTVAL,
+	"mip":       CSR_MIP,
+}
+
+// ParseCSR resolves a CSR operand, given by name (e.g. "mstatus") or as a 12-bit address.
+func ParseCSR(s string) (uint32, error) {
+	if addr, ok := csrNames[s]; ok {
+		return addr, nil
+	}
+	addr, err := parseUint(s)
+	if err != nil || addr > 0xFFF {
+		return 0, fmt.Errorf("invalid CSR: %q", s)
+	}
+	return addr, nil
+}
+
+// CSRName returns the name of the CSR at addr, or its address in hex if it has no name.
+func CSRName(addr uint32) string {
+	for name, a := range csrNames {
+		if a == addr {
+			return name
+		}
+	}
+	return fmt.Sprintf("0x%03x", addr)
+}
+
+// CSRNames returns the names of all known CSRs.
+func CSRNames() []string {
+	names := make([]string, 0, len(csrNames))
+	for name := range csrNames {
+		names = append(names, name)
+	}
+	return names
+}
+
+// csrPseudoInstructions rewrites the CSR pseudo-instructions to their base form.
+// $1 and $2 refer to the original operands.
+var csrPseudoInstructions = map[string]string{
+	"csrr":  "csrrs $1, $2, x0",
+	"csrw":  "csrrw x0, $1, $2",
+	"csrs":  "csrrs x0, $1, $2",
+	"csrc":  "csrrc x0, $1, $2",
+	"csrwi": "csrrwi x0, $1, $2",
+	"csrsi": "csrrsi x0, $1, $2",
+	"csrci": "csrrci x0, $1, $2",
+}
+
+// parseCSRPseudo expands a CSR pseudo-instruction like "csrr x1, mstatus".
+func parseCSRPseudo(mnemonic, operands, template string) (Instruction, error) {
+	m, err := parseOperands(operands, regexp.MustCompile(`^([^,]+),([^,]+)$`), mnemonic)
+	if err != nil {
+		return 0, err
+	}
+	line := templateOperand.ReplaceAllStringFunc(template, func(ref string) string {
+		return m[ref[1]-'0']
+	})
+	return ParseInstruction(line)
+}
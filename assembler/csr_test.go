@@ -0,0 +1,70 @@
+package assembler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Reference encodings as produced by the GNU assembler.
+func TestParseInstruction_CSREncodings(t *testing.T) {
+	cases := []struct {
+		asm  string
+		want uint32
+	}{
+		{"csrrw x1, mstatus, x2", 0x300110F3},
+		{"csrrs x5, mepc, x0", 0x341022F3},
+		{"csrrc x0, mie, x6", 0x30433073},
+		{"csrrwi x0, mtvec, 5", 0x3052D073},
+		{"csrrsi x1, mstatus, 8", 0x300460F3},
+		{"csrrci x0, mstatus, 8", 0x30047073},
+		{"csrrs x1, 0x340, x0", 0x340020F3},
+		{"csrr x10, mhartid", 0xF1402573},
+		{"csrw mtvec, x5", 0x30529073},
+		{"csrs mie, x6", 0x30432073},
+		{"csrc mstatus, x7", 0x3003B073},
+		{"csrwi mscratch, 31", 0x340FD073},
+		{"csrsi mstatus, 8", 0x30046073},
+		{"csrci mstatus, 8", 0x30047073},
+		{"csrr x1, fcsr", 0x003020F3},
+	}
+	for _, tc := range cases {
+		t.Run(tc.asm, func(t *testing.T) {
+			instr, err := ParseInstruction(tc.asm)
+			if assert.NoError(t, err) {
+				assert.Equalf(t, tc.want, uint32(instr), "encoding 0x%08X", uint32(instr))
+			}
+		})
+	}
+}
+
+func TestParseInstruction_CSRErrors(t *testing.T) {
+	cases := []string{
+		"csrrw x1, nosuchcsr, x2",
+		"csrrw x1, 0x1000, x2",
+		"csrrwi x1, mstatus, 32",
+		"csrrwi x1, mstatus, x2",
+		"csrr x1",
+	}
+	for _, asm := range cases {
+		_, err := ParseInstruction(asm)
+		assert.Errorf(t, err, "ParseInstruction(%q)", asm)
+	}
+}
+
+func TestParseCSRAndName(t *testing.T) {
+	addr, err := ParseCSR("mtvec")
+	assert.NoError(t, err)
+	assert.Equal(t, CSR_MTVEC, addr)
+	addr, err = ParseCSR("0x7C0")
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0x7C0), addr)
+	addr, err = ParseCSR("010")
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(10), addr, "decimal, not octal")
+	_, err = ParseCSR("0x1000")
+	assert.Error(t, err)
+	assert.Equal(t, "mcause", CSRName(CSR_MCAUSE))
+	assert.Equal(t, "0x7c0", CSRName(0x7C0))
+	assert.Contains(t, CSRNames(), "mscratch")
+}
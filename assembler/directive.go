@@ -0,0 +1,337 @@
+package assembler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The sections a program is assembled into, in the order they are laid out in
+// memory: .text makes up the text segment, the others the data segment.
+const (
+	SECTION_TEXT   = ".text"
+	SECTION_RODATA = ".rodata"
+	SECTION_DATA   = ".data"
+	SECTION_BSS    = ".bss"
+)
+
+var sectionOrder = []string{SECTION_TEXT, SECTION_RODATA, SECTION_DATA, SECTION_BSS}
+
+// sectionNames maps the section names accepted by .section to the sections;
+// the small data sections are merged into their counterparts.
+var sectionNames = map[string]string{
+	".text":    SECTION_TEXT,
+	".rodata":  SECTION_RODATA,
+	".srodata": SECTION_RODATA,
+	".data":    SECTION_DATA,
+	".sdata":   SECTION_DATA,
+	".bss":     SECTION_BSS,
+	".sbss":    SECTION_BSS,
+}
+
+// sectionOf returns the section a .section directive's name selects, where
+// subsections like .text.startup belong to their parent.
+func sectionOf(name string) (string, error) {
+	for prefix, section := range sectionNames {
+		if name == prefix || strings.HasPrefix(name, prefix+".") {
+			return section, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported section: %q", name)
+}
+
+// Segment is a contiguous part of an assembled program's memory image.
+type Segment struct {
+	Base uint32
+	// Data holds the initialized bytes
+	Data []byte
+	// Size is the size in memory: Data followed by zeros (.bss)
+	Size uint32
+}
+
+// Words returns Data as little-endian 32-bit words, the last one padded with zeros.
+func (s Segment) Words() []Instruction {
+	words := make([]Instruction, 0, (len(s.Data)+3)/4)
+	for i := 0; i < len(s.Data); i += 4 {
+		var word [4]byte
+		copy(word[:], s.Data[i:])
+		words = append(words, Instruction(binary.LittleEndian.Uint32(word[:])))
+	}
+	return words
+}
+
+// Program is an assembled program: the text segment holds .text, the data
+// segment following it .rodata, .data and .bss.
+type Program struct {
+	Text Segment
+	Data Segment
+	// Entry is the address of the label _start if there is one, otherwise the start of .text
+	Entry uint32
+	// Symbols maps the labels to their addresses
+	Symbols map[string]uint32
+	// Globals are the symbols declared by .globl
+	Globals []string
+}
+
+// Image returns the program's memory image from the start of the text segment to
+// the end of the data segment, with the gap between them and .bss filled with zeros.
+func (p *Program) Image() []byte {
+	end := p.Text.Base + p.Text.Size
+	if p.Data.Size > 0 {
+		end = p.Data.Base + p.Data.Size
+	}
+	image := make([]byte, end-p.Text.Base)
+	copy(image, p.Text.Data)
+	if p.Data.Size > 0 {
+		copy(image[p.Data.Base-p.Text.Base:], p.Data.Data)
+	}
+	return image
+}
+
+// isDirective reports whether a statement is an assembler directive.
+func isDirective(stmt string) bool {
+	return strings.HasPrefix(stmt, ".")
+}
+
+// splitDirective splits a directive into its name and operands.
+func splitDirective(stmt string) (name string, args []string) {
+	name = strings.Fields(stmt)[0]
+	return name, splitOperands(stmt[len(name):])
+}
+
+// symbols evaluates the operands of directives: numbers, character literals like
+// 'a', and the names of constants and labels.
+type symbols struct {
+	constants map[string]int64
+	// labels are nil while the program is laid out, labels then evaluate to 0
+	labels map[string]int
+}
+
+// value evaluates a data value, which may be a label.
+func (s symbols) value(arg string) (int64, error) {
+	if s.labels == nil && symbolName.MatchString(arg) {
+		if _, ok := s.constants[arg]; !ok {
+			return 0, nil
+		}
+	}
+	return parseValue(arg, s.constants, s.labels)
+}
+
+// size evaluates a size or alignment, which may not depend on a label.
+func (s symbols) size(arg string) (int64, error) {
+	return parseValue(arg, s.constants, nil)
+}
+
+// dataWidths are the sizes in bytes of the values of the data directives.
+var dataWidths = map[string]int{".byte": 1, ".half": 2, ".word": 4}
+
+// directiveData returns the bytes a data directive emits at addr in section.
+// The number of bytes never depends on the value of a label.
+func directiveData(name string, args []string, addr int, section string, syms symbols) ([]byte, error) {
+	switch name {
+	case ".byte", ".half", ".word":
+		width := dataWidths[name]
+		if len(args) == 0 {
+			return nil, fmt.Errorf("%s needs at least one value", name)
+		}
+		var data []byte
+		for _, arg := range args {
+			v, err := syms.value(arg)
+			if err != nil {
+				return nil, err
+			}
+			if v < -(1<<(8*width-1)) || v >= 1<<(8*width) {
+				return nil, fmt.Errorf("value out of range for %s: %s", name, arg)
+			}
+			var b [4]byte
+			binary.LittleEndian.PutUint32(b[:], uint32(v))
+			data = append(data, b[:width]...)
+		}
+		return data, nil
+	case ".ascii", ".asciz", ".string":
+		if len(args) == 0 {
+			return nil, fmt.Errorf("%s needs at least one string", name)
+		}
+		var data []byte
+		for _, arg := range args {
+			if !strings.HasPrefix(arg, `"`) {
+				return nil, fmt.Errorf("invalid string: %s", arg)
+			}
+			s, err := strconv.Unquote(arg)
+			if err != nil {
+				return nil, fmt.Errorf("invalid string: %s", arg)
+			}
+			data = append(data, s...)
+			if name != ".ascii" {
+				data = append(data, 0)
+			}
+		}
+		return data, nil
+	case ".space", ".zero":
+		if len(args) < 1 || len(args) > 2 || name == ".zero" && len(args) != 1 {
+			return nil, fmt.Errorf("invalid %s operands: %q", name, strings.Join(args, ", "))
+		}
+		n, err := syms.size(args[0])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 || n > 1<<24 {
+			return nil, fmt.Errorf("invalid %s size: %s", name, args[0])
+		}
+		fill, err := fillByte(name, args[1:], syms)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.Repeat([]byte{fill}, int(n)), nil
+	case ".align", ".balign":
+		align, err := alignment(name, args, syms)
+		if err != nil {
+			return nil, err
+		}
+		pad := (align - addr%align) % align
+		if len(args) == 1 && section == SECTION_TEXT {
+			return nopFill(addr, pad), nil
+		}
+		fill, err := fillByte(name, args[1:], syms)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.Repeat([]byte{fill}, pad), nil
+	}
+	return nil, fmt.Errorf("unsupported directive: %q", name)
+}
+
+// alignment returns the alignment in bytes of an .align or .balign directive:
+// .align takes the exponent of a power of two, as in the GNU assembler for RISC-V.
+func alignment(name string, args []string, syms symbols) (int, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return 0, fmt.Errorf("invalid %s operands: %q", name, strings.Join(args, ", "))
+	}
+	n, err := syms.size(args[0])
+	if err != nil {
+		return 0, err
+	}
+	if name == ".align" {
+		if n < 0 || n > 16 {
+			return 0, fmt.Errorf("invalid %s: %s", name, args[0])
+		}
+		n = 1 << n
+	}
+	if n < 1 || n > 1<<16 || n&(n-1) != 0 {
+		return 0, fmt.Errorf("invalid %s: %s", name, args[0])
+	}
+	return int(n), nil
+}
+
+// fillByte returns the optional fill value of .space and the alignment directives.
+func fillByte(name string, args []string, syms symbols) (byte, error) {
+	if len(args) == 0 {
+		return 0, nil
+	}
+	v, err := syms.size(args[0])
+	if err != nil {
+		return 0, err
+	}
+	if v < -128 || v > 255 {
+		return 0, fmt.Errorf("invalid %s fill value: %s", name, args[0])
+	}
+	return byte(v), nil
+}
+
+// nopFill returns n bytes of padding at addr in .text: nops where aligned, so that
+// execution can run through the padding, and zeros for an odd address.
+func nopFill(addr, n int) []byte {
+	data := make([]byte, 0, n)
+	for len(data) < n {
+		a := addr + len(data)
+		switch {
+		case a%4 == 0 && n-len(data) >= 4:
+			data = binary.LittleEndian.AppendUint32(data, uint32(nop))
+		case a%2 == 0 && n-len(data) >= 2:
+			data = binary.LittleEndian.AppendUint16(data, 0x0001) // c.nop
+		default:
+			data = append(data, 0)
+		}
+	}
+	return data
+}
+
+// nop is the encoding of addi x0, x0, 0.
+var nop = newIType(OPCODE_I_TYPE, FUNCT3_ADDI, 0, 0, 0)
+
+// symbolName matches the names of labels and constants.
+var symbolName = regexp.MustCompile(`^[A-Za-z_.$][A-Za-z0-9_.$]*$`)
+
+// parseEqu parses the operands of an .equ or .set directive with the constants defined so far.
+func parseEqu(name string, args []string, constants map[string]int64) (string, int64, error) {
+	if len(args) != 2 || !symbolName.MatchString(args[0]) {
+		return "", 0, fmt.Errorf("invalid %s operands: %q", name, strings.Join(args, ", "))
+	}
+	v, err := parseValue(args[1], constants, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	return args[0], v, nil
+}
+
+// parseValue evaluates a number, a character literal like 'a', or the name of a
+// constant or label.
+func parseValue(s string, constants map[string]int64, labels map[string]int) (int64, error) {
+	if v, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return v, nil
+	}
+	if strings.HasPrefix(s, "'") && strings.HasSuffix(s, "'") && len(s) >= 3 {
+		c, _, tail, err := strconv.UnquoteChar(s[1:len(s)-1], '\'')
+		if err == nil && tail == "" {
+			return int64(c), nil
+		}
+	}
+	if v, ok := constants[s]; ok {
+		return v, nil
+	}
+	if addr, ok := labels[s]; ok {
+		return int64(addr), nil
+	}
+	if symbolName.MatchString(s) {
+		return 0, fmt.Errorf("undefined symbol: %q", s)
+	}
+	return 0, fmt.Errorf("invalid value: %q", s)
+}
+
+// constantOperand matches the names in an instruction's operands that may be constants.
+var constantOperand = regexp.MustCompile(`[A-Za-z_.$][A-Za-z0-9_.$]*`)
+
+// substituteConstants replaces the constants defined by .equ in the operands of
+// an instruction with their values. Register names always refer to the register.
+func substituteConstants(stmt string, constants map[string]int64) string {
+	fields := strings.Fields(stmt)
+	if len(constants) == 0 || len(fields) < 2 {
+		return stmt
+	}
+	mnemonic := fields[0]
+	ops := splitOperands(strings.TrimSpace(stmt)[len(mnemonic):])
+	for i, op := range ops {
+		ops[i] = constantOperand.ReplaceAllStringFunc(op, func(name string) string {
+			if isRegisterName(name) {
+				return name
+			}
+			if v, ok := constants[name]; ok {
+				return fmt.Sprint(v)
+			}
+			return name
+		})
+	}
+	return mnemonic + " " + strings.Join(ops, ", ")
+}
+
+// isRegisterName reports whether name is an integer or floating-point register.
+func isRegisterName(name string) bool {
+	if _, err := ParseRegister(name); err == nil {
+		return true
+	}
+	_, err := parseFPReg(name)
+	return err == nil
+}
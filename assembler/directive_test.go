@@ -0,0 +1,149 @@
+package assembler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssemble_Sections(t *testing.T) {
+	src := `
+        .globl _start
+        .equ    N, 3
+        .data
+nums:   .word   1, -1, N
+        .rodata
+msg:    .asciz  "Hi, #1: ok\n"   # a comment
+        .bss
+buf:    .space  8
+        .text
+_start: la      a0, nums
+        lw      a1, 8(a0)
+        .section .sdata
+flag:   .byte   'y'
+        .section .text.exit
+        li      a7, N
+`
+	prog, err := Assemble(strings.Split(src, "\n"), Options{Base: 0x100})
+	if !assert.NoError(t, err) {
+		return
+	}
+	// text: la (2), lw, li; rodata: msg (12 bytes); data: nums, flag; bss: buf
+	assert.Equal(t, uint32(0x100), prog.Text.Base)
+	assert.Equal(t, uint32(16), prog.Text.Size)
+	assert.Equal(t, uint32(0x110), prog.Data.Base)
+	assert.Equal(t, map[string]uint32{"_start": 0x100, "msg": 0x110, "nums": 0x11C, "flag": 0x128, "buf": 0x12C}, prog.Symbols)
+	assert.Equal(t, uint32(0x100), prog.Entry)
+	assert.Equal(t, []string{"_start"}, prog.Globals)
+
+	checkInstructions(t, prog.Text.Words(), []string{
+		"auipc x10, 0",
+		"addi x10, x10, 28",
+		"lw x11, 8(x10)",
+		"addi x17, x0, 3",
+	})
+	want := append([]byte("Hi, #1: ok\n\x00"), 1, 0, 0, 0, 0xFF, 0xFF, 0xFF, 0xFF, 3, 0, 0, 0, 'y')
+	assert.Equal(t, want, prog.Data.Data)
+	assert.Equal(t, uint32(0x134-0x110), prog.Data.Size, ".bss aligned to a word and included in the size")
+
+	image := prog.Image()
+	assert.Len(t, image, 0x34)
+	assert.Equal(t, want, image[0x10:0x10+len(want)])
+}
+
+func TestAssemble_Directives(t *testing.T) {
+	cases := []struct {
+		src  string
+		want []byte
+	}{
+		{".byte 1, 0xFF, -1, 'a', '\\n'", []byte{1, 0xFF, 0xFF, 'a', '\n'}},
+		{".half 0x1234, -2", []byte{0x34, 0x12, 0xFE, 0xFF}},
+		{".word 0x12345678, 0xFFFFFFFF", []byte{0x78, 0x56, 0x34, 0x12, 0xFF, 0xFF, 0xFF, 0xFF}},
+		{".ascii \"ab\", \"c,d\"", []byte("abc,d")},
+		{".asciz \"ab\"", []byte("ab\x00")},
+		{".string \"a\\tb\"", []byte("a\tb\x00")},
+		{".space 3", []byte{0, 0, 0}},
+		{".space 2, 0xAA", []byte{0xAA, 0xAA}},
+		{".zero 2", []byte{0, 0}},
+		{".byte 1\n.align 2", []byte{1, 0, 0, 0}},
+		{".byte 1\n.balign 4, 0xEE", []byte{1, 0xEE, 0xEE, 0xEE}},
+		{".byte 1\n.balign 2\n.byte 2", []byte{1, 0, 2}},
+		{".equ SIZE, 2\n.set FILL, 7\n.space SIZE, FILL", []byte{7, 7}},
+		{".word end\nend:", []byte{4, 0, 0, 0}},
+	}
+	for _, tc := range cases {
+		prog, err := Assemble(strings.Split(".data\n"+tc.src, "\n"), Options{})
+		if assert.NoErrorf(t, err, "Assemble(%q)", tc.src) {
+			assert.Equalf(t, tc.want, prog.Data.Data, "Assemble(%q)", tc.src)
+		}
+	}
+}
+
+func TestAssemble_TextAlignment(t *testing.T) {
+	prog, err := Assemble([]string{"c.nop", ".align 3", "ret"}, Options{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	// a c.nop and a nop pad to 8 bytes, so that execution runs through the padding
+	assert.Equal(t, []byte{0x01, 0x00, 0x01, 0x00, 0x13, 0x00, 0x00, 0x00, 0x67, 0x80, 0x00, 0x00}, prog.Text.Data)
+}
+
+func TestAssemble_Errors(t *testing.T) {
+	cases := []string{
+		".data\naddi x1, x0, 1",
+		".bss\n.word 1",
+		".text\n.foo 1",
+		".section .comment",
+		".section",
+		".globl",
+		".byte 256",
+		".half -32769",
+		".word 0x100000000",
+		".word undefined",
+		".ascii abc",
+		".asciz \"abc",
+		".space -1",
+		".space undefined",
+		".align 17",
+		".balign 3",
+		".equ N",
+		".equ N, M",
+		".equ 1N, 2",
+		"a: nop\na: nop",
+		"la a0, nowhere",
+	}
+	for _, src := range cases {
+		_, err := Assemble(strings.Split(src, "\n"), Options{})
+		assert.Errorf(t, err, "Assemble(%q) should fail", src)
+	}
+	_, err := Assemble([]string{"nop", ".data", "mv a0, a1"}, Options{})
+	assert.EqualError(t, err, `3: instruction outside of .text: "mv a0, a1"`)
+}
+
+func TestAssemble_Entry(t *testing.T) {
+	prog, err := Assemble([]string{"nop", "_start: nop"}, Options{Base: 0x80000000})
+	if assert.NoError(t, err) {
+		assert.Equal(t, uint32(0x80000004), prog.Entry)
+		assert.Equal(t, uint32(0), prog.Data.Size)
+	}
+}
+
+func TestSubstituteConstants(t *testing.T) {
+	constants := map[string]int64{"N": 8, "OFF": -4}
+	assert.Equal(t, "addi a0, a0, 8", substituteConstants("addi a0, a0, N", constants))
+	assert.Equal(t, "lw a0, -4(sp)", substituteConstants("lw a0, OFF(sp)", constants))
+	assert.Equal(t, "ret", substituteConstants("ret", constants))
+	assert.Equal(t, "addi a0, a0, 8", substituteConstants("addi\ta0,a0,\tN", constants))
+
+	registers := map[string]int64{"t0": 3, "fa0": 1, "zero": 0}
+	assert.Equal(t, "addi t0, t0, 1", substituteConstants("addi t0, t0, 1", registers))
+	assert.Equal(t, "flw fa0, 0(zero)", substituteConstants("flw fa0, 0(zero)", registers))
+}
+
+func TestAssemble_ConstantNamedLikeRegister(t *testing.T) {
+	prog, err := Assemble([]string{".equ t0, 3", ".equ N, 2", "addi t0, t0, 1", "addi\ta0,\ta0, N"}, Options{})
+	if assert.NoError(t, err) {
+		checkInstructions(t, prog.Text.Words(), []string{"addi x5, x5, 1", "addi x10, x10, 2"})
+	}
+}
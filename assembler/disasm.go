@@ -0,0 +1,303 @@
+package assembler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DisasmOptions controls the output of Disassemble.
+type DisasmOptions struct {
+	// ABINames prints registers by their ABI names (a0, sp, fa0, ...) instead of x10, x2, f10
+	ABINames bool
+	// Pseudo prints pseudo-instructions (nop, li, mv, ret, j, beqz, csrr, ...) where an
+	// instruction is one
+	Pseudo bool
+	// Labels maps addresses to names, used for branch and jump targets
+	Labels map[uint32]string
+}
+
+// Labels inverts a symbol table for DisasmOptions.Labels. Of several names for
+// an address the alphabetically first one is used.
+func Labels(symbols map[string]uint32) map[uint32]string {
+	names := make([]string, 0, len(symbols))
+	for name := range symbols {
+		names = append(names, name)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	labels := make(map[uint32]string, len(symbols))
+	for _, name := range names {
+		labels[symbols[name]] = name
+	}
+	return labels
+}
+
+// roundingModeNames maps rm encodings to their operand names.
+var roundingModeNames = invert(roundingModes)
+
+// invert returns the inverse of a name table.
+func invert[K comparable](m map[string]K) map[K]string {
+	inverse := make(map[K]string, len(m))
+	for name, v := range m {
+		inverse[v] = name
+	}
+	return inverse
+}
+
+// disassembler formats the operands of one instruction.
+type disassembler struct {
+	DisasmOptions
+	pc uint32
+}
+
+func (d *disassembler) x(reg uint32) string {
+	if d.ABINames {
+		return RegABINames[reg]
+	}
+	return fmt.Sprintf("x%d", reg)
+}
+
+func (d *disassembler) f(reg uint32) string {
+	if d.ABINames {
+		return FPRegABINames[reg]
+	}
+	return fmt.Sprintf("f%d", reg)
+}
+
+// target formats the target of a branch or jump at offset from the PC: its
+// label if it has one, otherwise the offset followed by the address as a comment.
+func (d *disassembler) target(offset int32) string {
+	addr := d.pc + uint32(offset)
+	if label, ok := d.Labels[addr]; ok {
+		return label
+	}
+	return fmt.Sprintf("%d # 0x%08x", offset, addr)
+}
+
+func format(mnemonic string, operands ...string) string {
+	if len(operands) == 0 {
+		return mnemonic
+	}
+	return mnemonic + " " + strings.Join(operands, ", ")
+}
+
+// Disassemble returns instr, located at address pc, as assembly. Compressed
+// instructions are shown as their 32-bit equivalent; invalid encodings as a
+// ".word" (or ".half") directive.
+func Disassemble(instr Instruction, pc uint32, opts DisasmOptions) string {
+	if instr.Size() == COMPRESSED_SIZE {
+		expanded, err := Expand(uint16(instr))
+		if err != nil {
+			return fmt.Sprintf(".half 0x%04x", uint16(instr))
+		}
+		instr = expanded
+	}
+	d := &disassembler{DisasmOptions: opts, pc: pc}
+	if d.Pseudo {
+		if text, ok := d.pseudo(instr); ok {
+			return text
+		}
+	}
+	if text, ok := d.decode(instr); ok {
+		return text
+	}
+	return fmt.Sprintf(".word 0x%08x", uint32(instr))
+}
+
+// decode disassembles instr to its base instruction.
+func (d *disassembler) decode(instr Instruction) (string, bool) {
+	spec := Decode(instr)
+	if spec == nil {
+		return "", false
+	}
+	name := spec.Mnemonic
+	if spec.Opcode == OPCODE_AMO {
+		switch {
+		case instr.Aq() && instr.Rl():
+			name += ".aqrl"
+		case instr.Aq():
+			name += ".aq"
+		case instr.Rl():
+			name += ".rl"
+		}
+	}
+	var operands strings.Builder
+	for _, t := range shownTokens(spec, instr) {
+		switch {
+		case t.operand:
+			text, ok := d.operand(spec, instr, t.text)
+			if !ok {
+				return "", false
+			}
+			operands.WriteString(text)
+		case t.text == ",":
+			operands.WriteString(", ")
+		case t.text != "[" && t.text != "]":
+			operands.WriteString(t.text)
+		}
+	}
+	if operands.Len() == 0 {
+		return name, true
+	}
+	return name + " " + operands.String(), true
+}
+
+// shownTokens returns the operand syntax of instr without the trailing
+// optional operands that have their default value, e.g. the dynamic rounding
+// mode or the "iorw, iorw" of fence.
+func shownTokens(spec *Spec, instr Instruction) []token {
+	tokens := spec.encoding().tokens
+	end := len(tokens)
+	for i := len(tokens) - 1; i >= 0; i-- {
+		t := tokens[i]
+		if t.operand && (t.group == 0 || !spec.isDefault(instr, t.text)) {
+			break
+		}
+		if t.text == "[" {
+			end = i
+		}
+	}
+	return tokens[:end]
+}
+
+// operand formats the operand name of instr.
+func (d *disassembler) operand(spec *Spec, instr Instruction, name string) (string, bool) {
+	switch name {
+	case "rd", "rs1", "rs2":
+		return d.x(regField(instr, name)), true
+	case "frd", "frs1", "frs2", "frs3":
+		return d.f(regField(instr, name)), true
+	case "shamt", "uimm":
+		return fmt.Sprint(regField(instr, name)), true
+	case "csr":
+		return CSRName(uint32(instr.ImmI()) & 0xFFF), true
+	case "rm":
+		rm, ok := roundingModeNames[instr.Funct3()]
+		return rm, ok
+	case "pred":
+		return fenceSetName(uint32(instr) >> 24 & 0xF), true
+	case "succ":
+		return fenceSetName(uint32(instr) >> 20 & 0xF), true
+	case "offset":
+		if spec.Format == FORMAT_J {
+			return d.target(instr.ImmJ()), true
+		}
+		return d.target(instr.ImmB()), true
+	}
+	switch spec.Format {
+	case FORMAT_U:
+		return fmt.Sprintf("0x%x", uint32(instr.ImmU())), true
+	case FORMAT_S:
+		return fmt.Sprint(instr.ImmS()), true
+	}
+	return fmt.Sprint(instr.ImmI()), true
+}
+
+// csrWriteMnemonics names the pseudo-instructions of the CSR instructions that
+// discard the old value (rd x0).
+var csrWriteMnemonics = map[string]string{
+	"csrrw":  "csrw",
+	"csrrs":  "csrs",
+	"csrrc":  "csrc",
+	"csrrwi": "csrwi",
+	"csrrsi": "csrsi",
+	"csrrci": "csrci",
+}
+
+// fpSignPseudos names the pseudo-instructions of the sign injections with rs1 == rs2.
+var fpSignPseudos = map[string]string{
+	"fsgnj.s":  "fmv.s",
+	"fsgnjn.s": "fneg.s",
+	"fsgnjx.s": "fabs.s",
+	"fsgnj.d":  "fmv.d",
+	"fsgnjn.d": "fneg.d",
+	"fsgnjx.d": "fabs.d",
+}
+
+// pseudo disassembles instr to the pseudo-instruction it is, if any.
+func (d *disassembler) pseudo(instr Instruction) (string, bool) {
+	spec := Decode(instr)
+	if spec == nil {
+		return "", false
+	}
+	rd, rs1, rs2 := instr.Rd(), instr.Rs1(), instr.Rs2()
+	imm := instr.ImmI()
+	switch name := spec.Mnemonic; {
+	case name == "addi" && rd == 0 && rs1 == 0 && imm == 0:
+		return "nop", true
+	case name == "addi" && rs1 == 0:
+		return format("li", d.x(rd), fmt.Sprint(imm)), true
+	case name == "addi" && imm == 0:
+		return format("mv", d.x(rd), d.x(rs1)), true
+	case name == "xori" && imm == -1:
+		return format("not", d.x(rd), d.x(rs1)), true
+	case name == "sltiu" && imm == 1:
+		return format("seqz", d.x(rd), d.x(rs1)), true
+	case name == "sub" && rs1 == 0:
+		return format("neg", d.x(rd), d.x(rs2)), true
+	case name == "sltu" && rs1 == 0:
+		return format("snez", d.x(rd), d.x(rs2)), true
+	case name == "slt" && rs2 == 0:
+		return format("sltz", d.x(rd), d.x(rs1)), true
+	case name == "slt" && rs1 == 0:
+		return format("sgtz", d.x(rd), d.x(rs2)), true
+	case spec.Opcode == OPCODE_BRANCH:
+		target := d.target(instr.ImmB())
+		switch {
+		case name == "beq" && rs2 == 0:
+			return format("beqz", d.x(rs1), target), true
+		case name == "bne" && rs2 == 0:
+			return format("bnez", d.x(rs1), target), true
+		case name == "bge" && rs1 == 0:
+			return format("blez", d.x(rs2), target), true
+		case name == "bge" && rs2 == 0:
+			return format("bgez", d.x(rs1), target), true
+		case name == "blt" && rs2 == 0:
+			return format("bltz", d.x(rs1), target), true
+		case name == "blt" && rs1 == 0:
+			return format("bgtz", d.x(rs2), target), true
+		}
+	case name == "jal" && rd == 0:
+		return format("j", d.target(instr.ImmJ())), true
+	case name == "jal" && rd == 1:
+		return format("jal", d.target(instr.ImmJ())), true
+	case name == "jalr" && imm == 0:
+		switch {
+		case rd == 0 && rs1 == 1:
+			return "ret", true
+		case rd == 0:
+			return format("jr", d.x(rs1)), true
+		case rd == 1:
+			return format("jalr", d.x(rs1)), true
+		}
+	case spec.Opcode == OPCODE_SYSTEM && spec.Funct3 != FUNCT3_PRIV:
+		csr := CSRName(uint32(imm) & 0xFFF)
+		if name == "csrrs" && rs1 == 0 {
+			return format("csrr", d.x(rd), csr), true
+		}
+		if rd == 0 {
+			source := d.x(rs1)
+			if spec.Funct3 >= FUNCT3_CSRRWI {
+				source = fmt.Sprint(rs1)
+			}
+			return format(csrWriteMnemonics[name], csr, source), true
+		}
+	case fpSignPseudos[name] != "" && rs1 == rs2:
+		return format(fpSignPseudos[name], d.f(rd), d.f(rs1)), true
+	}
+	return "", false
+}
+
+// fenceSetName converts a 4-bit IORW mask to its fence operand, e.g. "rw".
+func fenceSetName(set uint32) string {
+	var s string
+	for i, c := range "iorw" {
+		if set&(0x8>>i) != 0 {
+			s += string(c)
+		}
+	}
+	if s == "" {
+		return "0"
+	}
+	return s
+}
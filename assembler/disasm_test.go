@@ -0,0 +1,140 @@
+package assembler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDisassemble_RoundTrip(t *testing.T) {
+	for _, line := range []string{
+		"add x3, x4, x5",
+		"sub x1, x2, x3",
+		"sra x1, x2, x3",
+		"mulhsu x1, x2, x3",
+		"remu x31, x30, x29",
+		"addi x1, x0, -5",
+		"sltiu x2, x3, 2047",
+		"andi x4, x5, -2048",
+		"slli x1, x2, 31",
+		"srai x1, x2, 7",
+		"srli x1, x2, 0",
+		"lw x1, -4(x2)",
+		"lbu x1, 0(x2)",
+		"sh x3, 12(x4)",
+		"beq x1, x2, 32",
+		"bgeu x1, x2, -4096",
+		"jal x1, 2048",
+		"jalr x1, 4(x5)",
+		"lui x1, 0xfffff",
+		"auipc x3, 0x1",
+		"fence",
+		"fence rw, w",
+		"ecall",
+		"ebreak",
+		"mret",
+		"sret",
+		"wfi",
+		"sfence.vma",
+		"sfence.vma x1, x2",
+		"csrrw x1, mstatus, x2",
+		"csrrsi x0, mie, 8",
+		"csrrc x3, 0x7c0, x0",
+		"lr.w x1, (x2)",
+		"sc.w.aq x1, x2, (x3)",
+		"amoadd.w.aqrl x1, x2, (x3)",
+		"amomaxu.w.rl x1, x2, (x3)",
+		"flw f1, 8(x2)",
+		"fsd f3, -8(x4)",
+		"fadd.s f1, f2, f3",
+		"fadd.d f1, f2, f3, rtz",
+		"fsqrt.s f1, f2, rne",
+		"fsgnjn.d f1, f2, f3",
+		"fmin.s f1, f2, f3",
+		"feq.d x1, f2, f3",
+		"fclass.s x1, f2",
+		"fcvt.w.s x1, f2, rtz",
+		"fcvt.wu.d x1, f2",
+		"fcvt.s.wu f1, x2",
+		"fcvt.d.w f1, x2",
+		"fcvt.s.d f1, f2",
+		"fcvt.d.s f1, f2",
+		"fmv.x.w x1, f2",
+		"fmv.w.x f1, x2",
+		"fmadd.s f1, f2, f3, f4",
+		"fnmsub.d f1, f2, f3, f4, rup",
+	} {
+		instr := mustParse(line)
+		text := Disassemble(instr, 0, DisasmOptions{})
+		text = strings.TrimSpace(strings.Split(text, "#")[0])
+		assert.Equal(t, line, text, "disassembly of %08x", uint32(instr))
+		again, err := ParseInstruction(text)
+		if assert.NoError(t, err, text) {
+			assert.Equal(t, instr, again, "%q assembles to the same instruction", text)
+		}
+	}
+}
+
+func TestDisassemble_Pseudo(t *testing.T) {
+	opts := DisasmOptions{Pseudo: true}
+	for line, want := range map[string]string{
+		"addi x0, x0, 0":      "nop",
+		"addi x5, x0, -1":     "li x5, -1",
+		"addi x5, x6, 0":      "mv x5, x6",
+		"xori x5, x6, -1":     "not x5, x6",
+		"sub x5, x0, x6":      "neg x5, x6",
+		"sltiu x5, x6, 1":     "seqz x5, x6",
+		"sltu x5, x0, x6":     "snez x5, x6",
+		"slt x5, x6, x0":      "sltz x5, x6",
+		"slt x5, x0, x6":      "sgtz x5, x6",
+		"beq x5, x0, 8":       "beqz x5, 8 # 0x00000108",
+		"bne x5, x0, 8":       "bnez x5, 8 # 0x00000108",
+		"bge x0, x5, 8":       "blez x5, 8 # 0x00000108",
+		"bge x5, x0, 8":       "bgez x5, 8 # 0x00000108",
+		"blt x5, x0, 8":       "bltz x5, 8 # 0x00000108",
+		"blt x0, x5, 8":       "bgtz x5, 8 # 0x00000108",
+		"jal x0, -8":          "j -8 # 0x000000f8",
+		"jal x1, 16":          "jal 16 # 0x00000110",
+		"jal x5, 16":          "jal x5, 16 # 0x00000110",
+		"jalr x0, 0(x1)":      "ret",
+		"jalr x0, 0(x6)":      "jr x6",
+		"jalr x1, 0(x6)":      "jalr x6",
+		"jalr x1, 4(x6)":      "jalr x1, 4(x6)",
+		"csrrs x5, mepc, x0":  "csrr x5, mepc",
+		"csrrw x0, mtvec, x5": "csrw mtvec, x5",
+		"csrrc x0, mie, x5":   "csrc mie, x5",
+		"csrrsi x0, mie, 8":   "csrsi mie, 8",
+		"csrrw x1, mtvec, x5": "csrrw x1, mtvec, x5",
+		"fsgnj.s f1, f2, f2":  "fmv.s f1, f2",
+		"fsgnjn.d f1, f2, f2": "fneg.d f1, f2",
+		"fsgnjx.s f1, f2, f2": "fabs.s f1, f2",
+		"fsgnj.s f1, f2, f3":  "fsgnj.s f1, f2, f3",
+		"add x1, x2, x3":      "add x1, x2, x3",
+	} {
+		assert.Equal(t, want, Disassemble(mustParse(line), 0x100, opts), line)
+	}
+}
+
+func TestDisassemble_Options(t *testing.T) {
+	instr := mustParse("addi x10, x2, 16")
+	assert.Equal(t, "addi a0, sp, 16", Disassemble(instr, 0, DisasmOptions{ABINames: true}))
+	assert.Equal(t, "fadd.d fa0, ft0, fs11", Disassemble(mustParse("fadd.d f10, f0, f27"), 0, DisasmOptions{ABINames: true}))
+	assert.Equal(t, "ret", Disassemble(mustParse("jalr x0, 0(x1)"), 0, DisasmOptions{ABINames: true, Pseudo: true}))
+
+	labels := Labels(map[string]uint32{"loop": 0x40, "_start": 0x40, "end": 0x80})
+	assert.Equal(t, map[uint32]string{0x40: "_start", 0x80: "end"}, labels)
+	opts := DisasmOptions{Labels: labels}
+	assert.Equal(t, "bne x1, x2, _start", Disassemble(mustParse("bne x1, x2, -16"), 0x50, opts))
+	assert.Equal(t, "jal x0, end", Disassemble(mustParse("jal x0, 48"), 0x50, opts))
+	assert.Equal(t, "jal x0, 4 # 0x00000054", Disassemble(mustParse("jal x0, 4"), 0x50, opts))
+}
+
+func TestDisassemble_CompressedAndInvalid(t *testing.T) {
+	c, ok := Compress(mustParse("addi x8, x8, 1"))
+	assert.True(t, ok)
+	assert.Equal(t, "addi x8, x8, 1", Disassemble(Instruction(c), 0, DisasmOptions{}), "compressed instructions are shown expanded")
+	assert.Equal(t, ".half 0x0000", Disassemble(0, 0, DisasmOptions{}))
+	assert.Equal(t, ".word 0xffffffff", Disassemble(0xFFFFFFFF, 0, DisasmOptions{}))
+	assert.Equal(t, ".word 0x0000700f", Disassemble(0x0000700F, 0, DisasmOptions{}), "MISC-MEM with funct3 7")
+}
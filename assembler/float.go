@@ -0,0 +1,42 @@
+package assembler
+
+import (
+	"fmt"
+)
+
+// FPRegABINames holds the ABI names of the FP registers f0-f31.
+var FPRegABINames = [32]string{
+	"ft0", "ft1", "ft2", "ft3", "ft4", "ft5", "ft6", "ft7",
+	"fs0", "fs1", "fa0", "fa1", "fa2", "fa3", "fa4", "fa5",
+	"fa6", "fa7", "fs2", "fs3", "fs4", "fs5", "fs6", "fs7",
+	"fs8", "fs9", "fs10", "fs11", "ft8", "ft9", "ft10", "ft11",
+}
+
+// fpRegNames maps both the numeric (f0-f31) and the ABI names of the FP registers to register numbers.
+var fpRegNames = func() map[string]uint32 {
+	names := make(map[string]uint32)
+	for i, abi := range FPRegABINames {
+		names[fmt.Sprintf("f%d", i)] = uint32(i)
+		names[abi] = uint32(i)
+	}
+	return names
+}()
+
+// roundingModes maps the optional rounding mode operand of FP instructions to its rm encoding.
+var roundingModes = map[string]uint32{
+	"rne": RM_RNE,
+	"rtz": RM_RTZ,
+	"rdn": RM_RDN,
+	"rup": RM_RUP,
+	"rmm": RM_RMM,
+	"dyn": RM_DYN,
+}
+
+// parseFPReg returns the number of an FP register given by name.
+func parseFPReg(name string) (uint32, error) {
+	reg, ok := fpRegNames[name]
+	if !ok {
+		return 0, fmt.Errorf("invalid FP register: %q", name)
+	}
+	return reg, nil
+}
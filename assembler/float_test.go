@@ -0,0 +1,89 @@
+package assembler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Reference encodings as produced by the GNU assembler for rv32imafd.
+func TestParseInstruction_FloatEncodings(t *testing.T) {
+	cases := []struct {
+		asm  string
+		want uint32
+	}{
+		{"flw ft0, 8(x2)", 0x00812007},
+		{"fld f1, -16(x8)", 0xFF043087},
+		{"fsw fa0, 4(x2)", 0x00A12227},
+		{"fsd fs11, -8(x3)", 0xFFB1BC27},
+		{"fadd.s fa0, fa0, fa1", 0x00B57553},
+		{"fadd.d f1, f2, f3, rtz", 0x023110D3},
+		{"fsub.s f1, f2, f3", 0x083170D3},
+		{"fmul.d f1, f2, f3, rne", 0x123100D3},
+		{"fdiv.s f1, f2, f3", 0x183170D3},
+		{"fsqrt.d f1, f2", 0x5A0170D3},
+		{"fsgnj.s f1, f2, f3", 0x203100D3},
+		{"fsgnjn.d f1, f2, f3", 0x223110D3},
+		{"fsgnjx.s f1, f2, f3", 0x203120D3},
+		{"fmin.s f1, f2, f3", 0x283100D3},
+		{"fmax.d f1, f2, f3", 0x2A3110D3},
+		{"fcvt.s.d f1, f2", 0x401170D3},
+		{"fcvt.d.s fa0, fa0", 0x42050553},
+		{"feq.d x1, f2, f3", 0xA23120D3},
+		{"flt.s x1, f2, f3", 0xA03110D3},
+		{"fle.s x1, f2, f3", 0xA03100D3},
+		{"fclass.s x1, f2", 0xE00110D3},
+		{"fcvt.w.s x10, fa0, rtz", 0xC0051553},
+		{"fcvt.wu.d x10, fa0", 0xC2157553},
+		{"fcvt.s.w f1, x10", 0xD00570D3},
+		{"fcvt.d.wu f1, x10", 0xD21500D3},
+		{"fmv.x.w x5, f6", 0xE00302D3},
+		{"fmv.w.x f6, x5", 0xF0028353},
+		{"fmadd.s f1, f2, f3, f4", 0x203170C3},
+		{"fmsub.d f1, f2, f3, f4, rdn", 0x223120C7},
+		{"fnmsub.s f1, f2, f3, f4", 0x203170CB},
+		{"fnmadd.d f1, f2, f3, f4", 0x223170CF},
+	}
+	for _, tc := range cases {
+		t.Run(tc.asm, func(t *testing.T) {
+			instr, err := ParseInstruction(tc.asm)
+			if assert.NoError(t, err) {
+				assert.Equalf(t, tc.want, uint32(instr), "encoding 0x%08X", uint32(instr))
+			}
+		})
+	}
+}
+
+func TestParseFPReg(t *testing.T) {
+	cases := map[string]uint32{
+		"f0": 0, "f31": 31,
+		"ft0": 0, "ft7": 7, "fs0": 8, "fs1": 9, "fa0": 10, "fa7": 17,
+		"fs2": 18, "fs11": 27, "ft8": 28, "ft11": 31,
+	}
+	for name, want := range cases {
+		got, err := parseFPReg(name)
+		assert.NoError(t, err, name)
+		assert.Equal(t, want, got, name)
+	}
+	for i, name := range FPRegABINames {
+		got, _ := parseFPReg(name)
+		assert.Equal(t, uint32(i), got, name)
+	}
+}
+
+func TestParseInstruction_FloatErrors(t *testing.T) {
+	cases := []string{
+		"fadd.s f1, f2",           // missing operand
+		"fadd.s f1, f2, f32",      // no such register
+		"fadd.s f1, f2, x3",       // integer register
+		"fadd.s f1, f2, f3, rxx",  // unknown rounding mode
+		"fsgnj.s f1, f2, f3, rne", // no rounding mode
+		"fcvt.w.s f1, f2",         // destination must be an integer register
+		"flw f1, 4096(x2)",        // immediate out of range
+		"fsw fb1, 0(x2)",
+	}
+	for _, asm := range cases {
+		_, err := ParseInstruction(asm)
+		assert.Errorf(t, err, "ParseInstruction(%q)", asm)
+	}
+}
@@ -32,6 +32,31 @@ func (i Instruction) Funct7() uint32 {
 	return (uint32(i) >> 25) & 0x7F
 }
 
+// Funct5 returns the upper five bits of funct7, which select the AMO operation.
+func (i Instruction) Funct5() uint32 {
+	return (uint32(i) >> 27) & 0x1F
+}
+
+// Aq returns the acquire ordering bit of an AMO instruction.
+func (i Instruction) Aq() bool {
+	return (uint32(i)>>26)&0x1 != 0
+}
+
+// Rl returns the release ordering bit of an AMO instruction.
+func (i Instruction) Rl() bool {
+	return (uint32(i)>>25)&0x1 != 0
+}
+
+// Rs3 returns the third source register of the fused multiply-add instructions (bits 27-31).
+func (i Instruction) Rs3() uint32 {
+	return i.Funct5()
+}
+
+// Fmt returns the format field of FP instructions (bits 25-26), FMT_S or FMT_D.
+func (i Instruction) Fmt() uint32 {
+	return (uint32(i) >> 25) & 0x3
+}
+
 func (i *Instruction) SetOpcode(opcode Opcode) {
 	*i = Instruction((uint32(*i) &^ 0x7F) | (uint32(opcode) & 0x7F))
 }
@@ -56,6 +81,29 @@ func (i *Instruction) SetFunct7(funct7 uint32) {
 	*i = Instruction((uint32(*i) &^ (0x7F << 25)) | ((funct7 & 0x7F) << 25))
 }
 
+func (i *Instruction) SetFunct5(funct5 uint32) {
+	*i = Instruction((uint32(*i) &^ (0x1F << 27)) | ((funct5 & 0x1F) << 27))
+}
+
+func (i *Instruction) SetRs3(rs3 uint32) {
+	i.SetFunct5(rs3)
+}
+
+func (i *Instruction) SetFmt(format uint32) {
+	*i = Instruction((uint32(*i) &^ (0x3 << 25)) | ((format & 0x3) << 25))
+}
+
+func (i *Instruction) SetAqRl(aq, rl bool) {
+	ui := uint32(*i) &^ (0x3 << 25)
+	if aq {
+		ui |= 1 << 26
+	}
+	if rl {
+		ui |= 1 << 25
+	}
+	*i = Instruction(ui)
+}
+
 func (i Instruction) ImmI() int32 {
 	// 12 Bit signed immediate (bits 20-31)
 	imm := int32(uint32(i) >> 20)
@@ -102,6 +150,11 @@ func (i Instruction) ImmJ() int32 {
 	return imm
 }
 
+func (i Instruction) ImmU() int32 {
+	// U-type: 20-bit immediate (bits 12-31), not shifted
+	return int32(uint32(i) >> 12)
+}
+
 func (i *Instruction) SetImmI(imm int32) {
 	// 12-bit signed immediate at bits 20-31
 	ui := uint32(*i) &^ (0xFFF << 20)
@@ -148,18 +201,28 @@ func (i *Instruction) SetImmJ(imm int32) {
 	*i = Instruction(ui)
 }
 
+func (i *Instruction) SetImmU(imm int32) {
+	// U-type: 20-bit immediate at bits 12-31
+	ui := uint32(*i) &^ (0xFFFFF << 12)
+	*i = Instruction(ui | ((uint32(imm) & 0xFFFFF) << 12))
+}
+
 func (i Instruction) Type() string {
 	switch i.Opcode() {
-	case OPCODE_R_TYPE:
+	case OPCODE_R_TYPE, OPCODE_AMO, OPCODE_OP_FP:
 		return "R"
-	case OPCODE_I_TYPE, OPCODE_LOAD, OPCODE_JALR:
+	case OPCODE_FMADD, OPCODE_FMSUB, OPCODE_FNMSUB, OPCODE_FNMADD:
+		return "R4"
+	case OPCODE_I_TYPE, OPCODE_LOAD, OPCODE_JALR, OPCODE_MISC_MEM, OPCODE_SYSTEM, OPCODE_LOAD_FP:
 		return "I"
-	case OPCODE_STORE:
+	case OPCODE_STORE, OPCODE_STORE_FP:
 		return "S"
 	case OPCODE_BRANCH:
 		return "B"
 	case OPCODE_JAL:
 		return "J"
+	case OPCODE_LUI, OPCODE_AUIPC:
+		return "U"
 	default:
 		return "unknown"
 	}
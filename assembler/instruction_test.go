@@ -85,6 +85,7 @@ func TestInstructionType(t *testing.T) {
 		{"S-Type", OPCODE_STORE, "S"},
 		{"B-Type", OPCODE_BRANCH, "B"},
 		{"J-Type", OPCODE_JAL, "J"},
+		{"U-Type", OPCODE_LUI, "U"},
 		{"Unknown", 0x7F, "unknown"},
 	}
 
@@ -141,6 +142,16 @@ func TestInstructionJTypeImmediate(t *testing.T) {
 	assert.Equal(t, int32(-1048576), inst.ImmJ(), "ImmJ")
 }
 
+// Test U-type immediate encoding and decoding.
+func TestInstructionUTypeImmediate(t *testing.T) {
+	var inst Instruction
+	inst.SetImmU(0xFFFFF)
+	assert.Equal(t, int32(0xFFFFF), inst.ImmU(), "ImmU")
+	inst.SetImmU(0x12345)
+	assert.Equal(t, int32(0x12345), inst.ImmU(), "ImmU")
+	assert.Equal(t, uint32(0x12345000), uint32(inst), "ImmU leaves low bits untouched")
+}
+
 // Test that Opcode() returns the correct value or OPCODE_INVALID for a variety of raw instruction values.
 func TestInstruction_OpcodeReturnsExpectedValue(t *testing.T) {
 	for v := uint32(0); v <= 0x7F; v++ {
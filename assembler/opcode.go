@@ -25,6 +25,28 @@ const (
 	// J-Type (jal)
 	OPCODE_JAL Opcode = 0x6F
 
+	// U-Type (lui, auipc)
+	OPCODE_LUI   Opcode = 0x37
+	OPCODE_AUIPC Opcode = 0x17
+
+	// FENCE
+	OPCODE_MISC_MEM Opcode = 0x0F
+
+	// ECALL, EBREAK
+	OPCODE_SYSTEM Opcode = 0x73
+
+	// A extension (lr.w, sc.w, amo*.w)
+	OPCODE_AMO Opcode = 0x2F
+
+	// F and D extensions
+	OPCODE_LOAD_FP  Opcode = 0x07
+	OPCODE_STORE_FP Opcode = 0x27
+	OPCODE_OP_FP    Opcode = 0x53
+	OPCODE_FMADD    Opcode = 0x43
+	OPCODE_FMSUB    Opcode = 0x47
+	OPCODE_FNMSUB   Opcode = 0x4B
+	OPCODE_FNMADD   Opcode = 0x4F
+
 	// Special value for invalid/unknown opcodes
 	OPCODE_INVALID Opcode = 0xFF
 )
@@ -37,26 +59,141 @@ const (
 	FUNCT3_XOR     uint32 = 0x4
 	FUNCT3_SLLI    uint32 = 0x1
 	FUNCT3_SLT     uint32 = 0x2
+	FUNCT3_SLL     uint32 = 0x1
+	FUNCT3_SLTU    uint32 = 0x3
+	FUNCT3_SRL_SRA uint32 = 0x5
+
+	FUNCT3_BEQ  uint32 = 0x0
+	FUNCT3_BNE  uint32 = 0x1
+	FUNCT3_BLT  uint32 = 0x4
+	FUNCT3_BGE  uint32 = 0x5
+	FUNCT3_BLTU uint32 = 0x6
+	FUNCT3_BGEU uint32 = 0x7
 
-	FUNCT3_BEQ uint32 = 0x0
-	FUNCT3_BNE uint32 = 0x1
+	FUNCT3_LB  uint32 = 0x0
+	FUNCT3_LH  uint32 = 0x1
+	FUNCT3_LW  uint32 = 0x2
+	FUNCT3_LBU uint32 = 0x4
+	FUNCT3_LHU uint32 = 0x5
 
-	FUNCT3_LW uint32 = 0x2
+	FUNCT3_SB uint32 = 0x0
+	FUNCT3_SH uint32 = 0x1
 	FUNCT3_SW uint32 = 0x2
 
-	FUNCT3_ADDI uint32 = 0x0
-	FUNCT3_ANDI uint32 = 0x7
-	FUNCT3_ORI  uint32 = 0x6
+	FUNCT3_ADDI      uint32 = 0x0
+	FUNCT3_SLTI      uint32 = 0x2
+	FUNCT3_SLTIU     uint32 = 0x3
+	FUNCT3_XORI      uint32 = 0x4
+	FUNCT3_ANDI      uint32 = 0x7
+	FUNCT3_ORI       uint32 = 0x6
+	FUNCT3_SRLI_SRAI uint32 = 0x5
 
 	FUNCT3_JALR uint32 = 0x0
+
+	FUNCT3_FENCE uint32 = 0x0
+
+	// M extension (funct7 FUNCT7_MULDIV)
+	FUNCT3_MUL    uint32 = 0x0
+	FUNCT3_MULH   uint32 = 0x1
+	FUNCT3_MULHSU uint32 = 0x2
+	FUNCT3_MULHU  uint32 = 0x3
+	FUNCT3_DIV    uint32 = 0x4
+	FUNCT3_DIVU   uint32 = 0x5
+	FUNCT3_REM    uint32 = 0x6
+	FUNCT3_REMU   uint32 = 0x7
+
+	// A extension: all RV32A operations are word sized
+	FUNCT3_AMO_W uint32 = 0x2
+
+	FUNCT3_PRIV uint32 = 0x0
+
+	// F and D extensions: loads/stores select the width, OP-FP operations without
+	// a rounding mode use funct3 to select a variant
+	FUNCT3_FLW    uint32 = 0x2
+	FUNCT3_FLD    uint32 = 0x3
+	FUNCT3_FSW    uint32 = 0x2
+	FUNCT3_FSD    uint32 = 0x3
+	FUNCT3_FSGNJ  uint32 = 0x0
+	FUNCT3_FSGNJN uint32 = 0x1
+	FUNCT3_FSGNJX uint32 = 0x2
+	FUNCT3_FMIN   uint32 = 0x0
+	FUNCT3_FMAX   uint32 = 0x1
+	FUNCT3_FLE    uint32 = 0x0
+	FUNCT3_FLT    uint32 = 0x1
+	FUNCT3_FEQ    uint32 = 0x2
+	FUNCT3_FMV_X  uint32 = 0x0
+	FUNCT3_FCLASS uint32 = 0x1
+)
+
+// Rounding modes, stored in the funct3 field of FP arithmetic instructions and in the frm CSR field
+const (
+	RM_RNE uint32 = 0x0 // round to nearest, ties to even
+	RM_RTZ uint32 = 0x1 // round towards zero
+	RM_RDN uint32 = 0x2 // round down (towards -inf)
+	RM_RUP uint32 = 0x3 // round up (towards +inf)
+	RM_RMM uint32 = 0x4 // round to nearest, ties to max magnitude
+	RM_DYN uint32 = 0x7 // use the dynamic rounding mode in frm
 )
 
-// Funct7 field values (only relevant for add/sub)
+// Funct7 field values (add/sub, the logical/arithmetic shifts and the M extension)
 const (
-	FUNCT7_ADD uint32 = 0x00
-	FUNCT7_SUB uint32 = 0x20
+	FUNCT7_ADD    uint32 = 0x00
+	FUNCT7_SUB    uint32 = 0x20
+	FUNCT7_SRL    uint32 = 0x00
+	FUNCT7_SRA    uint32 = 0x20
+	FUNCT7_MULDIV uint32 = 0x01
 )
 
+// Funct5 field values of the AMO opcode (bits 27-31, above the aq/rl bits)
+const (
+	FUNCT5_LR      uint32 = 0x02
+	FUNCT5_SC      uint32 = 0x03
+	FUNCT5_AMOSWAP uint32 = 0x01
+	FUNCT5_AMOADD  uint32 = 0x00
+	FUNCT5_AMOXOR  uint32 = 0x04
+	FUNCT5_AMOAND  uint32 = 0x0C
+	FUNCT5_AMOOR   uint32 = 0x08
+	FUNCT5_AMOMIN  uint32 = 0x10
+	FUNCT5_AMOMAX  uint32 = 0x14
+	FUNCT5_AMOMINU uint32 = 0x18
+	FUNCT5_AMOMAXU uint32 = 0x1C
+)
+
+// Funct5 field values of the OP-FP opcode (bits 27-31, above the fmt field)
+const (
+	FUNCT5_FADD      uint32 = 0x00
+	FUNCT5_FSUB      uint32 = 0x01
+	FUNCT5_FMUL      uint32 = 0x02
+	FUNCT5_FDIV      uint32 = 0x03
+	FUNCT5_FSGNJ     uint32 = 0x04
+	FUNCT5_FMINMAX   uint32 = 0x05
+	FUNCT5_FCVT_FMT  uint32 = 0x08 // fcvt.s.d, fcvt.d.s
+	FUNCT5_FSQRT     uint32 = 0x0B
+	FUNCT5_FCMP      uint32 = 0x14
+	FUNCT5_FCVT_TO_X uint32 = 0x18 // fcvt.w[u].{s,d}
+	FUNCT5_FCVT_TO_F uint32 = 0x1A // fcvt.{s,d}.w[u]
+	FUNCT5_FMV_X     uint32 = 0x1C // fmv.x.w, fclass
+	FUNCT5_FMV_F     uint32 = 0x1E // fmv.w.x
+)
+
+// Fmt field values of FP instructions (bits 25-26)
+const (
+	FMT_S uint32 = 0x0
+	FMT_D uint32 = 0x1
+)
+
+// Funct12 field values of the SYSTEM opcode (stored in the I-type immediate)
+const (
+	FUNCT12_ECALL  uint32 = 0x000
+	FUNCT12_EBREAK uint32 = 0x001
+	FUNCT12_SRET   uint32 = 0x102
+	FUNCT12_WFI    uint32 = 0x105
+	FUNCT12_MRET   uint32 = 0x302
+)
+
+// FUNCT7_SFENCE_VMA is the funct7 field of SFENCE.VMA (SYSTEM opcode, funct3 PRIV).
+const FUNCT7_SFENCE_VMA uint32 = 0x09
+
 func (op Opcode) String() string {
 	switch op {
 	case OPCODE_R_TYPE:
@@ -73,6 +210,30 @@ func (op Opcode) String() string {
 		return "BRANCH"
 	case OPCODE_JAL:
 		return "JAL"
+	case OPCODE_LUI:
+		return "LUI"
+	case OPCODE_AUIPC:
+		return "AUIPC"
+	case OPCODE_MISC_MEM:
+		return "MISC-MEM"
+	case OPCODE_SYSTEM:
+		return "SYSTEM"
+	case OPCODE_AMO:
+		return "AMO"
+	case OPCODE_LOAD_FP:
+		return "LOAD-FP"
+	case OPCODE_STORE_FP:
+		return "STORE-FP"
+	case OPCODE_OP_FP:
+		return "OP-FP"
+	case OPCODE_FMADD:
+		return "FMADD"
+	case OPCODE_FMSUB:
+		return "FMSUB"
+	case OPCODE_FNMSUB:
+		return "FNMSUB"
+	case OPCODE_FNMADD:
+		return "FNMADD"
 	default:
 		return fmt.Sprintf("Unknown(0x%X)", uint32(op))
 	}
@@ -87,7 +248,19 @@ func IsValidOpcode(op Opcode) bool {
 		OPCODE_LOAD,
 		OPCODE_STORE,
 		OPCODE_BRANCH,
-		OPCODE_JAL:
+		OPCODE_JAL,
+		OPCODE_LUI,
+		OPCODE_AUIPC,
+		OPCODE_MISC_MEM,
+		OPCODE_SYSTEM,
+		OPCODE_AMO,
+		OPCODE_LOAD_FP,
+		OPCODE_STORE_FP,
+		OPCODE_OP_FP,
+		OPCODE_FMADD,
+		OPCODE_FMSUB,
+		OPCODE_FNMSUB,
+		OPCODE_FNMADD:
 		return true
 	default:
 		return false
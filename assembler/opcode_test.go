@@ -19,6 +19,16 @@ func TestOpcodeConstants(t *testing.T) {
 		{"OPCODE_STORE", OPCODE_STORE, 0x23},
 		{"OPCODE_BRANCH", OPCODE_BRANCH, 0x63},
 		{"OPCODE_JAL", OPCODE_JAL, 0x6F},
+		{"OPCODE_LUI", OPCODE_LUI, 0x37},
+		{"OPCODE_AUIPC", OPCODE_AUIPC, 0x17},
+		{"OPCODE_MISC_MEM", OPCODE_MISC_MEM, 0x0F},
+		{"OPCODE_SYSTEM", OPCODE_SYSTEM, 0x73},
+		{"OPCODE_AMO", OPCODE_AMO, 0x2F},
+		{"OPCODE_LOAD_FP", OPCODE_LOAD_FP, 0x07},
+		{"OPCODE_STORE_FP", OPCODE_STORE_FP, 0x27},
+		{"OPCODE_OP_FP", OPCODE_OP_FP, 0x53},
+		{"OPCODE_FMADD", OPCODE_FMADD, 0x43},
+		{"OPCODE_FNMADD", OPCODE_FNMADD, 0x4F},
 	}
 
 	for _, tc := range cases {
@@ -46,6 +56,12 @@ func TestFunct3Constants(t *testing.T) {
 		{"FUNCT3_ANDI", FUNCT3_ANDI, 0x7},
 		{"FUNCT3_ORI", FUNCT3_ORI, 0x6},
 		{"FUNCT3_JALR", FUNCT3_JALR, 0x0},
+		{"FUNCT3_SLTU", FUNCT3_SLTU, 0x3},
+		{"FUNCT3_SRL_SRA", FUNCT3_SRL_SRA, 0x5},
+		{"FUNCT3_BLT", FUNCT3_BLT, 0x4},
+		{"FUNCT3_BGEU", FUNCT3_BGEU, 0x7},
+		{"FUNCT3_LBU", FUNCT3_LBU, 0x4},
+		{"FUNCT3_SH", FUNCT3_SH, 0x1},
 	}
 
 	for _, tc := range consts {
@@ -56,6 +72,7 @@ func TestFunct3Constants(t *testing.T) {
 func TestFunct7Constants(t *testing.T) {
 	assert.Equal(t, uint32(0x00), FUNCT7_ADD, "FUNCT7_ADD")
 	assert.Equal(t, uint32(0x20), FUNCT7_SUB, "FUNCT7_SUB")
+	assert.Equal(t, uint32(0x20), FUNCT7_SRA, "FUNCT7_SRA")
 }
 
 func TestOpcodeStringer(t *testing.T) {
@@ -82,13 +99,25 @@ func TestIsValidOpcode(t *testing.T) {
 		OPCODE_STORE,
 		OPCODE_BRANCH,
 		OPCODE_JAL,
+		OPCODE_LUI,
+		OPCODE_AUIPC,
+		OPCODE_MISC_MEM,
+		OPCODE_SYSTEM,
+		OPCODE_AMO,
+		OPCODE_LOAD_FP,
+		OPCODE_STORE_FP,
+		OPCODE_OP_FP,
+		OPCODE_FMADD,
+		OPCODE_FMSUB,
+		OPCODE_FNMSUB,
+		OPCODE_FNMADD,
 	}
 	for _, op := range validOpcodes {
 		assert.Equal(t, true, IsValidOpcode(op), "IsValidOpcode valid")
 	}
 
 	invalidOpcodes := []Opcode{
-		0x0, 0x1, 0x2, 0x5, 0x7, 0x12, 0x14, 0x20, 0xF0, 0xFF, 0x80, 0xDEADBEEF,
+		0x0, 0x1, 0x2, 0x5, 0x8, 0x12, 0x14, 0x20, 0xF0, 0xFF, 0x80, 0xDEADBEEF,
 	}
 	for _, op := range invalidOpcodes {
 		assert.Equal(t, false, IsValidOpcode(op), "IsValidOpcode invalid")
@@ -1,6 +1,7 @@
 package assembler
 
 import (
+	"errors"
 	"fmt"
 	"regexp"
 	"strconv"
@@ -17,16 +18,42 @@ func parseOperands(operands string, re *regexp.Regexp, mnemonic string) ([]strin
 	return matches, nil
 }
 
-// parseInt parses a string as int64.
-func parseInt(s string) int64 {
-	v, _ := strconv.ParseInt(s, 10, 32)
-	return v
+// parseInt parses a decimal or 0x-prefixed hexadecimal string, optionally
+// preceded by a minus sign, as a 32-bit signed value.
+func parseInt(s string) (int64, error) {
+	digits, base := numberBase(strings.TrimPrefix(s, "-"))
+	if strings.HasPrefix(s, "-") {
+		digits = "-" + digits
+	}
+	v, err := strconv.ParseInt(digits, base, 32)
+	return v, immediateError(s, err)
 }
 
-// parseUint parses a string as uint32.
-func parseUint(s string) uint32 {
-	v, _ := strconv.ParseUint(s, 10, 32)
-	return uint32(v)
+// parseUint parses a decimal or 0x-prefixed hexadecimal string as uint32.
+func parseUint(s string) (uint32, error) {
+	digits, base := numberBase(s)
+	v, err := strconv.ParseUint(digits, base, 32)
+	return uint32(v), immediateError(s, err)
+}
+
+// numberBase strips the 0x prefix of a hexadecimal number and returns its base.
+// Anything else is decimal, so a leading zero doesn't make a number octal.
+func numberBase(s string) (string, int) {
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		return s[2:], 16
+	}
+	return s, 10
+}
+
+// immediateError describes an error of strconv for the immediate s.
+func immediateError(s string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, strconv.ErrRange) {
+		return fmt.Errorf("immediate out of range: %s", s)
+	}
+	return fmt.Errorf("invalid immediate: %q", s)
 }
 
 // ParseInstruction parses a single RISC-V assembler instruction (e.g. "addi x1, x0, 5")
@@ -42,177 +69,66 @@ func ParseInstruction(line string) (Instruction, error) {
 		return 0, fmt.Errorf("invalid instruction: %q", line)
 	}
 	mnemonic := parts[0]
+	if expanded, err := preprocessPseudoInstructions(line); err != nil {
+		return 0, err
+	} else if len(expanded) != 1 {
+		return 0, fmt.Errorf("%s expands to %d instructions", mnemonic, len(expanded))
+	} else if expanded[0] != line {
+		return ParseInstruction(expanded[0])
+	}
 	operands := strings.Join(parts[1:], "")
 	operands = removeAllWhitespace(operands)
 
-	switch mnemonic {
-	case "addi":
-		re := regexp.MustCompile(`^x(\d+),x(\d+),(-?\d+)$`)
-		m, err := parseOperands(operands, re, mnemonic)
-		if err != nil {
-			return 0, err
-		}
-		rd, rs1, imm := parseUint(m[1]), parseUint(m[2]), parseInt(m[3])
-		if imm < -2048 || imm > 2047 {
-			return 0, fmt.Errorf("immediate out of range for addi: %d", imm)
-		}
-		var instr Instruction
-		instr.SetOpcode(OPCODE_I_TYPE)
-		instr.SetRd(rd)
-		instr.SetRs1(rs1)
-		instr.SetFunct3(FUNCT3_ADDI)
-		instr.SetImmI(int32(imm))
-		return instr, nil
-	case "add":
-		re := regexp.MustCompile(`^x(\d+),x(\d+),x(\d+)$`)
-		m, err := parseOperands(operands, re, mnemonic)
-		if err != nil {
-			return 0, err
-		}
-		rd, rs1, rs2 := parseUint(m[1]), parseUint(m[2]), parseUint(m[3])
-		var instr Instruction
-		instr.SetOpcode(OPCODE_R_TYPE)
-		instr.SetRd(rd)
-		instr.SetRs1(rs1)
-		instr.SetRs2(rs2)
-		instr.SetFunct3(FUNCT3_ADD_SUB)
-		instr.SetFunct7(FUNCT7_ADD)
-		return instr, nil
-	case "beq", "bne":
-		re := regexp.MustCompile(`^x(\d+),x(\d+),(-?\d+)$`)
-		m, err := parseOperands(operands, re, mnemonic)
-		if err != nil {
-			return 0, err
-		}
-		rs1, rs2, imm := parseUint(m[1]), parseUint(m[2]), parseInt(m[3])
-		if imm < -4096 || imm > 4095 {
-			return 0, fmt.Errorf("immediate out of range for %s: %d", mnemonic, imm)
-		}
-		var instr Instruction
-		instr.SetOpcode(OPCODE_BRANCH)
-		instr.SetRs1(rs1)
-		instr.SetRs2(rs2)
-		if mnemonic == "beq" {
-			instr.SetFunct3(FUNCT3_BEQ)
-		} else {
-			instr.SetFunct3(FUNCT3_BNE)
-		}
-		instr.SetImmB(int32(imm))
-		return instr, nil
-	case "jal":
-		re := regexp.MustCompile(`^x(\d+),(-?\d+)$`)
-		m, err := parseOperands(operands, re, mnemonic)
-		if err != nil {
-			return 0, err
-		}
-		rd, imm := parseUint(m[1]), parseInt(m[2])
-		if imm < -(1<<20) || imm > (1<<20)-1 {
-			return 0, fmt.Errorf("immediate out of range for jal: %d", imm)
-		}
-		var instr Instruction
-		instr.SetOpcode(OPCODE_JAL)
-		instr.SetRd(rd)
-		instr.SetImmJ(int32(imm))
-		return instr, nil
-	case "jalr":
-		re := regexp.MustCompile(`^x(\d+),(-?\d+)\(x(\d+)\)$`)
-		m, err := parseOperands(operands, re, mnemonic)
-		if err != nil {
-			return 0, err
-		}
-		rd, imm, rs1 := parseUint(m[1]), parseInt(m[2]), parseUint(m[3])
-		if imm < -2048 || imm > 2047 {
-			return 0, fmt.Errorf("immediate out of range for jalr: %d", imm)
-		}
-		var instr Instruction
-		instr.SetOpcode(OPCODE_JALR)
-		instr.SetRd(rd)
-		instr.SetRs1(rs1)
-		instr.SetFunct3(FUNCT3_JALR)
-		instr.SetImmI(int32(imm))
-		return instr, nil
-	case "lw":
-		re := regexp.MustCompile(`^x(\d+),(-?\d+)\(x(\d+)\)$`)
-		m, err := parseOperands(operands, re, mnemonic)
-		if err != nil {
-			return 0, err
-		}
-		rd, imm, rs1 := parseUint(m[1]), parseInt(m[2]), parseUint(m[3])
-		if imm < -2048 || imm > 2047 {
-			return 0, fmt.Errorf("immediate out of range for lw: %d", imm)
-		}
-		var instr Instruction
-		instr.SetOpcode(OPCODE_LOAD)
-		instr.SetRd(rd)
-		instr.SetRs1(rs1)
-		instr.SetFunct3(FUNCT3_LW)
-		instr.SetImmI(int32(imm))
-		return instr, nil
-	case "slli":
-		re := regexp.MustCompile(`^x(\d+),x(\d+),(\d+)$`)
-		m, err := parseOperands(operands, re, mnemonic)
-		if err != nil {
-			return 0, err
-		}
-		rd, rs1, shamt := parseUint(m[1]), parseUint(m[2]), parseUint(m[3])
-		if shamt > 31 {
-			return 0, fmt.Errorf("shift amount out of range for slli: %d", shamt)
-		}
-		var instr Instruction
-		instr.SetOpcode(OPCODE_I_TYPE)
-		instr.SetRd(rd)
-		instr.SetRs1(rs1)
-		instr.SetFunct3(FUNCT3_SLLI)
-		instr.SetImmI(int32(shamt))
-		return instr, nil
-	case "slt":
-		re := regexp.MustCompile(`^x(\d+),x(\d+),x(\d+)$`)
-		m, err := parseOperands(operands, re, mnemonic)
-		if err != nil {
-			return 0, err
-		}
-		rd, rs1, rs2 := parseUint(m[1]), parseUint(m[2]), parseUint(m[3])
-		var instr Instruction
-		instr.SetOpcode(OPCODE_R_TYPE)
-		instr.SetRd(rd)
-		instr.SetRs1(rs1)
-		instr.SetRs2(rs2)
-		instr.SetFunct3(FUNCT3_SLT)
-		instr.SetFunct7(0)
-		return instr, nil
-	case "sub":
-		re := regexp.MustCompile(`^x(\d+),x(\d+),x(\d+)$`)
-		m, err := parseOperands(operands, re, mnemonic)
-		if err != nil {
-			return 0, err
-		}
-		rd, rs1, rs2 := parseUint(m[1]), parseUint(m[2]), parseUint(m[3])
-		var instr Instruction
-		instr.SetOpcode(OPCODE_R_TYPE)
-		instr.SetRd(rd)
-		instr.SetRs1(rs1)
-		instr.SetRs2(rs2)
-		instr.SetFunct3(FUNCT3_ADD_SUB)
-		instr.SetFunct7(FUNCT7_SUB)
-		return instr, nil
-	case "sw":
-		re := regexp.MustCompile(`^x(\d+),(-?\d+)\(x(\d+)\)$`)
-		m, err := parseOperands(operands, re, mnemonic)
-		if err != nil {
-			return 0, err
+	if strings.HasPrefix(mnemonic, "c.") {
+		return parseCompressed(mnemonic, operands)
+	}
+	if template, ok := csrPseudoInstructions[mnemonic]; ok {
+		return parseCSRPseudo(mnemonic, operands, template)
+	}
+	if spec, ok := Lookup(mnemonic); ok {
+		return spec.Encode(operands)
+	}
+	if base, aq, rl := splitAqRl(mnemonic); base != mnemonic {
+		if spec, ok := Lookup(base); ok && spec.Opcode == OPCODE_AMO {
+			instr, err := spec.Encode(operands)
+			if err != nil {
+				return 0, err
+			}
+			instr.SetAqRl(aq, rl)
+			return instr, nil
 		}
-		rs2, imm, rs1 := parseUint(m[1]), parseInt(m[2]), parseUint(m[3])
-		if imm < -2048 || imm > 2047 {
-			return 0, fmt.Errorf("immediate out of range for sw: %d", imm)
+	}
+	return 0, fmt.Errorf("unsupported instruction: %q", mnemonic)
+}
+
+// splitAqRl splits an A extension mnemonic like "amoadd.w.aqrl" into its base
+// mnemonic and the acquire/release ordering suffix.
+func splitAqRl(mnemonic string) (base string, aq, rl bool) {
+	switch {
+	case strings.HasSuffix(mnemonic, ".aqrl"):
+		return strings.TrimSuffix(mnemonic, ".aqrl"), true, true
+	case strings.HasSuffix(mnemonic, ".aq"):
+		return strings.TrimSuffix(mnemonic, ".aq"), true, false
+	case strings.HasSuffix(mnemonic, ".rl"):
+		return strings.TrimSuffix(mnemonic, ".rl"), false, true
+	}
+	return mnemonic, false, false
+}
+
+// fenceSet converts a fence ordering set like "rw" into its 4-bit IORW mask.
+func fenceSet(s string) uint32 {
+	var set uint32
+	for _, c := range s {
+		switch c {
+		case 'i':
+			set |= 0x8
+		case 'o':
+			set |= 0x4
+		case 'r':
+			set |= 0x2
+		case 'w':
+			set |= 0x1
 		}
-		var instr Instruction
-		instr.SetOpcode(OPCODE_STORE)
-		instr.SetRs1(rs1)
-		instr.SetRs2(rs2)
-		instr.SetFunct3(FUNCT3_SW)
-		instr.SetImmS(int32(imm))
-		return instr, nil
-	default:
-		return 0, fmt.Errorf("unsupported instruction: %q", mnemonic)
 	}
+	return set
 }
@@ -1,6 +1,7 @@
 package assembler
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -179,3 +180,176 @@ func TestParseInstruction_WhitespaceAndComments(t *testing.T) {
 		}
 	}
 }
+
+func TestParseInstruction_Encodings(t *testing.T) {
+	// Reference encodings as produced by the GNU assembler
+	cases := []struct {
+		asm  string
+		want uint32
+	}{
+		{"lui x5, 0x12345", 0x123452B7},
+		{"auipc x6, 0xFFFFF", 0xFFFFF317},
+		{"slti x1, x2, -5", 0xFFB12093},
+		{"sltiu x1, x2, 10", 0x00A13093},
+		{"xori x1, x2, -1", 0xFFF14093},
+		{"ori x1, x2, 0x7F", 0x07F16093},
+		{"andi x1, x2, 255", 0x0FF17093},
+		{"srli x1, x2, 3", 0x00315093},
+		{"srai x1, x2, 3", 0x40315093},
+		{"sll x1, x2, x3", 0x003110B3},
+		{"sltu x1, x2, x3", 0x003130B3},
+		{"xor x1, x2, x3", 0x003140B3},
+		{"srl x1, x2, x3", 0x003150B3},
+		{"sra x1, x2, x3", 0x403150B3},
+		{"or x1, x2, x3", 0x003160B3},
+		{"and x1, x2, x3", 0x003170B3},
+		{"blt x1, x2, 16", 0x0020C863},
+		{"bge x1, x2, -16", 0xFE20D8E3},
+		{"bltu x1, x2, 8", 0x0020E463},
+		{"bgeu x1, x2, 8", 0x0020F463},
+		{"lb x1, -1(x2)", 0xFFF10083},
+		{"lh x1, 2(x2)", 0x00211083},
+		{"lbu x1, 0(x2)", 0x00014083},
+		{"lhu x1, 4(x2)", 0x00415083},
+		{"sb x1, 1(x2)", 0x001100A3},
+		{"sh x1, -2(x2)", 0xFE111F23},
+		{"fence", 0x0FF0000F},
+		{"fence rw, w", 0x0310000F},
+		{"ecall", 0x00000073},
+		{"ebreak", 0x00100073},
+		{"mret", 0x30200073},
+		{"sret", 0x10200073},
+		{"wfi", 0x10500073},
+		{"sfence.vma", 0x12000073},
+		{"sfence.vma x1", 0x12008073},
+		{"sfence.vma x1, x2", 0x12208073},
+		{"mul x1, x2, x3", 0x023100B3},
+		{"mulh x1, x2, x3", 0x023110B3},
+		{"mulhsu x1, x2, x3", 0x023120B3},
+		{"mulhu x1, x2, x3", 0x023130B3},
+		{"div x1, x2, x3", 0x023140B3},
+		{"divu x1, x2, x3", 0x023150B3},
+		{"rem x1, x2, x3", 0x023160B3},
+		{"remu x1, x2, x3", 0x023170B3},
+		{"lr.w x1, (x2)", 0x100120AF},
+		{"lr.w.aq x1, 0(x2)", 0x140120AF},
+		{"sc.w x1, x3, (x2)", 0x183120AF},
+		{"sc.w.rl x1, x3, (x2)", 0x1A3120AF},
+		{"amoswap.w.aqrl x1, x3, (x2)", 0x0E3120AF},
+		{"amoadd.w x1, x3, (x2)", 0x003120AF},
+		{"amoxor.w x1, x3, (x2)", 0x203120AF},
+		{"amoand.w x1, x3, (x2)", 0x603120AF},
+		{"amoor.w x1, x3, (x2)", 0x403120AF},
+		{"amomin.w x1, x3, (x2)", 0x803120AF},
+		{"amomax.w x1, x3, (x2)", 0xA03120AF},
+		{"amominu.w x1, x3, (x2)", 0xC03120AF},
+		{"amomaxu.w x1, x3, (x2)", 0xE03120AF},
+	}
+	for _, tc := range cases {
+		instr, err := ParseInstruction(tc.asm)
+		if assert.NoErrorf(t, err, "ParseInstruction(%q)", tc.asm) {
+			assert.Equalf(t, tc.want, uint32(instr), "ParseInstruction(%q) = 0x%08x", tc.asm, uint32(instr))
+		}
+	}
+}
+
+func TestParseInstruction_RV32IErrors(t *testing.T) {
+	cases := []string{
+		"lui x1, 0x100000",
+		"lui x1, -1",
+		"auipc x1, -0x80000",
+		"srai x1, x2, 32",
+		"lb x1, 2048(x2)",
+		"blt x1, x2, 4096",
+		"ecall x1",
+		"fence rw",
+		"lr.w x1, x2, (x3)",
+		"amoadd.w x1, x3, 4(x2)",
+	}
+	for _, asm := range cases {
+		_, err := ParseInstruction(asm)
+		assert.Errorf(t, err, "ParseInstruction(%q) should fail", asm)
+	}
+}
+
+func TestParseInstruction_Immediates(t *testing.T) {
+	cases := []struct {
+		asm, want string
+	}{
+		{"addi x1, x0, 09", "addi x1, x0, 9"},
+		{"addi x1, x0, 010", "addi x1, x0, 10"},
+		{"addi x1, x0, -0x10", "addi x1, x0, -16"},
+		{"addi x1, x0, 0X7f", "addi x1, x0, 127"},
+		{"slli x1, x2, 0x1F", "slli x1, x2, 31"},
+		{"jal x1, -0x8", "jal x1, -8"},
+	}
+	for _, tc := range cases {
+		assert.Equalf(t, mustParse(tc.want), mustParse(tc.asm), "ParseInstruction(%q)", tc.asm)
+	}
+
+	_, err := ParseInstruction("addi x1, x0, 5000000000")
+	assert.EqualError(t, err, "immediate out of range: 5000000000")
+	_, err = ParseInstruction("slli x1, x2, 0x100000000")
+	assert.EqualError(t, err, "immediate out of range: 0x100000000")
+	_, err = ParseInstruction("lw x1, 99999999999(x2)")
+	assert.Error(t, err)
+}
+
+func TestParseInstruction_AqRlError(t *testing.T) {
+	instr, err := ParseInstruction("amoadd.w.aqrl x1, x3, 4(x2)")
+	assert.Error(t, err)
+	assert.Equal(t, Instruction(0), instr)
+}
+
+func TestParseInstruction_ABIRegisters(t *testing.T) {
+	cases := []struct {
+		abi, numeric string
+	}{
+		{"addi sp, sp, -16", "addi x2, x2, -16"},
+		{"sw ra, 12(sp)", "sw x1, 12(x2)"},
+		{"lw s0, 8(sp)", "lw x8, 8(x2)"},
+		{"add fp, zero, a0", "add x8, x0, x10"},
+		{"jalr zero, 0(ra)", "jalr x0, 0(x1)"},
+		{"csrrw t0, mscratch, t6", "csrrw x5, mscratch, x31"},
+		{"csrr a7, mepc", "csrr x17, mepc"},
+		{"amoswap.w.aq gp, tp, (s11)", "amoswap.w.aq x3, x4, (x27)"},
+		{"flw fa0, 4(a1)", "flw f10, 4(x11)"},
+		{"c.addi s1, 1", "c.addi x9, 1"},
+	}
+	for _, tc := range cases {
+		want, err := ParseInstruction(tc.numeric)
+		if !assert.NoErrorf(t, err, "ParseInstruction(%q)", tc.numeric) {
+			continue
+		}
+		got, err := ParseInstruction(tc.abi)
+		if assert.NoErrorf(t, err, "ParseInstruction(%q)", tc.abi) {
+			assert.Equalf(t, want, got, "ParseInstruction(%q)", tc.abi)
+		}
+	}
+}
+
+func TestParseRegister(t *testing.T) {
+	for i, abi := range RegABINames {
+		reg, err := ParseRegister(abi)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(i), reg, abi)
+		reg, err = ParseRegister(fmt.Sprintf("x%d", i))
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(i), reg)
+	}
+	reg, err := ParseRegister("fp")
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(8), reg)
+
+	for _, name := range []string{"x32", "x99", "x256", "x01", "x-1", "x", "a8", "s12", "t7", "foo", "f0", ""} {
+		_, err := ParseRegister(name)
+		assert.Errorf(t, err, "ParseRegister(%q) should fail", name)
+	}
+}
+
+func TestParseInstruction_RegisterOutOfRange(t *testing.T) {
+	for _, asm := range []string{"addi x32, x0, 1", "add x1, x99, x2", "lw x1, 0(x40)", "jalr x33, 0(x1)"} {
+		_, err := ParseInstruction(asm)
+		assert.Errorf(t, err, "ParseInstruction(%q) should fail", asm)
+	}
+}
@@ -1,63 +1,52 @@
 package assembler
 
 import (
-	"bufio"
+	"bytes"
+	"encoding/binary"
 	"fmt"
-	"os"
-	"strconv"
 	"strings"
 )
 
-// preprocessPseudoInstructions rewrites pseudoinstructions (like "j label") to real instructions.
-func preprocessPseudoInstructions(line string) string {
-	fields := strings.Fields(line)
-	if len(fields) == 0 {
-		return line
-	}
-	switch fields[0] {
-	case "j":
-		if len(fields) == 2 {
-			return fmt.Sprintf("jal x0, %s", fields[1])
-		}
-	}
-	return line
-}
-
 // ReplaceLabelOperandWithOffset replaces a label operand in a branch or jump instruction
 // with the correct PC-relative offset using the provided label mapping.
-// idx is the instruction index (not byte address).
+// idx is the instruction index (not byte address) in a program of 32-bit instructions.
 func ReplaceLabelOperandWithOffset(line string, idx int, labelMap map[string]int) (string, error) {
+	return replaceLabelOperandAt(line, idx*INSTRUCTION_SIZE, labelMap)
+}
+
+// replaceLabelOperandAt works like ReplaceLabelOperandWithOffset for an instruction
+// located at byte address curAddr.
+func replaceLabelOperandAt(line string, curAddr int, labelMap map[string]int) (string, error) {
 	fields := strings.Fields(line)
 	if len(fields) == 0 {
 		return line, nil
 	}
 	mnemonic := fields[0]
+	ops := splitOperands(strings.TrimSpace(line)[len(mnemonic):])
 
-	// Only process branch and jump instructions with a label as the last operand
-	// For simplicity: beq, bne, jal
+	// Only process branch and jump instructions (and la) with a label as the last operand
 	needsLabel := false
-	var labelOperandIdx int
 	switch mnemonic {
-	case "beq", "bne":
-		if len(fields) == 4 {
-			needsLabel = true
-			labelOperandIdx = 3
-		}
+	case "beq", "bne", "blt", "bge", "bltu", "bgeu", "bgt", "ble", "bgtu", "bleu":
+		needsLabel = len(ops) == 3
 	case "jal":
-		if len(fields) == 3 {
-			needsLabel = true
-			labelOperandIdx = 2
-		}
+		// "jal label" is the pseudo-instruction, "jal rd, label" the base instruction
+		needsLabel = len(ops) == 1 || len(ops) == 2
+	case "c.beqz", "c.bnez", "beqz", "bnez", "blez", "bgez", "bltz", "bgtz", "la":
+		needsLabel = len(ops) == 2
+	case "c.j", "c.jal", "j", "call", "tail":
+		needsLabel = len(ops) == 1
 	}
 
 	if !needsLabel {
 		return line, nil
 	}
 
-	label := fields[labelOperandIdx]
+	labelOperandIdx := len(ops) - 1
+	label := ops[labelOperandIdx]
 
-	// If it's already a number, keep as is
-	if _, err := strconv.Atoi(label); err == nil || strings.HasPrefix(label, "-") {
+	// If it's not a label, like a number, keep as is
+	if !symbolName.MatchString(label) {
 		return line, nil
 	}
 
@@ -66,65 +55,269 @@ func ReplaceLabelOperandWithOffset(line string, idx int, labelMap map[string]int
 	if !ok {
 		return "", fmt.Errorf("unknown label: %q", label)
 	}
-	curAddr := idx * INSTRUCTION_SIZE
 	offset := targetAddr - curAddr
 
 	// For branches and jumps, replace label with offset (as string)
-	fields[labelOperandIdx] = fmt.Sprintf("%d", offset)
-	return strings.Join(fields, " "), nil
+	ops[labelOperandIdx] = fmt.Sprintf("%d", offset)
+	return mnemonic + " " + strings.Join(ops, ", "), nil
+}
+
+// Options controls optional assembler behaviour.
+type Options struct {
+	// Compress emits the 16-bit RVC encoding for every instruction that has one.
+	// Branches and jumps to labels are always kept at 32 bits so that label
+	// addresses can be computed before the offsets are known.
+	Compress bool
+	// Base is the address the program is assembled for, the start of its text
+	// segment. The data segment follows the text segment.
+	Base uint32
+}
+
+// AssembleFile reads an assembler source file and returns the assembled Program.
+// Compressed instructions in its text segment are packed, so a 32-bit instruction
+// may start at any even address.
+func AssembleFile(filename string) (*Program, error) {
+	return AssembleFileWithOptions(filename, Options{})
 }
 
-// AssembleFile reads an assembler source file and returns a slice of Instructions.
-func AssembleFile(filename string) ([]Instruction, error) {
-	file, err := os.Open(filename)
+// AssembleFileWithOptions works like AssembleFile with the given assembler options.
+func AssembleFileWithOptions(filename string, opts Options) (*Program, error) {
+	lines, err := linesFromFile(filename)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
-
-	var rawLines []string
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		rawLines = append(rawLines, scanner.Text())
+	prog, err := Assemble(lines, opts)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", filename, err)
 	}
-	if err := scanner.Err(); err != nil {
+	return prog, nil
+}
+
+// Assemble assembles the lines of a source file. Errors are prefixed with the
+// number of the line they occur on.
+func Assemble(lines []string, opts Options) (*Program, error) {
+	l, err := layoutProgram(lines, opts)
+	if err != nil {
 		return nil, err
 	}
-
-	labelMap, instructions := parseLabelsAndInstructions(rawLines)
-	var program []Instruction
-	for idx, line := range instructions {
-		line, err = ReplaceLabelOperandWithOffset(preprocessPseudoInstructions(line), idx, labelMap)
+	syms := symbols{constants: l.constants, labels: l.labels}
+	sections := make(map[string][]byte)
+	for _, stmt := range l.statements {
+		addr := l.bases[stmt.section] + stmt.offset
+		var data []byte
+		if isDirective(stmt.text) {
+			name, args := splitDirective(stmt.text)
+			data, err = directiveData(name, args, addr, stmt.section, syms)
+		} else {
+			data, err = assembleInstruction(stmt.text, addr, l.labels, opts)
+		}
 		if err != nil {
-			return nil, fmt.Errorf("%s:%d: %w", filename, idx+1, err)
+			return nil, fmt.Errorf("%d: %w", stmt.line, err)
+		}
+		if stmt.section == SECTION_BSS {
+			if len(bytes.Trim(data, "\x00")) > 0 {
+				return nil, fmt.Errorf("%d: data in .bss, which may only reserve zeros", stmt.line)
+			}
+			continue
 		}
+		sections[stmt.section] = append(sections[stmt.section], data...)
+	}
+
+	prog := &Program{Symbols: make(map[string]uint32, len(l.labels)), Globals: l.globals}
+	text := l.bases[SECTION_TEXT]
+	prog.Text = Segment{Base: uint32(text), Data: sections[SECTION_TEXT], Size: uint32(l.sizes[SECTION_TEXT])}
+	base, dataBase := l.bases[SECTION_RODATA], l.bases[SECTION_DATA]
+	data := make([]byte, dataBase+l.sizes[SECTION_DATA]-base)
+	copy(data, sections[SECTION_RODATA])
+	copy(data[dataBase-base:], sections[SECTION_DATA])
+	prog.Data = Segment{Base: uint32(base), Data: data, Size: uint32(l.bases[SECTION_BSS] + l.sizes[SECTION_BSS] - base)}
+	for name, addr := range l.labels {
+		prog.Symbols[name] = uint32(addr)
+	}
+	prog.Entry = uint32(text)
+	if start, ok := l.labels["_start"]; ok {
+		prog.Entry = uint32(start)
+	}
+	return prog, nil
+}
+
+// assembleInstruction assembles the instruction line at addr, which may be a
+// pseudo-instruction standing for several instructions, to its little-endian bytes.
+func assembleInstruction(line string, addr int, labels map[string]int, opts Options) ([]byte, error) {
+	// Expand before resolving labels only to learn which instructions were
+	// laid out compressed: the expansion does not depend on label values.
+	layout, _ := preprocessPseudoInstructions(line)
+	line, err := replaceLabelOperandAt(line, addr, labels)
+	if err != nil {
+		return nil, err
+	}
+	expanded, err := preprocessPseudoInstructions(line)
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	for i, line := range expanded {
 		instr, err := ParseInstruction(line)
 		if err != nil {
-			return nil, fmt.Errorf("%s:%d: %w", filename, idx+1, err)
+			return nil, err
+		}
+		if instructionSize(layout[i], opts) == COMPRESSED_SIZE && instr.Size() != COMPRESSED_SIZE {
+			half, _ := Compress(instr)
+			instr = Instruction(half)
+		}
+		data = binary.LittleEndian.AppendUint16(data, uint16(instr))
+		if instr.Size() == INSTRUCTION_SIZE {
+			data = binary.LittleEndian.AppendUint16(data, uint16(uint32(instr)>>16))
 		}
-		program = append(program, instr)
 	}
-	return program, nil
+	return data, nil
 }
 
-// parseLabelsAndInstructions parses a list of assembler source lines.
-// It returns a label map (label name -> address in bytes) and a slice of instruction lines (labels stripped).
-func parseLabelsAndInstructions(lines []string) (map[string]int, []string) {
-	labelMap := make(map[string]int)
-	var instructions []string
-	instrIndex := 0
+// statement is an instruction or a directive of a source line, without its labels.
+type statement struct {
+	text string
+	// line is the number of the source line
+	line    int
+	section string
+	// offset is the address relative to the start of the section
+	offset int
+	size   int
+}
 
-	for _, rawLine := range lines {
-		labels, instr := splitLabelsAndInstruction(rawLine)
+// layout is the result of the assembler's first pass over a program.
+type layout struct {
+	statements []statement
+	// labels maps the labels to their addresses in bytes
+	labels map[string]int
+	// constants are the symbols defined by .equ and .set
+	constants map[string]int64
+	globals   []string
+	// bases and sizes are the start addresses and sizes of the sections
+	bases map[string]int
+	sizes map[string]int
+}
+
+// layoutProgram determines the section, address and size of every statement and
+// the addresses of the labels. Statements are sized before labels are resolved:
+// a pseudo-instruction takes the size of all instructions it expands to.
+func layoutProgram(lines []string, opts Options) (*layout, error) {
+	l := &layout{
+		labels:    make(map[string]int),
+		constants: make(map[string]int64),
+		bases:     make(map[string]int),
+		sizes:     make(map[string]int),
+	}
+	// Constants may be used before their .equ, but must be defined in terms of earlier ones.
+	for i, rawLine := range lines {
+		_, stmt := splitLabelsAndInstruction(rawLine)
+		if !isDirective(stmt) {
+			continue
+		}
+		if name, args := splitDirective(stmt); name == ".equ" || name == ".set" {
+			symbol, v, err := parseEqu(name, args, l.constants)
+			if err != nil {
+				return nil, fmt.Errorf("%d: %w", i+1, err)
+			}
+			l.constants[symbol] = v
+		}
+	}
+
+	section := SECTION_TEXT
+	aligns := map[string]int{}
+	sectionLabels := map[string]string{}
+	syms := symbols{constants: l.constants}
+	for i, rawLine := range lines {
+		labels, text := splitLabelsAndInstruction(rawLine)
 		for _, label := range labels {
-			labelMap[label] = instrIndex * INSTRUCTION_SIZE
+			if _, ok := l.labels[label]; ok {
+				return nil, fmt.Errorf("%d: label %q defined twice", i+1, label)
+			}
+			l.labels[label] = l.sizes[section]
+			sectionLabels[label] = section
 		}
-		if instr == "" {
+		if text == "" {
 			continue
 		}
-		instructions = append(instructions, instr)
-		instrIndex++
+		stmt := statement{text: text, line: i + 1, section: section, offset: l.sizes[section]}
+		if isDirective(text) {
+			name, args := splitDirective(text)
+			switch name {
+			case SECTION_TEXT, SECTION_RODATA, SECTION_DATA, SECTION_BSS:
+				section = name
+				continue
+			case ".section":
+				if len(args) == 0 {
+					return nil, fmt.Errorf("%d: .section needs a name", i+1)
+				}
+				s, err := sectionOf(args[0])
+				if err != nil {
+					return nil, fmt.Errorf("%d: %w", i+1, err)
+				}
+				section = s
+				continue
+			case ".equ", ".set":
+				continue
+			case ".globl", ".global":
+				if len(args) == 0 {
+					return nil, fmt.Errorf("%d: %s needs a symbol", i+1, name)
+				}
+				l.globals = append(l.globals, args...)
+				continue
+			case ".align", ".balign":
+				align, err := alignment(name, args, syms)
+				if err != nil {
+					return nil, fmt.Errorf("%d: %w", i+1, err)
+				}
+				aligns[section] = max(aligns[section], align)
+			}
+			data, err := directiveData(name, args, stmt.offset, section, syms)
+			if err != nil {
+				return nil, fmt.Errorf("%d: %w", i+1, err)
+			}
+			stmt.size = len(data)
+		} else {
+			if section != SECTION_TEXT {
+				return nil, fmt.Errorf("%d: instruction outside of .text: %q", i+1, text)
+			}
+			stmt.text = substituteConstants(text, l.constants)
+			expanded, err := preprocessPseudoInstructions(stmt.text)
+			if err != nil {
+				expanded = []string{stmt.text} // reported when assembling
+			}
+			for _, line := range expanded {
+				stmt.size += instructionSize(line, opts)
+			}
+		}
+		l.statements = append(l.statements, stmt)
+		l.sizes[section] += stmt.size
 	}
 
-	return labelMap, instructions
+	// Each section starts where the previous one ends, aligned to at least a word.
+	addr := int(opts.Base)
+	for _, s := range sectionOrder {
+		align := max(aligns[s], INSTRUCTION_SIZE)
+		addr = (addr + align - 1) &^ (align - 1)
+		l.bases[s] = addr
+		addr += l.sizes[s]
+	}
+	for label, s := range sectionLabels {
+		l.labels[label] += l.bases[s]
+	}
+	return l, nil
+}
+
+// instructionSize determines the encoded size of a base instruction line before labels are resolved.
+func instructionSize(line string, opts Options) int {
+	if strings.HasPrefix(line, "c.") {
+		return COMPRESSED_SIZE
+	}
+	if opts.Compress {
+		// Lines with unresolved label operands fail to parse and stay 32-bit.
+		if instr, err := ParseInstruction(line); err == nil {
+			if _, ok := Compress(instr); ok {
+				return COMPRESSED_SIZE
+			}
+		}
+	}
+	return INSTRUCTION_SIZE
 }
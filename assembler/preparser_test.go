@@ -1,6 +1,7 @@
 package assembler
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -11,7 +12,7 @@ func TestAssembleFile_SimpleProgram(t *testing.T) {
 	if err != nil {
 		t.Fatalf("AssembleFile returned error: %v", err)
 	}
-	checkInstructions(t, prog, []string{"addi x1, x0, 42", "addi x2, x1, 1"})
+	checkInstructions(t, prog.Text.Words(), []string{"addi x1, x0, 42", "addi x2, x1, 1"})
 }
 
 func TestAssembleFile_Example2asm(t *testing.T) {
@@ -26,7 +27,7 @@ lw x3, 0(x2)
 	if err != nil {
 		t.Fatalf("AssembleFile returned error: %v", err)
 	}
-	checkInstructions(t, prog, []string{
+	checkInstructions(t, prog.Text.Words(), []string{
 		"addi x1, x0, 42",
 		"addi x2, x0, 100",
 		"sw x1, 0(x2)",
@@ -44,7 +45,7 @@ start:  addi x1, x0, 1
 	if err != nil {
 		t.Fatalf("AssembleFile returned error: %v", err)
 	}
-	checkInstructions(t, prog, []string{
+	checkInstructions(t, prog.Text.Words(), []string{
 		"addi x1, x0, 1",
 		"beq x1, x0, -4",
 	})
@@ -64,8 +65,15 @@ func TestReplaceLabelOperandWithOffset_Preparse(t *testing.T) {
 		{"beq x1, x0, start", 1, "beq x1, x0, -4", false},
 		{"beq x1, x0, loop", 1, "beq x1, x0, 4", false},
 		{"beq x1, x0, 12", 2, "beq x1, x0, 12", false},
+		{"bgeu x1, x0, loop", 0, "bgeu x1, x0, 8", false},
+		{"blt x1, x0, 0x10", 0, "blt x1, x0, 0x10", false},
 		{"beq x1, x0, missing", 0, "", true},
 		{"addi x1, x0, 5", 0, "addi x1, x0, 5", false},
+		{"bne x1,x0,loop", 0, "bne x1, x0, 8", false},
+		{"jal x1,8", 0, "jal x1,8", false},
+		{"jal x1,loop", 1, "jal x1, 4", false},
+		{"jal\tloop", 1, "jal 4", false},
+		{"jal x1,missing", 0, "", true},
 	}
 	for _, tc := range cases {
 		got, err := ReplaceLabelOperandWithOffset(tc.line, tc.idx, labelMap)
@@ -84,13 +92,20 @@ func TestReplaceLabelOperandWithOffset_Preparse(t *testing.T) {
 	}
 }
 
-func TestParseLabelsAndInstructions_LabelOnOwnLine(t *testing.T) {
+func TestLayoutProgram_LabelOnOwnLine(t *testing.T) {
 	lines := []string{
 		"addi x1, x0, 5",
 		"label_only:",
 		"addi x2, x0, 9",
 	}
-	labelMap, instructions := parseLabelsAndInstructions(lines)
+	l, err := layoutProgram(lines, Options{})
+	if err != nil {
+		t.Fatalf("layoutProgram returned error: %v", err)
+	}
+	var instructions []string
+	for _, stmt := range l.statements {
+		instructions = append(instructions, stmt.text)
+	}
 	wantInstr := []string{"addi x1, x0, 5", "addi x2, x0, 9"}
 	if len(instructions) != len(wantInstr) {
 		t.Fatalf("Expected %d instructions, got %d", len(wantInstr), len(instructions))
@@ -100,7 +115,7 @@ func TestParseLabelsAndInstructions_LabelOnOwnLine(t *testing.T) {
 			t.Errorf("Instruction %d mismatch: got %q, want %q", i, instructions[i], instr)
 		}
 	}
-	addr, ok := labelMap["label_only"]
+	addr, ok := l.labels["label_only"]
 	if !ok {
 		t.Errorf("Label 'label_only' not found in labelMap")
 	}
@@ -110,14 +125,96 @@ func TestParseLabelsAndInstructions_LabelOnOwnLine(t *testing.T) {
 }
 
 func TestPreprocessPseudoInstructions_Jump(t *testing.T) {
-	got := preprocessPseudoInstructions("j end")
-	want := "jal x0, end"
-	if got != want {
-		t.Errorf("preprocessPseudoInstructions(%q) = %q, want %q", "j end", got, want)
+	got, err := preprocessPseudoInstructions("j end")
+	want := []string{"jal x0, end"}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("preprocessPseudoInstructions(%q) = %q, %v, want %q", "j end", got, err, want)
 	}
 	// Should not touch normal instructions
 	normal := "addi x1, x0, 5"
-	if preprocessPseudoInstructions(normal) != normal {
+	if got, _ := preprocessPseudoInstructions(normal); !reflect.DeepEqual(got, []string{normal}) {
 		t.Errorf("preprocessPseudoInstructions(%q) should be unchanged", normal)
 	}
 }
+
+func TestAssembleFile_CompressedPacking(t *testing.T) {
+	asm := `
+start:  c.li x10, 5
+        addi x11, x10, 1
+        c.bnez x10, start
+`
+	filename := writeTempASM(t, asm)
+	program, err := AssembleFile(filename)
+	if err != nil {
+		t.Fatalf("AssembleFile returned error: %v", err)
+	}
+	prog := program.Text.Words()
+	addi := uint32(mustParse("addi x11, x10, 1"))
+	bnez := uint32(mustParse("c.bnez x10, -6"))
+	want := []Instruction{
+		Instruction(uint32(mustParse("c.li x10, 5")) | addi<<16),
+		Instruction(addi>>16 | bnez<<16),
+	}
+	if len(prog) != len(want) {
+		t.Fatalf("Expected %d words, got %d", len(want), len(prog))
+	}
+	for i := range want {
+		if prog[i] != want[i] {
+			t.Errorf("Word %d mismatch. Got %08x, want %08x", i, prog[i], want[i])
+		}
+	}
+}
+
+func TestAssembleFileWithOptions_Compress(t *testing.T) {
+	asm := `
+        addi x10, x0, 3
+loop:   addi x10, x10, -1
+        bne x10, x0, loop
+        addi x11, x10, 100
+`
+	filename := writeTempASM(t, asm)
+	program, err := AssembleFileWithOptions(filename, Options{Compress: true})
+	if err != nil {
+		t.Fatalf("AssembleFileWithOptions returned error: %v", err)
+	}
+	prog := program.Text.Words()
+	// c.li, c.addi, bne (label operand, kept at 32 bits), addi (not compressible)
+	bne := uint32(mustParse("bne x10, x0, -2"))
+	addi := uint32(mustParse("addi x11, x10, 100"))
+	want := []Instruction{
+		Instruction(uint32(mustParse("c.li x10, 3")) | uint32(mustParse("c.addi x10, -1"))<<16),
+		Instruction(bne),
+		Instruction(addi),
+	}
+	if len(prog) != len(want) {
+		t.Fatalf("Expected %d words, got %d", len(want), len(prog))
+	}
+	for i := range want {
+		if prog[i] != want[i] {
+			t.Errorf("Word %d mismatch. Got %08x, want %08x", i, prog[i], want[i])
+		}
+	}
+}
+
+func TestLayoutProgram_CompressedLabels(t *testing.T) {
+	lines := []string{
+		"c.li x10, 1",
+		"mid: addi x1, x0, 5",
+		"c.nop",
+		"end:",
+	}
+	l, err := layoutProgram(lines, Options{})
+	if err != nil {
+		t.Fatalf("layoutProgram returned error: %v", err)
+	}
+	if l.labels["mid"] != 2 || l.labels["end"] != 8 {
+		t.Errorf("Unexpected label addresses: %v", l.labels)
+	}
+	var sizes []int
+	for _, stmt := range l.statements {
+		sizes = append(sizes, stmt.size)
+	}
+	if !reflect.DeepEqual(sizes, []int{2, 4, 2}) {
+		t.Errorf("Unexpected sizes: %v", sizes)
+	}
+}
@@ -0,0 +1,132 @@
+package assembler
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// pseudoInstruction describes how a pseudo-instruction with a fixed number of
+// operands expands to base instructions.
+type pseudoInstruction struct {
+	operands int
+	// templates are the base instructions, $n refers to the n-th operand.
+	// %hi(n) and %lo(n) split a numeric operand into the upper 20 bits and the
+	// sign-adjusted lower 12 bits, as used by a lui/auipc followed by an addi.
+	templates []string
+	// expand is used instead of templates when the expansion depends on the operand values.
+	expand func(ops []string) ([]string, error)
+}
+
+// pseudoInstructions are the standard pseudo-instructions of the RISC-V assembly
+// manual. Operands that are labels are replaced by PC-relative offsets before the
+// expansion, so la, call and tail add an offset relative to their auipc.
+// jal and jalr are only pseudo-instructions with a single operand.
+var pseudoInstructions = map[string]pseudoInstruction{
+	"nop":  {0, []string{"addi x0, x0, 0"}, nil},
+	"li":   {2, nil, expandLi},
+	"la":   {2, []string{"auipc $1, %hi($2)", "addi $1, $1, %lo($2)"}, nil},
+	"mv":   {2, []string{"addi $1, $2, 0"}, nil},
+	"not":  {2, []string{"xori $1, $2, -1"}, nil},
+	"neg":  {2, []string{"sub $1, x0, $2"}, nil},
+	"seqz": {2, []string{"sltiu $1, $2, 1"}, nil},
+	"snez": {2, []string{"sltu $1, x0, $2"}, nil},
+	"sltz": {2, []string{"slt $1, $2, x0"}, nil},
+	"sgtz": {2, []string{"slt $1, x0, $2"}, nil},
+	"beqz": {2, []string{"beq $1, x0, $2"}, nil},
+	"bnez": {2, []string{"bne $1, x0, $2"}, nil},
+	"blez": {2, []string{"bge x0, $1, $2"}, nil},
+	"bgez": {2, []string{"bge $1, x0, $2"}, nil},
+	"bltz": {2, []string{"blt $1, x0, $2"}, nil},
+	"bgtz": {2, []string{"blt x0, $1, $2"}, nil},
+	"bgt":  {3, []string{"blt $2, $1, $3"}, nil},
+	"ble":  {3, []string{"bge $2, $1, $3"}, nil},
+	"bgtu": {3, []string{"bltu $2, $1, $3"}, nil},
+	"bleu": {3, []string{"bgeu $2, $1, $3"}, nil},
+	"j":    {1, []string{"jal x0, $1"}, nil},
+	"jal":  {1, []string{"jal x1, $1"}, nil},
+	"jr":   {1, []string{"jalr x0, 0($1)"}, nil},
+	"jalr": {1, []string{"jalr x1, 0($1)"}, nil},
+	"ret":  {0, []string{"jalr x0, 0(x1)"}, nil},
+	"call": {1, []string{"auipc x1, %hi($1)", "jalr x1, %lo($1)(x1)"}, nil},
+	"tail": {1, []string{"auipc x6, %hi($1)", "jalr x0, %lo($1)(x6)"}, nil},
+}
+
+// hiLoOperand matches the %hi(n) and %lo(n) of a pseudoInstruction template.
+var hiLoOperand = regexp.MustCompile(`%(hi|lo)\(([^()]*)\)`)
+
+// preprocessPseudoInstructions rewrites a pseudoinstruction (like "j label" or
+// "li x1, 0x12345678") to the real instructions it stands for. Other lines are
+// returned unchanged.
+func preprocessPseudoInstructions(line string) ([]string, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return []string{line}, nil
+	}
+	mnemonic := fields[0]
+	pseudo, ok := pseudoInstructions[mnemonic]
+	if !ok {
+		return []string{line}, nil
+	}
+	operands := removeAllWhitespace(strings.Join(fields[1:], ""))
+	var ops []string
+	if operands != "" {
+		ops = strings.Split(operands, ",")
+	}
+	if len(ops) != pseudo.operands {
+		if _, ok := Lookup(mnemonic); ok {
+			return []string{line}, nil // the base instruction, e.g. "jal x1, label"
+		}
+		return nil, fmt.Errorf("invalid %s operands: %q", mnemonic, operands)
+	}
+	if pseudo.expand != nil {
+		return pseudo.expand(ops)
+	}
+	lines := make([]string, len(pseudo.templates))
+	for i, template := range pseudo.templates {
+		line := templateOperand.ReplaceAllStringFunc(template, func(ref string) string {
+			return ops[ref[1]-'1']
+		})
+		lines[i] = hiLoOperand.ReplaceAllStringFunc(line, func(ref string) string {
+			m := hiLoOperand.FindStringSubmatch(ref)
+			offset, err := strconv.ParseInt(m[2], 0, 32)
+			if err != nil {
+				return ref // an unresolved label, left for the parser to reject
+			}
+			hi, lo := splitHiLo(int32(offset))
+			if m[1] == "hi" {
+				return fmt.Sprintf("0x%x", hi)
+			}
+			return fmt.Sprint(lo)
+		})
+	}
+	return lines, nil
+}
+
+// splitHiLo splits v into the 20-bit upper immediate of a lui or auipc and the
+// 12-bit immediate of the addi that completes it, such that hi<<12 + lo == v.
+func splitHiLo(v int32) (hi uint32, lo int32) {
+	hi = (uint32(v) + 0x800) >> 12 & 0xFFFFF
+	return hi, v - int32(hi<<12)
+}
+
+// expandLi loads a 32-bit constant with an addi if it fits 12 bits, otherwise
+// with a lui and, unless its lower 12 bits are zero, an addi.
+func expandLi(ops []string) ([]string, error) {
+	v, err := strconv.ParseInt(ops[1], 0, 64)
+	if err != nil || v < math.MinInt32 || v > math.MaxUint32 {
+		return nil, fmt.Errorf("invalid li operands: %q", strings.Join(ops, ","))
+	}
+	imm := int32(uint32(v))
+	if fitsSigned(imm, 12) {
+		return []string{fmt.Sprintf("addi %s, x0, %d", ops[0], imm)}, nil
+	}
+	hi, lo := splitHiLo(imm)
+	lines := []string{fmt.Sprintf("lui %s, 0x%x", ops[0], hi)}
+	if lo != 0 {
+		lines = append(lines, fmt.Sprintf("addi %s, %s, %d", ops[0], ops[0], lo))
+	}
+	return lines, nil
+}
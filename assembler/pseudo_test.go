@@ -0,0 +1,140 @@
+package assembler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreprocessPseudoInstructions(t *testing.T) {
+	cases := []struct {
+		line string
+		want []string
+	}{
+		{"nop", []string{"addi x0, x0, 0"}},
+		{"li a0, 42", []string{"addi a0, x0, 42"}},
+		{"li a0, -2048", []string{"addi a0, x0, -2048"}},
+		{"li a0, 2048", []string{"lui a0, 0x1", "addi a0, a0, -2048"}},
+		{"li a0, 0x12345678", []string{"lui a0, 0x12345", "addi a0, a0, 1656"}},
+		{"li a0, 0x12345800", []string{"lui a0, 0x12346", "addi a0, a0, -2048"}},
+		{"li a0, 0x12345000", []string{"lui a0, 0x12345"}},
+		{"li a0, 0xFFFFFFFF", []string{"addi a0, x0, -1"}},
+		{"li a0, 0x7FFFFFFF", []string{"lui a0, 0x80000", "addi a0, a0, -1"}},
+		{"li a0, -0x80000000", []string{"lui a0, 0x80000"}},
+		{"la a0, 0x1234", []string{"auipc a0, 0x1", "addi a0, a0, 564"}},
+		{"la a0, -4", []string{"auipc a0, 0x0", "addi a0, a0, -4"}},
+		{"mv a0, a1", []string{"addi a0, a1, 0"}},
+		{"not a0, a1", []string{"xori a0, a1, -1"}},
+		{"neg a0, a1", []string{"sub a0, x0, a1"}},
+		{"seqz a0, a1", []string{"sltiu a0, a1, 1"}},
+		{"snez a0, a1", []string{"sltu a0, x0, a1"}},
+		{"sltz a0, a1", []string{"slt a0, a1, x0"}},
+		{"sgtz a0, a1", []string{"slt a0, x0, a1"}},
+		{"beqz a0, 8", []string{"beq a0, x0, 8"}},
+		{"bnez a0, 8", []string{"bne a0, x0, 8"}},
+		{"blez a0, 8", []string{"bge x0, a0, 8"}},
+		{"bgez a0, 8", []string{"bge a0, x0, 8"}},
+		{"bltz a0, 8", []string{"blt a0, x0, 8"}},
+		{"bgtz a0, 8", []string{"blt x0, a0, 8"}},
+		{"bgt a0, a1, 8", []string{"blt a1, a0, 8"}},
+		{"ble a0, a1, 8", []string{"bge a1, a0, 8"}},
+		{"bgtu a0, a1, 8", []string{"bltu a1, a0, 8"}},
+		{"bleu a0, a1, 8", []string{"bgeu a1, a0, 8"}},
+		{"j -8", []string{"jal x0, -8"}},
+		{"jal 16", []string{"jal x1, 16"}},
+		{"jr t0", []string{"jalr x0, 0(t0)"}},
+		{"jalr t0", []string{"jalr x1, 0(t0)"}},
+		{"ret", []string{"jalr x0, 0(x1)"}},
+		{"call 0x800", []string{"auipc x1, 0x1", "jalr x1, -2048(x1)"}},
+		{"tail -0x10", []string{"auipc x6, 0x0", "jalr x0, -16(x6)"}},
+		// base instructions sharing a pseudo-instruction's mnemonic
+		{"jal x0, 16", []string{"jal x0, 16"}},
+		{"jalr x0, 4(x1)", []string{"jalr x0, 4(x1)"}},
+	}
+	for _, tc := range cases {
+		got, err := preprocessPseudoInstructions(tc.line)
+		if assert.NoErrorf(t, err, "preprocessPseudoInstructions(%q)", tc.line) {
+			assert.Equalf(t, tc.want, got, "preprocessPseudoInstructions(%q)", tc.line)
+		}
+	}
+}
+
+func TestPreprocessPseudoInstructions_Errors(t *testing.T) {
+	for _, line := range []string{"nop x1", "mv a0", "ret x1", "li a0, 0x100000000", "li a0, -0x80000001", "li a0, foo", "bgt a0, 8"} {
+		_, err := preprocessPseudoInstructions(line)
+		assert.Errorf(t, err, "preprocessPseudoInstructions(%q) should fail", line)
+	}
+}
+
+func TestParseInstruction_Pseudo(t *testing.T) {
+	assert.Equal(t, mustParse("addi x10, x11, 0"), mustParse("mv a0, a1"))
+	assert.Equal(t, mustParse("jalr x0, 0(x1)"), mustParse("ret"))
+	assert.Equal(t, mustParse("addi x5, x0, -1"), mustParse("li t0, 0xFFFFFFFF"))
+
+	_, err := ParseInstruction("li a0, 0x12345678")
+	assert.EqualError(t, err, "li expands to 2 instructions")
+	_, err = ParseInstruction("call 0x100")
+	assert.Error(t, err)
+}
+
+func TestAssembleFile_PseudoInstructions(t *testing.T) {
+	asm := `
+        li a0, 0x12345678   # lui + addi
+        la a1, data
+        call func
+        bgt a0, zero, end
+func:   mv a2, a0
+        ret
+end:    tail func
+data:   nop
+`
+	filename := writeTempASM(t, asm)
+	prog, err := AssembleFile(filename)
+	if err != nil {
+		t.Fatalf("AssembleFile returned error: %v", err)
+	}
+	checkInstructions(t, prog.Text.Words(), []string{
+		"lui x10, 0x12345",
+		"addi x10, x10, 1656",
+		"auipc x11, 0",      // 8
+		"addi x11, x11, 36", // data at 44
+		"auipc x1, 0",       // 16
+		"jalr x1, 12(x1)",   // func at 28
+		"blt x0, x10, 12",   // 24, end at 36
+		"addi x12, x10, 0",  // 28
+		"jalr x0, 0(x1)",    // 32
+		"auipc x6, 0",       // 36
+		"jalr x0, -8(x6)",
+		"addi x0, x0, 0", // 44
+	})
+}
+
+func TestAssemble_JalWithoutSpaces(t *testing.T) {
+	prog, err := Assemble([]string{"jal x1,8", "jal x1,end", "jal end", "end: nop"}, Options{})
+	if assert.NoError(t, err) {
+		checkInstructions(t, prog.Text.Words(), []string{"jal x1, 8", "jal x1, 8", "jal x1, 4", "addi x0, x0, 0"})
+	}
+}
+
+func TestLayoutProgram_PseudoInstructions(t *testing.T) {
+	lines := []string{
+		"li a0, 0x100000",
+		"a: li a0, 0x123456",
+		"b: call a",
+		"c: li a0, 1",
+		"d:",
+	}
+	l, err := layoutProgram(lines, Options{Compress: true})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, map[string]int{"a": 4, "b": 12, "c": 20, "d": 22}, l.labels)
+	var instructions []string
+	var sizes []int
+	for _, stmt := range l.statements {
+		instructions = append(instructions, stmt.text)
+		sizes = append(sizes, stmt.size)
+	}
+	assert.Equal(t, []string{"li a0, 0x100000", "li a0, 0x123456", "call a", "li a0, 1"}, instructions)
+	assert.Equal(t, []int{4, 8, 8, 2}, sizes)
+}
@@ -0,0 +1,39 @@
+package assembler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RegABINames holds the ABI names of the integer registers x0-x31.
+var RegABINames = [32]string{
+	"zero", "ra", "sp", "gp", "tp", "t0", "t1", "t2",
+	"s0", "s1", "a0", "a1", "a2", "a3", "a4", "a5",
+	"a6", "a7", "s2", "s3", "s4", "s5", "s6", "s7",
+	"s8", "s9", "s10", "s11", "t3", "t4", "t5", "t6",
+}
+
+// regNames maps the ABI names of the integer registers, and fp for s0, to register numbers.
+var regNames = func() map[string]uint32 {
+	names := map[string]uint32{"fp": 8}
+	for i, abi := range RegABINames {
+		names[abi] = uint32(i)
+	}
+	return names
+}()
+
+// ParseRegister returns the number of an integer register given by number
+// (x0-x31) or by ABI name (zero, ra, sp, a0, s0/fp, ...).
+func ParseRegister(name string) (uint32, error) {
+	if reg, ok := regNames[name]; ok {
+		return reg, nil
+	}
+	if digits, ok := strings.CutPrefix(name, "x"); ok {
+		// no sign, no leading zeros: x01 is not a register
+		if reg, err := strconv.ParseUint(digits, 10, 8); err == nil && reg <= 31 && digits == fmt.Sprint(reg) {
+			return uint32(reg), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid register: %q", name)
+}
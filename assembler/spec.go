@@ -0,0 +1,433 @@
+package assembler
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Format is the encoding format of an instruction, which determines where its
+// immediate is stored.
+type Format uint8
+
+const (
+	FORMAT_R Format = iota
+	FORMAT_R4
+	FORMAT_I
+	FORMAT_S
+	FORMAT_B
+	FORMAT_U
+	FORMAT_J
+)
+
+func (f Format) String() string {
+	return [...]string{"R", "R4", "I", "S", "B", "U", "J"}[f]
+}
+
+// Spec describes one instruction: its encoding, its assembler syntax and what
+// it does. The table of all instructions, Specs, drives the assembler
+// (ParseInstruction), the decoder used by the CPU and the disassembler (Decode)
+// and the help text. The CPU registers its execution with SetExec.
+//
+// Operands lists the operands in assembler order, e.g. "rd,imm(rs1)"; operands
+// in brackets are optional. The operand names are:
+//
+//	rd, rs1, rs2           integer registers in the rd, rs1 and rs2 fields
+//	frd, frs1, frs2, frs3  FP registers in the rd, rs1, rs2 and rs3 fields
+//	imm                    the immediate of the I-, S- or U-format
+//	offset                 the PC-relative offset of the B- or J-format
+//	shamt                  a shift amount (0-31) in the rs2 field
+//	uimm                   an unsigned immediate (0-31) in the rs1 field
+//	csr                    a CSR, by name or address, in the I-format immediate
+//	rm                     a rounding mode in funct3, Funct3 if omitted
+//	pred, succ             the ordering sets of fence, iorw if omitted
+//
+// Omitted optional registers are x0. All bits not covered by an operand are
+// fixed by Opcode, Funct3, Funct7 and Rs2.
+type Spec struct {
+	Mnemonic string
+	Format   Format
+	Opcode   Opcode
+	Funct3   uint32
+	// Funct7 holds bits 25-31: funct7, funct5 and fmt of FP instructions, or
+	// the upper bits of funct12 of the SYSTEM instructions
+	Funct7 uint32
+	// Rs2 holds bits 20-24 where they select the operation instead of a register
+	Rs2         uint32
+	Operands    string
+	Description string
+}
+
+// encoding holds what compile derives from a Spec.
+type encoding struct {
+	tokens      []token // the operand syntax
+	re          *regexp.Regexp
+	mask, match uint32 // instr&mask == match for all encodings of the instruction
+	exec        any    // see SetExec
+}
+
+// token is an operand name or a punctuation character of the operand syntax.
+type token struct {
+	text    string
+	operand bool
+	group   int // the innermost optional group the token is in, 0 if none
+}
+
+// operand patterns, matched against operands without whitespace
+const (
+	regPattern   = `[a-z][a-z0-9]*` // checked by ParseRegister
+	fpRegPattern = `f[a-z]?\d+`     // checked by parseFPReg
+	immPattern   = `-?(?:0[xX][0-9a-fA-F]+|\d+)`
+	uimmPattern  = `0[xX][0-9a-fA-F]+|\d+`
+	csrPattern   = `[a-z][a-z0-9]*|0[xX][0-9a-fA-F]+|\d+`
+)
+
+var operandPatterns = map[string]string{
+	"rd":     regPattern,
+	"rs1":    regPattern,
+	"rs2":    regPattern,
+	"frd":    fpRegPattern,
+	"frs1":   fpRegPattern,
+	"frs2":   fpRegPattern,
+	"frs3":   fpRegPattern,
+	"imm":    immPattern,
+	"offset": immPattern,
+	"shamt":  uimmPattern,
+	"uimm":   uimmPattern,
+	"csr":    csrPattern,
+	"rm":     `[a-z]+`,
+	"pred":   `[iorw]+`,
+	"succ":   `[iorw]+`,
+}
+
+// aqRlBits are the ordering bits of the AMO instructions, set by the .aq/.rl mnemonic suffix.
+const aqRlBits = 0x3 << 25
+
+// compile parses the operand syntax and derives the encoding mask.
+func (s *Spec) compile() *encoding {
+	e := &encoding{}
+	group, stack := 0, []int{0}
+	for _, text := range regexp.MustCompile(`[a-z0-9]+|.`).FindAllString(s.Operands, -1) {
+		_, operand := operandPatterns[text]
+		if !operand && strings.Trim(text, ",()[]") != "" {
+			panic(fmt.Sprintf("%s: unknown operand %q", s.Mnemonic, text))
+		}
+		if text == "[" {
+			group++
+			stack = append(stack, group)
+		}
+		e.tokens = append(e.tokens, token{text: text, operand: operand, group: stack[len(stack)-1]})
+		if text == "]" {
+			stack = stack[:len(stack)-1]
+		}
+	}
+
+	var pattern strings.Builder
+	for i, t := range e.tokens {
+		switch {
+		case t.operand:
+			pattern.WriteString("(" + operandPatterns[t.text] + ")")
+		case t.text == "[":
+			pattern.WriteString(`(?:`)
+		case t.text == "]":
+			pattern.WriteString(`)?`)
+		case t.text == "(" && (i == 0 || !e.tokens[i-1].operand):
+			// an address without offset may also be written with a zero offset, e.g. "0(x5)"
+			pattern.WriteString(`(?:0)?\(`)
+		default:
+			pattern.WriteString(regexp.QuoteMeta(t.text))
+		}
+	}
+	e.re = regexp.MustCompile("^" + pattern.String() + "$")
+
+	e.mask = 0xFFFFFFFF
+	for _, t := range e.tokens {
+		if t.operand {
+			e.mask &^= s.operandBits(t.text)
+		}
+	}
+	if s.Opcode == OPCODE_AMO {
+		e.mask &^= aqRlBits
+	}
+	e.match = s.base() & e.mask
+	return e
+}
+
+// encoding returns the compiled encoding of a spec of the table.
+func (s *Spec) encoding() *encoding {
+	return specs.encodings[s]
+}
+
+// SetExec registers the instruction's execution in the emulator. Package arch
+// does so for every instruction when it is initialized; the assembler does not
+// use it.
+func (s *Spec) SetExec(exec any) {
+	s.encoding().exec = exec
+}
+
+// Exec returns the execution registered with SetExec, or nil.
+func (s *Spec) Exec() any {
+	return s.encoding().exec
+}
+
+// base returns the fixed fields of the instruction.
+func (s *Spec) base() uint32 {
+	return uint32(s.Opcode) | s.Funct3<<12 | s.Rs2<<20 | s.Funct7<<25
+}
+
+// operandBits returns the bits of the instruction that encode the operand name.
+func (s *Spec) operandBits(name string) uint32 {
+	switch name {
+	case "rd", "frd":
+		return 0x1F << 7
+	case "rs1", "frs1", "uimm":
+		return 0x1F << 15
+	case "rs2", "frs2", "shamt":
+		return 0x1F << 20
+	case "frs3":
+		return 0x1F << 27
+	case "rm":
+		return 0x7 << 12
+	case "csr":
+		return 0xFFF << 20
+	case "pred":
+		return 0xFF << 24 // including the fm field
+	case "succ":
+		return 0xF << 20
+	}
+	// imm and offset
+	switch s.Format {
+	case FORMAT_I:
+		return 0xFFF << 20
+	case FORMAT_S, FORMAT_B:
+		return 0x7F<<25 | 0x1F<<7
+	case FORMAT_U, FORMAT_J:
+		return 0xFFFFF << 12
+	}
+	return 0
+}
+
+// Syntax returns the assembler syntax of the instruction, e.g. "addi rd, rs1, imm".
+func (s *Spec) Syntax() string {
+	if s.Operands == "" {
+		return s.Mnemonic
+	}
+	return s.Mnemonic + " " + strings.ReplaceAll(s.Operands, ",", ", ")
+}
+
+// Matches reports whether instr is an encoding of the instruction.
+func (s *Spec) Matches(instr Instruction) bool {
+	e := s.encoding()
+	return uint32(instr)&e.mask == e.match
+}
+
+// Encode assembles the instruction with the given operands, from which all
+// whitespace has been removed (e.g. "x1,x2,5").
+func (s *Spec) Encode(operands string) (Instruction, error) {
+	e := s.encoding()
+	m := e.re.FindStringSubmatch(operands)
+	if m == nil {
+		return 0, fmt.Errorf("invalid %s operands: %q", s.Mnemonic, operands)
+	}
+	instr := Instruction(s.base())
+	n := 1
+	for _, t := range e.tokens {
+		if !t.operand {
+			continue
+		}
+		if err := s.encodeOperand(&instr, t.text, m[n]); err != nil {
+			return 0, err
+		}
+		n++
+	}
+	return instr, nil
+}
+
+// encodeOperand stores the operand name, given as text, in instr. An empty
+// text is an omitted optional operand.
+func (s *Spec) encodeOperand(instr *Instruction, name, text string) error {
+	if text == "" {
+		if name != "pred" && name != "succ" {
+			return nil // x0, or the rounding mode in Funct3
+		}
+		text = "iorw"
+	}
+	switch name {
+	case "rd", "rs1", "rs2":
+		reg, err := ParseRegister(text)
+		if err != nil {
+			return err
+		}
+		setRegField(instr, name, reg)
+	case "frd", "frs1", "frs2", "frs3":
+		reg, err := parseFPReg(text)
+		if err != nil {
+			return err
+		}
+		setRegField(instr, name[1:], reg)
+	case "shamt", "uimm":
+		v, err := parseUint(text)
+		if err != nil {
+			return err
+		}
+		if v > 31 {
+			if name == "shamt" {
+				return fmt.Errorf("shift amount out of range for %s: %d", s.Mnemonic, v)
+			}
+			return fmt.Errorf("immediate out of range for %s: %d", s.Mnemonic, v)
+		}
+		setRegField(instr, map[string]string{"shamt": "rs2", "uimm": "rs1"}[name], v)
+	case "csr":
+		csr, err := ParseCSR(text)
+		if err != nil {
+			return err
+		}
+		instr.SetImmI(int32(csr))
+	case "rm":
+		rm, ok := roundingModes[text]
+		if !ok {
+			return fmt.Errorf("invalid rounding mode for %s: %q", s.Mnemonic, text)
+		}
+		instr.SetFunct3(rm)
+	case "pred":
+		*instr = Instruction(uint32(*instr)&^(0xF<<24) | fenceSet(text)<<24)
+	case "succ":
+		*instr = Instruction(uint32(*instr)&^(0xF<<20) | fenceSet(text)<<20)
+	case "imm", "offset":
+		v, err := parseInt(text)
+		if err != nil {
+			return err
+		}
+		if name == "offset" {
+			return s.encodeOffset(instr, v)
+		}
+		return s.encodeImm(instr, v)
+	}
+	return nil
+}
+
+func (s *Spec) encodeImm(instr *Instruction, imm int64) error {
+	if s.Format == FORMAT_U {
+		if imm < 0 || imm > 0xFFFFF {
+			return fmt.Errorf("immediate out of range for %s: %d", s.Mnemonic, imm)
+		}
+		instr.SetImmU(int32(imm))
+		return nil
+	}
+	if imm < -2048 || imm > 2047 {
+		return fmt.Errorf("immediate out of range for %s: %d", s.Mnemonic, imm)
+	}
+	if s.Format == FORMAT_S {
+		instr.SetImmS(int32(imm))
+	} else {
+		instr.SetImmI(int32(imm))
+	}
+	return nil
+}
+
+func (s *Spec) encodeOffset(instr *Instruction, offset int64) error {
+	limit := int64(1 << 12)
+	if s.Format == FORMAT_J {
+		limit = 1 << 20
+	}
+	if offset < -limit || offset > limit-1 {
+		return fmt.Errorf("immediate out of range for %s: %d", s.Mnemonic, offset)
+	}
+	if offset%2 != 0 {
+		return fmt.Errorf("offset must be a multiple of 2 for %s: %d", s.Mnemonic, offset)
+	}
+	if s.Format == FORMAT_J {
+		instr.SetImmJ(int32(offset))
+	} else {
+		instr.SetImmB(int32(offset))
+	}
+	return nil
+}
+
+// setRegField stores a register number in the field rd, rs1, rs2 or rs3.
+func setRegField(instr *Instruction, field string, reg uint32) {
+	switch field {
+	case "rd":
+		instr.SetRd(reg)
+	case "rs1":
+		instr.SetRs1(reg)
+	case "rs2":
+		instr.SetRs2(reg)
+	case "rs3":
+		instr.SetRs3(reg)
+	}
+}
+
+// regField returns the register number in the field of the register operand name.
+func regField(instr Instruction, name string) uint32 {
+	switch strings.TrimPrefix(name, "f") {
+	case "rd":
+		return instr.Rd()
+	case "rs1", "uimm":
+		return instr.Rs1()
+	case "rs2", "shamt":
+		return instr.Rs2()
+	}
+	return instr.Rs3()
+}
+
+// isDefault reports whether the operand name of instr has the value it gets when omitted.
+func (s *Spec) isDefault(instr Instruction, name string) bool {
+	switch name {
+	case "rm":
+		return instr.Funct3() == s.Funct3
+	case "pred":
+		return uint32(instr)>>24&0xF == 0xF
+	case "succ":
+		return uint32(instr)>>20&0xF == 0xF
+	}
+	return regField(instr, name) == 0
+}
+
+// specIndex finds the specs by mnemonic and by opcode, and holds their encodings.
+type specIndex struct {
+	byMnemonic map[string]*Spec
+	byOpcode   map[Opcode][]*Spec
+	encodings  map[*Spec]*encoding
+}
+
+var specs = newSpecIndex()
+
+func newSpecIndex() *specIndex {
+	index := &specIndex{make(map[string]*Spec), make(map[Opcode][]*Spec), make(map[*Spec]*encoding)}
+	for i := range Specs {
+		s := &Specs[i]
+		index.encodings[s] = s.compile()
+		index.byMnemonic[s.Mnemonic] = s
+		index.byOpcode[s.Opcode] = append(index.byOpcode[s.Opcode], s)
+	}
+	for alias, name := range specAliases {
+		index.byMnemonic[alias] = index.byMnemonic[name]
+	}
+	return index
+}
+
+// specAliases are alternative mnemonics of instructions.
+var specAliases = map[string]string{
+	// pre-2.2 spellings of fmv.x.w and fmv.w.x
+	"fmv.x.s": "fmv.x.w",
+	"fmv.s.x": "fmv.w.x",
+}
+
+// Lookup returns the spec of the instruction with the given mnemonic. The A
+// extension mnemonics are looked up without their .aq/.rl suffix.
+func Lookup(mnemonic string) (*Spec, bool) {
+	s, ok := specs.byMnemonic[mnemonic]
+	return s, ok
+}
+
+// Decode returns the spec of the 32-bit instruction instr, or nil if instr is
+// not a valid encoding of any instruction.
+func Decode(instr Instruction) *Spec {
+	for _, s := range specs.byOpcode[Opcode(uint32(instr)&0x7F)] {
+		if s.Matches(instr) {
+			return s
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package assembler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpecs_Decode(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := range Specs {
+		s := &Specs[i]
+		assert.False(t, seen[s.Mnemonic], "duplicate mnemonic %s", s.Mnemonic)
+		seen[s.Mnemonic] = true
+		// with all operands zero, no other instruction may claim the encoding
+		instr := Instruction(s.encoding().match)
+		assert.Same(t, s, Decode(instr), "decoding %s (0x%08x)", s.Mnemonic, uint32(instr))
+	}
+}
+
+func TestSpec_Encode(t *testing.T) {
+	// omitted optional operands take their default
+	same := [][2]string{
+		{"fence", "fence iorw, iorw"},
+		{"fadd.s f1, f2, f3", "fadd.s f1, f2, f3, dyn"},
+		{"fcvt.d.w f1, x2", "fcvt.d.w f1, x2, rne"},
+		{"sfence.vma", "sfence.vma x0, x0"},
+		{"sfence.vma x1", "sfence.vma x1, x0"},
+		{"lr.w x1, (x2)", "lr.w x1, 0(x2)"},
+	}
+	for _, pair := range same {
+		assert.Equal(t, mustParse(pair[1]), mustParse(pair[0]), pair[0])
+	}
+
+	for _, line := range []string{
+		"fence rw",        // both sets or none
+		"sfence.vma , x2", // rs2 needs rs1
+		"fmin.s f1, f2, f3, rne",
+		"add.aq x1, x2, x3", // ordering suffixes only for the A extension
+		"srli x1, x2, 32",
+		"csrrwi x1, mstatus, 32",
+	} {
+		_, err := ParseInstruction(line)
+		assert.Error(t, err, line)
+	}
+}
+
+func TestDecode_Invalid(t *testing.T) {
+	for _, instr := range []uint32{
+		0x00000000, // all zeros
+		0xFFFFFFFF, // all ones
+		0x00009067, // jalr with funct3 1
+		0x40001013, // slli with funct7 0x20
+		0x08000033, // R-type with funct7 0x04
+		0x00108073, // ecall with rs1 x1
+		0x04000053, // OP-FP with fmt 2
+	} {
+		assert.Nil(t, Decode(Instruction(instr)), "0x%08x", instr)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	s, ok := Lookup("addi")
+	if assert.True(t, ok) {
+		assert.Equal(t, "addi rd, rs1, imm", s.Syntax())
+		assert.Equal(t, FORMAT_I, s.Format)
+	}
+	s, ok = Lookup("fmv.x.s")
+	if assert.True(t, ok) {
+		assert.Equal(t, "fmv.x.w", s.Mnemonic)
+	}
+	s, _ = Lookup("sfence.vma")
+	assert.Equal(t, "sfence.vma [rs1[, rs2]]", s.Syntax())
+	_, ok = Lookup("amoadd.w.aq")
+	assert.False(t, ok)
+}
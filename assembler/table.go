@@ -0,0 +1,146 @@
+package assembler
+
+// fp returns the funct7 field of an OP-FP instruction: funct5 and fmt.
+func fp(funct5, format uint32) uint32 {
+	return funct5<<2 | format
+}
+
+// Specs lists all instructions of RV32IMAFD with Zicsr and the privileged
+// instructions, grouped by extension. Adding an instruction takes an entry
+// here and its executor in the CPU, which refuses to start without one.
+var Specs = []Spec{
+	// RV32I
+	{"lui", FORMAT_U, OPCODE_LUI, 0, 0, 0, "rd,imm", "rd = imm << 12"},
+	{"auipc", FORMAT_U, OPCODE_AUIPC, 0, 0, 0, "rd,imm", "rd = pc + (imm << 12)"},
+	{"jal", FORMAT_J, OPCODE_JAL, 0, 0, 0, "rd,offset", "rd = pc + 4; pc += offset"},
+	{"jalr", FORMAT_I, OPCODE_JALR, FUNCT3_JALR, 0, 0, "rd,imm(rs1)", "rd = pc + 4; pc = (rs1 + imm) & ~1"},
+	{"beq", FORMAT_B, OPCODE_BRANCH, FUNCT3_BEQ, 0, 0, "rs1,rs2,offset", "if rs1 == rs2: pc += offset"},
+	{"bne", FORMAT_B, OPCODE_BRANCH, FUNCT3_BNE, 0, 0, "rs1,rs2,offset", "if rs1 != rs2: pc += offset"},
+	{"blt", FORMAT_B, OPCODE_BRANCH, FUNCT3_BLT, 0, 0, "rs1,rs2,offset", "if rs1 < rs2 (signed): pc += offset"},
+	{"bge", FORMAT_B, OPCODE_BRANCH, FUNCT3_BGE, 0, 0, "rs1,rs2,offset", "if rs1 >= rs2 (signed): pc += offset"},
+	{"bltu", FORMAT_B, OPCODE_BRANCH, FUNCT3_BLTU, 0, 0, "rs1,rs2,offset", "if rs1 < rs2 (unsigned): pc += offset"},
+	{"bgeu", FORMAT_B, OPCODE_BRANCH, FUNCT3_BGEU, 0, 0, "rs1,rs2,offset", "if rs1 >= rs2 (unsigned): pc += offset"},
+	{"lb", FORMAT_I, OPCODE_LOAD, FUNCT3_LB, 0, 0, "rd,imm(rs1)", "rd = sign-extended byte at rs1 + imm"},
+	{"lh", FORMAT_I, OPCODE_LOAD, FUNCT3_LH, 0, 0, "rd,imm(rs1)", "rd = sign-extended halfword at rs1 + imm"},
+	{"lw", FORMAT_I, OPCODE_LOAD, FUNCT3_LW, 0, 0, "rd,imm(rs1)", "rd = word at rs1 + imm"},
+	{"lbu", FORMAT_I, OPCODE_LOAD, FUNCT3_LBU, 0, 0, "rd,imm(rs1)", "rd = zero-extended byte at rs1 + imm"},
+	{"lhu", FORMAT_I, OPCODE_LOAD, FUNCT3_LHU, 0, 0, "rd,imm(rs1)", "rd = zero-extended halfword at rs1 + imm"},
+	{"sb", FORMAT_S, OPCODE_STORE, FUNCT3_SB, 0, 0, "rs2,imm(rs1)", "byte at rs1 + imm = rs2"},
+	{"sh", FORMAT_S, OPCODE_STORE, FUNCT3_SH, 0, 0, "rs2,imm(rs1)", "halfword at rs1 + imm = rs2"},
+	{"sw", FORMAT_S, OPCODE_STORE, FUNCT3_SW, 0, 0, "rs2,imm(rs1)", "word at rs1 + imm = rs2"},
+	{"addi", FORMAT_I, OPCODE_I_TYPE, FUNCT3_ADDI, 0, 0, "rd,rs1,imm", "rd = rs1 + imm"},
+	{"slti", FORMAT_I, OPCODE_I_TYPE, FUNCT3_SLTI, 0, 0, "rd,rs1,imm", "rd = rs1 < imm (signed) ? 1 : 0"},
+	{"sltiu", FORMAT_I, OPCODE_I_TYPE, FUNCT3_SLTIU, 0, 0, "rd,rs1,imm", "rd = rs1 < imm (unsigned) ? 1 : 0"},
+	{"xori", FORMAT_I, OPCODE_I_TYPE, FUNCT3_XORI, 0, 0, "rd,rs1,imm", "rd = rs1 ^ imm"},
+	{"ori", FORMAT_I, OPCODE_I_TYPE, FUNCT3_ORI, 0, 0, "rd,rs1,imm", "rd = rs1 | imm"},
+	{"andi", FORMAT_I, OPCODE_I_TYPE, FUNCT3_ANDI, 0, 0, "rd,rs1,imm", "rd = rs1 & imm"},
+	{"slli", FORMAT_I, OPCODE_I_TYPE, FUNCT3_SLLI, 0, 0, "rd,rs1,shamt", "rd = rs1 << shamt"},
+	{"srli", FORMAT_I, OPCODE_I_TYPE, FUNCT3_SRLI_SRAI, FUNCT7_SRL, 0, "rd,rs1,shamt", "rd = rs1 >> shamt (logical)"},
+	{"srai", FORMAT_I, OPCODE_I_TYPE, FUNCT3_SRLI_SRAI, FUNCT7_SRA, 0, "rd,rs1,shamt", "rd = rs1 >> shamt (arithmetic)"},
+	{"add", FORMAT_R, OPCODE_R_TYPE, FUNCT3_ADD_SUB, FUNCT7_ADD, 0, "rd,rs1,rs2", "rd = rs1 + rs2"},
+	{"sub", FORMAT_R, OPCODE_R_TYPE, FUNCT3_ADD_SUB, FUNCT7_SUB, 0, "rd,rs1,rs2", "rd = rs1 - rs2"},
+	{"sll", FORMAT_R, OPCODE_R_TYPE, FUNCT3_SLL, 0, 0, "rd,rs1,rs2", "rd = rs1 << rs2"},
+	{"slt", FORMAT_R, OPCODE_R_TYPE, FUNCT3_SLT, 0, 0, "rd,rs1,rs2", "rd = rs1 < rs2 (signed) ? 1 : 0"},
+	{"sltu", FORMAT_R, OPCODE_R_TYPE, FUNCT3_SLTU, 0, 0, "rd,rs1,rs2", "rd = rs1 < rs2 (unsigned) ? 1 : 0"},
+	{"xor", FORMAT_R, OPCODE_R_TYPE, FUNCT3_XOR, 0, 0, "rd,rs1,rs2", "rd = rs1 ^ rs2"},
+	{"srl", FORMAT_R, OPCODE_R_TYPE, FUNCT3_SRL_SRA, FUNCT7_SRL, 0, "rd,rs1,rs2", "rd = rs1 >> rs2 (logical)"},
+	{"sra", FORMAT_R, OPCODE_R_TYPE, FUNCT3_SRL_SRA, FUNCT7_SRA, 0, "rd,rs1,rs2", "rd = rs1 >> rs2 (arithmetic)"},
+	{"or", FORMAT_R, OPCODE_R_TYPE, FUNCT3_OR, 0, 0, "rd,rs1,rs2", "rd = rs1 | rs2"},
+	{"and", FORMAT_R, OPCODE_R_TYPE, FUNCT3_AND, 0, 0, "rd,rs1,rs2", "rd = rs1 & rs2"},
+	{"fence", FORMAT_I, OPCODE_MISC_MEM, FUNCT3_FENCE, 0, 0, "[pred,succ]", "order memory accesses (a no-op on this single hart)"},
+	{"ecall", FORMAT_I, OPCODE_SYSTEM, FUNCT3_PRIV, FUNCT12_ECALL >> 5, FUNCT12_ECALL & 0x1F, "", "request a service from the execution environment"},
+	{"ebreak", FORMAT_I, OPCODE_SYSTEM, FUNCT3_PRIV, FUNCT12_EBREAK >> 5, FUNCT12_EBREAK & 0x1F, "", "breakpoint"},
+
+	// M extension
+	{"mul", FORMAT_R, OPCODE_R_TYPE, FUNCT3_MUL, FUNCT7_MULDIV, 0, "rd,rs1,rs2", "rd = lower 32 bits of rs1 * rs2"},
+	{"mulh", FORMAT_R, OPCODE_R_TYPE, FUNCT3_MULH, FUNCT7_MULDIV, 0, "rd,rs1,rs2", "rd = upper 32 bits of rs1 * rs2 (signed)"},
+	{"mulhsu", FORMAT_R, OPCODE_R_TYPE, FUNCT3_MULHSU, FUNCT7_MULDIV, 0, "rd,rs1,rs2", "rd = upper 32 bits of rs1 (signed) * rs2 (unsigned)"},
+	{"mulhu", FORMAT_R, OPCODE_R_TYPE, FUNCT3_MULHU, FUNCT7_MULDIV, 0, "rd,rs1,rs2", "rd = upper 32 bits of rs1 * rs2 (unsigned)"},
+	{"div", FORMAT_R, OPCODE_R_TYPE, FUNCT3_DIV, FUNCT7_MULDIV, 0, "rd,rs1,rs2", "rd = rs1 / rs2 (signed)"},
+	{"divu", FORMAT_R, OPCODE_R_TYPE, FUNCT3_DIVU, FUNCT7_MULDIV, 0, "rd,rs1,rs2", "rd = rs1 / rs2 (unsigned)"},
+	{"rem", FORMAT_R, OPCODE_R_TYPE, FUNCT3_REM, FUNCT7_MULDIV, 0, "rd,rs1,rs2", "rd = rs1 % rs2 (signed)"},
+	{"remu", FORMAT_R, OPCODE_R_TYPE, FUNCT3_REMU, FUNCT7_MULDIV, 0, "rd,rs1,rs2", "rd = rs1 % rs2 (unsigned)"},
+
+	// A extension; the mnemonics take an optional .aq, .rl or .aqrl suffix
+	{"lr.w", FORMAT_R, OPCODE_AMO, FUNCT3_AMO_W, FUNCT5_LR << 2, 0, "rd,(rs1)", "rd = word at rs1; reserve it"},
+	{"sc.w", FORMAT_R, OPCODE_AMO, FUNCT3_AMO_W, FUNCT5_SC << 2, 0, "rd,rs2,(rs1)", "if reserved: word at rs1 = rs2, rd = 0; else rd = 1"},
+	{"amoswap.w", FORMAT_R, OPCODE_AMO, FUNCT3_AMO_W, FUNCT5_AMOSWAP << 2, 0, "rd,rs2,(rs1)", "rd = word at rs1; word at rs1 = rs2"},
+	{"amoadd.w", FORMAT_R, OPCODE_AMO, FUNCT3_AMO_W, FUNCT5_AMOADD << 2, 0, "rd,rs2,(rs1)", "rd = word at rs1; word at rs1 += rs2"},
+	{"amoxor.w", FORMAT_R, OPCODE_AMO, FUNCT3_AMO_W, FUNCT5_AMOXOR << 2, 0, "rd,rs2,(rs1)", "rd = word at rs1; word at rs1 ^= rs2"},
+	{"amoand.w", FORMAT_R, OPCODE_AMO, FUNCT3_AMO_W, FUNCT5_AMOAND << 2, 0, "rd,rs2,(rs1)", "rd = word at rs1; word at rs1 &= rs2"},
+	{"amoor.w", FORMAT_R, OPCODE_AMO, FUNCT3_AMO_W, FUNCT5_AMOOR << 2, 0, "rd,rs2,(rs1)", "rd = word at rs1; word at rs1 |= rs2"},
+	{"amomin.w", FORMAT_R, OPCODE_AMO, FUNCT3_AMO_W, FUNCT5_AMOMIN << 2, 0, "rd,rs2,(rs1)", "rd = word at rs1; word at rs1 = min(rd, rs2) (signed)"},
+	{"amomax.w", FORMAT_R, OPCODE_AMO, FUNCT3_AMO_W, FUNCT5_AMOMAX << 2, 0, "rd,rs2,(rs1)", "rd = word at rs1; word at rs1 = max(rd, rs2) (signed)"},
+	{"amominu.w", FORMAT_R, OPCODE_AMO, FUNCT3_AMO_W, FUNCT5_AMOMINU << 2, 0, "rd,rs2,(rs1)", "rd = word at rs1; word at rs1 = min(rd, rs2) (unsigned)"},
+	{"amomaxu.w", FORMAT_R, OPCODE_AMO, FUNCT3_AMO_W, FUNCT5_AMOMAXU << 2, 0, "rd,rs2,(rs1)", "rd = word at rs1; word at rs1 = max(rd, rs2) (unsigned)"},
+
+	// F extension
+	{"flw", FORMAT_I, OPCODE_LOAD_FP, FUNCT3_FLW, 0, 0, "frd,imm(rs1)", "frd = single at rs1 + imm"},
+	{"fsw", FORMAT_S, OPCODE_STORE_FP, FUNCT3_FSW, 0, 0, "frs2,imm(rs1)", "single at rs1 + imm = frs2"},
+	{"fmadd.s", FORMAT_R4, OPCODE_FMADD, RM_DYN, FMT_S, 0, "frd,frs1,frs2,frs3[,rm]", "frd = frs1 * frs2 + frs3"},
+	{"fmsub.s", FORMAT_R4, OPCODE_FMSUB, RM_DYN, FMT_S, 0, "frd,frs1,frs2,frs3[,rm]", "frd = frs1 * frs2 - frs3"},
+	{"fnmsub.s", FORMAT_R4, OPCODE_FNMSUB, RM_DYN, FMT_S, 0, "frd,frs1,frs2,frs3[,rm]", "frd = -(frs1 * frs2) + frs3"},
+	{"fnmadd.s", FORMAT_R4, OPCODE_FNMADD, RM_DYN, FMT_S, 0, "frd,frs1,frs2,frs3[,rm]", "frd = -(frs1 * frs2) - frs3"},
+	{"fadd.s", FORMAT_R, OPCODE_OP_FP, RM_DYN, fp(FUNCT5_FADD, FMT_S), 0, "frd,frs1,frs2[,rm]", "frd = frs1 + frs2"},
+	{"fsub.s", FORMAT_R, OPCODE_OP_FP, RM_DYN, fp(FUNCT5_FSUB, FMT_S), 0, "frd,frs1,frs2[,rm]", "frd = frs1 - frs2"},
+	{"fmul.s", FORMAT_R, OPCODE_OP_FP, RM_DYN, fp(FUNCT5_FMUL, FMT_S), 0, "frd,frs1,frs2[,rm]", "frd = frs1 * frs2"},
+	{"fdiv.s", FORMAT_R, OPCODE_OP_FP, RM_DYN, fp(FUNCT5_FDIV, FMT_S), 0, "frd,frs1,frs2[,rm]", "frd = frs1 / frs2"},
+	{"fsqrt.s", FORMAT_R, OPCODE_OP_FP, RM_DYN, fp(FUNCT5_FSQRT, FMT_S), 0, "frd,frs1[,rm]", "frd = sqrt(frs1)"},
+	{"fsgnj.s", FORMAT_R, OPCODE_OP_FP, FUNCT3_FSGNJ, fp(FUNCT5_FSGNJ, FMT_S), 0, "frd,frs1,frs2", "frd = frs1 with the sign of frs2"},
+	{"fsgnjn.s", FORMAT_R, OPCODE_OP_FP, FUNCT3_FSGNJN, fp(FUNCT5_FSGNJ, FMT_S), 0, "frd,frs1,frs2", "frd = frs1 with the negated sign of frs2"},
+	{"fsgnjx.s", FORMAT_R, OPCODE_OP_FP, FUNCT3_FSGNJX, fp(FUNCT5_FSGNJ, FMT_S), 0, "frd,frs1,frs2", "frd = frs1 with the sign of frs1 ^ frs2"},
+	{"fmin.s", FORMAT_R, OPCODE_OP_FP, FUNCT3_FMIN, fp(FUNCT5_FMINMAX, FMT_S), 0, "frd,frs1,frs2", "frd = min(frs1, frs2)"},
+	{"fmax.s", FORMAT_R, OPCODE_OP_FP, FUNCT3_FMAX, fp(FUNCT5_FMINMAX, FMT_S), 0, "frd,frs1,frs2", "frd = max(frs1, frs2)"},
+	{"fcvt.w.s", FORMAT_R, OPCODE_OP_FP, RM_DYN, fp(FUNCT5_FCVT_TO_X, FMT_S), 0, "rd,frs1[,rm]", "rd = frs1 converted to a signed integer"},
+	{"fcvt.wu.s", FORMAT_R, OPCODE_OP_FP, RM_DYN, fp(FUNCT5_FCVT_TO_X, FMT_S), 1, "rd,frs1[,rm]", "rd = frs1 converted to an unsigned integer"},
+	{"fmv.x.w", FORMAT_R, OPCODE_OP_FP, FUNCT3_FMV_X, fp(FUNCT5_FMV_X, FMT_S), 0, "rd,frs1", "rd = bits of frs1"},
+	{"feq.s", FORMAT_R, OPCODE_OP_FP, FUNCT3_FEQ, fp(FUNCT5_FCMP, FMT_S), 0, "rd,frs1,frs2", "rd = frs1 == frs2 ? 1 : 0"},
+	{"flt.s", FORMAT_R, OPCODE_OP_FP, FUNCT3_FLT, fp(FUNCT5_FCMP, FMT_S), 0, "rd,frs1,frs2", "rd = frs1 < frs2 ? 1 : 0"},
+	{"fle.s", FORMAT_R, OPCODE_OP_FP, FUNCT3_FLE, fp(FUNCT5_FCMP, FMT_S), 0, "rd,frs1,frs2", "rd = frs1 <= frs2 ? 1 : 0"},
+	{"fclass.s", FORMAT_R, OPCODE_OP_FP, FUNCT3_FCLASS, fp(FUNCT5_FMV_X, FMT_S), 0, "rd,frs1", "rd = class mask of frs1"},
+	{"fcvt.s.w", FORMAT_R, OPCODE_OP_FP, RM_DYN, fp(FUNCT5_FCVT_TO_F, FMT_S), 0, "frd,rs1[,rm]", "frd = signed integer rs1 converted to single"},
+	{"fcvt.s.wu", FORMAT_R, OPCODE_OP_FP, RM_DYN, fp(FUNCT5_FCVT_TO_F, FMT_S), 1, "frd,rs1[,rm]", "frd = unsigned integer rs1 converted to single"},
+	{"fmv.w.x", FORMAT_R, OPCODE_OP_FP, 0, fp(FUNCT5_FMV_F, FMT_S), 0, "frd,rs1", "frd = bits of rs1"},
+
+	// D extension; int to double and single to double conversions are exact: rm defaults to RNE
+	{"fld", FORMAT_I, OPCODE_LOAD_FP, FUNCT3_FLD, 0, 0, "frd,imm(rs1)", "frd = double at rs1 + imm"},
+	{"fsd", FORMAT_S, OPCODE_STORE_FP, FUNCT3_FSD, 0, 0, "frs2,imm(rs1)", "double at rs1 + imm = frs2"},
+	{"fmadd.d", FORMAT_R4, OPCODE_FMADD, RM_DYN, FMT_D, 0, "frd,frs1,frs2,frs3[,rm]", "frd = frs1 * frs2 + frs3"},
+	{"fmsub.d", FORMAT_R4, OPCODE_FMSUB, RM_DYN, FMT_D, 0, "frd,frs1,frs2,frs3[,rm]", "frd = frs1 * frs2 - frs3"},
+	{"fnmsub.d", FORMAT_R4, OPCODE_FNMSUB, RM_DYN, FMT_D, 0, "frd,frs1,frs2,frs3[,rm]", "frd = -(frs1 * frs2) + frs3"},
+	{"fnmadd.d", FORMAT_R4, OPCODE_FNMADD, RM_DYN, FMT_D, 0, "frd,frs1,frs2,frs3[,rm]", "frd = -(frs1 * frs2) - frs3"},
+	{"fadd.d", FORMAT_R, OPCODE_OP_FP, RM_DYN, fp(FUNCT5_FADD, FMT_D), 0, "frd,frs1,frs2[,rm]", "frd = frs1 + frs2"},
+	{"fsub.d", FORMAT_R, OPCODE_OP_FP, RM_DYN, fp(FUNCT5_FSUB, FMT_D), 0, "frd,frs1,frs2[,rm]", "frd = frs1 - frs2"},
+	{"fmul.d", FORMAT_R, OPCODE_OP_FP, RM_DYN, fp(FUNCT5_FMUL, FMT_D), 0, "frd,frs1,frs2[,rm]", "frd = frs1 * frs2"},
+	{"fdiv.d", FORMAT_R, OPCODE_OP_FP, RM_DYN, fp(FUNCT5_FDIV, FMT_D), 0, "frd,frs1,frs2[,rm]", "frd = frs1 / frs2"},
+	{"fsqrt.d", FORMAT_R, OPCODE_OP_FP, RM_DYN, fp(FUNCT5_FSQRT, FMT_D), 0, "frd,frs1[,rm]", "frd = sqrt(frs1)"},
+	{"fsgnj.d", FORMAT_R, OPCODE_OP_FP, FUNCT3_FSGNJ, fp(FUNCT5_FSGNJ, FMT_D), 0, "frd,frs1,frs2", "frd = frs1 with the sign of frs2"},
+	{"fsgnjn.d", FORMAT_R, OPCODE_OP_FP, FUNCT3_FSGNJN, fp(FUNCT5_FSGNJ, FMT_D), 0, "frd,frs1,frs2", "frd = frs1 with the negated sign of frs2"},
+	{"fsgnjx.d", FORMAT_R, OPCODE_OP_FP, FUNCT3_FSGNJX, fp(FUNCT5_FSGNJ, FMT_D), 0, "frd,frs1,frs2", "frd = frs1 with the sign of frs1 ^ frs2"},
+	{"fmin.d", FORMAT_R, OPCODE_OP_FP, FUNCT3_FMIN, fp(FUNCT5_FMINMAX, FMT_D), 0, "frd,frs1,frs2", "frd = min(frs1, frs2)"},
+	{"fmax.d", FORMAT_R, OPCODE_OP_FP, FUNCT3_FMAX, fp(FUNCT5_FMINMAX, FMT_D), 0, "frd,frs1,frs2", "frd = max(frs1, frs2)"},
+	{"fcvt.s.d", FORMAT_R, OPCODE_OP_FP, RM_DYN, fp(FUNCT5_FCVT_FMT, FMT_S), FMT_D, "frd,frs1[,rm]", "frd = double frs1 converted to single"},
+	{"fcvt.d.s", FORMAT_R, OPCODE_OP_FP, RM_RNE, fp(FUNCT5_FCVT_FMT, FMT_D), FMT_S, "frd,frs1[,rm]", "frd = single frs1 converted to double"},
+	{"feq.d", FORMAT_R, OPCODE_OP_FP, FUNCT3_FEQ, fp(FUNCT5_FCMP, FMT_D), 0, "rd,frs1,frs2", "rd = frs1 == frs2 ? 1 : 0"},
+	{"flt.d", FORMAT_R, OPCODE_OP_FP, FUNCT3_FLT, fp(FUNCT5_FCMP, FMT_D), 0, "rd,frs1,frs2", "rd = frs1 < frs2 ? 1 : 0"},
+	{"fle.d", FORMAT_R, OPCODE_OP_FP, FUNCT3_FLE, fp(FUNCT5_FCMP, FMT_D), 0, "rd,frs1,frs2", "rd = frs1 <= frs2 ? 1 : 0"},
+	{"fclass.d", FORMAT_R, OPCODE_OP_FP, FUNCT3_FCLASS, fp(FUNCT5_FMV_X, FMT_D), 0, "rd,frs1", "rd = class mask of frs1"},
+	{"fcvt.w.d", FORMAT_R, OPCODE_OP_FP, RM_DYN, fp(FUNCT5_FCVT_TO_X, FMT_D), 0, "rd,frs1[,rm]", "rd = frs1 converted to a signed integer"},
+	{"fcvt.wu.d", FORMAT_R, OPCODE_OP_FP, RM_DYN, fp(FUNCT5_FCVT_TO_X, FMT_D), 1, "rd,frs1[,rm]", "rd = frs1 converted to an unsigned integer"},
+	{"fcvt.d.w", FORMAT_R, OPCODE_OP_FP, RM_RNE, fp(FUNCT5_FCVT_TO_F, FMT_D), 0, "frd,rs1[,rm]", "frd = signed integer rs1 converted to double"},
+	{"fcvt.d.wu", FORMAT_R, OPCODE_OP_FP, RM_RNE, fp(FUNCT5_FCVT_TO_F, FMT_D), 1, "frd,rs1[,rm]", "frd = unsigned integer rs1 converted to double"},
+
+	// Zicsr
+	{"csrrw", FORMAT_I, OPCODE_SYSTEM, FUNCT3_CSRRW, 0, 0, "rd,csr,rs1", "rd = csr; csr = rs1"},
+	{"csrrs", FORMAT_I, OPCODE_SYSTEM, FUNCT3_CSRRS, 0, 0, "rd,csr,rs1", "rd = csr; csr |= rs1"},
+	{"csrrc", FORMAT_I, OPCODE_SYSTEM, FUNCT3_CSRRC, 0, 0, "rd,csr,rs1", "rd = csr; csr &= ~rs1"},
+	{"csrrwi", FORMAT_I, OPCODE_SYSTEM, FUNCT3_CSRRWI, 0, 0, "rd,csr,uimm", "rd = csr; csr = uimm"},
+	{"csrrsi", FORMAT_I, OPCODE_SYSTEM, FUNCT3_CSRRSI, 0, 0, "rd,csr,uimm", "rd = csr; csr |= uimm"},
+	{"csrrci", FORMAT_I, OPCODE_SYSTEM, FUNCT3_CSRRCI, 0, 0, "rd,csr,uimm", "rd = csr; csr &= ~uimm"},
+
+	// privileged instructions
+	{"sret", FORMAT_I, OPCODE_SYSTEM, FUNCT3_PRIV, FUNCT12_SRET >> 5, FUNCT12_SRET & 0x1F, "", "return from a supervisor trap handler"},
+	{"mret", FORMAT_I, OPCODE_SYSTEM, FUNCT3_PRIV, FUNCT12_MRET >> 5, FUNCT12_MRET & 0x1F, "", "return from a machine trap handler"},
+	{"wfi", FORMAT_I, OPCODE_SYSTEM, FUNCT3_PRIV, FUNCT12_WFI >> 5, FUNCT12_WFI & 0x1F, "", "wait for an interrupt"},
+	{"sfence.vma", FORMAT_R, OPCODE_SYSTEM, FUNCT3_PRIV, FUNCT7_SFENCE_VMA, 0, "[rs1[,rs2]]", "flush the address translation cache (for the page at rs1, if not x0)"},
+}
@@ -18,9 +18,34 @@ func removeAllWhitespace(s string) string {
 	return b.String()
 }
 
-// removeCommentAndTrim removes comments (everything after # or ;) and trims whitespace.
+// unquotedIndex returns the index of the first character of s in chars that is
+// not part of a string or character literal, or -1.
+func unquotedIndex(s, chars string) int {
+	var quote rune
+	escaped := false
+	for i, c := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0 && c == '\\':
+			escaped = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case strings.ContainsRune(chars, c):
+			return i
+		}
+	}
+	return -1
+}
+
+// removeCommentAndTrim removes comments (everything after # or ; outside of string
+// and character literals) and trims whitespace.
 func removeCommentAndTrim(line string) string {
-	if idx := strings.IndexAny(line, "#;"); idx != -1 {
+	if idx := unquotedIndex(line, "#;"); idx != -1 {
 		line = line[:idx]
 	}
 	return strings.TrimSpace(line)
@@ -36,6 +61,9 @@ func splitLabelsAndInstruction(line string) (labels []string, instr string) {
 			break
 		}
 		label := strings.TrimSpace(line[:idx])
+		if strings.ContainsAny(label, " \t\"'") {
+			break // a colon in the operands, e.g. in .ascii "a:b"
+		}
 		if label != "" {
 			labels = append(labels, label)
 		}
@@ -44,6 +72,23 @@ func splitLabelsAndInstruction(line string) (labels []string, instr string) {
 	return labels, line
 }
 
+// splitOperands splits a comma-separated operand list, keeping commas in
+// string and character literals, and trims the operands.
+func splitOperands(s string) []string {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	var ops []string
+	for {
+		idx := unquotedIndex(s, ",")
+		if idx == -1 {
+			return append(ops, strings.TrimSpace(s))
+		}
+		ops = append(ops, strings.TrimSpace(s[:idx]))
+		s = s[idx+1:]
+	}
+}
+
 // linesFromFile reads all lines from a file and returns them as []string.
 func linesFromFile(filename string) ([]string, error) {
 	var lines []string
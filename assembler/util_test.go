@@ -17,6 +17,9 @@ func TestRemoveCommentAndTrim(t *testing.T) {
 		{"sw x3, 0(x1)", "sw x3, 0(x1)"},
 		{"", ""},
 		{"   ", ""},
+		{`.ascii "a#b;c" # comment`, `.ascii "a#b;c"`},
+		{`.byte '#', ';' ; comment`, `.byte '#', ';'`},
+		{`.ascii "\"#"`, `.ascii "\"#"`},
 	}
 	for _, c := range cases {
 		got := removeCommentAndTrim(c.in)
@@ -39,6 +42,7 @@ func TestSplitLabelsAndInstruction(t *testing.T) {
 		{"   addi x2, x3, 4", nil, "addi x2, x3, 4"},
 		{"# just a comment", nil, ""},
 		{"foo: bar: # comment", []string{"foo", "bar"}, ""},
+		{`msg: .asciz "a: b"`, []string{"msg"}, `.asciz "a: b"`},
 	}
 	for _, c := range cases {
 		gotLabs, gotInstr := splitLabelsAndInstruction(c.in)
@@ -49,6 +53,23 @@ func TestSplitLabelsAndInstruction(t *testing.T) {
 	}
 }
 
+func TestSplitOperands(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{" 1, 2 ,3", []string{"1", "2", "3"}},
+		{`"a, b", 'c', ','`, []string{`"a, b"`, "'c'", "','"}},
+		{`"\", x"`, []string{`"\", x"`}},
+	}
+	for _, c := range cases {
+		if got := splitOperands(c.in); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("splitOperands(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
 // Test linesFromFile reads all lines from a file and returns them as []string.
 func TestLinesFromFile(t *testing.T) {
 	content := "addi x1, x0, 1\nadd x2, x1, x0\n# comment line\n"
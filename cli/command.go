@@ -1,11 +1,18 @@
 package cli
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/malikwirin/riscvemu/arch"
 	"github.com/malikwirin/riscvemu/assembler"
+	"io"
+	"math"
 	"math/rand"
+	"os"
+	"sort"
 	"strconv"
+	"strings"
 )
 
 type Command struct {
@@ -35,20 +42,24 @@ func init() {
 		},
 		"help": {
 			Handler: cmdHelp,
-			Help:    "help [command]: Show help for a command",
+			Help:    "help [command|instruction]: Show help for a command, or the syntax and effect of an instruction (e.g. help addi)",
 		},
 		"load": {
 			Handler: cmdLoad,
-			Help:    "load <filename> [address]: Load a binary program into memory at an optional address (default 0)",
+			Help:    "load [-c] [-f format] <filename> [address]: Assemble a program into memory at an optional address (default 0), its data segment following the text, and start at _start if defined; -c emits compressed instructions where possible. ELF executables are loaded at their own addresses and start at their entry point. Memory images (bin, ihex, srec, readmemh) are detected by extension (.bin, .hex, .srec, .mem, ...) or forced with -f and loaded relative to the address",
 		},
-		"mem": {Handler: cmdMem, Help: "mem [start [length]]: Dump memory (default: start=0, length=16 words)"},
+		"mem": {Handler: cmdMem, Help: "mem [start [length]]: Dump memory (default: start=0, length=16 words); start may be hexadecimal (0x...)"},
 		"pc": {
 			Handler: cmdPC,
-			Help:    "pc: Print the current program counter",
+			Help:    "pc: Print the current program counter and privilege level",
 		},
 		"peek": {
 			Handler: cmdPeek,
-			Help:    "peek: Show the next instruction at the current PC",
+			Help:    "peek: Show the next instruction at the current PC, in hex and disassembled",
+		},
+		"dis": {
+			Handler: cmdDis,
+			Help:    "dis [-a] [-r] [address [count]]: Disassemble count instructions (default 8) from address (default PC); -a uses ABI register names, -r shows no pseudo-instructions",
 		},
 		"step": {
 			Handler: cmdStep,
@@ -56,7 +67,31 @@ func init() {
 		},
 		"regs": {
 			Handler: cmdRegs,
-			Help:    "regs: Print the current state of the registers",
+			Help:    "regs [-a] [-f] [register [value]]: Print the registers, or read/write a single register given as xN or by ABI name (e.g. regs a0 42); -a prints ABI names, -f prints the FP registers (hex and decimal) and fcsr",
+		},
+		"csr": {
+			Handler: cmdCSR,
+			Help:    "csr [name|address [value]]: Print all CSRs, or read/write a single CSR (e.g. csr mtvec 0x100)",
+		},
+		"export": {
+			Handler: cmdExport,
+			Help:    "export [-f format] [-c] <file> <start> <length> | <file> <program.asm> [address]: Write a memory range, or an assembled program (at address, default 0), as an image for hardware simulators: bin, ihex, readmemh, readmemb or logisim (default by extension: .bin, .hex, .mem)",
+		},
+		"symbols": {
+			Handler: cmdSymbols,
+			Help:    "symbols: List the symbols of the loaded ELF executable or assembled program by address",
+		},
+		"bus": {
+			Handler: cmdBus,
+			Help:    "bus: List the regions of the physical address space (RAM, ROM and devices)",
+		},
+		"uart": {
+			Handler: cmdUART,
+			Help:    "uart <text...>: Send a line of text (with a trailing newline) to the UART's receiver",
+		},
+		"traps": {
+			Handler: cmdTraps,
+			Help:    "traps [on|off]: Show or set whether exceptions trap to mtvec (on) or stop execution (off)",
 		},
 		"reset": {
 			Handler: cmdReset,
@@ -88,7 +123,7 @@ func cmdRandStore(owner machineOwner, args []string) error {
 	m := owner.Machine()
 	for i := 0; i < count; i++ {
 		val := rand.Uint32()
-		if err := m.Memory.WriteWord(uint32(addr)+uint32(i*4), val); err != nil {
+		if err := m.Bus.WriteWord(uint32(addr)+uint32(i*4), val); err != nil {
 			return fmt.Errorf("failed to write to address 0x%x: %v", uint32(addr)+uint32(i*4), err)
 		}
 	}
@@ -110,7 +145,7 @@ func cmdStore(owner machineOwner, args []string) error {
 		if err != nil {
 			return fmt.Errorf("invalid value: %q", valstr)
 		}
-		if err := m.Memory.WriteWord(uint32(addr)+uint32(i*4), uint32(val)); err != nil {
+		if err := m.Bus.WriteWord(uint32(addr)+uint32(i*4), uint32(val)); err != nil {
 			return fmt.Errorf("failed to write to address 0x%x: %v", uint32(addr)+uint32(i*4), err)
 		}
 	}
@@ -134,6 +169,10 @@ func cmdHelp(_ machineOwner, args []string) error {
 	cmdName := args[0]
 	cmd, ok := commands[cmdName]
 	if !ok {
+		if spec, ok := assembler.Lookup(cmdName); ok {
+			fmt.Printf("%s\n  %s (%s-type, opcode 0x%02x)\n", spec.Syntax(), spec.Description, spec.Format, uint32(spec.Opcode))
+			return nil
+		}
 		fmt.Printf("Unknown command: %s\n", cmdName)
 		return nil
 	}
@@ -142,14 +181,26 @@ func cmdHelp(_ machineOwner, args []string) error {
 }
 
 func cmdLoad(owner machineOwner, args []string) error {
+	const usage = "usage: load [-c] [-f format] <filename> [address]"
+	fs := flag.NewFlagSet("load", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	compress := fs.Bool("c", false, "emit compressed instructions where possible")
+	format := fs.String("f", "", "image format")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf(usage)
+	}
+	args = fs.Args()
 	if len(args) < 1 {
-		return fmt.Errorf("usage: load <filename> [address]")
+		return fmt.Errorf(usage)
 	}
 
 	filename := args[0]
 	address := uint32(0)
 
 	if len(args) > 1 {
+		if *format == "" && isELFFile(filename) {
+			return fmt.Errorf("ELF executables are loaded at their own addresses")
+		}
 		addr, err := strconv.ParseUint(args[1], 0, 32)
 		if err != nil {
 			return fmt.Errorf("invalid address: %q", args[1])
@@ -157,19 +208,161 @@ func cmdLoad(owner machineOwner, args []string) error {
 		address = uint32(addr)
 	}
 
-	prog, err := assembler.AssembleFile(filename)
+	loaded, err := loadFile(owner.Machine(), filename, *format, address, *compress)
+	if err != nil {
+		fmt.Println(err)
+		return errors.Unwrap(err)
+	}
+	fmt.Println(loaded)
+	return nil
+}
+
+// loadFile loads an ELF executable, a memory image or an assembler program into
+// m as the load command does, and describes what was loaded. Errors say which
+// step failed and wrap the cause.
+func loadFile(m *arch.Machine, filename, format string, address uint32, compress bool) (string, error) {
+	if format == "" && isELFFile(filename) {
+		exe, err := m.LoadELF(filename)
+		if err != nil {
+			return "", fmt.Errorf("Failed to load ELF: %w", err)
+		}
+		return fmt.Sprintf("ELF loaded: %d segment(s), %d symbol(s), entry 0x%08x", len(exe.Segments), len(exe.Symbols), exe.Entry), nil
+	}
+
+	if format == "" {
+		format = arch.DetectImageFormat(filename)
+	}
+	if format != "" {
+		img, err := m.LoadImage(filename, format, address)
+		if err != nil {
+			return "", fmt.Errorf("Failed to load image: %w", err)
+		}
+		size := uint32(0)
+		for _, seg := range img.Segments {
+			size += seg.Size
+		}
+		return fmt.Sprintf("Image loaded: %d byte(s) in %d segment(s), PC 0x%08x", size, len(img.Segments), m.CPU.PC), nil
+	}
+
+	prog, err := assembler.AssembleFileWithOptions(filename, assembler.Options{Compress: compress, Base: address})
 	if err != nil {
-		fmt.Printf("Failed to assemble: %v\n", err)
+		return "", fmt.Errorf("Failed to assemble: %w", err)
+	}
+	if _, err := m.LoadAssembly(prog); err != nil {
+		return "", fmt.Errorf("Failed to load program: %w", err)
+	}
+	return fmt.Sprintf("Program loaded: %d byte(s) text at 0x%08x, %d byte(s) data at 0x%08x, entry 0x%08x",
+		prog.Text.Size, prog.Text.Base, prog.Data.Size, prog.Data.Base, prog.Entry), nil
+}
+
+// RunProgram loads filename into m like the load command and runs it without
+// the REPL until it exits, returning its exit code. A program that never exits
+// through its environment runs until the process is stopped; other errors,
+// such as an exception without a trap handler, end the run.
+func RunProgram(m *arch.Machine, filename, format string, address uint32, compress bool) (int, error) {
+	if _, err := loadFile(m, filename, format, address, compress); err != nil {
+		return 0, err
+	}
+	for {
+		if err := m.Step(); err != nil {
+			var exit *arch.ExitError
+			if errors.As(err, &exit) {
+				return exit.Code, nil
+			}
+			return 0, fmt.Errorf("at PC 0x%08x: %w", m.CPU.PC, err)
+		}
+	}
+}
+
+func cmdExport(owner machineOwner, args []string) error {
+	const usage = "usage: export [-f format] [-c] <file> <start> <length> | <file> <program.asm> [address]"
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	format := fs.String("f", "", "image format")
+	compress := fs.Bool("c", false, "emit compressed instructions where possible")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf(usage)
+	}
+	args = fs.Args()
+	if len(args) < 2 || len(args) > 3 {
+		return fmt.Errorf(usage)
+	}
+	output := args[0]
+
+	start, err := strconv.ParseUint(args[1], 0, 32)
+	if err != nil {
+		// an assembly source file
+		address := uint64(0)
+		if len(args) == 3 {
+			if address, err = strconv.ParseUint(args[2], 0, 32); err != nil {
+				return fmt.Errorf("invalid address: %q", args[2])
+			}
+		}
+		if err := ExportProgram(args[1], output, *format, uint32(address), *compress); err != nil {
+			return err
+		}
+		fmt.Printf("Exported %s to %s\n", args[1], output)
+		return nil
+	}
+
+	if len(args) != 3 {
+		return fmt.Errorf(usage)
+	}
+	length, err := strconv.ParseUint(args[2], 0, 32)
+	if err != nil {
+		return fmt.Errorf("invalid length: %q", args[2])
+	}
+	data, err := arch.ReadRange(owner.Machine().Bus, uint32(start), uint32(length))
+	if err != nil {
+		return err
+	}
+	if err := arch.ExportImageFile(output, *format, uint32(start), data); err != nil {
 		return err
 	}
+	fmt.Printf("Exported %d byte(s) from 0x%08x to %s\n", length, uint32(start), output)
+	return nil
+}
 
-	m := owner.Machine()
-	if err := m.LoadProgram(prog, address); err != nil {
-		fmt.Printf("Failed to load program: %v\n", err)
+// ExportProgram assembles source for address and writes its text and data
+// segments to output as one memory image in format (see arch.ExportImageFile).
+func ExportProgram(source, output, format string, address uint32, compress bool) error {
+	prog, err := assembler.AssembleFileWithOptions(source, assembler.Options{Compress: compress, Base: address})
+	if err != nil {
 		return err
 	}
+	return arch.ExportImageFile(output, format, address, prog.Image())
+}
+
+// isELFFile reports whether the file at path starts with the ELF magic number.
+func isELFFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	magic := make([]byte, 4)
+	_, err = io.ReadFull(f, magic)
+	return err == nil && arch.IsELF(magic)
+}
 
-	fmt.Println("Program loaded")
+// cmdSymbols lists the machine's symbols sorted by address, then name.
+func cmdSymbols(owner machineOwner, _ []string) error {
+	symbols := owner.Machine().Symbols
+	if len(symbols) == 0 {
+		fmt.Println("No symbols loaded")
+		return nil
+	}
+	names := make([]string, 0, len(symbols))
+	for name := range symbols {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := symbols[names[i]], symbols[names[j]]
+		return a < b || a == b && names[i] < names[j]
+	})
+	for _, name := range names {
+		fmt.Printf("0x%08x %s\n", symbols[name], name)
+	}
 	return nil
 }
 
@@ -179,9 +372,8 @@ func cmdMem(owner machineOwner, args []string) error {
 
 	// Parse optional arguments: start and length
 	if len(args) >= 1 {
-		var s int
-		_, err := fmt.Sscanf(args[0], "%d", &s)
-		if err == nil && s >= 0 {
+		s, err := strconv.ParseUint(args[0], 0, 32)
+		if err == nil {
 			start = uint32(s)
 		}
 	}
@@ -196,7 +388,7 @@ func cmdMem(owner machineOwner, args []string) error {
 	// Dump memory
 	for i := 0; i < length; i++ {
 		addr := start + uint32(i*4)
-		word, err := owner.Machine().Memory.ReadWord(addr)
+		word, err := owner.Machine().Bus.ReadWord(addr)
 		if err != nil {
 			fmt.Printf("0x%08x: ERROR (%v)\n", addr, err)
 		} else {
@@ -207,20 +399,91 @@ func cmdMem(owner machineOwner, args []string) error {
 }
 
 func cmdPC(owner machineOwner, _ []string) error {
-	fmt.Printf("PC: %d\n", owner.Machine().CPU.PC)
+	cpu := owner.Machine().CPU
+	fmt.Printf("PC: %d (%s-mode)\n", cpu.PC, arch.PrivName(cpu.Priv))
 	return nil
 }
 
-// cmdPeek prints the next instruction at the current PC as a hex value.
+// cmdPeek prints the next instruction at the current PC as a hex value and as assembly.
 func cmdPeek(owner machineOwner, args []string) error {
 	m := owner.Machine()
 	pc := m.CPU.PC
-	word, err := m.Memory.ReadWord(pc)
+	instr, err := readInstruction(m, pc)
 	if err != nil {
 		fmt.Printf("Error reading memory at 0x%08x: %v\n", pc, err)
 		return err
 	}
-	fmt.Printf("Next instruction at 0x%08x: 0x%08x\n", pc, word)
+	opts := assembler.DisasmOptions{Pseudo: true, Labels: assembler.Labels(m.Symbols)}
+	fmt.Printf("Next instruction at 0x%08x: %s  %s\n", pc, hexInstruction(instr), assembler.Disassemble(instr, pc, opts))
+	return nil
+}
+
+// readInstruction reads the (possibly compressed) instruction at addr.
+func readInstruction(m *arch.Machine, addr uint32) (assembler.Instruction, error) {
+	lo, err := m.Bus.ReadHalf(addr)
+	if err != nil {
+		return 0, err
+	}
+	if assembler.IsCompressed(lo) {
+		return assembler.Instruction(lo), nil
+	}
+	hi, err := m.Bus.ReadHalf(addr + 2)
+	if err != nil {
+		return 0, err
+	}
+	return assembler.Instruction(uint32(lo) | uint32(hi)<<16), nil
+}
+
+// hexInstruction formats an instruction as hex digits of its size.
+func hexInstruction(instr assembler.Instruction) string {
+	if instr.Size() == assembler.COMPRESSED_SIZE {
+		return fmt.Sprintf("0x%04x", uint32(instr))
+	}
+	return fmt.Sprintf("0x%08x", uint32(instr))
+}
+
+func cmdDis(owner machineOwner, args []string) error {
+	const usage = "usage: dis [-a] [-r] [address [count]]"
+	fs := flag.NewFlagSet("dis", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	abi := fs.Bool("a", false, "ABI register names")
+	raw := fs.Bool("r", false, "no pseudo-instructions")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf(usage)
+	}
+	args = fs.Args()
+	m := owner.Machine()
+	addr, count := m.CPU.PC, 8
+	if len(args) > 0 {
+		v, err := strconv.ParseUint(args[0], 0, 32)
+		if err != nil {
+			return fmt.Errorf("invalid address: %q", args[0])
+		}
+		addr = uint32(v)
+	}
+	if len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid count: %q", args[1])
+		}
+		count = n
+	}
+	if len(args) > 2 {
+		return fmt.Errorf(usage)
+	}
+
+	opts := assembler.DisasmOptions{ABINames: *abi, Pseudo: !*raw, Labels: assembler.Labels(m.Symbols)}
+	for i := 0; i < count; i++ {
+		instr, err := readInstruction(m, addr)
+		if err != nil {
+			return fmt.Errorf("reading instruction at 0x%08x: %w", addr, err)
+		}
+		if label, ok := opts.Labels[addr]; ok {
+			fmt.Printf("%s:\n", label)
+		}
+		fmt.Printf("0x%08x: %-10s  %s\n", addr, hexInstruction(instr), assembler.Disassemble(instr, addr, opts))
+		addr += uint32(instr.Size())
+	}
 	return nil
 }
 
@@ -236,6 +499,11 @@ func cmdStep(owner machineOwner, args []string) error {
 	m := owner.Machine()
 	for i := 0; i < n; i++ {
 		if err := m.Step(); err != nil {
+			var exit *arch.ExitError
+			if errors.As(err, &exit) {
+				fmt.Printf("Program exited with code %d after %d step(s).\n", exit.Code, i+1)
+				return nil
+			}
 			return fmt.Errorf("error during Step %d: %w", i+1, err)
 		}
 	}
@@ -243,11 +511,159 @@ func cmdStep(owner machineOwner, args []string) error {
 	return nil
 }
 
-func cmdRegs(owner machineOwner, _ []string) error {
-	m := owner.Machine()
+func cmdRegs(owner machineOwner, args []string) error {
+	const usage = "usage: regs [-a] [-f] [register [value]]"
+	fs := flag.NewFlagSet("regs", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	abi := fs.Bool("a", false, "print ABI register names")
+	fp := fs.Bool("f", false, "print the FP registers")
+	if err := fs.Parse(args); err != nil || fs.NArg() > 2 || *fp && fs.NArg() > 0 {
+		return fmt.Errorf(usage)
+	}
+	cpu := owner.Machine().CPU
+	if *fp {
+		printFPRegs(cpu)
+		return nil
+	}
+	if fs.NArg() > 0 {
+		reg, err := assembler.ParseRegister(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		if fs.NArg() == 2 {
+			value, err := parseRegValue(fs.Arg(1))
+			if err != nil {
+				return err
+			}
+			cpu.SetReg(arch.RegIndex(reg), value)
+		}
+		fmt.Printf("x%d (%s): %d\n", reg, assembler.RegABINames[reg], cpu.Reg[reg])
+		return nil
+	}
 	fmt.Println("Registers:")
-	for i, v := range m.CPU.Reg {
-		fmt.Printf("x%-2d: %d\n", i, v)
+	for i, v := range cpu.Reg {
+		if *abi {
+			fmt.Printf("%-4s: %d\n", assembler.RegABINames[i], v)
+		} else {
+			fmt.Printf("x%-2d: %d\n", i, v)
+		}
+	}
+	return nil
+}
+
+// parseRegValue parses a register value given as a signed or unsigned 32-bit
+// number, in decimal or with a 0x prefix.
+func parseRegValue(s string) (uint32, error) {
+	if v, err := strconv.ParseInt(s, 0, 32); err == nil {
+		return uint32(v), nil
+	}
+	v, err := strconv.ParseUint(s, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value: %q", s)
+	}
+	return uint32(v), nil
+}
+
+// printFPRegs prints the FP registers as raw hex and as decimal values. NaN-boxed
+// registers are shown as single precision, all others as double precision.
+func printFPRegs(cpu *arch.CPU) {
+	fmt.Println("FP registers:")
+	for i, v := range cpu.FReg {
+		var value string
+		if arch.IsBoxedSingle(v) {
+			value = strconv.FormatFloat(float64(math.Float32frombits(uint32(v))), 'g', -1, 32) + " (s)"
+		} else {
+			value = strconv.FormatFloat(math.Float64frombits(v), 'g', -1, 64) + " (d)"
+		}
+		fmt.Printf("f%-2d %-5s: 0x%016x %s\n", i, "("+assembler.FPRegABINames[i]+")", v, value)
+	}
+	fmt.Printf("fcsr: 0x%02x (frm=%d, fflags=0x%02x)\n", cpu.FCSR, cpu.Frm(), cpu.FFlags())
+}
+
+// cmdCSR prints all CSRs, or reads and optionally writes a single CSR.
+func cmdCSR(owner machineOwner, args []string) error {
+	cpu := owner.Machine().CPU
+	switch len(args) {
+	case 0:
+		names := assembler.CSRNames()
+		sort.Slice(names, func(i, j int) bool {
+			a, _ := assembler.ParseCSR(names[i])
+			b, _ := assembler.ParseCSR(names[j])
+			return a < b
+		})
+		for _, name := range names {
+			addr, _ := assembler.ParseCSR(name)
+			if value, err := cpu.ReadCSR(addr); err == nil {
+				fmt.Printf("%-9s (0x%03x): 0x%08x\n", name, addr, value)
+			}
+		}
+		return nil
+	case 1, 2:
+		addr, err := assembler.ParseCSR(args[0])
+		if err != nil {
+			return err
+		}
+		if len(args) == 2 {
+			value, err := strconv.ParseUint(args[1], 0, 32)
+			if err != nil {
+				return fmt.Errorf("invalid value: %q", args[1])
+			}
+			if err := cpu.WriteCSR(addr, uint32(value)); err != nil {
+				return err
+			}
+		}
+		value, err := cpu.ReadCSR(addr)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s (0x%03x): 0x%08x\n", assembler.CSRName(addr), addr, value)
+		return nil
+	default:
+		return fmt.Errorf("usage: csr [name|address [value]]")
+	}
+}
+
+// cmdBus lists the regions mapped on the machine's bus.
+func cmdBus(owner machineOwner, _ []string) error {
+	for _, r := range owner.Machine().Bus.Regions() {
+		fmt.Printf("0x%08x-0x%08x %-8s %T\n", r.Base, r.Base+r.Size-1, r.Name, r.Device)
+	}
+	return nil
+}
+
+// cmdUART queues a line of input for the UART, as the REPL itself reads stdin.
+func cmdUART(owner machineOwner, args []string) error {
+	uart := owner.Machine().UART
+	if uart == nil {
+		return fmt.Errorf("no UART attached")
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("usage: uart <text...>")
+	}
+	uart.Receive([]byte(strings.Join(args, " ") + "\n"))
+	return nil
+}
+
+// cmdTraps shows or sets how the CPU handles exceptions.
+func cmdTraps(owner machineOwner, args []string) error {
+	cpu := owner.Machine().CPU
+	if len(args) > 1 {
+		return fmt.Errorf("usage: traps [on|off]")
+	}
+	if len(args) == 1 {
+		switch args[0] {
+		case "on":
+			cpu.Traps = true
+		case "off":
+			cpu.Traps = false
+		default:
+			return fmt.Errorf("usage: traps [on|off]")
+		}
+	}
+	if cpu.Traps {
+		fmt.Println("Traps: on (exceptions jump to mtvec)")
+	} else {
+		fmt.Println("Traps: off (execution stops on exceptions)")
 	}
 	return nil
 }
@@ -2,8 +2,11 @@ package cli
 
 import (
 	"fmt"
+	"io"
 	"math/rand"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -62,6 +65,9 @@ func TestCmdHelp(t *testing.T) {
 	out = captureOutput(func() { _ = cmdHelp(nil, []string{"step"}) })
 	assert.Contains(t, out, "step", "cmdHelp output for step missing")
 
+	out = captureOutput(func() { _ = cmdHelp(nil, []string{"fadd.s"}) })
+	assert.Contains(t, out, "fadd.s frd, frs1, frs2[, rm]\n  frd = frs1 + frs2 (R-type, opcode 0x53)")
+
 	out = captureOutput(func() { _ = cmdHelp(nil, []string{"unknowncmd"}) })
 	assert.Contains(t, out, "Unknown command", "cmdHelp output for unknown command missing")
 }
@@ -93,6 +99,11 @@ func TestCmdPC(t *testing.T) {
 		m.CPU.PC = 1234
 		out := captureOutput(func() { _ = cmdPC(owner, nil) })
 		assert.Contains(t, out, "PC: 1234", "cmdPC output missing correct PC")
+		assert.Contains(t, out, "(M-mode)")
+
+		m.CPU.Priv = arch.PRIV_U
+		out = captureOutput(func() { _ = cmdPC(owner, nil) })
+		assert.Contains(t, out, "(U-mode)")
 	})
 }
 
@@ -137,6 +148,123 @@ func TestCmdRegs(t *testing.T) {
 	})
 }
 
+func TestCmdRegs_ABI(t *testing.T) {
+	withMachine(64, func(m *arch.Machine, owner *testOwner) {
+		m.CPU.Reg[2] = 64
+		out := captureOutput(func() { assert.NoError(t, cmdRegs(owner, []string{"-a"})) })
+		assert.Contains(t, out, "zero: 0")
+		assert.Contains(t, out, "sp  : 64")
+		assert.Contains(t, out, "t6  : 0")
+
+		out = captureOutput(func() { assert.NoError(t, cmdRegs(owner, []string{"a0", "-1"})) })
+		assert.Equal(t, "x10 (a0): 4294967295\n", out)
+		assert.Equal(t, uint32(0xFFFFFFFF), m.CPU.Reg[10])
+
+		out = captureOutput(func() { assert.NoError(t, cmdRegs(owner, []string{"fp", "0x10"})) })
+		assert.Equal(t, "x8 (s0): 16\n", out)
+
+		_ = captureOutput(func() { assert.NoError(t, cmdRegs(owner, []string{"zero", "5"})) })
+		assert.Equal(t, uint32(0), m.CPU.Reg[0], "x0 stays zero")
+
+		assert.Error(t, cmdRegs(owner, []string{"x32"}))
+		assert.Error(t, cmdRegs(owner, []string{"a0", "xyz"}))
+		assert.Error(t, cmdRegs(owner, []string{"-f", "a0"}))
+	})
+}
+
+func TestCmdRegs_FP(t *testing.T) {
+	withMachine(64, func(m *arch.Machine, owner *testOwner) {
+		m.CPU.FReg[1] = arch.BoxSingle(0x3FC00000) // 1.5
+		m.CPU.FReg[31] = 0x400921FB54442D18        // pi
+		m.CPU.FCSR = 0x21
+		out := captureOutput(func() { _ = cmdRegs(owner, []string{"-f"}) })
+		assert.Contains(t, out, "f1  (ft1):", "FP register label with ABI name")
+		assert.Contains(t, out, "0xffffffff3fc00000 1.5 (s)", "single shown as hex and decimal")
+		assert.Contains(t, out, "0x400921fb54442d18 3.141592653589793 (d)", "double shown as hex and decimal")
+		assert.Contains(t, out, "fcsr: 0x21 (frm=1, fflags=0x01)")
+
+		assert.Error(t, cmdRegs(owner, []string{"-x"}))
+	})
+}
+
+func TestCmdCSR(t *testing.T) {
+	withMachine(64, func(m *arch.Machine, owner *testOwner) {
+		out := captureOutput(func() { assert.NoError(t, cmdCSR(owner, []string{"mtvec", "0x100"})) })
+		assert.Contains(t, out, "mtvec (0x305): 0x00000100")
+		got, _ := m.CPU.ReadCSR(assembler.CSR_MTVEC)
+		assert.Equal(t, uint32(0x100), got)
+
+		out = captureOutput(func() { assert.NoError(t, cmdCSR(owner, []string{"0x340"})) })
+		assert.Contains(t, out, "mscratch (0x340): 0x00000000")
+
+		out = captureOutput(func() { assert.NoError(t, cmdCSR(owner, nil)) })
+		assert.Contains(t, out, "misa")
+		assert.Contains(t, out, "mtvec     (0x305): 0x00000100")
+
+		assert.Error(t, cmdCSR(owner, []string{"nosuchcsr"}))
+		assert.Error(t, cmdCSR(owner, []string{"mhartid", "1"}), "mhartid is read-only")
+		assert.Error(t, cmdCSR(owner, []string{"mtvec", "xyz"}))
+	})
+}
+
+func TestCmdBus(t *testing.T) {
+	withMachine(64, func(m *arch.Machine, owner *testOwner) {
+		out := captureOutput(func() { assert.NoError(t, cmdBus(owner, nil)) })
+		assert.Contains(t, out, "0x00000000-0x0000003f ram      *arch.Memory")
+		assert.Contains(t, out, "0x02000000-0x0200ffff clint    *arch.CLINT")
+		assert.Contains(t, out, "plic")
+	})
+}
+
+func TestCmdUART(t *testing.T) {
+	withMachine(64, func(m *arch.Machine, owner *testOwner) {
+		assert.Error(t, cmdUART(owner, []string{"hi"}), "no UART attached")
+	})
+	m := arch.NewMachine(64, arch.WithUART(arch.UART_BASE, io.Discard, nil))
+	owner := &testOwner{m}
+	assert.Error(t, cmdUART(owner, nil))
+	assert.NoError(t, cmdUART(owner, []string{"hello", "world"}))
+	var got []byte
+	for {
+		lsr, _ := m.Bus.ReadByteAt(arch.UART_BASE + arch.UART_LSR)
+		if lsr&arch.UART_LSR_DR == 0 {
+			break
+		}
+		b, _ := m.Bus.ReadByteAt(arch.UART_BASE + arch.UART_RBR)
+		got = append(got, b)
+	}
+	assert.Equal(t, "hello world\n", string(got))
+}
+
+func TestCmdMemHighAddress(t *testing.T) {
+	m := arch.NewMachine(64, arch.WithSparseRAM(arch.DRAM_BASE, arch.DRAM_SIZE))
+	owner := &testOwner{m}
+	captureOutput(func() { assert.NoError(t, cmdStore(owner, []string{"0x80000004", "0x1234"})) })
+	out := captureOutput(func() { assert.NoError(t, cmdMem(owner, []string{"0x80000000", "2"})) })
+	assert.Contains(t, out, "0x80000000: 0x00000000")
+	assert.Contains(t, out, "0x80000004: 0x00001234")
+}
+
+func TestCmdTraps(t *testing.T) {
+	withMachine(64, func(m *arch.Machine, owner *testOwner) {
+		out := captureOutput(func() { assert.NoError(t, cmdTraps(owner, nil)) })
+		assert.Contains(t, out, "Traps: off")
+
+		out = captureOutput(func() { assert.NoError(t, cmdTraps(owner, []string{"on"})) })
+		assert.Contains(t, out, "Traps: on")
+		assert.True(t, m.CPU.Traps)
+
+		assert.NoError(t, m.Reset())
+		assert.True(t, m.CPU.Traps, "reset keeps the trap setting")
+
+		captureOutput(func() { assert.NoError(t, cmdTraps(owner, []string{"off"})) })
+		assert.False(t, m.CPU.Traps)
+
+		assert.Error(t, cmdTraps(owner, []string{"maybe"}))
+		assert.Error(t, cmdTraps(owner, []string{"on", "off"}))
+	})
+}
+
 func TestCmdReset(t *testing.T) {
 	withMachine(64, func(m *arch.Machine, owner *testOwner) {
 		m.CPU.PC = 123
@@ -171,6 +299,116 @@ func TestCmdLoad(t *testing.T) {
 	})
 }
 
+func TestCmdLoad_ELF(t *testing.T) {
+	withMachine(64, func(m *arch.Machine, owner *testOwner) {
+		path := filepath.Join(t.TempDir(), "broken.elf")
+		assert.NoError(t, os.WriteFile(path, []byte("\x7fELF truncated"), 0o644))
+		out := captureOutput(func() {
+			assert.Error(t, cmdLoad(owner, []string{path}), "ELF files are not assembled")
+		})
+		assert.Contains(t, out, "Failed to load ELF")
+		assert.Error(t, cmdLoad(owner, []string{path, "0x100"}), "ELF files have their own addresses")
+	})
+}
+
+func TestCmdLoad_Image(t *testing.T) {
+	withMachine(64, func(m *arch.Machine, owner *testOwner) {
+		dir := t.TempDir()
+		mem := filepath.Join(dir, "prog.mem")
+		assert.NoError(t, os.WriteFile(mem, []byte("00700093 00000013\n"), 0o644))
+		out := captureOutput(func() { assert.NoError(t, cmdLoad(owner, []string{mem, "8"})) })
+		assert.Contains(t, out, "Image loaded: 8 byte(s) in 1 segment(s), PC 0x00000008")
+		word, _ := m.Memory.ReadWord(8)
+		assert.Equal(t, uint32(0x00700093), word)
+
+		raw := filepath.Join(dir, "prog.img")
+		assert.NoError(t, os.WriteFile(raw, []byte{0x13, 0, 0, 0}, 0o644))
+		captureOutput(func() { assert.NoError(t, cmdLoad(owner, []string{"-f", "bin", raw, "0x20"})) })
+		word, _ = m.Memory.ReadWord(0x20)
+		assert.Equal(t, uint32(0x13), word)
+
+		captureOutput(func() { assert.Error(t, cmdLoad(owner, []string{"-f", "coff", raw})) })
+	})
+}
+
+func TestCmdLoad_DataSection(t *testing.T) {
+	withMachine(64, func(m *arch.Machine, owner *testOwner) {
+		src := filepath.Join(t.TempDir(), "data.asm")
+		asm := ".data\nvalue: .word 0x12345678\n.text\n_start: la a0, value\nlw a1, 0(a0)\n"
+		assert.NoError(t, os.WriteFile(src, []byte(asm), 0o644))
+
+		out := captureOutput(func() { assert.NoError(t, cmdLoad(owner, []string{src, "8"})) })
+		assert.Contains(t, out, "Program loaded: 12 byte(s) text at 0x00000008, 4 byte(s) data at 0x00000014, entry 0x00000008")
+		word, _ := m.Memory.ReadWord(0x14)
+		assert.Equal(t, uint32(0x12345678), word)
+		assert.Equal(t, map[string]uint32{"_start": 8, "value": 0x14}, m.Symbols)
+		assert.Equal(t, uint32(8), m.CPU.PC)
+	})
+}
+
+func TestCmdExport(t *testing.T) {
+	withMachine(64, func(m *arch.Machine, owner *testOwner) {
+		dir := t.TempDir()
+		_ = m.Memory.WriteWord(8, 0x00500093)
+		out := filepath.Join(dir, "range.mem")
+		captureOutput(func() { assert.NoError(t, cmdExport(owner, []string{out, "8", "4"})) })
+		text, _ := os.ReadFile(out)
+		assert.Equal(t, "@2\n00500093\n", string(text))
+
+		src := filepath.Join(dir, "prog.asm")
+		assert.NoError(t, os.WriteFile(src, []byte("addi x1, x0, 5\n"), 0o644))
+		out = filepath.Join(dir, "prog.txt")
+		captureOutput(func() { assert.NoError(t, cmdExport(owner, []string{"-f", "logisim", out, src})) })
+		text, _ = os.ReadFile(out)
+		assert.Equal(t, "v2.0 raw\n500093\n", string(text))
+		word, _ := m.Memory.ReadWord(0)
+		assert.Zero(t, word, "exporting a program does not load it")
+
+		assert.Error(t, cmdExport(owner, []string{out}), "usage")
+		assert.Error(t, cmdExport(owner, []string{out, "8"}), "range without length")
+		assert.Error(t, cmdExport(owner, []string{filepath.Join(dir, "x.out"), "8", "4"}), "unknown format")
+		assert.Error(t, cmdExport(owner, []string{out, "60", "8"}), "outside memory")
+	})
+}
+
+func TestCmdSymbols(t *testing.T) {
+	withMachine(64, func(m *arch.Machine, owner *testOwner) {
+		out := captureOutput(func() { assert.NoError(t, cmdSymbols(owner, nil)) })
+		assert.Contains(t, out, "No symbols loaded")
+
+		m.Symbols = map[string]uint32{"main": 0x80000010, "_start": 0x80000000, "loop": 0x80000010}
+		out = captureOutput(func() { assert.NoError(t, cmdSymbols(owner, nil)) })
+		assert.Equal(t, "0x80000000 _start\n0x80000010 loop\n0x80000010 main\n", out)
+	})
+}
+
+func TestCmdLoad_Compressed(t *testing.T) {
+	withMachine(64, func(m *arch.Machine, owner *testOwner) {
+		tmpfile, err := os.CreateTemp("", "testprog-*.asm")
+		assert.NoError(t, err, "create temp file")
+		defer os.Remove(tmpfile.Name())
+		_, err = tmpfile.WriteString("addi x10, x0, 1\naddi x10, x10, 2\n")
+		assert.NoError(t, err, "write temp file")
+		tmpfile.Close()
+
+		out := captureOutput(func() {
+			err := cmdLoad(owner, []string{"-c", tmpfile.Name(), "8"})
+			assert.NoError(t, err, "cmdLoad -c")
+		})
+		assert.Contains(t, out, "Program loaded", "cmdLoad output missing 'Program loaded'")
+
+		word, err := m.Memory.ReadWord(8)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(0x0509), word>>16, "second instruction should be c.addi x10, 2")
+		assert.Equal(t, uint32(0x4505), word&0xFFFF, "first instruction should be c.li x10, 1")
+		assert.Equal(t, uint32(8), m.CPU.PC)
+
+		err = cmdLoad(owner, []string{"-x", tmpfile.Name()})
+		assert.Error(t, err, "unknown flag")
+		assert.Contains(t, err.Error(), "usage")
+	})
+}
+
 func TestCmdPeek(t *testing.T) {
 	withMachine(64, func(m *arch.Machine, owner *testOwner) {
 		_ = m.Memory.WriteWord(0, 0xDEADBEEF)
@@ -220,3 +458,61 @@ func TestCmdStore(t *testing.T) {
 		assert.Contains(t, err.Error(), "invalid value", "Expected error for invalid value")
 	})
 }
+
+func TestCmdStep_Exit(t *testing.T) {
+	m := arch.NewMachine(64, arch.WithEnvironment(arch.NewLinux(t.TempDir())))
+	owner := &testOwner{m}
+	for i, line := range []string{"addi x10, x0, 2", "addi x17, x0, 93", "ecall", "addi x0, x0, 0"} {
+		instr, _ := assembler.ParseInstruction(line)
+		assert.NoError(t, m.Memory.WriteWord(uint32(i*4), uint32(instr)))
+	}
+	out := captureOutput(func() { assert.NoError(t, cmdStep(owner, []string{"10"})) })
+	assert.Contains(t, out, "Program exited with code 2 after 3 step(s).")
+}
+
+func TestCmdDis(t *testing.T) {
+	withMachine(64, func(m *arch.Machine, owner *testOwner) {
+		for i, line := range []string{"addi x10, x0, 5", "jalr x0, 0(x1)", "beq x10, x0, -8"} {
+			instr, _ := assembler.ParseInstruction(line)
+			assert.NoError(t, m.Memory.WriteWord(uint32(i*4), uint32(instr)))
+		}
+		c, _ := assembler.Compress(assembler.Instruction(0x00150513)) // addi x10, x10, 1
+		assert.NoError(t, m.Memory.WriteHalf(12, c))
+		m.Symbols = map[string]uint32{"start": 0}
+
+		out := captureOutput(func() { assert.NoError(t, cmdDis(owner, []string{"0", "4"})) })
+		assert.Equal(t, "start:\n"+
+			"0x00000000: 0x00500513  li x10, 5\n"+
+			"0x00000004: 0x00008067  ret\n"+
+			"0x00000008: 0xfe050ce3  beqz x10, start\n"+
+			"0x0000000c: 0x0505      addi x10, x10, 1\n", out)
+
+		out = captureOutput(func() { assert.NoError(t, cmdDis(owner, []string{"-a", "-r", "4", "1"})) })
+		assert.Equal(t, "0x00000004: 0x00008067  jalr zero, 0(ra)\n", out)
+
+		out = captureOutput(func() { assert.NoError(t, cmdPeek(owner, nil)) })
+		assert.Equal(t, "Next instruction at 0x00000000: 0x00500513  li x10, 5\n", out)
+
+		assert.Error(t, cmdDis(owner, []string{"x"}))
+		assert.Error(t, cmdDis(owner, []string{"0", "0"}))
+		assert.Error(t, cmdDis(owner, []string{"1000"}), "outside memory")
+	})
+}
+
+func TestRunProgram(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "exit.asm")
+	asm := "li a0, 120\nli a7, 11\necall\nli a0, 3\nli a7, 93\necall\n"
+	assert.NoError(t, os.WriteFile(src, []byte(asm), 0o644))
+
+	var out strings.Builder
+	m := arch.NewMachine(1024, arch.WithEnvironment(arch.NewRARS(strings.NewReader(""), &out)))
+	code, err := RunProgram(m, src, "", 0, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, code)
+	assert.Equal(t, "x", out.String())
+
+	_, err = RunProgram(arch.NewMachine(1024), src, "", 0, false)
+	assert.Error(t, err, "an ECALL without an environment")
+	_, err = RunProgram(arch.NewMachine(1024), filepath.Join(t.TempDir(), "missing.asm"), "", 0, false)
+	assert.Error(t, err)
+}
@@ -0,0 +1,6 @@
+  addi	x1, x0, 5	# x1 = n
+  addi	x2, x0, 1	# x2 = result
+loop_fact:
+  mul	x2, x2, x1	# result *= n
+  addi	x1, x1, -1	# n--
+  bne	x1, x0, loop_fact	# finally x2 contains 5! = 120
@@ -0,0 +1,8 @@
+  addi	x1, x0, 200	# x1 = address of the lock
+  addi	x2, x0, 1	# x2 = value of a taken lock
+acquire:
+  amoswap.w.aq	x3, x2, (x1)	# x3 = old lock value, lock = 1
+  bne	x3, x0, acquire	# spin while somebody else holds the lock
+  addi	x4, x0, 42	# critical section
+  amoswap.w.rl	x0, x0, (x1)	# release the lock
+  lw	x5, 0(x1)	# x5 = 0, the lock is free again
@@ -0,0 +1,9 @@
+  addi	x1, x0, 7	# x1 = 7
+  addi	x2, x0, 2	# x2 = 2
+  fcvt.s.w	fa0, x1	# fa0 = 7.0
+  fcvt.s.w	fa1, x2	# fa1 = 2.0
+  fdiv.s	fa2, fa0, fa1	# fa2 = 3.5
+  fcvt.w.s	x3, fa2, rne	# x3 = 4 (ties to even)
+  fcvt.w.s	x4, fa2, rtz	# x4 = 3 (towards zero)
+  fsqrt.s	fa3, fa0	# fa3 = sqrt(7) ~ 2.6458
+  flt.s	x5, fa1, fa3	# x5 = (2.0 < sqrt(7)) = 1
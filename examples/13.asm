@@ -0,0 +1,22 @@
+# Timer interrupt: the CLINT raises a machine timer interrupt once mtime
+# (counting retired instructions) reaches mtimecmp.
+  lui	x10, 0x2004	# x10 = CLINT mtimecmp (0x02004000)
+  addi	x1, x0, 50
+  sw	x1, 0(x10)	# mtimecmp = 50
+  sw	x0, 4(x10)
+  auipc	x1, 0
+  addi	x1, x1, 36	# x1 = address of handler
+  csrw	mtvec, x1
+  addi	x1, x0, 128
+  csrw	mie, x1	# enable the machine timer interrupt (MTIE)
+  csrsi	mstatus, 8	# enable interrupts (MIE)
+loop:
+  beq	x3, x0, loop	# wait for the handler to run
+  addi	x4, x0, 42	# x4 = 42
+end:
+  jal	x0, end
+handler:
+  addi	x3, x3, 1	# x3 = number of interrupts
+  addi	x1, x0, -1
+  sw	x1, 4(x10)	# disarm the timer: mtimecmp = 0xFFFFFFFF_00000032
+  mret
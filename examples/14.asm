@@ -0,0 +1,16 @@
+# UART: print "Hi" and echo the received characters up to a newline.
+  lui	x10, 0x10000	# x10 = UART (0x10000000)
+  addi	x1, x0, 72	# 'H'
+  sb	x1, 0(x10)	# transmit
+  addi	x1, x0, 105	# 'i'
+  sb	x1, 0(x10)
+  addi	x2, x0, 10	# x2 = '\n'
+wait:
+  lbu	x3, 5(x10)	# line status register
+  andi	x3, x3, 1	# data ready?
+  beq	x3, x0, wait
+  lbu	x4, 0(x10)	# receive
+  sb	x4, 0(x10)	# echo
+  bne	x4, x2, wait
+end:
+  jal	x0, end
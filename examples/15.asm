@@ -0,0 +1,12 @@
+# RARS services (-env rars): read an integer, print its square and exit.
+  addi	x17, x0, 5	# read int
+  ecall
+  mul	x5, x10, x10	# x5 = n * n
+  addi	x10, x5, 0
+  addi	x17, x0, 1	# print int
+  ecall
+  addi	x10, x0, 10	# '\n'
+  addi	x17, x0, 11	# print char
+  ecall
+  addi	x17, x0, 10	# exit
+  ecall
@@ -0,0 +1,26 @@
+# Pseudo-instructions and ABI register names: a function summing 1..n.
+  li	sp, 1024	# stack at the top of memory
+  li	a0, 10
+  call	sum		# a0 = 1 + 2 + ... + 10
+  mv	s0, a0		# s0 = 55
+  li	s1, 0x12345678	# lui + addi
+  j	end
+
+# sum returns 1 + 2 + ... + a0
+sum:
+  addi	sp, sp, -4
+  sw	ra, 0(sp)
+  mv	t0, a0
+  li	a0, 0
+loop:
+  blez	t0, done	# until t0 <= 0
+  add	a0, a0, t0
+  addi	t0, t0, -1
+  j	loop
+done:
+  lw	ra, 0(sp)
+  addi	sp, sp, 4
+  ret
+
+end:
+  nop
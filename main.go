@@ -1,15 +1,98 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
 	"os"
+	"strconv"
 
 	"github.com/malikwirin/riscvemu/arch"
 	"github.com/malikwirin/riscvemu/cli"
 )
 
 func main() {
-	machine := arch.NewMachine(64 * 1024)
+	uartBase := flag.String("uart", fmt.Sprintf("0x%08x", arch.UART_BASE), "address of the UART, or \"off\"")
+	uartIn := flag.String("uart-in", "", "file or pipe the UART receives from (\"-\" for stdin with -run); use the uart command otherwise")
+	assemble := flag.String("assemble", "", "assemble this program, write it to the -o file and exit")
+	run := flag.String("run", "", "run this program without the REPL until it exits, and exit with its exit code")
+	output := flag.String("o", "", "output file of -assemble")
+	format := flag.String("format", "", "image format of -assemble: bin, ihex, readmemh, readmemb or logisim (default by extension); of -run: as for the load command")
+	base := flag.String("base", "0", "address the -assemble output or the -run program is placed at")
+	compress := flag.Bool("c", false, "emit compressed instructions in -assemble or -run programs where possible")
+	env := flag.String("env", "none", "environment serving ECALLs: none (trap), linux (system calls) or rars (RARS/MARS services)")
+	semihosting := flag.Bool("semihosting", false, "serve RISC-V semihosting calls (EBREAK between the marker instructions)")
+	root := flag.String("root", ".", "directory the files of the linux environment and of semihosting are confined to")
+	flag.Parse()
+
+	address, err := strconv.ParseUint(*base, 0, 32)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid address %q: %v\n", *base, err)
+		os.Exit(2)
+	}
+	if *assemble != "" {
+		if *output == "" {
+			fmt.Fprintln(os.Stderr, "usage: riscvemu -assemble <program.asm> -o <file> [-format format] [-base address] [-c]")
+			os.Exit(2)
+		}
+		if err := cli.ExportProgram(*assemble, *output, *format, uint32(address), *compress); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to assemble: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	opts := []arch.Option{arch.WithSparseRAM(arch.DRAM_BASE, arch.DRAM_SIZE)}
+	switch *env {
+	case "none":
+	case "linux":
+		opts = append(opts, arch.WithEnvironment(arch.NewLinux(*root)))
+	case "rars":
+		opts = append(opts, arch.WithEnvironment(arch.NewRARS(os.Stdin, os.Stdout)))
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown environment %q\n", *env)
+		os.Exit(2)
+	}
+	if *semihosting {
+		opts = append(opts, arch.WithSemihosting(arch.NewSemihosting(*root)))
+	}
+	if *uartBase != "off" {
+		base, err := strconv.ParseUint(*uartBase, 0, 32)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid UART address %q: %v\n", *uartBase, err)
+			os.Exit(1)
+		}
+		var in io.Reader
+		switch *uartIn {
+		case "":
+		case "-":
+			// the UART reads stdin in the background, so nothing else may read it
+			if *run == "" || *env != "none" || *semihosting {
+				fmt.Fprintln(os.Stderr, "-uart-in - needs -run without -env or -semihosting, which read stdin as well")
+				os.Exit(2)
+			}
+			in = os.Stdin
+		default:
+			f, err := os.Open(*uartIn)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to open UART input: %v\n", err)
+				os.Exit(1)
+			}
+			defer f.Close()
+			in = f
+		}
+		opts = append(opts, arch.WithUART(uint32(base), os.Stdout, in))
+	}
+	machine := arch.NewMachine(64*1024, opts...)
+
+	if *run != "" {
+		code, err := cli.RunProgram(machine, *run, *format, uint32(address), *compress)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *run, err)
+			os.Exit(1)
+		}
+		os.Exit(code)
+	}
 
 	repl, err := cli.NewREPL(machine)
 	if err != nil {
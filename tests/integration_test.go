@@ -1,7 +1,9 @@
 package tests
 
 import (
+	"bytes"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/malikwirin/riscvemu/arch"
@@ -14,6 +16,14 @@ type exampleCase struct {
 	expect     map[int]uint32
 	steps      int
 	memoryInit map[uint32]uint32
+	// uartInput is received by a UART at the default address; the transmitted
+	// output must equal uartOutput
+	uartInput  string
+	uartOutput string
+	// rars selects the RARS environment, reading console and writing consoleOutput
+	rars          bool
+	console       string
+	consoleOutput string
 }
 
 var exampleTests = []exampleCase{
@@ -55,20 +65,55 @@ var exampleTests = []exampleCase{
 	{
 		filename: "../examples/8.asm",
 		expect:   map[int]uint32{3: 123},
-		steps:    95,
-		memoryInit: map[uint32]uint32{
-			100: 1,
-			104: 2,
-			108: 3,
-			112: 123,
-			116: 4,
-		},
+		steps:    40,
 	},
 	{
 		filename: "../examples/9.asm",
 		expect:   map[int]uint32{6: 42},
 		steps:    7,
 	},
+	{
+		filename: "../examples/10.asm",
+		expect:   map[int]uint32{1: 0, 2: 120},
+		steps:    17,
+	},
+	{
+		filename: "../examples/11.asm",
+		expect:   map[int]uint32{3: 0, 4: 42, 5: 0},
+		steps:    7,
+		memoryInit: map[uint32]uint32{
+			200: 0,
+		},
+	},
+	{
+		filename: "../examples/12.asm",
+		expect:   map[int]uint32{3: 4, 4: 3, 5: 1},
+		steps:    9,
+	},
+	{
+		filename: "../examples/13.asm",
+		expect:   map[int]uint32{3: 1, 4: 42},
+		steps:    80,
+	},
+	{
+		filename:   "../examples/14.asm",
+		expect:     map[int]uint32{4: '\n'},
+		steps:      60,
+		uartInput:  "ok\n",
+		uartOutput: "Hiok\n",
+	},
+	{
+		filename:      "../examples/15.asm",
+		expect:        map[int]uint32{5: 144},
+		rars:          true,
+		console:       "12\n",
+		consoleOutput: "144\n",
+	},
+	{
+		filename: "../examples/16.asm",
+		expect:   map[int]uint32{2: 1024, 8: 55, 9: 0x12345678},
+		steps:    70,
+	},
 }
 
 func TestExamplesIntegration(t *testing.T) {
@@ -78,19 +123,25 @@ func TestExamplesIntegration(t *testing.T) {
 			prog, err := assembler.AssembleFile(tc.filename)
 			assert.NoError(t, err)
 
-			m := arch.NewMachine(1024)
+			var uartOut, consoleOut bytes.Buffer
+			opts := []arch.Option{arch.WithUART(arch.UART_BASE, &uartOut, nil)}
+			if tc.rars {
+				opts = append(opts, arch.WithEnvironment(arch.NewRARS(strings.NewReader(tc.console), &consoleOut)))
+			}
+			m := arch.NewMachine(1024, opts...)
+			m.UART.Receive([]byte(tc.uartInput))
 
 			for addr, val := range tc.memoryInit {
 				err := m.Memory.WriteWord(addr, val)
 				assert.NoErrorf(t, err, "Memory init failed at 0x%X", addr)
 			}
 
-			err = m.LoadProgram(prog, 0)
+			_, err = m.LoadAssembly(prog)
 			assert.NoError(t, err)
 
 			steps := tc.steps
 			if steps == 0 {
-				steps = len(prog) + 5
+				steps = int(prog.Text.Size)/4 + 5
 			}
 			for i := 0; i < steps; i++ {
 				_ = m.Step()
@@ -100,6 +151,8 @@ func TestExamplesIntegration(t *testing.T) {
 				got := m.CPU.Reg[reg]
 				assert.Equalf(t, want, got, "Register x%d: expected %d, got %d", reg, want, got)
 			}
+			assert.Equal(t, tc.uartOutput, uartOut.String(), "UART output")
+			assert.Equal(t, tc.consoleOutput, consoleOut.String(), "console output")
 		})
 	}
 }